@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+const (
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+// ValidMethods are the signing algorithms accepted when verifying tokens.
+var ValidMethods = []string{AlgorithmRS256, AlgorithmEdDSA}
+
+// PublicKey is a verification key identified by the kid token header.
+type PublicKey struct {
+	ID        string
+	Algorithm string
+	Key       crypto.PublicKey
+}
+
+// JWK is the RFC 7517 representation of a public key. Only RSA and Ed25519
+// keys are supported.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+func NewJWK(key PublicKey) (JWK, error) {
+	jwk := JWK{Kid: key.ID, Use: "sig", Alg: key.Algorithm}
+	switch pub := key.Key.(type) {
+	case *rsa.PublicKey:
+		if key.Algorithm != AlgorithmRS256 {
+			return JWK{}, fmt.Errorf("key %s: RSA key used with %s", key.ID, key.Algorithm)
+		}
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case ed25519.PublicKey:
+		if key.Algorithm != AlgorithmEdDSA {
+			return JWK{}, fmt.Errorf("key %s: Ed25519 key used with %s", key.ID, key.Algorithm)
+		}
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+	default:
+		return JWK{}, fmt.Errorf("key %s: unsupported key type %T", key.ID, key.Key)
+	}
+	return jwk, nil
+}
+
+func (j JWK) PublicKey() (PublicKey, error) {
+	key := PublicKey{ID: j.Kid, Algorithm: j.Alg}
+	switch {
+	case j.Kty == "RSA" && j.Alg == AlgorithmRS256:
+		n, err := base64.RawURLEncoding.DecodeString(j.N)
+		if err != nil {
+			return PublicKey{}, fmt.Errorf("key %s: invalid modulus: %w", j.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(j.E)
+		if err != nil {
+			return PublicKey{}, fmt.Errorf("key %s: invalid exponent: %w", j.Kid, err)
+		}
+		key.Key = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	case j.Kty == "OKP" && j.Crv == "Ed25519" && j.Alg == AlgorithmEdDSA:
+		x, err := base64.RawURLEncoding.DecodeString(j.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return PublicKey{}, fmt.Errorf("key %s: invalid Ed25519 key", j.Kid)
+		}
+		key.Key = ed25519.PublicKey(x)
+	default:
+		return PublicKey{}, fmt.Errorf("key %s: unsupported key %s/%s", j.Kid, j.Kty, j.Alg)
+	}
+	if key.ID == "" {
+		return PublicKey{}, errors.New("key without kid")
+	}
+	return key, nil
+}
@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RemoteKeySet serves keys from a JWKS endpoint. The document is cached for
+// ttl and fetched again early when a token names an unknown kid, which is
+// what happens right after the issuer rotates its key.
+type RemoteKeySet struct {
+	url        string
+	client     *http.Client
+	ttl        time.Duration
+	minRefresh time.Duration
+
+	mu        sync.Mutex
+	keys      map[string]PublicKey
+	fetchedAt time.Time
+}
+
+func NewRemoteKeySet(url string, ttl time.Duration) *RemoteKeySet {
+	return &RemoteKeySet{
+		url:        url,
+		client:     &http.Client{Timeout: 5 * time.Second},
+		ttl:        ttl,
+		minRefresh: 10 * time.Second,
+		keys:       map[string]PublicKey{},
+	}
+}
+
+func (r *RemoteKeySet) PublicKey(ctx context.Context, kid string) (PublicKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key, ok := r.keys[kid]
+	age := time.Since(r.fetchedAt)
+	if ok && age < r.ttl {
+		return key, nil
+	}
+	if ok || age >= r.minRefresh {
+		if err := r.fetch(ctx); err != nil {
+			if ok {
+				return key, nil
+			}
+			return PublicKey{}, err
+		}
+		key, ok = r.keys[kid]
+	}
+	if !ok {
+		return PublicKey{}, fmt.Errorf("%w %q", ErrUnknownKey, kid)
+	}
+	return key, nil
+}
+
+func (r *RemoteKeySet) fetch(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.url, nil)
+	if err != nil {
+		return err
+	}
+	res, err := r.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch JWKS: status %d", res.StatusCode)
+	}
+
+	var doc JWKS
+	if err := json.NewDecoder(res.Body).Decode(&doc); err != nil {
+		return fmt.Errorf("failed to decode JWKS: %w", err)
+	}
+	keys := make(map[string]PublicKey, len(doc.Keys))
+	for _, jwk := range doc.Keys {
+		key, err := jwk.PublicKey()
+		if err != nil {
+			continue
+		}
+		keys[key.ID] = key
+	}
+	r.keys = keys
+	r.fetchedAt = time.Now()
+	return nil
+}
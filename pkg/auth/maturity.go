@@ -0,0 +1,28 @@
+package auth
+
+import "slices"
+
+// Maturity ratings are the minimum age a video is suitable for. Videos carry
+// a rating and viewer profiles the highest rating they may watch.
+const (
+	MaturityAll   = 0
+	Maturity7     = 7
+	Maturity13    = 13
+	Maturity16    = 16
+	MaturityAdult = 18
+)
+
+var MaturityRatings = []int{MaturityAll, Maturity7, Maturity13, Maturity16, MaturityAdult}
+
+func ValidMaturity(rating int) bool {
+	return slices.Contains(MaturityRatings, rating)
+}
+
+// CanWatch reports whether the token may play a video rated rating. Tokens
+// without a profile belong to the account holder and aren't limited.
+func (c *Claims) CanWatch(rating int) bool {
+	if c.ProfileID == "" {
+		return true
+	}
+	return rating <= c.MaxMaturity
+}
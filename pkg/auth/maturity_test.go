@@ -0,0 +1,28 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanWatch(t *testing.T) {
+	tests := map[string]struct {
+		claims   Claims
+		rating   int
+		expected bool
+	}{
+		"account holder":             {claims: Claims{}, rating: MaturityAdult, expected: true},
+		"kids profile, all ages":     {claims: Claims{ProfileID: "p-1", MaxMaturity: Maturity7}, rating: MaturityAll, expected: true},
+		"kids profile, at its limit": {claims: Claims{ProfileID: "p-1", MaxMaturity: Maturity7}, rating: Maturity7, expected: true},
+		"kids profile, above limit":  {claims: Claims{ProfileID: "p-1", MaxMaturity: Maturity7}, rating: Maturity13, expected: false},
+		"all ages profile":           {claims: Claims{ProfileID: "p-1", MaxMaturity: MaturityAll}, rating: Maturity7, expected: false},
+		"adult profile":              {claims: Claims{ProfileID: "p-1", MaxMaturity: MaturityAdult}, rating: MaturityAdult, expected: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.claims.CanWatch(tc.rating))
+		})
+	}
+}
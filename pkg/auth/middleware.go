@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+const contextClaims = "auth.claims"
+
+// HeaderServiceToken carries the secret the services share to call each
+// other's internal endpoints.
+const HeaderServiceToken = "X-Service-Token"
+
+// MinServiceTokenSize is the shortest service token the services accept.
+const MinServiceTokenSize = 32
+
+// Middleware rejects requests without a valid bearer access token and makes
+// its claims available through ClaimsFromContext.
+func Middleware(verifier *Verifier) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			fields := strings.Fields(c.Request().Header.Get("Authorization"))
+			if len(fields) != 2 || !strings.EqualFold(fields[0], "bearer") {
+				return echo.NewHTTPError(http.StatusUnauthorized, "missing or invalid authorization header")
+			}
+			claims, err := verifier.Verify(c.Request().Context(), fields[1])
+			if err != nil {
+				return echo.NewHTTPError(http.StatusUnauthorized, "invalid or expired access token")
+			}
+			SetClaims(c, claims)
+			return next(c)
+		}
+	}
+}
+
+// SetClaims is for services that authenticate requests themselves and still
+// mount RequirePermission.
+func SetClaims(c echo.Context, claims *Claims) {
+	c.Set(contextClaims, claims)
+}
+
+func ClaimsFromContext(c echo.Context) (*Claims, bool) {
+	claims, ok := c.Get(contextClaims).(*Claims)
+	return claims, ok
+}
+
+// RequireVerifiedEmail must be mounted after Middleware.
+func RequireVerifiedEmail() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			claims, ok := ClaimsFromContext(c)
+			if !ok {
+				return echo.NewHTTPError(http.StatusUnauthorized, "authentication required")
+			}
+			if !claims.EmailVerified {
+				return echo.NewHTTPError(http.StatusForbidden, "email address not verified")
+			}
+			return next(c)
+		}
+	}
+}
+
+// RequirePermission lets through requests whose token grants every one of
+// permissions, it must be mounted after Middleware.
+func RequirePermission(permissions ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			claims, ok := ClaimsFromContext(c)
+			if !ok {
+				return echo.NewHTTPError(http.StatusUnauthorized, "authentication required")
+			}
+			for _, permission := range permissions {
+				if !claims.HasPermission(permission) {
+					return echo.NewHTTPError(http.StatusForbidden, "missing permission "+permission)
+				}
+			}
+			return next(c)
+		}
+	}
+}
+
+// ServiceMiddleware lets through requests carrying the shared service token,
+// it guards the internal endpoints. An empty token refuses every request.
+func ServiceMiddleware(token string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			got := c.Request().Header.Get(HeaderServiceToken)
+			if token == "" || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				return echo.NewHTTPError(http.StatusUnauthorized, "invalid service token")
+			}
+			return next(c)
+		}
+	}
+}
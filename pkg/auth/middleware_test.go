@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+type staticKeySet PublicKey
+
+func (s staticKeySet) PublicKey(ctx context.Context, kid string) (PublicKey, error) {
+	if kid != s.ID {
+		return PublicKey{}, ErrUnknownKey
+	}
+	return PublicKey(s), nil
+}
+
+func TestRequireVerifiedEmail(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	sign := func(verified bool) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, Claims{
+			UserID:        "user-1",
+			EmailVerified: verified,
+			Type:          TokenTypeAccess,
+			RegisteredClaims: jwt.RegisteredClaims{
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+			},
+		})
+		token.Header["kid"] = "ed-1"
+		signed, err := token.SignedString(priv)
+		assert.NoError(t, err)
+		return signed
+	}
+
+	tests := map[string]struct {
+		header   string
+		expected int
+	}{
+		"verified email":   {header: "Bearer " + sign(true), expected: http.StatusOK},
+		"unverified email": {header: "Bearer " + sign(false), expected: http.StatusForbidden},
+		"missing header":   {expected: http.StatusUnauthorized},
+		"invalid token":    {header: "Bearer nope", expected: http.StatusUnauthorized},
+		"wrong scheme":     {header: "Basic " + sign(true), expected: http.StatusUnauthorized},
+	}
+
+	verifier := NewVerifier(staticKeySet{ID: "ed-1", Algorithm: AlgorithmEdDSA, Key: pub})
+	e := echo.New()
+	e.POST("/videos", func(c echo.Context) error {
+		claims, ok := ClaimsFromContext(c)
+		assert.True(t, ok)
+		assert.Equal(t, "user-1", claims.UserID)
+		return c.NoContent(http.StatusOK)
+	}, Middleware(verifier), RequireVerifiedEmail())
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/videos", nil)
+			if tc.header != "" {
+				req.Header.Set("Authorization", tc.header)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			assert.Equal(t, tc.expected, rec.Code)
+		})
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	tests := map[string]struct {
+		claims   *Claims
+		expected int
+	}{
+		"admin can delete any video": {
+			claims:   &Claims{Roles: []string{RoleAdmin}, Permissions: []string{PermVideosDeleteAny, PermUsersManage}},
+			expected: http.StatusOK,
+		},
+		"creator can't": {
+			claims:   &Claims{Roles: []string{RoleCreator}, Permissions: []string{PermVideosUpload, PermVideosDeleteOwn}},
+			expected: http.StatusForbidden,
+		},
+		"unauthenticated": {
+			expected: http.StatusUnauthorized,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			e := echo.New()
+			e.DELETE("/videos/:id", func(c echo.Context) error {
+				return c.NoContent(http.StatusOK)
+			}, func(next echo.HandlerFunc) echo.HandlerFunc {
+				return func(c echo.Context) error {
+					if tc.claims != nil {
+						SetClaims(c, tc.claims)
+					}
+					return next(c)
+				}
+			}, RequirePermission(PermVideosDeleteAny))
+
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/videos/1", nil))
+			assert.Equal(t, tc.expected, rec.Code)
+		})
+	}
+}
+
+func TestServiceMiddleware(t *testing.T) {
+	tests := map[string]struct {
+		configured string
+		header     string
+		expected   int
+	}{
+		"matching token":     {configured: "service-token", header: "service-token", expected: http.StatusOK},
+		"wrong token":        {configured: "service-token", header: "other-token", expected: http.StatusUnauthorized},
+		"missing token":      {configured: "service-token", expected: http.StatusUnauthorized},
+		"nothing configured": {expected: http.StatusUnauthorized},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			e := echo.New()
+			e.GET("/internal/revocations", func(c echo.Context) error {
+				return c.NoContent(http.StatusOK)
+			}, ServiceMiddleware(tc.configured))
+
+			req := httptest.NewRequest(http.MethodGet, "/internal/revocations", nil)
+			if tc.header != "" {
+				req.Header.Set(HeaderServiceToken, tc.header)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			assert.Equal(t, tc.expected, rec.Code)
+		})
+	}
+}
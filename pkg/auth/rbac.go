@@ -0,0 +1,27 @@
+package auth
+
+import "slices"
+
+// Roles and permissions are stored in the users database, the constants
+// name the ones the services check. A token carries the roles of the user
+// and the permissions they grant, so services authorize without a lookup.
+const (
+	RoleAdmin   = "admin"
+	RoleCreator = "creator"
+	RoleViewer  = "viewer"
+
+	PermVideosWatch     = "videos:watch"
+	PermVideosUpload    = "videos:upload"
+	PermVideosDeleteOwn = "videos:delete:own"
+	PermVideosDeleteAny = "videos:delete:any"
+	PermUsersRead       = "users:read"
+	PermUsersManage     = "users:manage"
+)
+
+func (c *Claims) HasRole(role string) bool {
+	return slices.Contains(c.Roles, role)
+}
+
+func (c *Claims) HasPermission(permission string) bool {
+	return slices.Contains(c.Permissions, permission)
+}
@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	ErrTokenRevoked     = errors.New("token revoked")
+	ErrRevocationsStale = errors.New("revocation list is stale")
+)
+
+// RevocationChecker rejects access tokens before they expire, once their
+// session is revoked or their user suspended.
+type RevocationChecker interface {
+	Revoked(ctx context.Context, claims *Claims) (bool, error)
+}
+
+// Revocations is what the user service revoked during the lifetime of an
+// access token: sessions by ID and users by the time they were suspended
+// or deleted.
+type Revocations struct {
+	Sessions map[string]time.Time `json:"sessions"`
+	Users    map[string]time.Time `json:"users"`
+}
+
+func (r Revocations) Revoked(claims *Claims) bool {
+	if _, ok := r.Sessions[claims.SessionID]; ok {
+		return true
+	}
+	at, ok := r.Users[claims.UserID]
+	if !ok {
+		return false
+	}
+	return claims.IssuedAt == nil || !claims.IssuedAt.After(at)
+}
+
+// RemoteRevocationList polls the revocations of the user service with the
+// shared service token. When a fetch fails the previous list stays in use
+// until it is older than maxAge, then every check fails until a fetch
+// succeeds again. Before the first fetch every check fails too.
+type RemoteRevocationList struct {
+	url          string
+	serviceToken string
+	maxAge       time.Duration
+	client       *http.Client
+
+	mu        sync.RWMutex
+	list      Revocations
+	fetchedAt time.Time
+}
+
+func NewRemoteRevocationList(url string, serviceToken string, maxAge time.Duration) *RemoteRevocationList {
+	return &RemoteRevocationList{
+		url:          url,
+		serviceToken: serviceToken,
+		maxAge:       maxAge,
+		client:       &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+func (r *RemoteRevocationList) Revoked(ctx context.Context, claims *Claims) (bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if time.Since(r.fetchedAt) > r.maxAge {
+		return false, ErrRevocationsStale
+	}
+	return r.list.Revoked(claims), nil
+}
+
+// Run fetches the list every interval until ctx is done.
+func (r *RemoteRevocationList) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := r.Fetch(ctx); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *RemoteRevocationList) Fetch(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(HeaderServiceToken, r.serviceToken)
+	res, err := r.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch revocations: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch revocations: status %d", res.StatusCode)
+	}
+
+	var list Revocations
+	if err := json.NewDecoder(res.Body).Decode(&list); err != nil {
+		return fmt.Errorf("failed to decode revocations: %w", err)
+	}
+	r.mu.Lock()
+	r.list = list
+	r.fetchedAt = time.Now()
+	r.mu.Unlock()
+	return nil
+}
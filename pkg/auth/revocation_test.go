@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRemoteRevocationList(t *testing.T) {
+	suspendedAt := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(HeaderServiceToken) != "service-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(Revocations{
+			Sessions: map[string]time.Time{"session-1": suspendedAt},
+			Users:    map[string]time.Time{"user-2": suspendedAt},
+		})
+	}))
+	defer server.Close()
+
+	list := NewRemoteRevocationList(server.URL, "service-token", time.Minute)
+	_, err := list.Revoked(context.Background(), &Claims{UserID: "user-1"})
+	assert.ErrorIs(t, err, ErrRevocationsStale)
+	assert.NoError(t, list.Fetch(context.Background()))
+
+	issued := func(at time.Time) jwt.RegisteredClaims {
+		return jwt.RegisteredClaims{IssuedAt: jwt.NewNumericDate(at)}
+	}
+	tests := map[string]struct {
+		claims   Claims
+		expected bool
+	}{
+		"revoked session": {
+			claims:   Claims{UserID: "user-1", SessionID: "session-1", RegisteredClaims: issued(suspendedAt.Add(-time.Minute))},
+			expected: true,
+		},
+		"other session": {
+			claims: Claims{UserID: "user-1", SessionID: "session-2", RegisteredClaims: issued(suspendedAt.Add(-time.Minute))},
+		},
+		"token issued before the suspension": {
+			claims:   Claims{UserID: "user-2", SessionID: "session-3", RegisteredClaims: issued(suspendedAt.Add(-time.Minute))},
+			expected: true,
+		},
+		"token issued after the user was reinstated": {
+			claims: Claims{UserID: "user-2", SessionID: "session-4", RegisteredClaims: issued(suspendedAt.Add(time.Minute))},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			revoked, err := list.Revoked(context.Background(), &tc.claims)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, revoked)
+		})
+	}
+}
+
+func TestRemoteRevocationList_Stale(t *testing.T) {
+	failing := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(Revocations{})
+	}))
+	defer server.Close()
+
+	list := NewRemoteRevocationList(server.URL, "service-token", 50*time.Millisecond)
+	assert.NoError(t, list.Fetch(context.Background()))
+
+	failing = true
+	assert.Error(t, list.Fetch(context.Background()))
+	revoked, err := list.Revoked(context.Background(), &Claims{UserID: "user-1"})
+	assert.NoError(t, err)
+	assert.False(t, revoked)
+
+	time.Sleep(60 * time.Millisecond)
+	_, err = list.Revoked(context.Background(), &Claims{UserID: "user-1"})
+	assert.ErrorIs(t, err, ErrRevocationsStale)
+}
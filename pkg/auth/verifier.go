@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrUnknownKey     = errors.New("unknown signing key")
+	ErrNotAccessToken = errors.New("not an access token")
+)
+
+// Token types of the typ claim. Refresh tokens are only accepted by the
+// user service's renew endpoint, never as bearer tokens.
+const (
+	TokenTypeAccess  = "access"
+	TokenTypeRefresh = "refresh"
+)
+
+// KeySet resolves the verification key named by a token's kid header.
+type KeySet interface {
+	PublicKey(ctx context.Context, kid string) (PublicKey, error)
+}
+
+// Keyfunc looks the token's key up in keys and refuses tokens whose alg
+// header doesn't match the algorithm the key was published for.
+func Keyfunc(ctx context.Context, keys KeySet) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" {
+			return nil, errors.New("token has no kid header")
+		}
+		key, err := keys.PublicKey(ctx, kid)
+		if err != nil {
+			return nil, err
+		}
+		if token.Method.Alg() != key.Algorithm {
+			return nil, fmt.Errorf("token signed with %s but key %s is %s", token.Method.Alg(), kid, key.Algorithm)
+		}
+		return key.Key, nil
+	}
+}
+
+// Claims are the claims of the access tokens issued by the user service.
+type Claims struct {
+	UserID        string   `json:"id"`
+	Email         string   `json:"email"`
+	Plan          int8     `json:"plan"`
+	EmailVerified bool     `json:"email_verified"`
+	Roles         []string `json:"roles"`
+	Permissions   []string `json:"perms"`
+	SessionID     string   `json:"sid"`
+	Type          string   `json:"typ"`
+	// MaxStreams is how many videos the plan lets the account play at once.
+	MaxStreams int `json:"max_streams,omitempty"`
+	// ProfileID is the viewer profile selected on the session, MaxMaturity
+	// its maturity limit. Both are empty until a profile is selected.
+	ProfileID   string `json:"pid,omitempty"`
+	MaxMaturity int    `json:"max_maturity,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Verifier checks access tokens with public keys only, services other than
+// the user service never hold a signing key.
+type Verifier struct {
+	keys        KeySet
+	revocations RevocationChecker
+}
+
+type VerifierOption func(*Verifier)
+
+// WithRevocationCheck makes Verify reject the tokens revocations reports,
+// failing closed when the check itself fails, e.g. when a remote list is
+// stale.
+func WithRevocationCheck(revocations RevocationChecker) VerifierOption {
+	return func(v *Verifier) {
+		v.revocations = revocations
+	}
+}
+
+func NewVerifier(keys KeySet, opts ...VerifierOption) *Verifier {
+	v := &Verifier{
+		keys: keys,
+	}
+	for _, opt := range opts {
+		opt(v)
+	}
+	return v
+}
+
+func (v *Verifier) Verify(ctx context.Context, tokenStr string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(tokenStr, claims, Keyfunc(ctx, v.keys), jwt.WithValidMethods(ValidMethods))
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+	if claims.Type != TokenTypeAccess {
+		return nil, ErrNotAccessToken
+	}
+	if v.revocations != nil {
+		revoked, err := v.revocations.Revoked(ctx, claims)
+		if err != nil {
+			return nil, fmt.Errorf("error checking revocation: %w", err)
+		}
+		if revoked {
+			return nil, ErrTokenRevoked
+		}
+	}
+	return claims, nil
+}
@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifier(t *testing.T) {
+	edPub, edPriv, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	rsaPriv, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	var doc JWKS
+	for _, key := range []PublicKey{
+		{ID: "ed-1", Algorithm: AlgorithmEdDSA, Key: edPub},
+		{ID: "rsa-1", Algorithm: AlgorithmRS256, Key: &rsaPriv.PublicKey},
+	} {
+		jwk, err := NewJWK(key)
+		assert.NoError(t, err)
+		doc.Keys = append(doc.Keys, jwk)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(doc)
+	}))
+	defer server.Close()
+
+	sign := func(method jwt.SigningMethod, kid string, key interface{}, typ string, expiresIn time.Duration) string {
+		token := jwt.NewWithClaims(method, Claims{
+			UserID:    "user-1",
+			SessionID: "session-1",
+			Type:      typ,
+			RegisteredClaims: jwt.RegisteredClaims{
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiresIn)),
+			},
+		})
+		token.Header["kid"] = kid
+		signed, err := token.SignedString(key)
+		assert.NoError(t, err)
+		return signed
+	}
+
+	tests := map[string]struct {
+		token    string
+		expected bool
+	}{
+		"EdDSA token":           {token: sign(jwt.SigningMethodEdDSA, "ed-1", edPriv, TokenTypeAccess, time.Minute), expected: true},
+		"RS256 token":           {token: sign(jwt.SigningMethodRS256, "rsa-1", rsaPriv, TokenTypeAccess, time.Minute), expected: true},
+		"unknown kid":           {token: sign(jwt.SigningMethodEdDSA, "ed-2", edPriv, TokenTypeAccess, time.Minute)},
+		"alg doesn't match key": {token: sign(jwt.SigningMethodRS256, "ed-1", rsaPriv, TokenTypeAccess, time.Minute)},
+		"expired token":         {token: sign(jwt.SigningMethodEdDSA, "ed-1", edPriv, TokenTypeAccess, -time.Minute)},
+		"HMAC token is refused": {token: sign(jwt.SigningMethodHS256, "ed-1", []byte("secret"), TokenTypeAccess, time.Minute)},
+		"refresh token":         {token: sign(jwt.SigningMethodEdDSA, "ed-1", edPriv, TokenTypeRefresh, time.Minute)},
+		"token without a type":  {token: sign(jwt.SigningMethodEdDSA, "ed-1", edPriv, "", time.Minute)},
+	}
+
+	verifier := NewVerifier(NewRemoteKeySet(server.URL, time.Minute))
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			claims, err := verifier.Verify(context.Background(), tc.token)
+			if !tc.expected {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "user-1", claims.UserID)
+			assert.Equal(t, "session-1", claims.SessionID)
+		})
+	}
+}
@@ -0,0 +1,298 @@
+package configloader
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config structs describe every setting with struct tags:
+//
+//	yaml:"name"        key in the config file
+//	env:"NAME"         environment variable
+//	flag:"name"        command line flag
+//	default:"value"    value used when no source sets the field
+//	usage:"text"       flag help
+//	secret:"true"      redacted by Print
+//
+// Supported field types are string, bool, int, time.Duration and []string
+// (comma separated). Nested structs become sections of the config file.
+
+const redacted = "******"
+
+type Validator interface {
+	Validate() error
+}
+
+type Options struct {
+	ConfigFile  string
+	PrintConfig bool
+	Args        []string
+}
+
+type field struct {
+	path   string
+	value  reflect.Value
+	tag    reflect.StructTag
+	secret bool
+}
+
+// Load fills cfg from its defaults, the config file, the environment and the
+// command line flags, in increasing order of precedence. Every parse and
+// validation problem is collected so the caller can report all of them.
+func Load(cfg any, name string, args []string) (Options, error) {
+	var opts Options
+	fields, err := collectFields(cfg)
+	if err != nil {
+		return opts, err
+	}
+
+	var errs []error
+	for _, f := range fields {
+		if def, ok := f.tag.Lookup("default"); ok {
+			if err := setValue(f.value, def); err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid default %q: %w", f.path, def, err))
+			}
+		}
+	}
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&opts.ConfigFile, "config", os.Getenv("CONFIG_FILE"), "path to a YAML config file (env CONFIG_FILE)")
+	fs.BoolVar(&opts.PrintConfig, "print-config", false, "print the effective configuration with secrets redacted and exit")
+	flagValues := map[string]*flagValue{}
+	for _, f := range fields {
+		flagName := f.tag.Get("flag")
+		if flagName == "" {
+			continue
+		}
+		v := &flagValue{isBool: f.value.Kind() == reflect.Bool}
+		flagValues[flagName] = v
+		usage := f.tag.Get("usage")
+		if env := f.tag.Get("env"); env != "" {
+			usage = fmt.Sprintf("%s (env %s)", usage, env)
+		}
+		fs.Var(v, flagName, usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return opts, err
+	}
+	opts.Args = fs.Args()
+
+	if opts.ConfigFile != "" {
+		data, err := os.ReadFile(opts.ConfigFile)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("config file: %w", err))
+		} else if err := yaml.Unmarshal(data, cfg); err != nil {
+			errs = append(errs, fmt.Errorf("config file %s: %w", opts.ConfigFile, err))
+		}
+	}
+
+	for _, f := range fields {
+		env := f.tag.Get("env")
+		if env == "" {
+			continue
+		}
+		if raw, ok := os.LookupEnv(env); ok {
+			if err := setValue(f.value, raw); err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid value for %s: %w", f.path, env, err))
+			}
+		}
+	}
+
+	for _, f := range fields {
+		v, ok := flagValues[f.tag.Get("flag")]
+		if !ok || !v.set {
+			continue
+		}
+		if err := setValue(f.value, v.raw); err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid value for --%s: %w", f.path, f.tag.Get("flag"), err))
+		}
+	}
+
+	if v, ok := cfg.(Validator); ok {
+		if err := v.Validate(); err != nil {
+			if joined, ok := err.(interface{ Unwrap() []error }); ok {
+				errs = append(errs, joined.Unwrap()...)
+			} else {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return opts, errors.Join(errs...)
+}
+
+// Print writes the effective configuration as YAML with secrets redacted.
+func Print(w io.Writer, cfg any) error {
+	out, err := redactedTree(reflect.ValueOf(cfg))
+	if err != nil {
+		return err
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(out); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// Problems collects validation errors so Validate can report all of them.
+type Problems []error
+
+func (p *Problems) Addf(format string, args ...any) {
+	*p = append(*p, fmt.Errorf(format, args...))
+}
+
+func (p Problems) Err() error {
+	return errors.Join(p...)
+}
+
+func collectFields(cfg any) ([]field, error) {
+	v := reflect.ValueOf(cfg)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("config must be a pointer to a struct, got %T", cfg)
+	}
+	var fields []field
+	walk(v.Elem(), "", &fields)
+	return fields, nil
+}
+
+func walk(v reflect.Value, prefix string, fields *[]field) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		path := prefix + yamlName(sf)
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Struct && fv.Type() != reflect.TypeOf(time.Duration(0)) {
+			walk(fv, path+".", fields)
+			continue
+		}
+		*fields = append(*fields, field{
+			path:   path,
+			value:  fv,
+			tag:    sf.Tag,
+			secret: sf.Tag.Get("secret") == "true",
+		})
+	}
+}
+
+func yamlName(sf reflect.StructField) string {
+	name, _, _ := strings.Cut(sf.Tag.Get("yaml"), ",")
+	if name == "" {
+		return strings.ToLower(sf.Name)
+	}
+	return name
+}
+
+func setValue(v reflect.Value, raw string) error {
+	switch {
+	case v.Type() == reflect.TypeOf(time.Duration(0)):
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(raw)
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.Int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(n))
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported config type %s", v.Type())
+	}
+	return nil
+}
+
+func redactedTree(v reflect.Value) (map[string]any, error) {
+	if v.Kind() == reflect.Pointer {
+		v = v.Elem()
+	}
+	out := map[string]any{}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		fv := v.Field(i)
+		name := yamlName(sf)
+		switch {
+		case fv.Kind() == reflect.Struct && fv.Type() != reflect.TypeOf(time.Duration(0)):
+			sub, err := redactedTree(fv)
+			if err != nil {
+				return nil, err
+			}
+			out[name] = sub
+		case fv.Type() == reflect.TypeOf(time.Duration(0)):
+			out[name] = time.Duration(fv.Int()).String()
+		case sf.Tag.Get("secret") == "true":
+			out[name] = redact(fv.String())
+		default:
+			out[name] = fv.Interface()
+		}
+	}
+	return out, nil
+}
+
+// redact hides a secret value. Connection URLs keep everything but their
+// password so the printed config is still useful to debug.
+func redact(value string) string {
+	if value == "" {
+		return ""
+	}
+	if u, err := url.Parse(value); err == nil && u.Scheme != "" && u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), "xxxxx")
+			return u.String()
+		}
+	}
+	return redacted
+}
+
+type flagValue struct {
+	raw    string
+	set    bool
+	isBool bool
+}
+
+func (f *flagValue) String() string {
+	return f.raw
+}
+
+func (f *flagValue) Set(raw string) error {
+	f.raw = raw
+	f.set = true
+	return nil
+}
+
+func (f *flagValue) IsBoolFlag() bool {
+	return f.isBool
+}
@@ -0,0 +1,148 @@
+package events
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	SchemaVersion       = 1
+	ContentType         = "application/vnd.video-streaming.user-event+json"
+	HeaderContentType   = "content-type"
+	HeaderSchemaVersion = "schema-version"
+	HeaderEventType     = "event-type"
+
+	TypePlanChanged       = "user.plan_changed"
+	TypeDeleted           = "user.deleted"
+	TypeDeletionConfirmed = "user.deletion_confirmed"
+
+	DirectionUpgrade   = "upgrade"
+	DirectionDowngrade = "downgrade"
+)
+
+// UserEvent is the envelope the user service publishes on the user events
+// topic, keyed by user ID. Consumers must skip types they don't know.
+// Services answer user.deleted with a user.deletion_confirmed on the
+// deletion confirmations topic once they erased the user's data.
+type UserEvent struct {
+	Version    int         `json:"version"`
+	ID         string      `json:"id"`
+	Type       string      `json:"type"`
+	UserID     string      `json:"user_id"`
+	Plan       *PlanChange `json:"plan,omitempty"`
+	Deletion   *Deletion   `json:"deletion,omitempty"`
+	OccurredAt time.Time   `json:"occurred_at"`
+}
+
+type PlanChange struct {
+	From      int8   `json:"from"`
+	To        int8   `json:"to"`
+	Name      string `json:"name"`
+	Direction string `json:"direction"`
+	ChangedBy string `json:"changed_by"`
+}
+
+// Deletion identifies an account deletion, Service is the one confirming it.
+type Deletion struct {
+	ID      string `json:"id"`
+	Service string `json:"service,omitempty"`
+}
+
+func NewPlanChanged(userID string, change PlanChange) UserEvent {
+	return newUserEvent(TypePlanChanged, userID, func(e *UserEvent) {
+		e.Plan = &change
+	})
+}
+
+func NewDeleted(userID string, deletionID string) UserEvent {
+	return newUserEvent(TypeDeleted, userID, func(e *UserEvent) {
+		e.Deletion = &Deletion{ID: deletionID}
+	})
+}
+
+func NewDeletionConfirmed(userID string, deletionID string, service string) UserEvent {
+	return newUserEvent(TypeDeletionConfirmed, userID, func(e *UserEvent) {
+		e.Deletion = &Deletion{ID: deletionID, Service: service}
+	})
+}
+
+func newUserEvent(eventType string, userID string, set func(*UserEvent)) UserEvent {
+	e := UserEvent{
+		Version:    SchemaVersion,
+		ID:         newEventID(),
+		Type:       eventType,
+		UserID:     userID,
+		OccurredAt: time.Now().UTC(),
+	}
+	set(&e)
+	return e
+}
+
+func (e UserEvent) Validate() error {
+	var errs []error
+
+	if e.Version != SchemaVersion {
+		errs = append(errs, fmt.Errorf("unsupported schema version %d", e.Version))
+	}
+	if e.ID == "" {
+		errs = append(errs, errors.New("id is required"))
+	}
+	if e.Type == "" {
+		errs = append(errs, errors.New("type is required"))
+	}
+	if e.UserID == "" {
+		errs = append(errs, errors.New("user_id is required"))
+	}
+	if e.OccurredAt.IsZero() {
+		errs = append(errs, errors.New("occurred_at is required"))
+	}
+	if e.Type == TypePlanChanged {
+		switch {
+		case e.Plan == nil:
+			errs = append(errs, errors.New("plan is required"))
+		case e.Plan.Direction != DirectionUpgrade && e.Plan.Direction != DirectionDowngrade:
+			errs = append(errs, fmt.Errorf("unknown direction %q", e.Plan.Direction))
+		}
+	}
+	if e.Type == TypeDeleted || e.Type == TypeDeletionConfirmed {
+		switch {
+		case e.Deletion == nil || e.Deletion.ID == "":
+			errs = append(errs, errors.New("deletion id is required"))
+		case e.Type == TypeDeletionConfirmed && e.Deletion.Service == "":
+			errs = append(errs, errors.New("deletion service is required"))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func Encode(e UserEvent) ([]byte, error) {
+	if err := e.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid user event: %w", err)
+	}
+	return json.Marshal(e)
+}
+
+func Decode(value []byte) (UserEvent, error) {
+	var e UserEvent
+	if err := json.Unmarshal(value, &e); err != nil {
+		return UserEvent{}, fmt.Errorf("malformed user event: %w", err)
+	}
+	if err := e.Validate(); err != nil {
+		return UserEvent{}, fmt.Errorf("invalid user event: %w", err)
+	}
+	return e, nil
+}
+
+func newEventID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,71 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecode(t *testing.T) {
+	tests := map[string]struct {
+		value  string
+		expect bool
+	}{
+		"plan changed": {
+			value:  `{"version":1,"id":"abc","type":"user.plan_changed","user_id":"user-1","plan":{"from":0,"to":2,"name":"premium","direction":"upgrade"},"occurred_at":"2025-01-01T00:00:00Z"}`,
+			expect: true,
+		},
+		"plan changed without plan": {
+			value:  `{"version":1,"id":"abc","type":"user.plan_changed","user_id":"user-1","occurred_at":"2025-01-01T00:00:00Z"}`,
+			expect: false,
+		},
+		"deleted": {
+			value:  `{"version":1,"id":"abc","type":"user.deleted","user_id":"user-1","deletion":{"id":"deletion-1"},"occurred_at":"2025-01-01T00:00:00Z"}`,
+			expect: true,
+		},
+		"deleted without deletion": {
+			value:  `{"version":1,"id":"abc","type":"user.deleted","user_id":"user-1","occurred_at":"2025-01-01T00:00:00Z"}`,
+			expect: false,
+		},
+		"deletion confirmed without service": {
+			value:  `{"version":1,"id":"abc","type":"user.deletion_confirmed","user_id":"user-1","deletion":{"id":"deletion-1"},"occurred_at":"2025-01-01T00:00:00Z"}`,
+			expect: false,
+		},
+		"unknown type is decoded": {
+			value:  `{"version":1,"id":"abc","type":"user.renamed","user_id":"user-1","occurred_at":"2025-01-01T00:00:00Z"}`,
+			expect: true,
+		},
+		"unsupported version": {
+			value:  `{"version":2,"id":"abc","type":"user.renamed","user_id":"user-1","occurred_at":"2025-01-01T00:00:00Z"}`,
+			expect: false,
+		},
+		"malformed json": {
+			value:  `{"version":1,`,
+			expect: false,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Decode([]byte(tc.value))
+			if tc.expect {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestEncode_RoundTrip(t *testing.T) {
+	event := NewPlanChanged("user-1", PlanChange{From: 0, To: 1, Name: "standard", Direction: DirectionUpgrade, ChangedBy: "user-1"})
+
+	value, err := Encode(event)
+	assert.NoError(t, err)
+
+	decoded, err := Decode(value)
+	assert.NoError(t, err)
+	assert.Equal(t, event.ID, decoded.ID)
+	assert.Equal(t, TypePlanChanged, decoded.Type)
+	assert.Equal(t, int8(1), decoded.Plan.To)
+}
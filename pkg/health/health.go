@@ -0,0 +1,113 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	StatusOK          = "ok"
+	StatusUnavailable = "unavailable"
+)
+
+type Check func(ctx context.Context) error
+
+// Checker serves the liveness and readiness probes of a service. Liveness
+// only tells the process is running, readiness runs every registered
+// dependency check and fails as soon as the service starts shutting down.
+type Checker struct {
+	mu           sync.RWMutex
+	checks       map[string]Check
+	timeout      time.Duration
+	shuttingDown atomic.Bool
+}
+
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+func NewChecker(timeout time.Duration) *Checker {
+	return &Checker{
+		checks:  map[string]Check{},
+		timeout: timeout,
+	}
+}
+
+func (h *Checker) Add(name string, check Check) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checks[name] = check
+}
+
+func (h *Checker) SetShuttingDown() {
+	h.shuttingDown.Store(true)
+}
+
+func (h *Checker) Check(ctx context.Context) Report {
+	report := Report{Status: StatusOK, Checks: map[string]string{}}
+	if h.shuttingDown.Load() {
+		report.Status = StatusUnavailable
+		report.Checks["shutdown"] = "service is shutting down"
+		return report
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	var (
+		wg      sync.WaitGroup
+		results sync.Map
+	)
+	for name, check := range h.checks {
+		wg.Add(1)
+		go func(name string, check Check) {
+			defer wg.Done()
+			if err := check(ctx); err != nil {
+				results.Store(name, err.Error())
+				return
+			}
+			results.Store(name, StatusOK)
+		}(name, check)
+	}
+	wg.Wait()
+
+	results.Range(func(key, value any) bool {
+		report.Checks[key.(string)] = value.(string)
+		if value.(string) != StatusOK {
+			report.Status = StatusUnavailable
+		}
+		return true
+	})
+	return report
+}
+
+func (h *Checker) LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeReport(w, http.StatusOK, Report{Status: StatusOK})
+	})
+}
+
+func (h *Checker) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := h.Check(r.Context())
+		status := http.StatusOK
+		if report.Status != StatusOK {
+			status = http.StatusServiceUnavailable
+		}
+		writeReport(w, status, report)
+	})
+}
+
+func writeReport(w http.ResponseWriter, status int, report Report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,68 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChecker_Readiness(t *testing.T) {
+	tests := map[string]struct {
+		checks       map[string]Check
+		shuttingDown bool
+		expected     int
+	}{
+		"all dependencies up": {
+			checks: map[string]Check{
+				"postgres": func(ctx context.Context) error { return nil },
+				"s3":       func(ctx context.Context) error { return nil },
+			},
+			expected: http.StatusOK,
+		},
+		"dependency down": {
+			checks: map[string]Check{
+				"postgres": func(ctx context.Context) error { return nil },
+				"kafka":    func(ctx context.Context) error { return errors.New("no brokers") },
+			},
+			expected: http.StatusServiceUnavailable,
+		},
+		"check timeout": {
+			checks: map[string]Check{
+				"s3": func(ctx context.Context) error {
+					<-ctx.Done()
+					return ctx.Err()
+				},
+			},
+			expected: http.StatusServiceUnavailable,
+		},
+		"shutting down": {
+			checks:       map[string]Check{},
+			shuttingDown: true,
+			expected:     http.StatusServiceUnavailable,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := NewChecker(50 * time.Millisecond)
+			for n, c := range tc.checks {
+				h.Add(n, c)
+			}
+			if tc.shuttingDown {
+				h.SetShuttingDown()
+			}
+
+			rec := httptest.NewRecorder()
+			h.ReadinessHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			assert.Equal(t, tc.expected, rec.Code)
+
+			rec = httptest.NewRecorder()
+			h.LivenessHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+			assert.Equal(t, http.StatusOK, rec.Code)
+		})
+	}
+}
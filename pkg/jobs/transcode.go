@@ -0,0 +1,151 @@
+package jobs
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SchemaVersion       = 1
+	ContentType         = "application/vnd.video-streaming.transcode-job+json"
+	HeaderContentType   = "content-type"
+	HeaderSchemaVersion = "schema-version"
+
+	ProfileHLSBaseline = "hls-baseline"
+	OutputHLS          = "hls"
+)
+
+var (
+	knownProfiles = map[string]bool{ProfileHLSBaseline: true}
+	knownOutputs  = map[string]bool{OutputHLS: true}
+)
+
+// TranscodeJob is the envelope video_store publishes on the transcoding topic.
+type TranscodeJob struct {
+	Version   int               `json:"version"`
+	JobID     string            `json:"job_id"`
+	VideoID   string            `json:"video_id"`
+	SourceKey string            `json:"source_key"`
+	Profile   string            `json:"profile"`
+	Outputs   []string          `json:"outputs"`
+	Owner     string            `json:"owner,omitempty"`
+	Trace     map[string]string `json:"trace,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+func NewTranscodeJob(videoID string, sourceKey string, owner string) TranscodeJob {
+	return TranscodeJob{
+		Version:   SchemaVersion,
+		JobID:     newJobID(),
+		VideoID:   videoID,
+		SourceKey: sourceKey,
+		Profile:   ProfileHLSBaseline,
+		Outputs:   []string{OutputHLS},
+		Owner:     owner,
+		Trace:     map[string]string{},
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+func SourceKey(videoID string, filename string) string {
+	return fmt.Sprintf("videos/%s/%s", videoID, filename)
+}
+
+func (j TranscodeJob) Validate() error {
+	var errs []error
+
+	if j.Version != SchemaVersion {
+		errs = append(errs, fmt.Errorf("unsupported schema version %d", j.Version))
+	}
+	if j.JobID == "" {
+		errs = append(errs, errors.New("job_id is required"))
+	}
+	if id, err := strconv.Atoi(j.VideoID); err != nil || id < 1 {
+		errs = append(errs, fmt.Errorf("invalid video_id %q", j.VideoID))
+	}
+	if !validSourceKey(j.SourceKey) {
+		errs = append(errs, fmt.Errorf("invalid source_key %q", j.SourceKey))
+	}
+	if !knownProfiles[j.Profile] {
+		errs = append(errs, fmt.Errorf("unknown profile %q", j.Profile))
+	}
+	if len(j.Outputs) == 0 {
+		errs = append(errs, errors.New("at least one output is required"))
+	}
+	for _, output := range j.Outputs {
+		if !knownOutputs[output] {
+			errs = append(errs, fmt.Errorf("unknown output %q", output))
+		}
+	}
+	if j.CreatedAt.IsZero() {
+		errs = append(errs, errors.New("created_at is required"))
+	}
+
+	return errors.Join(errs...)
+}
+
+func Encode(job TranscodeJob) ([]byte, error) {
+	if err := job.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid transcode job: %w", err)
+	}
+	return json.Marshal(job)
+}
+
+// Decode parses a message value into a job. Values that are not JSON are
+// read as the legacy "id/filename" format so jobs published by older
+// video_store replicas keep working during rollout.
+func Decode(key string, value []byte) (TranscodeJob, error) {
+	trimmed := bytes.TrimSpace(value)
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		var job TranscodeJob
+		if err := json.Unmarshal(trimmed, &job); err != nil {
+			return TranscodeJob{}, fmt.Errorf("malformed transcode job: %w", err)
+		}
+		if err := job.Validate(); err != nil {
+			return TranscodeJob{}, fmt.Errorf("invalid transcode job: %w", err)
+		}
+		return job, nil
+	}
+	return decodeLegacy(key, string(trimmed))
+}
+
+func decodeLegacy(key string, content string) (TranscodeJob, error) {
+	prefix, filename, ok := strings.Cut(content, "/")
+	if !ok || prefix == "" || filename == "" {
+		return TranscodeJob{}, fmt.Errorf("invalid legacy transcode job %q", content)
+	}
+
+	job := TranscodeJob{
+		Version:   SchemaVersion,
+		JobID:     "legacy-" + key,
+		VideoID:   key,
+		SourceKey: "videos/" + content,
+		Profile:   ProfileHLSBaseline,
+		Outputs:   []string{OutputHLS},
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := job.Validate(); err != nil {
+		return TranscodeJob{}, fmt.Errorf("invalid legacy transcode job: %w", err)
+	}
+	return job, nil
+}
+
+func validSourceKey(key string) bool {
+	return strings.HasPrefix(key, "videos/") && path.Clean(key) == key && len(key) > len("videos/")
+}
+
+func newJobID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,94 @@
+package jobs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecode(t *testing.T) {
+	tests := map[string]struct {
+		key       string
+		value     string
+		expect    bool
+		sourceKey string
+	}{
+		"valid envelope": {
+			key:       "42",
+			value:     `{"version":1,"job_id":"abc","video_id":"42","source_key":"videos/42/video.mp4","profile":"hls-baseline","outputs":["hls"],"created_at":"2025-01-01T00:00:00Z"}`,
+			expect:    true,
+			sourceKey: "videos/42/video.mp4",
+		},
+		"envelope with slash in filename": {
+			key:       "42",
+			value:     `{"version":1,"job_id":"abc","video_id":"42","source_key":"videos/42/holiday/beach.mp4","profile":"hls-baseline","outputs":["hls"],"created_at":"2025-01-01T00:00:00Z"}`,
+			expect:    true,
+			sourceKey: "videos/42/holiday/beach.mp4",
+		},
+		"unsupported version": {
+			key:    "42",
+			value:  `{"version":2,"job_id":"abc","video_id":"42","source_key":"videos/42/video.mp4","profile":"hls-baseline","outputs":["hls"],"created_at":"2025-01-01T00:00:00Z"}`,
+			expect: false,
+		},
+		"path traversal in source key": {
+			key:    "42",
+			value:  `{"version":1,"job_id":"abc","video_id":"42","source_key":"videos/../secrets","profile":"hls-baseline","outputs":["hls"],"created_at":"2025-01-01T00:00:00Z"}`,
+			expect: false,
+		},
+		"unknown output": {
+			key:    "42",
+			value:  `{"version":1,"job_id":"abc","video_id":"42","source_key":"videos/42/video.mp4","profile":"hls-baseline","outputs":["dash"],"created_at":"2025-01-01T00:00:00Z"}`,
+			expect: false,
+		},
+		"malformed json": {
+			key:    "42",
+			value:  `{"version":1,`,
+			expect: false,
+		},
+		"legacy format": {
+			key:       "42",
+			value:     "42/video123.mp4",
+			expect:    true,
+			sourceKey: "videos/42/video123.mp4",
+		},
+		"legacy empty content": {
+			key:    "42",
+			value:  "",
+			expect: false,
+		},
+		"legacy invalid id": {
+			key:    "0",
+			value:  "0/video123.mp4",
+			expect: false,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			job, err := Decode(tc.key, []byte(tc.value))
+			if !tc.expect {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.sourceKey, job.SourceKey)
+		})
+	}
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	job := NewTranscodeJob("42", SourceKey("42", "video.mp4"), "user-1")
+	job.Trace["traceparent"] = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	value, err := Encode(job)
+	assert.NoError(t, err)
+
+	decoded, err := Decode("42", value)
+	assert.NoError(t, err)
+	assert.Equal(t, job.JobID, decoded.JobID)
+	assert.Equal(t, job.Trace, decoded.Trace)
+	assert.Equal(t, job.CreatedAt.Truncate(time.Second), decoded.CreatedAt.Truncate(time.Second))
+
+	_, err = Encode(TranscodeJob{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,101 @@
+package messagebus_test
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eduardo-ax/video-streaming/pkg/events"
+	"github.com/eduardo-ax/video-streaming/pkg/messagebus"
+	"github.com/eduardo-ax/video-streaming/pkg/messagebus/kafka"
+	"github.com/eduardo-ax/video-streaming/pkg/messagebus/postgres"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestDeletionCascade runs the account deletion round trip between the user
+// service and video_store, each on its own bus, over every driver the
+// services support. A driver is skipped unless its environment is set:
+// MESSAGE_BUS_TEST_DATABASE_URL for postgres, MESSAGE_BUS_TEST_KAFKA_BROKERS
+// for kafka.
+func TestDeletionCascade(t *testing.T) {
+	policy := messagebus.DefaultRetryPolicy()
+
+	drivers := map[string]func(t *testing.T) messagebus.Bus{
+		"postgres": func(t *testing.T) messagebus.Bus {
+			dsn := os.Getenv("MESSAGE_BUS_TEST_DATABASE_URL")
+			if dsn == "" {
+				t.Skip("MESSAGE_BUS_TEST_DATABASE_URL is not set")
+			}
+			pool, err := pgxpool.New(context.Background(), dsn)
+			assert.NoError(t, err)
+			bus := postgres.NewBus(pool, policy, time.Minute)
+			assert.NoError(t, bus.EnsureSchema(context.Background()))
+			return bus
+		},
+		"kafka": func(t *testing.T) messagebus.Bus {
+			brokers := os.Getenv("MESSAGE_BUS_TEST_KAFKA_BROKERS")
+			if brokers == "" {
+				t.Skip("MESSAGE_BUS_TEST_KAFKA_BROKERS is not set")
+			}
+			bus, err := kafka.NewBus(strings.Split(brokers, ","), policy)
+			assert.NoError(t, err)
+			return bus
+		},
+	}
+
+	for name, newBus := range drivers {
+		t.Run(name, func(t *testing.T) {
+			userBus := newBus(t)
+			defer userBus.Close()
+			videoBus := newBus(t)
+			defer videoBus.Close()
+
+			suffix := strconv.FormatInt(time.Now().UnixNano(), 36)
+			userEvents := "user-events-" + suffix
+			confirmations := "user-deletion-confirmations-" + suffix
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+
+			go videoBus.Subscribe(ctx, userEvents, "video_store-"+suffix, func(ctx context.Context, msg *messagebus.Message) error {
+				event, err := events.Decode(msg.Value)
+				if err != nil || event.Type != events.TypeDeleted {
+					return err
+				}
+				value, err := events.Encode(events.NewDeletionConfirmed(event.UserID, event.Deletion.ID, "video_store"))
+				if err != nil {
+					return err
+				}
+				return videoBus.Publish(ctx, confirmations, messagebus.NewMessage(event.UserID, value))
+			})
+
+			confirmed := make(chan events.UserEvent, 1)
+			go userBus.Subscribe(ctx, confirmations, "user-"+suffix, func(ctx context.Context, msg *messagebus.Message) error {
+				event, err := events.Decode(msg.Value)
+				if err != nil {
+					return err
+				}
+				confirmed <- event
+				return nil
+			})
+
+			value, err := events.Encode(events.NewDeleted("user-1", "deletion-1"))
+			assert.NoError(t, err)
+			assert.NoError(t, userBus.Publish(ctx, userEvents, messagebus.NewMessage("user-1", value)))
+
+			select {
+			case event := <-confirmed:
+				assert.Equal(t, events.TypeDeletionConfirmed, event.Type)
+				assert.Equal(t, "user-1", event.UserID)
+				assert.Equal(t, "deletion-1", event.Deletion.ID)
+				assert.Equal(t, "video_store", event.Deletion.Service)
+			case <-ctx.Done():
+				t.Fatal("the deletion was never confirmed")
+			}
+		})
+	}
+}
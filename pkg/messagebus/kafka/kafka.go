@@ -0,0 +1,190 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/eduardo-ax/video-streaming/pkg/messagebus"
+)
+
+const headerAttempt = "x-attempt"
+
+type Bus struct {
+	brokers      []string
+	policy       messagebus.RetryPolicy
+	client       sarama.Client
+	syncProducer sarama.SyncProducer
+}
+
+func NewBus(brokers []string, policy messagebus.RetryPolicy) (*Bus, error) {
+	config := sarama.NewConfig()
+	config.Producer.RequiredAcks = sarama.WaitForAll
+	config.Producer.Retry.Max = 5
+	config.Producer.Return.Successes = true
+	config.Version = sarama.V3_0_0_0
+	client, err := sarama.NewClient(brokers, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Sarama client: %w", err)
+	}
+	syncProducer, err := sarama.NewSyncProducerFromClient(client)
+	if err != nil {
+		_ = client.Close()
+		return nil, fmt.Errorf("failed to create Sarama producer: %w", err)
+	}
+
+	return &Bus{
+		brokers:      brokers,
+		policy:       policy,
+		client:       client,
+		syncProducer: syncProducer,
+	}, nil
+}
+
+func (b *Bus) Close() error {
+	if err := b.syncProducer.Close(); err != nil {
+		return err
+	}
+	return b.client.Close()
+}
+
+func (b *Bus) Ping(ctx context.Context) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- b.client.RefreshMetadata()
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("kafka unreachable: %w", err)
+		}
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("kafka unreachable: %w", ctx.Err())
+	}
+}
+
+func (b *Bus) Publish(ctx context.Context, topic string, msg *messagebus.Message) error {
+	producerMsg := &sarama.ProducerMessage{
+		Topic: topic,
+		Key:   sarama.StringEncoder(msg.Key),
+		Value: sarama.ByteEncoder(msg.Value),
+	}
+	for k, v := range msg.Headers {
+		producerMsg.Headers = append(producerMsg.Headers, sarama.RecordHeader{Key: []byte(k), Value: []byte(v)})
+	}
+	_, _, err := b.syncProducer.SendMessage(producerMsg)
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	return nil
+}
+
+// Subscribe joins the consumer group and blocks until ctx is cancelled. Kafka
+// has no per message nack, so a nacked message is retried in place following
+// the retry policy and then moved to the "<topic>.dlq" topic.
+func (b *Bus) Subscribe(ctx context.Context, topic string, group string, handler messagebus.Handler) error {
+	config := sarama.NewConfig()
+	config.Version = sarama.V3_0_0_0
+	config.Consumer.Return.Errors = true
+	config.Consumer.Offsets.Initial = sarama.OffsetOldest
+
+	consumerGroup, err := sarama.NewConsumerGroup(b.brokers, group, config)
+	if err != nil {
+		return fmt.Errorf("failed to create consumer group: %w", err)
+	}
+	defer consumerGroup.Close()
+
+	go func() {
+		for err := range consumerGroup.Errors() {
+			log.Printf("consumer error: %v", err)
+		}
+	}()
+
+	log.Printf("listening to topic %s as group %s", topic, group)
+
+	groupHandler := &groupHandler{bus: b, handler: handler}
+	for {
+		if err := consumerGroup.Consume(ctx, []string{topic}, groupHandler); err != nil {
+			if errors.Is(err, sarama.ErrClosedConsumerGroup) {
+				return nil
+			}
+			return fmt.Errorf("failed to consume topic %s: %w", topic, err)
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+type groupHandler struct {
+	bus     *Bus
+	handler messagebus.Handler
+}
+
+func (h *groupHandler) Setup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+func (h *groupHandler) Cleanup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+func (h *groupHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	for {
+		select {
+		case consumerMsg, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			msg := toMessage(consumerMsg)
+			if h.process(session.Context(), msg) {
+				session.MarkMessage(consumerMsg, "")
+			}
+		case <-session.Context().Done():
+			return nil
+		}
+	}
+}
+
+// process reports whether the message offset can be committed.
+func (h *groupHandler) process(ctx context.Context, msg *messagebus.Message) bool {
+	for {
+		msg.Attempt++
+		err := h.handler(ctx, msg)
+		if err == nil {
+			return true
+		}
+		if !h.bus.policy.ShouldRetry(msg.Attempt, err) {
+			log.Printf("message %s on %s failed after %d attempts: %v", msg.ID, msg.Topic, msg.Attempt, err)
+			msg.SetHeader(headerAttempt, fmt.Sprintf("%d", msg.Attempt))
+			if dlqErr := h.bus.Publish(ctx, msg.Topic+".dlq", msg); dlqErr != nil {
+				log.Printf("failed to move message %s to dead letter topic: %v", msg.ID, dlqErr)
+			}
+			return true
+		}
+		select {
+		case <-time.After(h.bus.policy.Delay(msg.Attempt)):
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+func toMessage(consumerMsg *sarama.ConsumerMessage) *messagebus.Message {
+	msg := &messagebus.Message{
+		ID:        fmt.Sprintf("%d-%d", consumerMsg.Partition, consumerMsg.Offset),
+		Topic:     consumerMsg.Topic,
+		Key:       string(consumerMsg.Key),
+		Value:     consumerMsg.Value,
+		Headers:   make(map[string]string, len(consumerMsg.Headers)),
+		Timestamp: consumerMsg.Timestamp,
+	}
+	for _, h := range consumerMsg.Headers {
+		msg.Headers[string(h.Key)] = string(h.Value)
+	}
+	return msg
+}
@@ -0,0 +1,172 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/eduardo-ax/video-streaming/pkg/messagebus"
+)
+
+const queueSize = 1024
+
+// Bus is an in-process message bus. Every consumer group of a topic receives
+// each message once, and subscribers sharing a group compete for messages.
+// It is meant for tests and single binary deployments.
+type Bus struct {
+	mu          sync.Mutex
+	topics      map[string]*topic
+	policy      messagebus.RetryPolicy
+	seq         atomic.Int64
+	done        chan struct{}
+	closeOnce   sync.Once
+	deadLetters []*messagebus.Message
+}
+
+type topic struct {
+	groups  map[string]chan *messagebus.Message
+	backlog []*messagebus.Message
+}
+
+func NewBus(policy messagebus.RetryPolicy) *Bus {
+	return &Bus{
+		topics: map[string]*topic{},
+		policy: policy,
+		done:   make(chan struct{}),
+	}
+}
+
+func (b *Bus) Publish(ctx context.Context, topicName string, msg *messagebus.Message) error {
+	if b.isClosed() {
+		return messagebus.ErrClosed
+	}
+
+	delivery := cloneMessage(msg)
+	delivery.ID = strconv.FormatInt(b.seq.Add(1), 10)
+	delivery.Topic = topicName
+	delivery.Timestamp = time.Now()
+
+	b.mu.Lock()
+	t := b.topic(topicName)
+	if len(t.groups) == 0 {
+		t.backlog = append(t.backlog, delivery)
+		b.mu.Unlock()
+		return nil
+	}
+	queues := make([]chan *messagebus.Message, 0, len(t.groups))
+	for _, q := range t.groups {
+		queues = append(queues, q)
+	}
+	b.mu.Unlock()
+
+	for _, q := range queues {
+		if err := b.enqueue(ctx, q, cloneMessage(delivery)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Bus) Subscribe(ctx context.Context, topicName string, group string, handler messagebus.Handler) error {
+	if b.isClosed() {
+		return messagebus.ErrClosed
+	}
+
+	b.mu.Lock()
+	t := b.topic(topicName)
+	q, ok := t.groups[group]
+	if !ok {
+		backlog := t.backlog
+		t.backlog = nil
+		q = make(chan *messagebus.Message, max(queueSize, 2*len(backlog)))
+		t.groups[group] = q
+		for _, msg := range backlog {
+			q <- msg
+		}
+	}
+	b.mu.Unlock()
+
+	for {
+		select {
+		case msg := <-q:
+			msg.Attempt++
+			err := handler(ctx, msg)
+			if err == nil {
+				continue
+			}
+			if !b.policy.ShouldRetry(msg.Attempt, err) {
+				b.deadLetter(msg)
+				continue
+			}
+			time.AfterFunc(b.policy.Delay(msg.Attempt), func() {
+				_ = b.enqueue(context.Background(), q, msg)
+			})
+		case <-ctx.Done():
+			return nil
+		case <-b.done:
+			return nil
+		}
+	}
+}
+
+// DeadLetters returns the messages that exhausted their retries.
+func (b *Bus) DeadLetters() []*messagebus.Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]*messagebus.Message(nil), b.deadLetters...)
+}
+
+func (b *Bus) Close() error {
+	b.closeOnce.Do(func() {
+		close(b.done)
+	})
+	return nil
+}
+
+func (b *Bus) topic(name string) *topic {
+	t, ok := b.topics[name]
+	if !ok {
+		t = &topic{groups: map[string]chan *messagebus.Message{}}
+		b.topics[name] = t
+	}
+	return t
+}
+
+func (b *Bus) enqueue(ctx context.Context, q chan *messagebus.Message, msg *messagebus.Message) error {
+	select {
+	case q <- msg:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("failed to publish message: %w", ctx.Err())
+	case <-b.done:
+		return messagebus.ErrClosed
+	}
+}
+
+func (b *Bus) deadLetter(msg *messagebus.Message) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.deadLetters = append(b.deadLetters, msg)
+}
+
+func (b *Bus) isClosed() bool {
+	select {
+	case <-b.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func cloneMessage(msg *messagebus.Message) *messagebus.Message {
+	c := *msg
+	c.Value = append([]byte(nil), msg.Value...)
+	c.Headers = make(map[string]string, len(msg.Headers))
+	for k, v := range msg.Headers {
+		c.Headers[k] = v
+	}
+	return &c
+}
@@ -0,0 +1,131 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eduardo-ax/video-streaming/pkg/messagebus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBus_Delivery(t *testing.T) {
+	policy := messagebus.RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}
+
+	tests := map[string]struct {
+		failures     int
+		permanent    bool
+		expectCalls  int
+		expectAcked  bool
+		expectDeadLt int
+	}{
+		"ack on first attempt": {
+			failures:    0,
+			expectCalls: 1,
+			expectAcked: true,
+		},
+		"nack is redelivered": {
+			failures:    2,
+			expectCalls: 3,
+			expectAcked: true,
+		},
+		"retries exhausted go to dead letters": {
+			failures:     5,
+			expectCalls:  3,
+			expectDeadLt: 1,
+		},
+		"permanent error is not redelivered": {
+			failures:     5,
+			permanent:    true,
+			expectCalls:  1,
+			expectDeadLt: 1,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			bus := NewBus(policy)
+			defer bus.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			var (
+				mu    sync.Mutex
+				calls int
+				acked bool
+			)
+			done := make(chan struct{})
+			go bus.Subscribe(ctx, "transcoding", "transcoder", func(ctx context.Context, msg *messagebus.Message) error {
+				mu.Lock()
+				defer mu.Unlock()
+				calls++
+				assert.Equal(t, "42", msg.Key)
+				assert.Equal(t, "trace", msg.Header("traceparent"))
+				if calls <= tc.failures {
+					err := errors.New("boom")
+					if tc.permanent {
+						err = messagebus.Permanent(err)
+					}
+					if calls == tc.expectCalls {
+						close(done)
+					}
+					return err
+				}
+				acked = true
+				close(done)
+				return nil
+			})
+
+			msg := messagebus.NewMessage("42", []byte("payload"))
+			msg.SetHeader("traceparent", "trace")
+			assert.NoError(t, bus.Publish(ctx, "transcoding", msg))
+
+			select {
+			case <-done:
+			case <-ctx.Done():
+				t.Fatal("message was not delivered")
+			}
+			time.Sleep(10 * time.Millisecond)
+
+			mu.Lock()
+			defer mu.Unlock()
+			assert.Equal(t, tc.expectCalls, calls)
+			assert.Equal(t, tc.expectAcked, acked)
+			assert.Len(t, bus.DeadLetters(), tc.expectDeadLt)
+		})
+	}
+}
+
+func TestBus_ConsumerGroups(t *testing.T) {
+	bus := NewBus(messagebus.DefaultRetryPolicy())
+	defer bus.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	received := make(chan string, 4)
+	for _, group := range []string{"a", "b"} {
+		group := group
+		go bus.Subscribe(ctx, "events", group, func(ctx context.Context, msg *messagebus.Message) error {
+			received <- group
+			return nil
+		})
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	assert.NoError(t, bus.Publish(ctx, "events", messagebus.NewMessage("1", nil)))
+
+	groups := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case g := <-received:
+			groups[g] = true
+		case <-ctx.Done():
+			t.Fatal("message was not delivered to every group")
+		}
+	}
+	assert.Equal(t, map[string]bool{"a": true, "b": true}, groups)
+}
@@ -0,0 +1,126 @@
+package messagebus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Message is the broker independent unit of work exchanged between services.
+type Message struct {
+	ID        string
+	Topic     string
+	Key       string
+	Value     []byte
+	Headers   map[string]string
+	Attempt   int
+	Timestamp time.Time
+}
+
+func NewMessage(key string, value []byte) *Message {
+	return &Message{
+		Key:     key,
+		Value:   value,
+		Headers: map[string]string{},
+	}
+}
+
+func (m *Message) Header(key string) string {
+	if m.Headers == nil {
+		return ""
+	}
+	return m.Headers[key]
+}
+
+func (m *Message) SetHeader(key string, value string) {
+	if m.Headers == nil {
+		m.Headers = map[string]string{}
+	}
+	m.Headers[key] = value
+}
+
+// Handler processes a delivered message. Returning nil acks the message,
+// returning an error nacks it so the bus can redeliver it later. Errors
+// wrapped with Permanent are never redelivered.
+type Handler func(ctx context.Context, msg *Message) error
+
+type Publisher interface {
+	Publish(ctx context.Context, topic string, msg *Message) error
+	Close() error
+}
+
+type Subscriber interface {
+	Subscribe(ctx context.Context, topic string, group string, handler Handler) error
+	Close() error
+}
+
+type Bus interface {
+	Publisher
+	Subscriber
+}
+
+// Pinger is implemented by buses able to report broker connectivity.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+var ErrClosed = errors.New("message bus closed")
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return fmt.Sprintf("permanent failure: %v", e.err)
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+func IsPermanent(err error) bool {
+	var p *permanentError
+	return errors.As(err, &p)
+}
+
+// RetryPolicy decides how many times a nacked message is redelivered and how
+// long to wait between attempts.
+type RetryPolicy struct {
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 5,
+		Backoff:     time.Second,
+		MaxBackoff:  time.Minute,
+	}
+}
+
+func (r RetryPolicy) ShouldRetry(attempt int, err error) bool {
+	if IsPermanent(err) {
+		return false
+	}
+	return attempt < r.MaxAttempts
+}
+
+func (r RetryPolicy) Delay(attempt int) time.Duration {
+	delay := r.Backoff
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if r.MaxBackoff > 0 && delay >= r.MaxBackoff {
+			return r.MaxBackoff
+		}
+	}
+	return delay
+}
@@ -0,0 +1,215 @@
+package postgres
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/eduardo-ax/video-streaming/pkg/messagebus"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const notifyChannel = "message_bus"
+
+// ErrGroupConflict is returned by Subscribe when another consumer group
+// already reads the topic.
+var ErrGroupConflict = errors.New("topic is consumed by another group")
+
+//go:embed schema.sql
+var schema string
+
+// Bus is a job queue stored in the message_bus_jobs table. Consumers claim
+// jobs with FOR UPDATE SKIP LOCKED and are woken up through LISTEN/NOTIFY.
+// An acked job is gone for everyone, so a topic is bound to the first group
+// that subscribes to it and other groups are refused. Every service of a
+// topic must use the same database, the bus owns pool and closes it.
+type Bus struct {
+	pool         *pgxpool.Pool
+	policy       messagebus.RetryPolicy
+	visibility   time.Duration
+	pollInterval time.Duration
+}
+
+func NewBus(pool *pgxpool.Pool, policy messagebus.RetryPolicy, visibility time.Duration) *Bus {
+	return &Bus{
+		pool:         pool,
+		policy:       policy,
+		visibility:   visibility,
+		pollInterval: 5 * time.Second,
+	}
+}
+
+func (b *Bus) EnsureSchema(ctx context.Context) error {
+	if _, err := b.pool.Exec(ctx, schema); err != nil {
+		return fmt.Errorf("failed to create message bus schema: %w", err)
+	}
+	return nil
+}
+
+func (b *Bus) Close() error {
+	b.pool.Close()
+	return nil
+}
+
+func (b *Bus) Ping(ctx context.Context) error {
+	return b.pool.Ping(ctx)
+}
+
+func (b *Bus) Publish(ctx context.Context, topic string, msg *messagebus.Message) error {
+	headers, err := json.Marshal(msg.Headers)
+	if err != nil {
+		return fmt.Errorf("failed to encode headers: %w", err)
+	}
+
+	err = pgx.BeginFunc(ctx, b.pool, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, "INSERT INTO message_bus_jobs (topic, key, value, headers) VALUES ($1, $2, $3, $4)", topic, msg.Key, msg.Value, headers)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, "SELECT pg_notify($1, $2)", notifyChannel, topic)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	return nil
+}
+
+func (b *Bus) Subscribe(ctx context.Context, topic string, group string, handler messagebus.Handler) error {
+	if err := b.bindGroup(ctx, topic, group); err != nil {
+		return err
+	}
+
+	conn, err := b.pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire listen connection: %w", err)
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, "LISTEN "+notifyChannel); err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", notifyChannel, err)
+	}
+
+	log.Printf("listening to topic %s as group %s", topic, group)
+
+	for {
+		for {
+			msg, err := b.claim(ctx, topic)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return err
+			}
+			if msg == nil {
+				break
+			}
+			b.process(ctx, msg, handler)
+		}
+
+		waitCtx, cancel := context.WithTimeout(ctx, b.pollInterval)
+		_, err := conn.Conn().WaitForNotification(waitCtx)
+		cancel()
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+			return fmt.Errorf("failed waiting for notification: %w", err)
+		}
+	}
+}
+
+func (b *Bus) bindGroup(ctx context.Context, topic string, group string) error {
+	_, err := b.pool.Exec(ctx, "INSERT INTO message_bus_groups (topic, group_name) VALUES ($1, $2) ON CONFLICT (topic) DO NOTHING", topic, group)
+	if err != nil {
+		return fmt.Errorf("failed to register group %s: %w", group, err)
+	}
+	var bound string
+	if err := b.pool.QueryRow(ctx, "SELECT group_name FROM message_bus_groups WHERE topic = $1", topic).Scan(&bound); err != nil {
+		return fmt.Errorf("failed to read group of topic %s: %w", topic, err)
+	}
+	if bound != group {
+		return fmt.Errorf("topic %s is bound to group %s: %w", topic, bound, ErrGroupConflict)
+	}
+	return nil
+}
+
+// claim leases the oldest available job of the topic by pushing its
+// available_at past the visibility timeout. A consumer that dies before
+// acking lets the lease expire and the job is delivered again.
+func (b *Bus) claim(ctx context.Context, topic string) (*messagebus.Message, error) {
+	var (
+		id        int64
+		headers   []byte
+		msg       = &messagebus.Message{Topic: topic}
+		createdAt time.Time
+	)
+	err := b.pool.QueryRow(ctx, `
+		UPDATE message_bus_jobs SET attempts = attempts + 1, available_at = now() + make_interval(secs => $2)
+		WHERE id = (
+			SELECT id FROM message_bus_jobs
+			WHERE topic = $1 AND NOT dead AND available_at <= now()
+			ORDER BY available_at, id
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1
+		)
+		RETURNING id, key, value, headers, attempts, created_at`,
+		topic, b.visibility.Seconds()).Scan(&id, &msg.Key, &msg.Value, &headers, &msg.Attempt, &createdAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim job: %w", err)
+	}
+
+	if err := json.Unmarshal(headers, &msg.Headers); err != nil {
+		return nil, fmt.Errorf("failed to decode headers of job %d: %w", id, err)
+	}
+	msg.ID = strconv.FormatInt(id, 10)
+	msg.Timestamp = createdAt
+	return msg, nil
+}
+
+func (b *Bus) process(ctx context.Context, msg *messagebus.Message, handler messagebus.Handler) {
+	err := handler(ctx, msg)
+	if err == nil {
+		b.ack(ctx, msg)
+		return
+	}
+	b.nack(ctx, msg, err)
+}
+
+// ack and nack only touch the job while it is still on the claim they
+// answer: a job whose lease expired and was claimed again has more attempts,
+// and belongs to its new consumer.
+func (b *Bus) ack(ctx context.Context, msg *messagebus.Message) {
+	query, err := b.pool.Exec(ctx, "DELETE FROM message_bus_jobs WHERE id = $1::text::bigint AND attempts = $2", msg.ID, msg.Attempt)
+	if err != nil {
+		log.Printf("failed to ack job %s: %v", msg.ID, err)
+		return
+	}
+	if query.RowsAffected() == 0 {
+		log.Printf("job %s was claimed again before it was acked", msg.ID)
+	}
+}
+
+func (b *Bus) nack(ctx context.Context, msg *messagebus.Message, cause error) {
+	dead := !b.policy.ShouldRetry(msg.Attempt, cause)
+	delay := b.policy.Delay(msg.Attempt)
+	query, err := b.pool.Exec(ctx,
+		"UPDATE message_bus_jobs SET available_at = now() + make_interval(secs => $2), last_error = $3, dead = $4 WHERE id = $1::text::bigint AND attempts = $5",
+		msg.ID, delay.Seconds(), cause.Error(), dead, msg.Attempt)
+	if err != nil {
+		log.Printf("failed to nack job %s: %v", msg.ID, err)
+		return
+	}
+	if query.RowsAffected() == 0 {
+		log.Printf("job %s was claimed again before it was nacked", msg.ID)
+	}
+}
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS message_bus_jobs (
+    id BIGSERIAL PRIMARY KEY,
+    topic TEXT NOT NULL,
+    key TEXT NOT NULL DEFAULT '',
+    value BYTEA NOT NULL,
+    headers JSONB NOT NULL DEFAULT '{}',
+    attempts INT NOT NULL DEFAULT 0,
+    available_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_error TEXT,
+    dead BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS message_bus_jobs_ready_idx ON message_bus_jobs (topic, available_at) WHERE NOT dead;
+
+CREATE TABLE IF NOT EXISTS message_bus_groups (
+    topic TEXT PRIMARY KEY,
+    group_name TEXT NOT NULL
+);
@@ -0,0 +1,54 @@
+package migrate
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoad(t *testing.T) {
+	tests := map[string]struct {
+		files    fstest.MapFS
+		expected []Migration
+		wantErr  bool
+	}{
+		"sorted by version with up and down": {
+			files: fstest.MapFS{
+				"migrations/0002_create_sessions.up.sql":   {Data: []byte("CREATE TABLE sessions ();")},
+				"migrations/0002_create_sessions.down.sql": {Data: []byte("DROP TABLE sessions;")},
+				"migrations/0001_create_users.up.sql":      {Data: []byte("CREATE TABLE users ();")},
+				"migrations/README.md":                     {Data: []byte("ignored")},
+			},
+			expected: []Migration{
+				{Version: 1, Name: "create_users", Up: "CREATE TABLE users ();"},
+				{Version: 2, Name: "create_sessions", Up: "CREATE TABLE sessions ();", Down: "DROP TABLE sessions;"},
+			},
+		},
+		"down without up": {
+			files: fstest.MapFS{
+				"migrations/0001_create_users.down.sql": {Data: []byte("DROP TABLE users;")},
+			},
+			wantErr: true,
+		},
+		"conflicting names": {
+			files: fstest.MapFS{
+				"migrations/0001_create_users.up.sql":  {Data: []byte("CREATE TABLE users ();")},
+				"migrations/0001_create_people.up.sql": {Data: []byte("CREATE TABLE people ();")},
+			},
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			migrations, err := Load(tc.files, "migrations")
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, migrations)
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package telemetry
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// HTTPMetrics records the RED metrics (rate, errors, duration) of an echo
+// server, labelled by method, route template and status code.
+type HTTPMetrics struct {
+	requests *prometheus.CounterVec
+	errors   *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+func NewHTTPMetrics(reg prometheus.Registerer, namespace string) *HTTPMetrics {
+	labels := []string{"method", "route", "status"}
+	m := &HTTPMetrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "Number of HTTP requests handled.",
+		}, labels),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_request_errors_total",
+			Help:      "Number of HTTP requests answered with a 5xx status.",
+		}, labels),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Time taken to handle an HTTP request in seconds.",
+			Buckets:   prometheus.DefBuckets,
+		}, labels),
+	}
+	reg.MustRegister(m.requests, m.errors, m.duration)
+	return m
+}
+
+func (m *HTTPMetrics) Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			route := c.Path()
+			if IsOperationalPath(route) {
+				return next(c)
+			}
+			if route == "" {
+				route = "unmatched"
+			}
+
+			start := time.Now()
+			err := next(c)
+			status := responseStatus(c, err)
+
+			values := []string{c.Request().Method, route, strconv.Itoa(status)}
+			m.requests.WithLabelValues(values...).Inc()
+			m.duration.WithLabelValues(values...).Observe(time.Since(start).Seconds())
+			if status >= http.StatusInternalServerError {
+				m.errors.WithLabelValues(values...).Inc()
+			}
+			return err
+		}
+	}
+}
+
+// IsOperationalPath reports whether path is a probe or scrape endpoint that
+// should stay out of request metrics and traces.
+func IsOperationalPath(path string) bool {
+	switch path {
+	case "/metrics", "/healthz", "/readyz":
+		return true
+	}
+	return false
+}
+
+func responseStatus(c echo.Context, err error) int {
+	if err == nil || c.Response().Committed {
+		return c.Response().Status
+	}
+	var httpErr *echo.HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.Code
+	}
+	return http.StatusInternalServerError
+}
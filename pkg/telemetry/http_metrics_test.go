@@ -0,0 +1,51 @@
+package telemetry
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPMetrics_Middleware(t *testing.T) {
+	tests := map[string]struct {
+		handler echo.HandlerFunc
+		status  string
+		errors  float64
+	}{
+		"success": {
+			handler: func(c echo.Context) error { return c.String(http.StatusOK, "ok") },
+			status:  "200",
+		},
+		"client error": {
+			handler: func(c echo.Context) error { return echo.NewHTTPError(http.StatusNotFound, "file not found") },
+			status:  "404",
+		},
+		"server error": {
+			handler: func(c echo.Context) error { return echo.NewHTTPError(http.StatusInternalServerError, "boom") },
+			status:  "500",
+			errors:  1,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			reg := prometheus.NewRegistry()
+			m := NewHTTPMetrics(reg, "test")
+
+			e := echo.New()
+			e.Use(m.Middleware())
+			e.GET("/videos/:id/:filename", tc.handler)
+
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/videos/42/index.m3u8", nil))
+
+			labels := []string{http.MethodGet, "/videos/:id/:filename", tc.status}
+			assert.Equal(t, float64(1), testutil.ToFloat64(m.requests.WithLabelValues(labels...)))
+			assert.Equal(t, tc.errors, testutil.ToFloat64(m.errors.WithLabelValues(labels...)))
+		})
+	}
+}
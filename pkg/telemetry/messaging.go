@@ -0,0 +1,54 @@
+package telemetry
+
+import (
+	"context"
+
+	"github.com/eduardo-ax/video-streaming/pkg/messagebus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const messagingTracer = "github.com/eduardo-ax/video-streaming/pkg/messagebus"
+
+// InjectMessage writes the trace context of ctx into the message headers.
+func InjectMessage(ctx context.Context, msg *messagebus.Message) {
+	if msg.Headers == nil {
+		msg.Headers = map[string]string{}
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.MapCarrier(msg.Headers))
+}
+
+// ExtractMessage returns ctx enriched with the trace context carried by the
+// message headers.
+func ExtractMessage(ctx context.Context, msg *messagebus.Message) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(msg.Headers))
+}
+
+func StartPublishSpan(ctx context.Context, topic string, msg *messagebus.Message) (context.Context, trace.Span) {
+	ctx, span := otel.Tracer(messagingTracer).Start(ctx, topic+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingDestinationName(topic),
+			semconv.MessagingOperationTypePublish,
+			attribute.String("messaging.message.key", msg.Key),
+		),
+	)
+	InjectMessage(ctx, msg)
+	return ctx, span
+}
+
+func StartConsumeSpan(ctx context.Context, msg *messagebus.Message) (context.Context, trace.Span) {
+	ctx = ExtractMessage(ctx, msg)
+	return otel.Tracer(messagingTracer).Start(ctx, msg.Topic+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.MessagingDestinationName(msg.Topic),
+			semconv.MessagingOperationTypeDeliver,
+			semconv.MessagingMessageID(msg.ID),
+			attribute.Int("messaging.delivery.attempt", msg.Attempt),
+		),
+	)
+}
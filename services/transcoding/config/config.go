@@ -0,0 +1,104 @@
+package config
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/eduardo-ax/video-streaming/pkg/configloader"
+	"github.com/eduardo-ax/video-streaming/pkg/telemetry"
+)
+
+const serviceName = "transcoding"
+
+type Config struct {
+	Ops        Ops        `yaml:"ops"`
+	Database   Database   `yaml:"database"`
+	S3         S3         `yaml:"s3"`
+	Storage    Storage    `yaml:"storage"`
+	MessageBus MessageBus `yaml:"message_bus"`
+	Tracing    Tracing    `yaml:"tracing"`
+}
+
+type Ops struct {
+	Addr            string        `yaml:"addr" env:"OPS_ADDR" flag:"ops-addr" default:":8080" usage:"listen address of the metrics and health server"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" flag:"shutdown-timeout" default:"2m" usage:"time an in-flight job gets to finish on shutdown"`
+}
+
+type Database struct {
+	URL string `yaml:"url" env:"VIDEOS_DATABASE_URL" flag:"database-url" secret:"true" usage:"postgres connection URL"`
+}
+
+type S3 struct {
+	Bucket string `yaml:"bucket" env:"S3_BUCKET_NAME" flag:"s3-bucket" usage:"bucket storing uploaded videos"`
+}
+
+type Storage struct {
+	Path string `yaml:"path" env:"VIDEO_STORAGE_PATH" flag:"storage-path" default:"/var/videos" usage:"local scratch directory for transcoding"`
+}
+
+type MessageBus struct {
+	Driver      string        `yaml:"driver" env:"MESSAGE_BUS_DRIVER" flag:"message-bus-driver" default:"kafka" usage:"kafka or postgres"`
+	Brokers     []string      `yaml:"brokers" env:"KAFKA_BROKER_URL" flag:"kafka-brokers" default:"kafka:9092" usage:"comma separated Kafka brokers"`
+	DatabaseURL string        `yaml:"database_url" env:"MESSAGE_BUS_DATABASE_URL" secret:"true" usage:"postgres database shared by all services, used by the postgres driver"`
+	Topic       string        `yaml:"topic" env:"TRANSCODING_TOPIC" flag:"transcoding-topic" default:"transcoding" usage:"topic transcoding jobs are consumed from"`
+	Group       string        `yaml:"group" env:"CONSUMER_GROUP" flag:"consumer-group" default:"transcoder" usage:"consumer group of the transcoders"`
+	Visibility  time.Duration `yaml:"visibility" env:"MESSAGE_BUS_VISIBILITY" default:"30m" usage:"lease of a claimed job on the postgres driver"`
+}
+
+type Tracing struct {
+	Exporter string `yaml:"exporter" env:"OTEL_TRACES_EXPORTER" flag:"traces-exporter" usage:"none, stdout or otlp"`
+}
+
+func Load(args []string) (*Config, configloader.Options, error) {
+	cfg := &Config{}
+	opts, err := configloader.Load(cfg, serviceName, args)
+	return cfg, opts, err
+}
+
+func (c *Config) Validate() error {
+	var problems configloader.Problems
+
+	if c.Ops.Addr == "" {
+		problems.Addf("ops.addr is required")
+	}
+	if c.Ops.ShutdownTimeout <= 0 {
+		problems.Addf("ops.shutdown_timeout must be positive")
+	}
+	if c.Database.URL == "" {
+		problems.Addf("database.url is required (VIDEOS_DATABASE_URL)")
+	} else if u, err := url.Parse(c.Database.URL); err != nil || (u.Scheme != "postgres" && u.Scheme != "postgresql") {
+		problems.Addf("database.url must be a postgres:// URL")
+	}
+	if c.S3.Bucket == "" {
+		problems.Addf("s3.bucket is required (S3_BUCKET_NAME)")
+	}
+	if c.Storage.Path == "" {
+		problems.Addf("storage.path is required")
+	}
+	switch c.MessageBus.Driver {
+	case "kafka":
+		if len(c.MessageBus.Brokers) == 0 {
+			problems.Addf("message_bus.brokers is required for the kafka driver")
+		}
+	case "postgres":
+		if u, err := url.Parse(c.MessageBus.DatabaseURL); err != nil || (u.Scheme != "postgres" && u.Scheme != "postgresql") {
+			problems.Addf("message_bus.database_url must be a postgres:// URL shared by all services (MESSAGE_BUS_DATABASE_URL)")
+		}
+		if c.MessageBus.Visibility < c.Ops.ShutdownTimeout {
+			problems.Addf("message_bus.visibility must be at least ops.shutdown_timeout")
+		}
+	default:
+		problems.Addf("message_bus.driver %q is not one of kafka, postgres", c.MessageBus.Driver)
+	}
+	if c.MessageBus.Topic == "" {
+		problems.Addf("message_bus.topic is required")
+	}
+	if c.MessageBus.Group == "" {
+		problems.Addf("message_bus.group is required")
+	}
+	if !telemetry.ValidExporter(c.Tracing.Exporter) {
+		problems.Addf("tracing.exporter %q is not one of none, stdout, otlp", c.Tracing.Exporter)
+	}
+
+	return problems.Err()
+}
@@ -3,82 +3,170 @@ package domain
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eduardo-ax/video-streaming/pkg/jobs"
+	"github.com/eduardo-ax/video-streaming/pkg/messagebus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+const tracerName = "github.com/eduardo-ax/video-streaming/services/transcoding/domain"
+
 type QueueContent struct {
-	id      string
-	content string
+	job jobs.TranscodeJob
 }
 
 func NewQueueContent(id string, content string) (QueueContent, error) {
-	integerID, _ := strconv.Atoi(id)
-	if integerID < 1 {
-		return QueueContent{}, fmt.Errorf("Queue ID error")
-	}
-	if content < "" {
-		return QueueContent{}, fmt.Errorf("Queue Content error")
+	job, err := jobs.Decode(id, []byte(content))
+	if err != nil {
+		return QueueContent{}, fmt.Errorf("Queue Content error: %w", err)
 	}
 
 	return QueueContent{
-		id:      id,
-		content: content,
+		job: job,
 	}, nil
 }
 
+// profiles maps the profiles of pkg/jobs to the ffmpeg run producing them,
+// with the outputs each run writes.
+var profiles = map[string]struct {
+	transcode func(ctx context.Context, inputPath string) (string, error)
+	outputs   map[string]bool
+}{
+	jobs.ProfileHLSBaseline: {transcode: TranscodeToHLS, outputs: map[string]bool{jobs.OutputHLS: true}},
+}
+
+const (
+	OutcomeSucceeded = "succeeded"
+	OutcomeFailed    = "failed"
+	OutcomeInvalid   = "invalid"
+)
+
 type VideoTranscoder struct {
 	db          Storage
 	queue       MessageQueue
 	ObjectStore ObjectStore
+	metrics     Metrics
 }
 
-func NewVideoTranscoder(db Storage, queue MessageQueue, objectStore ObjectStore) *VideoTranscoder {
+func NewVideoTranscoder(db Storage, queue MessageQueue, objectStore ObjectStore, metrics Metrics) *VideoTranscoder {
 	return &VideoTranscoder{
 		db:          db,
 		queue:       queue,
 		ObjectStore: objectStore,
+		metrics:     metrics,
 	}
 }
 
 func (v *VideoTranscoder) TranscodeVideo(ctx context.Context, id string, content string) error {
+	outcome := OutcomeFailed
+	defer func() {
+		v.metrics.JobsInc(outcome)
+	}()
 
 	queueContent, err := NewQueueContent(id, content)
 
 	if err != nil {
-		return fmt.Errorf("Queue content error")
+		outcome = OutcomeInvalid
+		return messagebus.Permanent(err)
+	}
+	job := queueContent.job
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.String("job.id", job.JobID),
+		attribute.String("video.id", job.VideoID),
+	)
+	v.metrics.ObserveQueueLag(time.Since(job.CreatedAt))
+	logger := slog.With("job_id", job.JobID, "video_id", job.VideoID)
+
+	profile, ok := profiles[job.Profile]
+	if !ok {
+		outcome = OutcomeInvalid
+		return messagebus.Permanent(fmt.Errorf("profile %q is not supported by this transcoder", job.Profile))
+	}
+	for _, output := range job.Outputs {
+		if !profile.outputs[output] {
+			outcome = OutcomeInvalid
+			return messagebus.Permanent(fmt.Errorf("profile %q doesn't produce output %q", job.Profile, output))
+		}
 	}
 
-	localPath, err := v.ObjectStore.DownloadFile(ctx, queueContent.content)
+	logger.Info("downloading source", "source_key", job.SourceKey)
+	localPath, err := v.ObjectStore.DownloadFile(ctx, job.SourceKey)
 	if err != nil {
-		fmt.Println("Error Download:", err)
+		logger.Error("download failed", "source_key", job.SourceKey, "error", err)
 		return err
 	}
 
 	defer func() {
 		if rErr := os.Remove(localPath); rErr != nil {
-			fmt.Printf("Warning: failed to delete original file %s: %v\n", localPath, rErr)
+			logger.Warn("failed to delete source file", "path", localPath, "error", rErr)
 		}
 	}()
 
-	m3u8Path, err := TranscodeToHLS(ctx, localPath)
+	start := time.Now()
+	m3u8Path, err := profile.transcode(ctx, localPath)
 	if err != nil {
-		fmt.Println("Error Transcode:", err)
+		logger.Error("transcode failed", "profile", job.Profile, "error", err)
 		return err
 	}
+	mediaDuration, err := PlaylistDuration(m3u8Path)
+	if err != nil {
+		logger.Warn("failed to read playlist duration", "path", m3u8Path, "error", err)
+	}
+	v.metrics.ObserveFFmpeg(time.Since(start), mediaDuration)
 
 	hlsDir := filepath.Dir(m3u8Path)
-	if err := v.ObjectStore.UploadHLSFiles(ctx, hlsDir, queueContent.id); err != nil {
+	uploaded, err := v.ObjectStore.UploadHLSFiles(ctx, hlsDir, job.VideoID)
+	v.metrics.BytesUploadedAdd(uploaded)
+	if err != nil {
 		return err
 	}
 
-	fmt.Printf("Transcoding complete: %s\n", m3u8Path)
+	outcome = OutcomeSucceeded
+	logger.Info("transcoding complete", "profile", job.Profile, "playlist", m3u8Path)
 	return nil
 }
 
+// PlaylistDuration sums the #EXTINF segment durations of an HLS playlist.
+func PlaylistDuration(m3u8Path string) (time.Duration, error) {
+	data, err := os.ReadFile(m3u8Path)
+	if err != nil {
+		return 0, err
+	}
+	var total float64
+	for _, line := range strings.Split(string(data), "\n") {
+		value, ok := strings.CutPrefix(strings.TrimSpace(line), "#EXTINF:")
+		if !ok {
+			continue
+		}
+		value, _, _ = strings.Cut(value, ",")
+		seconds, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid segment duration %q: %w", value, err)
+		}
+		total += seconds
+	}
+	return time.Duration(total * float64(time.Second)), nil
+}
+
 func TranscodeToHLS(ctx context.Context, inputPath string) (string, error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "ffmpeg transcode",
+		trace.WithAttributes(
+			attribute.String("ffmpeg.input", inputPath),
+			attribute.String("ffmpeg.format", "hls"),
+		),
+	)
+	defer span.End()
+
 	dir := filepath.Dir(inputPath)
 	outputM3U8 := filepath.Join(dir, "index.m3u8")
 
@@ -101,7 +189,10 @@ func TranscodeToHLS(ctx context.Context, inputPath string) (string, error) {
 	cmd.Stderr = nil
 
 	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("ffmpeg error: %w", err)
+		err = fmt.Errorf("ffmpeg error: %w", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return "", err
 	}
 	return outputM3U8, nil
 }
@@ -112,10 +203,17 @@ type Storage interface {
 
 type MessageQueue interface {
 	SendMessage(ctx context.Context, key string) error
-	ReceiveMessage(ctx context.Context, handler func(id string, msg string)) error
+	ReceiveMessage(ctx context.Context, handler func(ctx context.Context, id string, msg string) error) error
 }
 
 type ObjectStore interface {
-	DownloadFile(ctx context.Context, filename string) (string, error)
-	UploadHLSFiles(ctx context.Context, hlsDir, s3Prefix string) error
+	DownloadFile(ctx context.Context, key string) (string, error)
+	UploadHLSFiles(ctx context.Context, hlsDir, s3Prefix string) (int64, error)
+}
+
+type Metrics interface {
+	JobsInc(outcome string)
+	ObserveQueueLag(lag time.Duration)
+	ObserveFFmpeg(elapsed time.Duration, media time.Duration)
+	BytesUploadedAdd(bytes int64)
 }
@@ -1,7 +1,10 @@
 package domain
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestQueueContentValidate(t *testing.T) {
@@ -30,6 +33,16 @@ func TestQueueContentValidate(t *testing.T) {
 			content: "",
 			expect:  false,
 		},
+		"valid json envelope": {
+			id:      "17",
+			content: `{"version":1,"job_id":"a1","video_id":"17","source_key":"videos/17/my/clip.avi","profile":"hls-baseline","outputs":["hls"],"created_at":"2025-01-01T00:00:00Z"}`,
+			expect:  true,
+		},
+		"invalid json envelope": {
+			id:      "17",
+			content: `{"version":9,"job_id":"a1","video_id":"17"}`,
+			expect:  false,
+		},
 	}
 	for name, tc := range tests {
 		t.Run(name, func(t *testing.T) {
@@ -37,7 +50,51 @@ func TestQueueContentValidate(t *testing.T) {
 			if err != nil && tc.expect {
 				t.Errorf("Test %s failed: %s. Expected valid but got error: %v", name, tc.content, err)
 			}
+			if err == nil && !tc.expect {
+				t.Errorf("Test %s failed: %s. Expected error but got none", name, tc.content)
+			}
 		})
 	}
 
 }
+
+func TestPlaylistDuration(t *testing.T) {
+	tests := map[string]struct {
+		playlist string
+		expected time.Duration
+		expect   bool
+	}{
+		"hls playlist": {
+			playlist: "#EXTM3U\n#EXT-X-TARGETDURATION:6\n#EXTINF:6.006000,\nindex0.ts\n#EXTINF:3.500000,\nindex1.ts\n#EXT-X-ENDLIST\n",
+			expected: 9506 * time.Millisecond,
+			expect:   true,
+		},
+		"empty playlist": {
+			playlist: "#EXTM3U\n",
+			expected: 0,
+			expect:   true,
+		},
+		"invalid duration": {
+			playlist: "#EXTM3U\n#EXTINF:abc,\nindex0.ts\n",
+			expect:   false,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "index.m3u8")
+			if err := os.WriteFile(path, []byte(tc.playlist), 0644); err != nil {
+				t.Fatal(err)
+			}
+			duration, err := PlaylistDuration(path)
+			if tc.expect && err != nil {
+				t.Errorf("Test %s failed. Expected valid but got error: %v", name, err)
+			}
+			if !tc.expect && err == nil {
+				t.Errorf("Test %s failed. Expected error but got none", name)
+			}
+			if tc.expect && duration != tc.expected {
+				t.Errorf("Test %s failed. Expected %v but got %v", name, tc.expected, duration)
+			}
+		})
+	}
+}
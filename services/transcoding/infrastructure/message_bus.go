@@ -0,0 +1,85 @@
+package infrastructure
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/eduardo-ax/video-streaming/pkg/jobs"
+	"github.com/eduardo-ax/video-streaming/pkg/messagebus"
+	"github.com/eduardo-ax/video-streaming/pkg/messagebus/kafka"
+	"github.com/eduardo-ax/video-streaming/pkg/messagebus/postgres"
+	"github.com/eduardo-ax/video-streaming/pkg/telemetry"
+	"github.com/eduardo-ax/video-streaming/services/transcoding/config"
+)
+
+func NewMessageBus(cfg config.MessageBus) (messagebus.Bus, error) {
+	policy := messagebus.DefaultRetryPolicy()
+
+	switch cfg.Driver {
+	case "kafka":
+		return kafka.NewBus(cfg.Brokers, policy)
+	case "postgres":
+		bus := postgres.NewBus(NewPool(cfg.DatabaseURL), policy, cfg.Visibility)
+		if err := bus.EnsureSchema(context.Background()); err != nil {
+			return nil, err
+		}
+		return bus, nil
+	default:
+		return nil, fmt.Errorf("unknown message bus driver %q", cfg.Driver)
+	}
+}
+
+type Publisher struct {
+	bus   messagebus.Bus
+	topic string
+	group string
+}
+
+func NewProducer(bus messagebus.Bus, topic string, group string) *Publisher {
+	return &Publisher{
+		bus:   bus,
+		topic: topic,
+		group: group,
+	}
+}
+
+func (p *Publisher) Close() error {
+	return p.bus.Close()
+}
+
+func (p *Publisher) Ping(ctx context.Context) error {
+	if pinger, ok := p.bus.(messagebus.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (p *Publisher) SendMessage(ctx context.Context, key string) error {
+	return p.bus.Publish(ctx, p.topic, messagebus.NewMessage(key, nil))
+}
+
+func (p *Publisher) ReceiveMessage(ctx context.Context, handler func(ctx context.Context, id string, msg string) error) error {
+	return p.bus.Subscribe(ctx, p.topic, p.group, func(ctx context.Context, msg *messagebus.Message) error {
+		slog.Debug("message received", "topic", msg.Topic, "key", msg.Key, "attempt", msg.Attempt)
+		propagateJobTrace(msg)
+		ctx, span := telemetry.StartConsumeSpan(ctx, msg)
+		defer span.End()
+		return telemetry.RecordError(span, handler(ctx, msg.Key, string(msg.Value)))
+	})
+}
+
+// propagateJobTrace falls back to the trace context stored in the job
+// envelope when the broker dropped the message headers.
+func propagateJobTrace(msg *messagebus.Message) {
+	if msg.Header("traceparent") != "" {
+		return
+	}
+	job, err := jobs.Decode(msg.Key, msg.Value)
+	if err != nil {
+		return
+	}
+	for k, v := range job.Trace {
+		msg.SetHeader(k, v)
+	}
+}
@@ -4,32 +4,38 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"log/slog"
 	"mime"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 )
 
 type ObjectStore struct {
-	client *s3.Client
-	bucket string
+	client   *s3.Client
+	bucket   string
+	basePath string
 }
 
-func NewObjectStore(client *s3.Client, bucket string) *ObjectStore {
+func NewObjectStore(client *s3.Client, bucket string, basePath string) *ObjectStore {
 	return &ObjectStore{
-		client: client,
-		bucket: bucket,
+		client:   client,
+		bucket:   bucket,
+		basePath: basePath,
 	}
 }
 
-func (o *ObjectStore) DownloadFile(ctx context.Context, filename string) (string, error) {
-	fmt.Println("Downloading file from S3:")
-	fmt.Printf("videos/%s\n", filename)
-
-	key := fmt.Sprintf("videos/%s", filename)
+func (o *ObjectStore) Ping(ctx context.Context) error {
+	_, err := o.client.HeadBucket(ctx, &s3.HeadBucketInput{
+		Bucket: aws.String(o.bucket),
+	})
+	return err
+}
 
+func (o *ObjectStore) DownloadFile(ctx context.Context, key string) (string, error) {
 	out, err := o.client.GetObject(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(o.bucket),
 		Key:    aws.String(key),
@@ -39,8 +45,7 @@ func (o *ObjectStore) DownloadFile(ctx context.Context, filename string) (string
 	}
 	defer out.Body.Close()
 
-	basePath := "/var/videos"
-	localPath := filepath.Join(basePath, filename)
+	localPath := filepath.Join(o.basePath, strings.TrimPrefix(key, "videos/"))
 	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
 		return "", err
 	}
@@ -58,13 +63,14 @@ func (o *ObjectStore) DownloadFile(ctx context.Context, filename string) (string
 	return localPath, nil
 }
 
-func (o *ObjectStore) UploadHLSFiles(ctx context.Context, hlsDir, id string) error {
+func (o *ObjectStore) UploadHLSFiles(ctx context.Context, hlsDir, id string) (int64, error) {
 	bucketPath := fmt.Sprintf("videos/%s/", id)
 	files, err := os.ReadDir(hlsDir)
 	if err != nil {
-		return fmt.Errorf("error reading HLS directory %s: %w", hlsDir, err)
+		return 0, fmt.Errorf("error reading HLS directory %s: %w", hlsDir, err)
 	}
 
+	var uploaded int64
 	for _, file := range files {
 		if !file.IsDir() {
 			fileName := file.Name()
@@ -72,33 +78,35 @@ func (o *ObjectStore) UploadHLSFiles(ctx context.Context, hlsDir, id string) err
 
 			s3Key := bucketPath + fileName
 
-			fmt.Printf("Uploading %s to S3 key %s\n", fileName, s3Key)
+			slog.Debug("uploading HLS file", "file", fileName, "key", s3Key)
 
-			if err := o.UploadLocalFile(ctx, filePath, s3Key); err != nil {
-				return fmt.Errorf("error uploading file %s to S3: %w", fileName, err)
+			size, err := o.UploadLocalFile(ctx, filePath, s3Key)
+			if err != nil {
+				return uploaded, fmt.Errorf("error uploading file %s to S3: %w", fileName, err)
 			}
+			uploaded += size
 
 			if err := os.Remove(filePath); err != nil {
-				fmt.Printf("Warning: failed to delete local file %s: %v\n", filePath, err)
+				slog.Warn("failed to delete local file", "path", filePath, "error", err)
 			}
 		}
 	}
 	if err := os.Remove(hlsDir); err != nil {
-		fmt.Printf("Warning: failed to delete HLS directory %s: %v\n", hlsDir, err)
+		slog.Warn("failed to delete HLS directory", "path", hlsDir, "error", err)
 	}
-	return nil
+	return uploaded, nil
 }
 
-func (o *ObjectStore) UploadLocalFile(ctx context.Context, localPath string, key string) error {
+func (o *ObjectStore) UploadLocalFile(ctx context.Context, localPath string, key string) (int64, error) {
 	file, err := os.Open(localPath)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer file.Close()
 
 	fileInfo, err := file.Stat()
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	contentType := mime.TypeByExtension(filepath.Ext(localPath))
@@ -113,6 +121,8 @@ func (o *ObjectStore) UploadLocalFile(ctx context.Context, localPath string, key
 		ContentLength: aws.Int64(fileInfo.Size()),
 		ContentType:   aws.String(contentType),
 	})
-
-	return err
+	if err != nil {
+		return 0, err
+	}
+	return fileInfo.Size(), nil
 }
@@ -2,43 +2,140 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
-	"github.com/aws/aws-sdk-go-v2/config"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/eduardo-ax/video-streaming/pkg/configloader"
+	"github.com/eduardo-ax/video-streaming/pkg/health"
+	"github.com/eduardo-ax/video-streaming/pkg/telemetry"
+	"github.com/eduardo-ax/video-streaming/services/transcoding/config"
 	"github.com/eduardo-ax/video-streaming/services/transcoding/domain"
 	"github.com/eduardo-ax/video-streaming/services/transcoding/infrastructure"
+	metrics "github.com/eduardo-ax/video-streaming/services/transcoding/observability"
 	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/aws/aws-sdk-go-v2/otelaws"
 )
 
+const serviceName = "transcoding"
+
 func main() {
 	err := godotenv.Load()
+	if err != nil {
+		log.Println("Warning: Could not load .env file, falling back to environment variables.")
+	}
+
+	cfg, opts, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if opts.PrintConfig {
+		if err := configloader.Print(os.Stdout, cfg); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err != nil {
+		log.Fatalf("FATAL ERROR: invalid configuration:\n%v", err)
+	}
+	if opts.PrintConfig {
+		return
+	}
+
+	shutdownTracing, err := telemetry.SetupTracing(context.Background(), serviceName, cfg.Tracing.Exporter)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer shutdownTracing(context.Background())
 
-	pool := infrastructure.NewPool()
+	pool := infrastructure.NewPool(cfg.Database.URL)
 	defer pool.Close()
 	db := infrastructure.NewDatabase(pool)
 
-	cfg, err := config.LoadDefaultConfig(context.TODO())
-	s3Client := s3.NewFromConfig(cfg)
-	bucketName := os.Getenv("S3_BUCKET_NAME")
-	objectStore := infrastructure.NewObjectStore(s3Client, bucketName)
+	awsCfg, err := awsconfig.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		log.Fatal(err)
+	}
+	otelaws.AppendMiddlewares(&awsCfg.APIOptions)
+	s3Client := s3.NewFromConfig(awsCfg)
+	objectStore := infrastructure.NewObjectStore(s3Client, cfg.S3.Bucket, cfg.Storage.Path)
 
-	producer, err := infrastructure.NewProducer()
+	bus, err := infrastructure.NewMessageBus(cfg.MessageBus)
 	if err != nil {
 		log.Fatal(err)
 	}
+	producer := infrastructure.NewProducer(bus, cfg.MessageBus.Topic, cfg.MessageBus.Group)
 	defer producer.Close()
 
-	ctx := context.Background()
-	videoTranscoder := domain.NewVideoTranscoder(db, producer, objectStore)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	reg := prometheus.NewRegistry()
+	m := metrics.NewMetrics(reg)
+
+	checker := health.NewChecker(2 * time.Second)
+	checker.Add("postgres", db.Ping)
+	checker.Add("s3", objectStore.Ping)
+	checker.Add("message_bus", producer.Ping)
+
+	server := newOpsServer(cfg.Ops.Addr, reg, checker)
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("ops server error %v", err)
+		}
+	}()
+
+	videoTranscoder := domain.NewVideoTranscoder(db, producer, objectStore, m)
+
+	// A job in flight when SIGTERM arrives gets the shutdown timeout to finish
+	// before its ffmpeg process is killed and the message is redelivered.
+	drainCtx, cancelDrain := context.WithCancel(context.Background())
+	defer cancelDrain()
+	context.AfterFunc(ctx, func() {
+		time.AfterFunc(cfg.Ops.ShutdownTimeout, cancelDrain)
+	})
+
+	err = producer.ReceiveMessage(ctx, func(ctx context.Context, id string, msg string) error {
+		jobCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		defer cancel()
+		stopDrain := context.AfterFunc(drainCtx, cancel)
+		defer stopDrain()
 
-	err = producer.ReceiveMessage(ctx, func(id string, msg string) {
-		if err := videoTranscoder.TranscodeVideo(ctx, id, msg); err != nil {
+		if err := videoTranscoder.TranscodeVideo(jobCtx, id, msg); err != nil {
 			log.Printf("transcoding error %v", err)
+			return err
 		}
+		return nil
 	})
 	if err != nil {
-		log.Fatal(err)
+		log.Printf("consumer error %v", err)
+	}
+
+	log.Println("Shutting down: consumer stopped")
+	checker.SetShuttingDown()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Warning: ops server shutdown error: %v", err)
+	}
+}
+
+func newOpsServer(addr string, reg *prometheus.Registry, checker *health.Checker) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	mux.Handle("/healthz", checker.LivenessHandler())
+	mux.Handle("/readyz", checker.ReadinessHandler())
+	return &http.Server{
+		Addr:    addr,
+		Handler: mux,
 	}
 }
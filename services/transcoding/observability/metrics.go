@@ -0,0 +1,71 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "transcoding"
+
+type Metrics struct {
+	jobs           *prometheus.CounterVec
+	queueLag       prometheus.Histogram
+	ffmpegDuration prometheus.Histogram
+	realtimeFactor prometheus.Histogram
+	bytesUploaded  prometheus.Counter
+}
+
+func NewMetrics(reg prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		jobs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "jobs_total",
+			Help:      "Number of transcoding jobs processed, by outcome.",
+		}, []string{"outcome"}),
+		queueLag: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "queue_lag_seconds",
+			Help:      "Time between a job being published and the transcoder picking it up.",
+			Buckets:   prometheus.ExponentialBuckets(0.1, 2, 14),
+		}),
+		ffmpegDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "ffmpeg_duration_seconds",
+			Help:      "Time taken by ffmpeg to transcode a video in seconds.",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 12),
+		}),
+		realtimeFactor: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "realtime_factor",
+			Help:      "Seconds of media transcoded per second of ffmpeg run time.",
+			Buckets:   []float64{0.25, 0.5, 1, 2, 4, 8, 16, 32},
+		}),
+		bytesUploaded: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "bytes_uploaded_total",
+			Help:      "Number of HLS bytes uploaded to object storage.",
+		}),
+	}
+	reg.MustRegister(m.jobs, m.queueLag, m.ffmpegDuration, m.realtimeFactor, m.bytesUploaded)
+	return m
+}
+
+func (m *Metrics) JobsInc(outcome string) {
+	m.jobs.WithLabelValues(outcome).Inc()
+}
+
+func (m *Metrics) ObserveQueueLag(lag time.Duration) {
+	m.queueLag.Observe(lag.Seconds())
+}
+
+func (m *Metrics) ObserveFFmpeg(elapsed time.Duration, media time.Duration) {
+	m.ffmpegDuration.Observe(elapsed.Seconds())
+	if elapsed > 0 && media > 0 {
+		m.realtimeFactor.Observe(media.Seconds() / elapsed.Seconds())
+	}
+}
+
+func (m *Metrics) BytesUploadedAdd(bytes int64) {
+	m.bytesUploaded.Add(float64(bytes))
+}
@@ -1,27 +1,54 @@
 package api
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
+	"math"
 	"net/http"
+	"path"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/eduardo-ax/video-streaming/pkg/auth"
 	"github.com/eduardo-ax/video-streaming/services/user/domain"
 	"github.com/eduardo-ax/video-streaming/services/user/token"
+	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 )
 
+// maxPendingResetEmails bounds the password reset emails sent in the
+// background at once.
+const maxPendingResetEmails = 16
+
 type UserHandler struct {
-	user domain.UserInterface
+	user      domain.UserInterface
+	billing   domain.BillingInterface
+	deletions domain.DeletionInterface
+	exports   domain.ExportInterface
+	resets    chan struct{}
+	pending   sync.WaitGroup
 }
 
-func NewUserHander(user domain.UserInterface) *UserHandler {
+func NewUserHander(user domain.UserInterface, billing domain.BillingInterface, deletions domain.DeletionInterface, exports domain.ExportInterface) *UserHandler {
 	return &UserHandler{
-		user: user,
+		user:      user,
+		billing:   billing,
+		deletions: deletions,
+		exports:   exports,
+		resets:    make(chan struct{}, maxPendingResetEmails),
 	}
 }
 
+// Wait blocks until the password reset emails sent in the background are
+// out. Call it once the server stopped taking requests.
+func (u *UserHandler) Wait() {
+	u.pending.Wait()
+}
+
 func JSONError(c echo.Context, status int, message string) error {
 	return c.JSON(status, map[string]string{"error": message})
 }
@@ -43,10 +70,39 @@ func SetRefreshTokenCookie(c echo.Context, refreshToken string, expiresAt time.T
 	c.SetCookie(cookie)
 }
 
+func ClientFromContext(c echo.Context) domain.Client {
+	return domain.Client{
+		UserAgent: c.Request().UserAgent(),
+		IP:        c.RealIP(),
+	}
+}
+
+// ClientMiddleware attaches the client to the request context, for the
+// audit events recorded while handling it.
+func ClientMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := domain.WithClient(c.Request().Context(), ClientFromContext(c))
+		c.SetRequest(c.Request().WithContext(ctx))
+		return next(c)
+	}
+}
+
 const ContextUserID = "userID"
 const ContextSessionID = "sessionID"
+const ContextProfileID = "profileID"
+
+const (
+	HeaderBillingSignature = "Billing-Signature"
+	maxWebhookSize         = 64 << 10
+
+	// The avatar plus the multipart framing.
+	maxAvatarUploadSize = domain.MaxAvatarSize + 64<<10
+)
 
-func (u *UserHandler) AuthMiddleware(tokenMaker *token.JWTMaker) echo.MiddlewareFunc {
+// AuthMiddleware also stores the pkg/auth claims, so the routes can mount
+// auth.RequirePermission like any other service.
+func (u *UserHandler) AuthMiddleware(tokenMaker *token.JWTMaker, revocations auth.RevocationChecker) echo.MiddlewareFunc {
+	verifier := auth.NewVerifier(tokenMaker, auth.WithRevocationCheck(revocations))
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			authHeader := c.Request().Header.Get("Authorization")
@@ -60,30 +116,120 @@ func (u *UserHandler) AuthMiddleware(tokenMaker *token.JWTMaker) echo.Middleware
 			}
 
 			accessToken := fields[1]
-			claims, err := tokenMaker.VerifyToken(accessToken)
+			claims, err := verifier.Verify(c.Request().Context(), accessToken)
 			if err != nil {
 				return JSONError(c, http.StatusUnauthorized, "invalid or expired access token")
 			}
-			c.Set(ContextUserID, claims.ID)
-			c.Set(ContextSessionID, claims.RegisteredClaims.ID)
+			auth.SetClaims(c, claims)
+			c.Set(ContextUserID, claims.UserID)
+			c.Set(ContextSessionID, claims.SessionID)
+			c.Set(ContextProfileID, claims.ProfileID)
 			return next(c)
 		}
 	}
 }
 
-func (u *UserHandler) Register(g *echo.Group, tokenMaker *token.JWTMaker) {
+// RequireUnrestrictedProfile refuses sessions on a restricted profile, it
+// guards the routes that manage the account and must be mounted after
+// AuthMiddleware.
+func (u *UserHandler) RequireUnrestrictedProfile(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID, _ := c.Get(ContextUserID).(string)
+		profileID, _ := c.Get(ContextProfileID).(string)
+		err := u.user.RequireUnrestricted(c.Request().Context(), userID, profileID)
+		if errors.Is(err, domain.ErrProfileRestricted) {
+			return JSONError(c, http.StatusForbidden, err.Error())
+		}
+		if err != nil {
+			return JSONError(c, http.StatusInternalServerError, "failed to check profile")
+		}
+		return next(c)
+	}
+}
+
+func (u *UserHandler) Register(g *echo.Group, tokenMaker *token.JWTMaker, revocations auth.RevocationChecker) {
+	g.Use(ClientMiddleware)
+
 	g.POST("/user", u.CreateUserHandler)
 	g.POST("/login", u.LoginHandler)
+	g.POST("/login/mfa", u.MFALoginHandler)
 	g.POST("/renew", u.RenewTokenHandler)
+	g.POST("/user/verify", u.VerifyEmailHandler)
+	g.POST("/password/forgot", u.ForgotPasswordHandler)
+	g.POST("/password/reset", u.ResetPasswordHandler)
+	g.POST("/user/deletion/cancel", u.CancelDeletionHandler)
+	g.GET("/user/exports/download", u.DownloadExportHandler)
+	g.GET("/plans", u.ListPlansHandler)
+	g.POST("/billing/webhook", u.BillingWebhookHandler)
+	g.GET("/users/:id/avatar/:file", u.AvatarHandler)
 
 	protected := g.Group("")
-	protected.Use(u.AuthMiddleware(tokenMaker))
+	protected.Use(u.AuthMiddleware(tokenMaker, revocations))
+
+	// Restricted profiles watch, they don't manage the account.
+	unrestricted := u.RequireUnrestrictedProfile
+
+	protected.GET("/user/me", u.GetCurrentUserHandler)
+	protected.PUT("/user", u.UpdateUserHandler, unrestricted)
+	protected.PUT("/user/avatar", u.UploadAvatarHandler, unrestricted)
+	protected.DELETE("/user/avatar", u.DeleteAvatarHandler, unrestricted)
+	protected.DELETE("/user", u.DeleteUserHandler, unrestricted)
+	protected.POST("/user/exports", u.RequestExportHandler, unrestricted)
+	protected.GET("/user/exports", u.ListExportsHandler, unrestricted)
+	protected.POST("/user/verify/resend", u.ResendVerificationHandler, unrestricted)
+	protected.POST("/user/mfa/enroll", u.EnrollMFAHandler, unrestricted)
+	protected.POST("/user/mfa/confirm", u.ConfirmMFAHandler, unrestricted)
+	protected.PUT("/user/plan", u.SwitchPlanHandler, unrestricted)
+	protected.GET("/user/subscription", u.GetSubscriptionHandler)
+	protected.POST("/user/subscription", u.SubscribeHandler, unrestricted)
+	protected.DELETE("/user/subscription", u.CancelSubscriptionHandler, unrestricted)
 
-	protected.PUT("/user", u.UpdateUserHandler)
-	protected.DELETE("/user", u.DeleteUserHandler)
+	protected.GET("/profiles", u.ListProfilesHandler)
+	protected.POST("/profiles", u.CreateProfileHandler)
+	protected.PUT("/profiles/:id", u.UpdateProfileHandler)
+	protected.DELETE("/profiles/:id", u.DeleteProfileHandler)
+	protected.POST("/profiles/:id/switch", u.SwitchProfileHandler)
 
 	protected.POST("/logout/", u.LogoutHandler)
 	protected.POST("/revoke/:id", u.RevokeTokenHandler)
+
+	protected.GET("/user/security-activity", u.SecurityActivityHandler, unrestricted)
+
+	protected.GET("/sessions", u.ListSessionsHandler, unrestricted)
+	protected.DELETE("/sessions/:id", u.RevokeTokenHandler, unrestricted)
+	protected.POST("/sessions/revoke-others", u.RevokeOtherSessionsHandler, unrestricted)
+
+	admin := protected.Group("/admin", unrestricted)
+	admin.GET("/roles", u.ListRolesHandler, auth.RequirePermission(auth.PermUsersRead))
+	admin.PUT("/users/:id/roles/:role", u.GrantRoleHandler, auth.RequirePermission(auth.PermUsersManage))
+	admin.DELETE("/users/:id/roles/:role", u.RevokeRoleHandler, auth.RequirePermission(auth.PermUsersManage))
+	admin.GET("/users", u.SearchUsersHandler, auth.RequirePermission(auth.PermUsersRead))
+	admin.GET("/users/:id", u.GetUserDetailsHandler, auth.RequirePermission(auth.PermUsersRead))
+	admin.POST("/users/:id/suspend", u.SuspendUserHandler, auth.RequirePermission(auth.PermUsersManage))
+	admin.POST("/users/:id/unsuspend", u.UnsuspendUserHandler, auth.RequirePermission(auth.PermUsersManage))
+	admin.POST("/users/:id/logout", u.ForceLogoutHandler, auth.RequirePermission(auth.PermUsersManage))
+	admin.PUT("/users/:id/plan", u.ChangePlanHandler, auth.RequirePermission(auth.PermUsersManage))
+	admin.DELETE("/users/:id", u.AdminDeleteUserHandler, auth.RequirePermission(auth.PermUsersManage))
+	admin.GET("/audit", u.SearchAuditEventsHandler, auth.RequirePermission(auth.PermUsersRead))
+}
+
+// RevocationsHandler lists what other services must reject until the access
+// tokens issued before expire. It's meant for the internal network only.
+func (u *UserHandler) RevocationsHandler(c echo.Context) error {
+	list, err := u.user.Revocations(c.Request().Context())
+	if err != nil {
+		return JSONError(c, http.StatusInternalServerError, "failed to list revocations")
+	}
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.JSON(http.StatusOK, list)
+}
+
+// JWKSHandler publishes the public keys other services verify tokens with.
+func JWKSHandler(tokenMaker *token.JWTMaker) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		c.Response().Header().Set("Cache-Control", "public, max-age=300")
+		return c.JSON(http.StatusOK, tokenMaker.JWKS())
+	}
 }
 
 func (u *UserHandler) CreateUserHandler(c echo.Context) error {
@@ -94,13 +240,114 @@ func (u *UserHandler) CreateUserHandler(c echo.Context) error {
 		return JSONError(c, http.StatusBadRequest, "invalid request body")
 	}
 
-	err := u.user.CreateUser(ctx, user.Name, user.Email, user.Plan, user.Password)
+	err := u.user.CreateUser(ctx, user.Name, user.Email, user.Password)
 	if err != nil {
 		return JSONError(c, http.StatusInternalServerError, fmt.Sprintf("failed to create user: %s", err))
 	}
 	return JSONSucess(c, http.StatusCreated, "user created successfully")
 }
 
+func (u *UserHandler) VerifyEmailHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	req := &VerifyEmailRequest{}
+
+	if err := c.Bind(req); err != nil {
+		return JSONError(c, http.StatusBadRequest, "invalid request body")
+	}
+
+	err := u.user.VerifyEmail(ctx, req.Token)
+	if errors.Is(err, domain.ErrInvalidVerificationToken) {
+		return JSONError(c, http.StatusBadRequest, "invalid or expired verification token")
+	}
+	if err != nil {
+		return JSONError(c, http.StatusInternalServerError, "failed to verify email")
+	}
+	return JSONSucess(c, http.StatusOK, "email verified successfully")
+}
+
+func (u *UserHandler) ResendVerificationHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, ok := c.Get(ContextUserID).(string)
+	if !ok || userID == "" {
+		return JSONError(c, http.StatusUnauthorized, "user ID not available in context")
+	}
+
+	err := u.user.ResendVerification(ctx, userID)
+	if errors.Is(err, domain.ErrEmailAlreadyVerified) {
+		return JSONError(c, http.StatusConflict, "email already verified")
+	}
+	if errors.Is(err, domain.ErrVerificationSentRecently) {
+		return JSONError(c, http.StatusTooManyRequests, "a verification email was sent recently, try again later")
+	}
+	if err != nil {
+		return JSONError(c, http.StatusInternalServerError, "failed to send verification email")
+	}
+	return JSONSucess(c, http.StatusAccepted, "verification email sent")
+}
+
+// ForgotPasswordHandler answers 200 whether or not the email has an account
+// and sends the email in the background, so neither the status nor the
+// response time reveal which addresses are registered. Requests are
+// throttled per email and per address; throttled requests, and requests
+// arriving while too many emails are pending, are dropped with the same 200.
+func (u *UserHandler) ForgotPasswordHandler(c echo.Context) error {
+	req := &ForgotPasswordRequest{}
+	if err := c.Bind(req); err != nil {
+		return JSONError(c, http.StatusBadRequest, "invalid request body")
+	}
+
+	err := u.user.ThrottlePasswordReset(c.Request().Context(), req.Email, ClientFromContext(c))
+	var throttled *domain.TooManyAttemptsError
+	if errors.As(err, &throttled) {
+		fmt.Printf("dropped throttled password reset request, retry after %s\n", throttled.RetryAfter)
+		return JSONSucess(c, http.StatusOK, "if the email is registered, a reset link was sent")
+	}
+	if err != nil {
+		return JSONError(c, http.StatusInternalServerError, "failed to request password reset")
+	}
+
+	select {
+	case u.resets <- struct{}{}:
+	default:
+		fmt.Printf("dropped password reset email, %d already pending\n", maxPendingResetEmails)
+		return JSONSucess(c, http.StatusOK, "if the email is registered, a reset link was sent")
+	}
+	ctx := context.WithoutCancel(c.Request().Context())
+	u.pending.Add(1)
+	go func() {
+		defer u.pending.Done()
+		defer func() { <-u.resets }()
+		if err := u.user.ForgotPassword(ctx, req.Email); err != nil {
+			fmt.Printf("failed to send password reset email: %v\n", err)
+		}
+	}()
+	return JSONSucess(c, http.StatusOK, "if the email is registered, a reset link was sent")
+}
+
+func (u *UserHandler) ResetPasswordHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	req := &ResetPasswordRequest{}
+
+	if err := c.Bind(req); err != nil {
+		return JSONError(c, http.StatusBadRequest, "invalid request body")
+	}
+
+	err := u.user.ResetPassword(ctx, req.Token, req.Password)
+	if errors.Is(err, domain.ErrInvalidResetToken) {
+		return JSONError(c, http.StatusBadRequest, "invalid or expired reset token")
+	}
+	if errors.Is(err, domain.ErrInvalidPassword) {
+		return JSONError(c, http.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		return JSONError(c, http.StatusInternalServerError, "failed to reset password")
+	}
+	return JSONSucess(c, http.StatusOK, "password reset successfully")
+}
+
+// DeleteUserHandler schedules the deletion of the account, it is erased
+// once the grace period is over unless the user follows the mailed link.
 func (u *UserHandler) DeleteUserHandler(c echo.Context) error {
 	ctx := c.Request().Context()
 
@@ -109,11 +356,155 @@ func (u *UserHandler) DeleteUserHandler(c echo.Context) error {
 		return JSONError(c, http.StatusUnauthorized, "user ID not available in context")
 	}
 
-	err := u.user.DeleteUser(ctx, loggedInUserID)
+	req := &DeleteAccountRequest{}
+	if err := c.Bind(req); err != nil || req.Password == "" {
+		return JSONError(c, http.StatusBadRequest, "password is required")
+	}
+
+	scheduledFor, err := u.deletions.RequestDeletion(ctx, loggedInUserID, req.Password)
+	if errors.Is(err, domain.ErrWrongPassword) {
+		return JSONError(c, http.StatusForbidden, "password incorrect")
+	}
+	if errors.Is(err, domain.ErrSubscriptionExists) {
+		return JSONError(c, http.StatusConflict, "cancel the subscription before deleting the account")
+	}
+	if errors.Is(err, domain.ErrUserNotFound) {
+		return JSONError(c, http.StatusNotFound, "user not found")
+	}
+	if err != nil {
+		return JSONError(c, http.StatusInternalServerError, "failed to delete user")
+	}
+	return c.JSON(http.StatusAccepted, map[string]interface{}{
+		"message":       "account scheduled for deletion, follow the link sent by email to keep it",
+		"scheduled_for": scheduledFor,
+	})
+}
+
+func (u *UserHandler) CancelDeletionHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	req := &CancelDeletionRequest{}
+
+	if err := c.Bind(req); err != nil {
+		return JSONError(c, http.StatusBadRequest, "invalid request body")
+	}
+
+	err := u.deletions.CancelDeletion(ctx, req.Token)
+	if errors.Is(err, domain.ErrInvalidUndoToken) {
+		return JSONError(c, http.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		return JSONError(c, http.StatusInternalServerError, "failed to cancel deletion")
+	}
+	return JSONSucess(c, http.StatusOK, "account deletion cancelled, log in again to use it")
+}
+
+// RequestExportHandler queues a copy of the user's data, the download link is
+// mailed once it is built.
+func (u *UserHandler) RequestExportHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, ok := c.Get(ContextUserID).(string)
+	if !ok || userID == "" {
+		return JSONError(c, http.StatusUnauthorized, "user ID not available in context")
+	}
+
+	export, err := u.exports.RequestExport(ctx, userID)
+	if errors.Is(err, domain.ErrExportInProgress) {
+		return JSONError(c, http.StatusConflict, err.Error())
+	}
+	if err != nil {
+		return JSONError(c, http.StatusInternalServerError, "failed to request data export")
+	}
+	return c.JSON(http.StatusAccepted, exportResponse(export))
+}
+
+func (u *UserHandler) ListExportsHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, ok := c.Get(ContextUserID).(string)
+	if !ok || userID == "" {
+		return JSONError(c, http.StatusUnauthorized, "user ID not available in context")
+	}
+
+	exports, err := u.exports.ListExports(ctx, userID)
+	if err != nil {
+		return JSONError(c, http.StatusInternalServerError, "failed to list data exports")
+	}
+	res := make([]DataExportResponse, 0, len(exports))
+	for i := range exports {
+		res = append(res, exportResponse(&exports[i]))
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"exports": res,
+	})
+}
+
+// DownloadExportHandler serves the archive of the mailed link, the token
+// authenticates the download.
+func (u *UserHandler) DownloadExportHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	archive, export, err := u.exports.Download(ctx, c.QueryParam("token"))
+	if errors.Is(err, domain.ErrExportNotFound) {
+		return JSONError(c, http.StatusNotFound, "data export not found or expired")
+	}
+	if err != nil {
+		return JSONError(c, http.StatusInternalServerError, "failed to download data export")
+	}
+	defer archive.Close()
+
+	c.Response().Header().Set("Cache-Control", "no-store")
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", "data-export-"+export.ID+".zip"))
+	return c.Stream(http.StatusOK, "application/zip", archive)
+}
+
+func exportResponse(export *domain.DataExport) DataExportResponse {
+	return DataExportResponse{
+		ID:          export.ID,
+		Status:      export.Status,
+		RequestedAt: export.RequestedAt,
+		CompletedAt: export.CompletedAt,
+		ExpiresAt:   export.ExpiresAt,
+	}
+}
+
+func userResponse(user *domain.User) UserResponse {
+	res := UserResponse{
+		ID:            user.ID,
+		Name:          user.Name,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		Plan:          user.Plan,
+		MFAEnabled:    user.MFAEnabled,
+		Roles:         user.Roles,
+		DisplayName:   user.DisplayName,
+		Bio:           user.Bio,
+		Locale:        user.Locale,
+		Timezone:      user.Timezone,
+		CreatedAt:     user.CreatedAt,
+	}
+	if user.AvatarKey != "" {
+		res.AvatarURL = fmt.Sprintf("/v1/users/%s/avatar/%s", user.ID, path.Base(user.AvatarKey))
+	}
+	return res
+}
+
+func (u *UserHandler) GetCurrentUserHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, ok := c.Get(ContextUserID).(string)
+	if !ok || userID == "" {
+		return JSONError(c, http.StatusUnauthorized, "user ID not available in context")
+	}
+
+	user, err := u.user.CurrentUser(ctx, userID)
+	if errors.Is(err, domain.ErrUserNotFound) {
+		return JSONError(c, http.StatusNotFound, "user not found")
+	}
 	if err != nil {
-		return JSONError(c, http.StatusInternalServerError, fmt.Sprintf("failed to delete user: %s", err))
+		return JSONError(c, http.StatusInternalServerError, "failed to get user")
 	}
-	return JSONSucess(c, http.StatusNoContent, "user deleted successfully")
+	return c.JSON(http.StatusOK, userResponse(user))
 }
 
 func (u *UserHandler) UpdateUserHandler(c echo.Context) error {
@@ -125,21 +516,109 @@ func (u *UserHandler) UpdateUserHandler(c echo.Context) error {
 	}
 
 	req := &UpdateUserRequest{}
-	if err := c.Bind(&req); err != nil {
+	if err := c.Bind(req); err != nil {
 		return JSONError(c, http.StatusBadRequest, "invalid request body format.")
 	}
 
-	if req.Name == "" && req.Email == nil && req.Password == nil {
+	update := domain.UserUpdate{
+		Name:        req.Name,
+		Email:       req.Email,
+		Password:    req.Password,
+		DisplayName: req.DisplayName,
+		Bio:         req.Bio,
+		Locale:      req.Locale,
+		Timezone:    req.Timezone,
+	}
+	if update.Empty() {
 		return JSONError(c, http.StatusBadRequest, "no fields provided")
 	}
 
-	err := u.user.UpdateUser(ctx, loggedInUserID, req.Name, req.Email, req.Password)
+	err := u.user.UpdateUser(ctx, loggedInUserID, update)
+	if errors.Is(err, domain.ErrInvalidUserUpdate) {
+		return JSONError(c, http.StatusBadRequest, err.Error())
+	}
 	if err != nil {
 		return JSONError(c, http.StatusInternalServerError, fmt.Sprintf("failed to update user: %s", err))
 	}
 	return JSONSucess(c, http.StatusOK, "user updated successfully")
 }
 
+// UploadAvatarHandler takes the image from the "avatar" field of a
+// multipart form.
+func (u *UserHandler) UploadAvatarHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, ok := c.Get(ContextUserID).(string)
+	if !ok || userID == "" {
+		return JSONError(c, http.StatusUnauthorized, "user ID not available in context")
+	}
+
+	c.Request().Body = http.MaxBytesReader(c.Response(), c.Request().Body, maxAvatarUploadSize)
+	file, err := c.FormFile("avatar")
+	if err != nil {
+		return JSONError(c, http.StatusBadRequest, "avatar file is required")
+	}
+	src, err := file.Open()
+	if err != nil {
+		return JSONError(c, http.StatusBadRequest, "failed to read avatar")
+	}
+	defer src.Close()
+	image, err := io.ReadAll(io.LimitReader(src, domain.MaxAvatarSize+1))
+	if err != nil {
+		return JSONError(c, http.StatusBadRequest, "failed to read avatar")
+	}
+
+	user, err := u.user.SetAvatar(ctx, userID, image)
+	if errors.Is(err, domain.ErrInvalidAvatar) {
+		return JSONError(c, http.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		return JSONError(c, http.StatusInternalServerError, "failed to update avatar")
+	}
+	return c.JSON(http.StatusOK, userResponse(user))
+}
+
+func (u *UserHandler) DeleteAvatarHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, ok := c.Get(ContextUserID).(string)
+	if !ok || userID == "" {
+		return JSONError(c, http.StatusUnauthorized, "user ID not available in context")
+	}
+
+	err := u.user.RemoveAvatar(ctx, userID)
+	if errors.Is(err, domain.ErrAvatarNotFound) {
+		return JSONError(c, http.StatusNotFound, "no avatar")
+	}
+	if err != nil {
+		return JSONError(c, http.StatusInternalServerError, "failed to remove avatar")
+	}
+	return JSONSucess(c, http.StatusOK, "avatar removed")
+}
+
+// AvatarHandler is public so avatars can be shown in <img> tags. Every
+// upload gets a new file name, the response can be cached forever.
+func (u *UserHandler) AvatarHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if _, err := uuid.Parse(c.Param("id")); err != nil {
+		return JSONError(c, http.StatusNotFound, "avatar not found")
+	}
+
+	body, contentType, err := u.user.Avatar(ctx, c.Param("id"), c.Param("file"))
+	if errors.Is(err, domain.ErrAvatarNotFound) {
+		return JSONError(c, http.StatusNotFound, "avatar not found")
+	}
+	if err != nil {
+		return JSONError(c, http.StatusInternalServerError, "failed to get avatar")
+	}
+	defer body.Close()
+
+	c.Response().Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	c.Response().Header().Set("X-Content-Type-Options", "nosniff")
+	return c.Stream(http.StatusOK, contentType, body)
+}
+
 func (u *UserHandler) LoginHandler(c echo.Context) error {
 	ctx := c.Request().Context()
 	userLogin := &LoginUserRequest{}
@@ -148,11 +627,60 @@ func (u *UserHandler) LoginHandler(c echo.Context) error {
 		return JSONError(c, http.StatusBadRequest, "invalid request body format")
 	}
 
-	userClaims, err := u.user.UserLogin(ctx, userLogin.Email, userLogin.Password)
+	userClaims, err := u.user.UserLogin(ctx, userLogin.Email, userLogin.Password, ClientFromContext(c))
+	var throttled *domain.TooManyAttemptsError
+	if errors.As(err, &throttled) {
+		c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+		return JSONError(c, http.StatusTooManyRequests, "too many login attempts, try again later")
+	}
+	if errors.Is(err, domain.ErrAccountSuspended) || errors.Is(err, domain.ErrDeletionScheduled) {
+		return JSONError(c, http.StatusForbidden, err.Error())
+	}
 	if err != nil {
 		return JSONError(c, http.StatusUnauthorized, "incorrect credentials")
 	}
 
+	if userClaims.MFAChallenge != nil {
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"message":              "two-factor authentication required",
+			"mfa_required":         true,
+			"mfa_token":            userClaims.MFAChallenge.Token,
+			"mfa_token_expires_at": userClaims.MFAChallenge.ExpiresAt,
+		})
+	}
+	return loginResponse(c, userClaims)
+}
+
+func (u *UserHandler) MFALoginHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	req := &MFALoginRequest{}
+
+	if err := c.Bind(req); err != nil {
+		return JSONError(c, http.StatusBadRequest, "invalid request body format")
+	}
+
+	userClaims, err := u.user.CompleteMFALogin(ctx, req.MFAToken, req.Code, ClientFromContext(c))
+	var throttled *domain.TooManyAttemptsError
+	if errors.As(err, &throttled) {
+		c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+		return JSONError(c, http.StatusTooManyRequests, "too many two-factor attempts, try again later")
+	}
+	if errors.Is(err, domain.ErrInvalidMFAChallenge) {
+		return JSONError(c, http.StatusUnauthorized, "invalid or expired mfa token, log in again")
+	}
+	if errors.Is(err, domain.ErrInvalidMFACode) {
+		return JSONError(c, http.StatusUnauthorized, "invalid two-factor code")
+	}
+	if errors.Is(err, domain.ErrAccountSuspended) || errors.Is(err, domain.ErrDeletionScheduled) {
+		return JSONError(c, http.StatusForbidden, err.Error())
+	}
+	if err != nil {
+		return JSONError(c, http.StatusInternalServerError, "failed to log in")
+	}
+	return loginResponse(c, userClaims)
+}
+
+func loginResponse(c echo.Context, userClaims *domain.LoginUserRes) error {
 	refreshToken := userClaims.RefreshToken
 	expiresAtStr := userClaims.RefreshTokenExpiresAt
 
@@ -171,6 +699,57 @@ func (u *UserHandler) LoginHandler(c echo.Context) error {
 	})
 }
 
+func (u *UserHandler) EnrollMFAHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, ok := c.Get(ContextUserID).(string)
+	if !ok || userID == "" {
+		return JSONError(c, http.StatusUnauthorized, "user ID not available in context")
+	}
+
+	enrollment, err := u.user.EnrollMFA(ctx, userID)
+	if errors.Is(err, domain.ErrMFAAlreadyEnabled) {
+		return JSONError(c, http.StatusConflict, "two-factor authentication already enabled")
+	}
+	if err != nil {
+		return JSONError(c, http.StatusInternalServerError, "failed to enroll two-factor authentication")
+	}
+	return c.JSON(http.StatusOK, MFAEnrollmentResponse{
+		Secret:     enrollment.Secret,
+		OTPAuthURI: enrollment.URI,
+	})
+}
+
+func (u *UserHandler) ConfirmMFAHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, ok := c.Get(ContextUserID).(string)
+	if !ok || userID == "" {
+		return JSONError(c, http.StatusUnauthorized, "user ID not available in context")
+	}
+
+	req := &MFACodeRequest{}
+	if err := c.Bind(req); err != nil {
+		return JSONError(c, http.StatusBadRequest, "invalid request body")
+	}
+
+	codes, err := u.user.ConfirmMFA(ctx, userID, req.Code)
+	switch {
+	case errors.Is(err, domain.ErrMFANotEnrolled):
+		return JSONError(c, http.StatusConflict, "two-factor authentication not enrolled")
+	case errors.Is(err, domain.ErrMFAAlreadyEnabled):
+		return JSONError(c, http.StatusConflict, "two-factor authentication already enabled")
+	case errors.Is(err, domain.ErrInvalidMFACode):
+		return JSONError(c, http.StatusBadRequest, "invalid two-factor code")
+	case err != nil:
+		return JSONError(c, http.StatusInternalServerError, "failed to confirm two-factor authentication")
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message":        "two-factor authentication enabled",
+		"recovery_codes": codes,
+	})
+}
+
 func (u *UserHandler) LogoutHandler(c echo.Context) error {
 	ctx := c.Request().Context()
 
@@ -179,7 +758,8 @@ func (u *UserHandler) LogoutHandler(c echo.Context) error {
 		return JSONError(c, http.StatusUnauthorized, "invalid authorization format")
 	}
 
-	err := u.user.UserLogout(ctx, sessionID)
+	userID, _ := c.Get(ContextUserID).(string)
+	err := u.user.UserLogout(ctx, userID, sessionID)
 	if err != nil {
 		return JSONError(c, http.StatusInternalServerError, fmt.Sprintf("failed to logout user: %s", err))
 	}
@@ -198,27 +778,838 @@ func (u *UserHandler) RenewTokenHandler(c echo.Context) error {
 	}
 
 	refreshTokenValue := cookie.Value
-	renewResponse, err := u.user.RenewAccessToken(ctx, refreshTokenValue)
+	renewResponse, err := u.user.RenewAccessToken(ctx, refreshTokenValue, ClientFromContext(c))
+	if errors.Is(err, domain.ErrRefreshTokenReused) || errors.Is(err, domain.ErrSessionRevoked) {
+		SetRefreshTokenCookie(c, "", time.Unix(0, 0))
+		return JSONError(c, http.StatusUnauthorized, "session revoked")
+	}
+	if errors.Is(err, domain.ErrInvalidRefreshToken) {
+		SetRefreshTokenCookie(c, "", time.Unix(0, 0))
+		return JSONError(c, http.StatusUnauthorized, "invalid refresh token")
+	}
+	if errors.Is(err, domain.ErrAccountSuspended) || errors.Is(err, domain.ErrDeletionScheduled) {
+		SetRefreshTokenCookie(c, "", time.Unix(0, 0))
+		return JSONError(c, http.StatusForbidden, err.Error())
+	}
 	if err != nil {
 		return JSONError(c, http.StatusInternalServerError, "failed to renew token")
 	}
+
+	SetRefreshTokenCookie(c, renewResponse.RefreshToken, renewResponse.RefreshTokenExpiresAt)
+
 	return c.JSON(http.StatusOK, map[string]interface{}{
-		"message":      "renew successfully",
-		"access_token": renewResponse.AccessToken,
+		"message":                "renew successfully",
+		"access_token":           renewResponse.AccessToken,
+		"acess_token_expires_at": renewResponse.AcessTokenExpiresAt,
 	})
 }
 
-func (u *UserHandler) RevokeTokenHandler(c echo.Context) error {
+func (u *UserHandler) ListPlansHandler(c echo.Context) error {
+	plans, err := u.user.ListPlans(c.Request().Context())
+	if err != nil {
+		return JSONError(c, http.StatusInternalServerError, "failed to list plans")
+	}
+
+	res := make([]PlanResponse, 0, len(plans))
+	for _, p := range plans {
+		res = append(res, planResponse(p))
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"plans": res,
+	})
+}
+
+func planResponse(p domain.Plan) PlanResponse {
+	return PlanResponse{
+		ID:            p.ID,
+		Name:          p.Name,
+		Description:   p.Description,
+		PriceCents:    p.PriceCents,
+		Currency:      p.Currency,
+		MaxStreams:    p.MaxStreams,
+		MaxProfiles:   p.MaxProfiles,
+		MaxResolution: p.MaxResolution,
+		Features:      p.Features,
+		Default:       p.IsDefault,
+	}
+}
+
+func (u *UserHandler) SwitchPlanHandler(c echo.Context) error {
 	ctx := c.Request().Context()
 
-	SessionID, ok := c.Get(ContextSessionID).(string)
-	if !ok || SessionID == "" {
+	userID, ok := c.Get(ContextUserID).(string)
+	if !ok || userID == "" {
 		return JSONError(c, http.StatusUnauthorized, "user ID not available in context")
 	}
 
-	err := u.user.RevokeSession(ctx, SessionID)
+	req := &ChangePlanRequest{}
+	if err := c.Bind(req); err != nil || req.Plan == nil {
+		return JSONError(c, http.StatusBadRequest, "plan is required")
+	}
+
+	plan, err := u.user.SwitchPlan(ctx, userID, *req.Plan)
+	if errors.Is(err, domain.ErrPlanNotFound) || errors.Is(err, domain.ErrPlanUnavailable) {
+		return JSONError(c, http.StatusBadRequest, "unknown plan")
+	}
+	if errors.Is(err, domain.ErrSamePlan) {
+		return JSONError(c, http.StatusConflict, err.Error())
+	}
+	if errors.Is(err, domain.ErrPaymentRequired) {
+		return JSONError(c, http.StatusPaymentRequired, "subscribe to choose a paid plan")
+	}
+	if errors.Is(err, domain.ErrSubscriptionExists) {
+		return JSONError(c, http.StatusConflict, "cancel the subscription to leave a paid plan")
+	}
 	if err != nil {
-		return JSONError(c, http.StatusInternalServerError, fmt.Sprintf("failed to revoke session %s", err))
+		return JSONError(c, http.StatusInternalServerError, "failed to change plan")
 	}
-	return JSONSucess(c, http.StatusOK, "session revoked successfully")
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": "plan changed successfully, renew the access token to use it",
+		"plan":    planResponse(*plan),
+	})
+}
+
+func subscriptionResponse(s *domain.Subscription) SubscriptionResponse {
+	return SubscriptionResponse{
+		ID:                s.ID,
+		Plan:              s.PlanID,
+		Status:            s.Status,
+		TrialEndsAt:       s.TrialEndsAt,
+		CurrentPeriodEnd:  s.CurrentPeriodEnd,
+		GraceEndsAt:       s.GraceEndsAt,
+		CancelAtPeriodEnd: s.CancelAtPeriodEnd,
+		CanceledAt:        s.CanceledAt,
+		CreatedAt:         s.CreatedAt,
+	}
+}
+
+func (u *UserHandler) GetSubscriptionHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, ok := c.Get(ContextUserID).(string)
+	if !ok || userID == "" {
+		return JSONError(c, http.StatusUnauthorized, "user ID not available in context")
+	}
+
+	sub, err := u.billing.Subscription(ctx, userID)
+	if errors.Is(err, domain.ErrSubscriptionNotFound) {
+		return JSONError(c, http.StatusNotFound, "no subscription")
+	}
+	if err != nil {
+		return JSONError(c, http.StatusInternalServerError, "failed to get subscription")
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"subscription": subscriptionResponse(sub),
+	})
+}
+
+func (u *UserHandler) SubscribeHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, ok := c.Get(ContextUserID).(string)
+	if !ok || userID == "" {
+		return JSONError(c, http.StatusUnauthorized, "user ID not available in context")
+	}
+
+	req := &SubscribeRequest{}
+	if err := c.Bind(req); err != nil || req.Plan == nil {
+		return JSONError(c, http.StatusBadRequest, "plan is required")
+	}
+
+	sub, err := u.billing.Subscribe(ctx, userID, *req.Plan)
+	if errors.Is(err, domain.ErrPlanNotFound) || errors.Is(err, domain.ErrPlanUnavailable) {
+		return JSONError(c, http.StatusBadRequest, "unknown paid plan")
+	}
+	if errors.Is(err, domain.ErrEmailNotVerified) {
+		return JSONError(c, http.StatusForbidden, "verify your email before subscribing")
+	}
+	if errors.Is(err, domain.ErrSubscriptionExists) {
+		return JSONError(c, http.StatusConflict, err.Error())
+	}
+	if errors.Is(err, domain.ErrBillingDisabled) {
+		return JSONError(c, http.StatusServiceUnavailable, err.Error())
+	}
+	if err != nil {
+		return JSONError(c, http.StatusInternalServerError, "failed to subscribe")
+	}
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"message":      "subscribed successfully",
+		"subscription": subscriptionResponse(sub),
+	})
+}
+
+func (u *UserHandler) CancelSubscriptionHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, ok := c.Get(ContextUserID).(string)
+	if !ok || userID == "" {
+		return JSONError(c, http.StatusUnauthorized, "user ID not available in context")
+	}
+
+	sub, err := u.billing.CancelSubscription(ctx, userID)
+	if errors.Is(err, domain.ErrSubscriptionNotFound) {
+		return JSONError(c, http.StatusNotFound, "no subscription")
+	}
+	if errors.Is(err, domain.ErrSubscriptionCancelling) {
+		return JSONError(c, http.StatusConflict, err.Error())
+	}
+	if err != nil {
+		return JSONError(c, http.StatusInternalServerError, "failed to cancel subscription")
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message":      "subscription cancelled",
+		"subscription": subscriptionResponse(sub),
+	})
+}
+
+// BillingWebhookHandler needs the exact body the provider signed, it reads
+// it raw instead of binding it.
+func (u *UserHandler) BillingWebhookHandler(c echo.Context) error {
+	payload, err := io.ReadAll(io.LimitReader(c.Request().Body, maxWebhookSize))
+	if err != nil {
+		return JSONError(c, http.StatusBadRequest, "failed to read body")
+	}
+
+	err = u.billing.HandleWebhook(c.Request().Context(), payload, c.Request().Header.Get(HeaderBillingSignature))
+	if errors.Is(err, domain.ErrInvalidWebhook) {
+		return JSONError(c, http.StatusBadRequest, "invalid webhook")
+	}
+	if err != nil {
+		// The provider retries failed deliveries.
+		return JSONError(c, http.StatusInternalServerError, "failed to process webhook")
+	}
+	return JSONSucess(c, http.StatusOK, "webhook processed")
+}
+
+func (u *UserHandler) ListSessionsHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, ok := c.Get(ContextUserID).(string)
+	if !ok || userID == "" {
+		return JSONError(c, http.StatusUnauthorized, "user ID not available in context")
+	}
+	currentID, _ := c.Get(ContextSessionID).(string)
+
+	sessions, err := u.user.ListSessions(ctx, userID)
+	if err != nil {
+		return JSONError(c, http.StatusInternalServerError, "failed to list sessions")
+	}
+
+	res := make([]SessionResponse, 0, len(sessions))
+	for _, s := range sessions {
+		res = append(res, SessionResponse{
+			ID:         s.ID,
+			Device:     s.UserAgent,
+			IP:         s.IP,
+			CreatedAt:  s.CreatedAt,
+			LastUsedAt: s.LastUsedAt,
+			ExpiresAt:  s.ExpiresAt,
+			Current:    s.ID == currentID,
+		})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"sessions": res,
+	})
+}
+
+func (u *UserHandler) RevokeTokenHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, ok := c.Get(ContextUserID).(string)
+	if !ok || userID == "" {
+		return JSONError(c, http.StatusUnauthorized, "user ID not available in context")
+	}
+
+	sessionID := c.Param("id")
+	if _, err := uuid.Parse(sessionID); err != nil {
+		return JSONError(c, http.StatusNotFound, "session not found")
+	}
+
+	err := u.user.RevokeSession(ctx, userID, sessionID)
+	if errors.Is(err, domain.ErrSessionNotFound) {
+		return JSONError(c, http.StatusNotFound, "session not found")
+	}
+	if err != nil {
+		return JSONError(c, http.StatusInternalServerError, fmt.Sprintf("failed to revoke session %s", err))
+	}
+	return JSONSucess(c, http.StatusOK, "session revoked successfully")
+}
+
+func (u *UserHandler) RevokeOtherSessionsHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, ok := c.Get(ContextUserID).(string)
+	if !ok || userID == "" {
+		return JSONError(c, http.StatusUnauthorized, "user ID not available in context")
+	}
+	currentID, ok := c.Get(ContextSessionID).(string)
+	if !ok || currentID == "" {
+		return JSONError(c, http.StatusUnauthorized, "session ID not available in context")
+	}
+
+	revoked, err := u.user.RevokeOtherSessions(ctx, userID, currentID)
+	if err != nil {
+		return JSONError(c, http.StatusInternalServerError, "failed to revoke sessions")
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": "sessions revoked successfully",
+		"revoked": revoked,
+	})
+}
+
+func (u *UserHandler) ListRolesHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	roles, err := u.user.ListRoles(ctx)
+	if err != nil {
+		return JSONError(c, http.StatusInternalServerError, "failed to list roles")
+	}
+
+	res := make([]RoleResponse, 0, len(roles))
+	for _, r := range roles {
+		res = append(res, RoleResponse{
+			Name:        r.Name,
+			Description: r.Description,
+			Default:     r.IsDefault,
+			Permissions: r.Permissions,
+		})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"roles": res,
+	})
+}
+
+func (u *UserHandler) GrantRoleHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	actorID, _ := c.Get(ContextUserID).(string)
+	userID := c.Param("id")
+	if _, err := uuid.Parse(userID); err != nil {
+		return JSONError(c, http.StatusNotFound, "user not found")
+	}
+
+	err := u.user.GrantRole(ctx, actorID, userID, c.Param("role"))
+	if errors.Is(err, domain.ErrUserNotFound) {
+		return JSONError(c, http.StatusNotFound, "user not found")
+	}
+	if errors.Is(err, domain.ErrRoleNotFound) {
+		return JSONError(c, http.StatusNotFound, "role not found")
+	}
+	if err != nil {
+		return JSONError(c, http.StatusInternalServerError, "failed to grant role")
+	}
+	return JSONSucess(c, http.StatusOK, "role granted successfully")
+}
+
+func (u *UserHandler) RevokeRoleHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	actorID, _ := c.Get(ContextUserID).(string)
+	userID := c.Param("id")
+	if _, err := uuid.Parse(userID); err != nil {
+		return JSONError(c, http.StatusNotFound, "user not found")
+	}
+
+	err := u.user.RevokeRole(ctx, actorID, userID, c.Param("role"))
+	if errors.Is(err, domain.ErrRoleNotFound) {
+		return JSONError(c, http.StatusNotFound, "user doesn't have this role")
+	}
+	if err != nil {
+		return JSONError(c, http.StatusInternalServerError, "failed to revoke role")
+	}
+	return JSONSucess(c, http.StatusOK, "role revoked successfully")
+}
+
+func userSummaryResponse(user domain.UserSummary) UserSummaryResponse {
+	return UserSummaryResponse{
+		ID:               user.ID,
+		Name:             user.Name,
+		Email:            user.Email,
+		Plan:             user.Plan,
+		Status:           user.Status,
+		EmailVerified:    user.EmailVerified,
+		Roles:            user.Roles,
+		SuspendedAt:      user.SuspendedAt,
+		SuspensionReason: user.SuspensionReason,
+		CreatedAt:        user.CreatedAt,
+	}
+}
+
+func (u *UserHandler) SearchUsersHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	filter := domain.UserFilter{
+		Email:  c.QueryParam("email"),
+		Status: c.QueryParam("status"),
+	}
+	if plan := c.QueryParam("plan"); plan != "" {
+		p, err := strconv.ParseInt(plan, 10, 8)
+		if err != nil {
+			return JSONError(c, http.StatusBadRequest, "invalid plan")
+		}
+		filter.Plan = new(int8)
+		*filter.Plan = int8(p)
+	}
+	for param, dst := range map[string]*int{"page": &filter.Page, "per_page": &filter.PerPage} {
+		if v := c.QueryParam(param); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return JSONError(c, http.StatusBadRequest, "invalid "+param)
+			}
+			*dst = n
+		}
+	}
+
+	page, err := u.user.SearchUsers(ctx, filter)
+	if errors.Is(err, domain.ErrInvalidFilter) {
+		return JSONError(c, http.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		return JSONError(c, http.StatusInternalServerError, "failed to search users")
+	}
+
+	res := make([]UserSummaryResponse, 0, len(page.Users))
+	for _, user := range page.Users {
+		res = append(res, userSummaryResponse(user))
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"users":    res,
+		"total":    page.Total,
+		"page":     page.Page,
+		"per_page": page.PerPage,
+	})
+}
+
+func (u *UserHandler) GetUserDetailsHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID := c.Param("id")
+	if _, err := uuid.Parse(userID); err != nil {
+		return JSONError(c, http.StatusNotFound, "user not found")
+	}
+
+	details, err := u.user.GetUserDetails(ctx, userID)
+	if errors.Is(err, domain.ErrUserNotFound) {
+		return JSONError(c, http.StatusNotFound, "user not found")
+	}
+	if err != nil {
+		return JSONError(c, http.StatusInternalServerError, "failed to get user")
+	}
+
+	sessions := make([]SessionResponse, 0, len(details.Sessions))
+	for _, s := range details.Sessions {
+		sessions = append(sessions, SessionResponse{
+			ID:         s.ID,
+			Device:     s.UserAgent,
+			IP:         s.IP,
+			CreatedAt:  s.CreatedAt,
+			LastUsedAt: s.LastUsedAt,
+			ExpiresAt:  s.ExpiresAt,
+		})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"user":     userSummaryResponse(details.User),
+		"sessions": sessions,
+	})
+}
+
+func (u *UserHandler) SuspendUserHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	actorID, _ := c.Get(ContextUserID).(string)
+	userID := c.Param("id")
+	if _, err := uuid.Parse(userID); err != nil {
+		return JSONError(c, http.StatusNotFound, "user not found")
+	}
+
+	req := &SuspendUserRequest{}
+	if err := c.Bind(req); err != nil {
+		return JSONError(c, http.StatusBadRequest, "invalid request body format")
+	}
+
+	err := u.user.SuspendUser(ctx, actorID, userID, req.Reason)
+	if errors.Is(err, domain.ErrCannotManageSelf) {
+		return JSONError(c, http.StatusBadRequest, err.Error())
+	}
+	if errors.Is(err, domain.ErrUserNotFound) {
+		return JSONError(c, http.StatusNotFound, "user not found")
+	}
+	if err != nil {
+		return JSONError(c, http.StatusInternalServerError, "failed to suspend user")
+	}
+	return JSONSucess(c, http.StatusOK, "user suspended successfully")
+}
+
+func (u *UserHandler) UnsuspendUserHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	actorID, _ := c.Get(ContextUserID).(string)
+	userID := c.Param("id")
+	if _, err := uuid.Parse(userID); err != nil {
+		return JSONError(c, http.StatusNotFound, "user not found")
+	}
+
+	err := u.user.UnsuspendUser(ctx, actorID, userID)
+	if errors.Is(err, domain.ErrUserNotFound) {
+		return JSONError(c, http.StatusNotFound, "user not found")
+	}
+	if err != nil {
+		return JSONError(c, http.StatusInternalServerError, "failed to unsuspend user")
+	}
+	return JSONSucess(c, http.StatusOK, "user unsuspended successfully")
+}
+
+func (u *UserHandler) ForceLogoutHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	actorID, _ := c.Get(ContextUserID).(string)
+	userID := c.Param("id")
+	if _, err := uuid.Parse(userID); err != nil {
+		return JSONError(c, http.StatusNotFound, "user not found")
+	}
+
+	revoked, err := u.user.ForceLogout(ctx, actorID, userID)
+	if errors.Is(err, domain.ErrUserNotFound) {
+		return JSONError(c, http.StatusNotFound, "user not found")
+	}
+	if err != nil {
+		return JSONError(c, http.StatusInternalServerError, "failed to log user out")
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": "sessions revoked successfully",
+		"revoked": revoked,
+	})
+}
+
+func (u *UserHandler) ChangePlanHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	actorID, _ := c.Get(ContextUserID).(string)
+	userID := c.Param("id")
+	if _, err := uuid.Parse(userID); err != nil {
+		return JSONError(c, http.StatusNotFound, "user not found")
+	}
+
+	req := &ChangePlanRequest{}
+	if err := c.Bind(req); err != nil || req.Plan == nil {
+		return JSONError(c, http.StatusBadRequest, "plan is required")
+	}
+
+	err := u.user.ChangePlan(ctx, actorID, userID, *req.Plan)
+	if errors.Is(err, domain.ErrPlanNotFound) {
+		return JSONError(c, http.StatusBadRequest, "unknown plan")
+	}
+	if errors.Is(err, domain.ErrSamePlan) {
+		return JSONError(c, http.StatusConflict, err.Error())
+	}
+	if errors.Is(err, domain.ErrUserNotFound) {
+		return JSONError(c, http.StatusNotFound, "user not found")
+	}
+	if err != nil {
+		return JSONError(c, http.StatusInternalServerError, "failed to change plan")
+	}
+	return JSONSucess(c, http.StatusOK, "plan changed successfully")
+}
+
+func (u *UserHandler) AdminDeleteUserHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	actorID, _ := c.Get(ContextUserID).(string)
+	userID := c.Param("id")
+	if _, err := uuid.Parse(userID); err != nil {
+		return JSONError(c, http.StatusNotFound, "user not found")
+	}
+
+	err := u.deletions.AdminDeleteUser(ctx, actorID, userID)
+	if errors.Is(err, domain.ErrCannotManageSelf) {
+		return JSONError(c, http.StatusBadRequest, err.Error())
+	}
+	if errors.Is(err, domain.ErrSubscriptionExists) {
+		return JSONError(c, http.StatusConflict, "cancel the subscription before deleting the account")
+	}
+	if errors.Is(err, domain.ErrUserNotFound) {
+		return JSONError(c, http.StatusNotFound, "user not found")
+	}
+	if err != nil {
+		return JSONError(c, http.StatusInternalServerError, "failed to delete user")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func profileResponse(p *domain.Profile) ProfileResponse {
+	return ProfileResponse{
+		ID:          p.ID,
+		Name:        p.Name,
+		Avatar:      p.Avatar,
+		MaxMaturity: p.MaxMaturity,
+		HasPIN:      p.HasPIN(),
+		CreatedAt:   p.CreatedAt,
+	}
+}
+
+func (r *ProfileRequest) input() domain.ProfileInput {
+	return domain.ProfileInput{
+		Name:        r.Name,
+		Avatar:      r.Avatar,
+		MaxMaturity: r.MaxMaturity,
+		PIN:         r.PIN,
+	}
+}
+
+// profileErrorStatus maps the errors shared by the profile handlers to a
+// status and message, the status is 0 for the others.
+func profileErrorStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, domain.ErrProfileNotFound):
+		return http.StatusNotFound, "profile not found"
+	case errors.Is(err, domain.ErrInvalidProfile):
+		return http.StatusBadRequest, err.Error()
+	case errors.Is(err, domain.ErrProfileRestricted):
+		return http.StatusForbidden, err.Error()
+	case errors.Is(err, domain.ErrProfileNameTaken), errors.Is(err, domain.ErrProfileLimit), errors.Is(err, domain.ErrProfileInUse):
+		return http.StatusConflict, err.Error()
+	}
+	return 0, ""
+}
+
+func (u *UserHandler) ListProfilesHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, ok := c.Get(ContextUserID).(string)
+	if !ok || userID == "" {
+		return JSONError(c, http.StatusUnauthorized, "user ID not available in context")
+	}
+
+	profiles, err := u.user.ListProfiles(ctx, userID)
+	if err != nil {
+		return JSONError(c, http.StatusInternalServerError, "failed to list profiles")
+	}
+
+	res := make([]ProfileResponse, 0, len(profiles))
+	for i := range profiles {
+		res = append(res, profileResponse(&profiles[i]))
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"profiles": res,
+	})
+}
+
+func (u *UserHandler) CreateProfileHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, ok := c.Get(ContextUserID).(string)
+	if !ok || userID == "" {
+		return JSONError(c, http.StatusUnauthorized, "user ID not available in context")
+	}
+	currentProfileID, _ := c.Get(ContextProfileID).(string)
+
+	req := &ProfileRequest{}
+	if err := c.Bind(req); err != nil {
+		return JSONError(c, http.StatusBadRequest, "invalid request body")
+	}
+
+	profile, err := u.user.CreateProfile(ctx, userID, currentProfileID, req.input())
+	if status, message := profileErrorStatus(err); status != 0 {
+		return JSONError(c, status, message)
+	}
+	if err != nil {
+		return JSONError(c, http.StatusInternalServerError, "failed to create profile")
+	}
+	return c.JSON(http.StatusCreated, profileResponse(profile))
+}
+
+func (u *UserHandler) UpdateProfileHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, ok := c.Get(ContextUserID).(string)
+	if !ok || userID == "" {
+		return JSONError(c, http.StatusUnauthorized, "user ID not available in context")
+	}
+	currentProfileID, _ := c.Get(ContextProfileID).(string)
+
+	if _, err := uuid.Parse(c.Param("id")); err != nil {
+		return JSONError(c, http.StatusNotFound, "profile not found")
+	}
+	req := &ProfileRequest{}
+	if err := c.Bind(req); err != nil {
+		return JSONError(c, http.StatusBadRequest, "invalid request body")
+	}
+
+	profile, err := u.user.UpdateProfile(ctx, userID, currentProfileID, c.Param("id"), req.input())
+	if status, message := profileErrorStatus(err); status != 0 {
+		return JSONError(c, status, message)
+	}
+	if err != nil {
+		return JSONError(c, http.StatusInternalServerError, "failed to update profile")
+	}
+	return c.JSON(http.StatusOK, profileResponse(profile))
+}
+
+func (u *UserHandler) DeleteProfileHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, ok := c.Get(ContextUserID).(string)
+	if !ok || userID == "" {
+		return JSONError(c, http.StatusUnauthorized, "user ID not available in context")
+	}
+	currentProfileID, _ := c.Get(ContextProfileID).(string)
+
+	if _, err := uuid.Parse(c.Param("id")); err != nil {
+		return JSONError(c, http.StatusNotFound, "profile not found")
+	}
+
+	err := u.user.DeleteProfile(ctx, userID, currentProfileID, c.Param("id"))
+	if status, message := profileErrorStatus(err); status != 0 {
+		return JSONError(c, status, message)
+	}
+	if err != nil {
+		return JSONError(c, http.StatusInternalServerError, "failed to delete profile")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// SwitchProfileHandler answers a new access token carrying the profile, the
+// refresh token keeps working and renews into the same profile.
+func (u *UserHandler) SwitchProfileHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, ok := c.Get(ContextUserID).(string)
+	if !ok || userID == "" {
+		return JSONError(c, http.StatusUnauthorized, "user ID not available in context")
+	}
+	sessionID, ok := c.Get(ContextSessionID).(string)
+	if !ok || sessionID == "" {
+		return JSONError(c, http.StatusUnauthorized, "session ID not available in context")
+	}
+
+	if _, err := uuid.Parse(c.Param("id")); err != nil {
+		return JSONError(c, http.StatusNotFound, "profile not found")
+	}
+	req := &SwitchProfileRequest{}
+	if err := c.Bind(req); err != nil {
+		return JSONError(c, http.StatusBadRequest, "invalid request body")
+	}
+
+	switched, err := u.user.SwitchProfile(ctx, userID, sessionID, c.Param("id"), req.PIN, ClientFromContext(c))
+	var throttled *domain.TooManyAttemptsError
+	if errors.As(err, &throttled) {
+		c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+		return JSONError(c, http.StatusTooManyRequests, "too many PIN attempts, try again later")
+	}
+	if errors.Is(err, domain.ErrInvalidPIN) {
+		return JSONError(c, http.StatusForbidden, "invalid PIN")
+	}
+	if errors.Is(err, domain.ErrAccountSuspended) || errors.Is(err, domain.ErrDeletionScheduled) {
+		return JSONError(c, http.StatusForbidden, err.Error())
+	}
+	if errors.Is(err, domain.ErrSessionNotFound) {
+		return JSONError(c, http.StatusUnauthorized, "session revoked")
+	}
+	if status, message := profileErrorStatus(err); status != 0 {
+		return JSONError(c, status, message)
+	}
+	if err != nil {
+		return JSONError(c, http.StatusInternalServerError, "failed to switch profile")
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message":                "profile switched",
+		"access_token":           switched.AccessToken,
+		"acess_token_expires_at": switched.AccessTokenExpiresAt,
+		"profile":                profileResponse(switched.Profile),
+	})
+}
+
+func (u *UserHandler) SearchAuditEventsHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	filter := domain.AuditFilter{
+		UserID:  c.QueryParam("user_id"),
+		ActorID: c.QueryParam("actor_id"),
+		Type:    c.QueryParam("type"),
+		IP:      c.QueryParam("ip"),
+	}
+	for param, id := range map[string]string{"user_id": filter.UserID, "actor_id": filter.ActorID} {
+		if _, err := uuid.Parse(id); id != "" && err != nil {
+			return JSONError(c, http.StatusBadRequest, "invalid "+param)
+		}
+	}
+	for param, dst := range map[string]**time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if v := c.QueryParam(param); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return JSONError(c, http.StatusBadRequest, param+" must be an RFC 3339 time")
+			}
+			*dst = &t
+		}
+	}
+	for param, dst := range map[string]*int{"page": &filter.Page, "per_page": &filter.PerPage} {
+		if v := c.QueryParam(param); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return JSONError(c, http.StatusBadRequest, "invalid "+param)
+			}
+			*dst = n
+		}
+	}
+
+	page, err := u.user.SearchAuditEvents(ctx, filter)
+	if errors.Is(err, domain.ErrInvalidFilter) {
+		return JSONError(c, http.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		return JSONError(c, http.StatusInternalServerError, "failed to search audit events")
+	}
+
+	res := make([]AuditEventResponse, 0, len(page.Events))
+	for _, event := range page.Events {
+		res = append(res, AuditEventResponse{
+			ID:        event.ID,
+			Type:      event.Type,
+			ActorID:   event.ActorID,
+			UserID:    event.UserID,
+			SessionID: event.SessionID,
+			IP:        event.IP,
+			UserAgent: event.UserAgent,
+			Metadata:  event.Metadata,
+			CreatedAt: event.CreatedAt,
+		})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"events":   res,
+		"total":    page.Total,
+		"page":     page.Page,
+		"per_page": page.PerPage,
+	})
+}
+
+// SecurityActivityHandler shows the user what happened to their account,
+// without the IDs of the admins involved.
+func (u *UserHandler) SecurityActivityHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, ok := c.Get(ContextUserID).(string)
+	if !ok || userID == "" {
+		return JSONError(c, http.StatusUnauthorized, "user ID not available in context")
+	}
+	currentID, _ := c.Get(ContextSessionID).(string)
+
+	events, err := u.user.SecurityActivity(ctx, userID)
+	if err != nil {
+		return JSONError(c, http.StatusInternalServerError, "failed to list security activity")
+	}
+
+	res := make([]SecurityActivityResponse, 0, len(events))
+	for _, event := range events {
+		res = append(res, SecurityActivityResponse{
+			Type:           event.Type,
+			Device:         event.UserAgent,
+			IP:             event.IP,
+			ByAdmin:        event.ActorID != "" && event.ActorID != userID,
+			CurrentSession: event.SessionID != "" && event.SessionID == currentID,
+			CreatedAt:      event.CreatedAt,
+		})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"events": res,
+	})
 }
@@ -5,7 +5,6 @@ import "time"
 type UserRequest struct {
 	Name     string `json:"name"`
 	Email    string `json:"email"`
-	Plan     int8   `json:"plan"`
 	Password string `json:"password"`
 }
 
@@ -15,12 +14,183 @@ type LoginUserRequest struct {
 }
 
 type UpdateUserRequest struct {
-	Name     string  `json:"name,omitempty"`
-	Email    *string `json:"email,omitempty"`
-	Password *string `json:"password,omitempty"`
+	Name        string  `json:"name,omitempty"`
+	Email       *string `json:"email,omitempty"`
+	Password    *string `json:"password,omitempty"`
+	DisplayName *string `json:"display_name,omitempty"`
+	Bio         *string `json:"bio,omitempty"`
+	Locale      *string `json:"locale,omitempty"`
+	Timezone    *string `json:"timezone,omitempty"`
+}
+
+type UserResponse struct {
+	ID            string    `json:"id"`
+	Name          string    `json:"name"`
+	Email         string    `json:"email"`
+	EmailVerified bool      `json:"email_verified"`
+	Plan          int8      `json:"plan"`
+	MFAEnabled    bool      `json:"mfa_enabled"`
+	Roles         []string  `json:"roles"`
+	DisplayName   string    `json:"display_name"`
+	AvatarURL     string    `json:"avatar_url,omitempty"`
+	Bio           string    `json:"bio"`
+	Locale        string    `json:"locale"`
+	Timezone      string    `json:"timezone"`
+	CreatedAt     time.Time `json:"created_at"`
 }
 
 type RenewAccessTokenRes struct {
 	AccessToken         string    `json:"access_token"`
 	AcessTokenExpiresAt time.Time `json:"acess_token_expires_at"`
 }
+
+type SessionResponse struct {
+	ID         string    `json:"id"`
+	Device     string    `json:"device"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
+}
+
+type DataExportResponse struct {
+	ID          string     `json:"id"`
+	Status      string     `json:"status"`
+	RequestedAt time.Time  `json:"requested_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+}
+
+type CancelDeletionRequest struct {
+	Token string `json:"token"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+type MFALoginRequest struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"`
+}
+
+type MFACodeRequest struct {
+	Code string `json:"code"`
+}
+
+type MFAEnrollmentResponse struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+type RoleResponse struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Default     bool     `json:"default"`
+	Permissions []string `json:"permissions"`
+}
+
+type UserSummaryResponse struct {
+	ID               string     `json:"id"`
+	Name             string     `json:"name"`
+	Email            string     `json:"email"`
+	Plan             int8       `json:"plan"`
+	Status           string     `json:"status"`
+	EmailVerified    bool       `json:"email_verified"`
+	Roles            []string   `json:"roles"`
+	SuspendedAt      *time.Time `json:"suspended_at,omitempty"`
+	SuspensionReason string     `json:"suspension_reason,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+}
+
+type SuspendUserRequest struct {
+	Reason string `json:"reason"`
+}
+
+type ChangePlanRequest struct {
+	Plan *int8 `json:"plan"`
+}
+
+type PlanResponse struct {
+	ID            int8     `json:"id"`
+	Name          string   `json:"name"`
+	Description   string   `json:"description"`
+	PriceCents    int      `json:"price_cents"`
+	Currency      string   `json:"currency"`
+	MaxStreams    int      `json:"max_streams"`
+	MaxProfiles   int      `json:"max_profiles"`
+	MaxResolution int      `json:"max_resolution"`
+	Features      []string `json:"features"`
+	Default       bool     `json:"default"`
+}
+
+type SubscribeRequest struct {
+	Plan *int8 `json:"plan"`
+}
+
+type SubscriptionResponse struct {
+	ID                string     `json:"id"`
+	Plan              int8       `json:"plan"`
+	Status            string     `json:"status"`
+	TrialEndsAt       *time.Time `json:"trial_ends_at,omitempty"`
+	CurrentPeriodEnd  *time.Time `json:"current_period_end,omitempty"`
+	GraceEndsAt       *time.Time `json:"grace_ends_at,omitempty"`
+	CancelAtPeriodEnd bool       `json:"cancel_at_period_end"`
+	CanceledAt        *time.Time `json:"canceled_at,omitempty"`
+	CreatedAt         time.Time  `json:"created_at"`
+}
+
+type ProfileRequest struct {
+	Name        *string `json:"name"`
+	Avatar      *string `json:"avatar"`
+	MaxMaturity *int    `json:"max_maturity"`
+	PIN         *string `json:"pin"`
+}
+
+type ProfileResponse struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Avatar      string    `json:"avatar"`
+	MaxMaturity int       `json:"max_maturity"`
+	HasPIN      bool      `json:"has_pin"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type SwitchProfileRequest struct {
+	PIN string `json:"pin"`
+}
+
+type AuditEventResponse struct {
+	ID        int64             `json:"id"`
+	Type      string            `json:"type"`
+	ActorID   string            `json:"actor_id,omitempty"`
+	UserID    string            `json:"user_id,omitempty"`
+	SessionID string            `json:"session_id,omitempty"`
+	IP        string            `json:"ip,omitempty"`
+	UserAgent string            `json:"user_agent,omitempty"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+type SecurityActivityResponse struct {
+	Type           string    `json:"type"`
+	Device         string    `json:"device,omitempty"`
+	IP             string    `json:"ip,omitempty"`
+	ByAdmin        bool      `json:"by_admin"`
+	CurrentSession bool      `json:"current_session"`
+	CreatedAt      time.Time `json:"created_at"`
+}
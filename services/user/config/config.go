@@ -0,0 +1,229 @@
+package config
+
+import (
+	"net"
+	"net/mail"
+	"net/url"
+	"time"
+
+	"github.com/eduardo-ax/video-streaming/pkg/auth"
+	"github.com/eduardo-ax/video-streaming/pkg/configloader"
+	"github.com/eduardo-ax/video-streaming/pkg/telemetry"
+)
+
+const (
+	serviceName      = "user"
+	MinSecretKeySize = 32
+
+	minWebhookSecretSize = 16
+
+	// Retired keys must verify refresh tokens until they expire.
+	minKeyGracePeriod = 24 * time.Hour
+)
+
+type Config struct {
+	HTTP       HTTP       `yaml:"http"`
+	Database   Database   `yaml:"database"`
+	Auth       Auth       `yaml:"auth"`
+	Mail       Mail       `yaml:"mail"`
+	Lockout    Lockout    `yaml:"lockout"`
+	MessageBus MessageBus `yaml:"message_bus"`
+	Billing    Billing    `yaml:"billing"`
+	Deletion   Deletion   `yaml:"deletion"`
+	Export     Export     `yaml:"export"`
+	S3         S3         `yaml:"s3"`
+	Tracing    Tracing    `yaml:"tracing"`
+}
+
+type HTTP struct {
+	Addr            string        `yaml:"addr" env:"HTTP_ADDR" flag:"http-addr" default:":8080" usage:"HTTP listen address"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"HTTP_SHUTDOWN_TIMEOUT" flag:"shutdown-timeout" default:"30s" usage:"time allowed to drain HTTP connections"`
+	TrustedProxies  []string      `yaml:"trusted_proxies" env:"TRUSTED_PROXIES" usage:"comma separated CIDR ranges of proxies whose X-Forwarded-For is trusted"`
+}
+
+type Database struct {
+	URL         string `yaml:"url" env:"USERS_DATABASE_URL" flag:"database-url" secret:"true" usage:"postgres connection URL"`
+	AutoMigrate bool   `yaml:"auto_migrate" env:"DB_AUTO_MIGRATE" flag:"auto-migrate" default:"false" usage:"apply pending migrations at startup"`
+}
+
+type Auth struct {
+	SecretKey         string        `yaml:"secret_key" env:"SECRET_KEY" secret:"true" usage:"encrypts the JWT signing keys at rest"`
+	Algorithm         string        `yaml:"algorithm" env:"JWT_ALGORITHM" flag:"jwt-algorithm" default:"EdDSA" usage:"algorithm of new signing keys, EdDSA or RS256"`
+	KeyGracePeriod    time.Duration `yaml:"key_grace_period" env:"JWT_KEY_GRACE_PERIOD" flag:"key-grace-period" default:"48h" usage:"how long a rotated key keeps verifying tokens"`
+	KeyReloadInterval time.Duration `yaml:"key_reload_interval" env:"JWT_KEY_RELOAD_INTERVAL" default:"1m" usage:"how often signing keys are reloaded from the database"`
+	ServiceToken      string        `yaml:"service_token" env:"SERVICE_TOKEN" secret:"true" usage:"secret shared by the services for their internal endpoints"`
+}
+
+type Mail struct {
+	Driver       string `yaml:"driver" env:"MAIL_DRIVER" flag:"mail-driver" default:"log" usage:"smtp or log"`
+	From         string `yaml:"from" env:"MAIL_FROM" default:"no-reply@localhost" usage:"sender address"`
+	LinkBaseURL  string `yaml:"link_base_url" env:"MAIL_LINK_BASE_URL" default:"http://localhost:3001" usage:"web client URL used in email links"`
+	SMTPAddr     string `yaml:"smtp_addr" env:"SMTP_ADDR" usage:"SMTP server host:port"`
+	SMTPUsername string `yaml:"smtp_username" env:"SMTP_USERNAME"`
+	SMTPPassword string `yaml:"smtp_password" env:"SMTP_PASSWORD" secret:"true"`
+	LogDir       string `yaml:"log_dir" env:"MAIL_LOG_DIR" usage:"directory the log driver writes .eml files to, the log when empty"`
+}
+
+type Lockout struct {
+	Store         string        `yaml:"store" env:"LOGIN_ATTEMPT_STORE" flag:"login-attempt-store" default:"postgres" usage:"where failed logins are counted, postgres or memory (single instance only)"`
+	Window        time.Duration `yaml:"window" env:"LOGIN_FAILURE_WINDOW" default:"15m" usage:"sliding window failed logins are counted over"`
+	FreeAttempts  int           `yaml:"free_attempts" env:"LOGIN_FREE_ATTEMPTS" default:"3" usage:"failures of an account before logins are delayed"`
+	MaxDelay      time.Duration `yaml:"max_delay" env:"LOGIN_MAX_DELAY" default:"30s" usage:"longest delay between two attempts on an account"`
+	AccountLimit  int           `yaml:"account_limit" env:"LOGIN_ACCOUNT_LIMIT" default:"10" usage:"failures within the window that lock an account"`
+	IPLimit       int           `yaml:"ip_limit" env:"LOGIN_IP_LIMIT" default:"50" usage:"failures within the window that lock an IP address"`
+	Duration      time.Duration `yaml:"duration" env:"LOGIN_LOCKOUT_DURATION" default:"15m" usage:"how long a lockout lasts"`
+	SweepInterval time.Duration `yaml:"sweep_interval" env:"LOGIN_FAILURE_SWEEP_INTERVAL" default:"10m" usage:"how often failures that left the window are deleted"`
+}
+
+type MessageBus struct {
+	Driver      string   `yaml:"driver" env:"MESSAGE_BUS_DRIVER" flag:"message-bus-driver" default:"kafka" usage:"kafka or postgres"`
+	Brokers     []string `yaml:"brokers" env:"KAFKA_BROKER_URL" flag:"kafka-brokers" default:"kafka:9092" usage:"comma separated Kafka brokers"`
+	DatabaseURL string   `yaml:"database_url" env:"MESSAGE_BUS_DATABASE_URL" secret:"true" usage:"postgres database shared by all services, used by the postgres driver"`
+	Topic       string   `yaml:"topic" env:"USER_EVENTS_TOPIC" flag:"user-events-topic" default:"user-events" usage:"topic user events are published to"`
+
+	ConfirmationsTopic string `yaml:"confirmations_topic" env:"DELETION_CONFIRMATIONS_TOPIC" default:"user-deletion-confirmations" usage:"topic services confirm account deletions on"`
+}
+
+type Billing struct {
+	Provider      string        `yaml:"provider" env:"BILLING_PROVIDER" flag:"billing-provider" default:"none" usage:"payment provider, none or fake (development only, charges no one)"`
+	WebhookSecret string        `yaml:"webhook_secret" env:"BILLING_WEBHOOK_SECRET" secret:"true" usage:"signs the payment provider webhooks"`
+	TrialPeriod   time.Duration `yaml:"trial_period" env:"BILLING_TRIAL_PERIOD" default:"336h" usage:"trial of a user's first subscription, 0 disables trials"`
+	GracePeriod   time.Duration `yaml:"grace_period" env:"BILLING_GRACE_PERIOD" default:"168h" usage:"how long a failed payment keeps the paid plan"`
+	SweepInterval time.Duration `yaml:"sweep_interval" env:"BILLING_SWEEP_INTERVAL" default:"10m" usage:"how often expired grace periods and trials are cancelled"`
+}
+
+type Deletion struct {
+	GracePeriod   time.Duration `yaml:"grace_period" env:"ACCOUNT_DELETION_GRACE_PERIOD" default:"168h" usage:"how long a deleted account can be restored"`
+	Services      []string      `yaml:"services" env:"ACCOUNT_DELETION_SERVICES" default:"video_store" usage:"comma separated services that must confirm a deletion"`
+	SweepInterval time.Duration `yaml:"sweep_interval" env:"ACCOUNT_DELETION_SWEEP_INTERVAL" default:"10m" usage:"how often due deletions are executed"`
+}
+
+type Export struct {
+	LinkTTL       time.Duration `yaml:"link_ttl" env:"EXPORT_LINK_TTL" default:"48h" usage:"how long the download link of a data export works"`
+	PollInterval  time.Duration `yaml:"poll_interval" env:"EXPORT_POLL_INTERVAL" default:"30s" usage:"how often pending data exports are built"`
+	VideoStoreURL string        `yaml:"video_store_url" env:"VIDEO_STORE_INTERNAL_URL" default:"http://video_store:8080" usage:"internal base URL of the video store"`
+}
+
+type S3 struct {
+	Bucket string `yaml:"bucket" env:"S3_BUCKET_NAME" flag:"s3-bucket" usage:"bucket storing avatars under avatars/ and data exports under exports/"`
+}
+
+type Tracing struct {
+	Exporter string `yaml:"exporter" env:"OTEL_TRACES_EXPORTER" flag:"traces-exporter" usage:"none, stdout or otlp"`
+}
+
+func Load(args []string) (*Config, configloader.Options, error) {
+	cfg := &Config{}
+	opts, err := configloader.Load(cfg, serviceName, args)
+	return cfg, opts, err
+}
+
+func (c *Config) Validate() error {
+	var problems configloader.Problems
+
+	if c.HTTP.Addr == "" {
+		problems.Addf("http.addr is required")
+	}
+	if c.HTTP.ShutdownTimeout <= 0 {
+		problems.Addf("http.shutdown_timeout must be positive")
+	}
+	for _, cidr := range c.HTTP.TrustedProxies {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			problems.Addf("http.trusted_proxies %q is not a CIDR range", cidr)
+		}
+	}
+	if c.Database.URL == "" {
+		problems.Addf("database.url is required (USERS_DATABASE_URL)")
+	} else if u, err := url.Parse(c.Database.URL); err != nil || (u.Scheme != "postgres" && u.Scheme != "postgresql") {
+		problems.Addf("database.url must be a postgres:// URL")
+	}
+	if len(c.Auth.SecretKey) < MinSecretKeySize {
+		problems.Addf("auth.secret_key must be at least %d characters (SECRET_KEY)", MinSecretKeySize)
+	}
+	if c.Auth.Algorithm != auth.AlgorithmEdDSA && c.Auth.Algorithm != auth.AlgorithmRS256 {
+		problems.Addf("auth.algorithm %q is not one of EdDSA, RS256", c.Auth.Algorithm)
+	}
+	if c.Auth.KeyGracePeriod < minKeyGracePeriod {
+		problems.Addf("auth.key_grace_period must be at least %s", minKeyGracePeriod)
+	}
+	if c.Auth.KeyReloadInterval <= 0 {
+		problems.Addf("auth.key_reload_interval must be positive")
+	}
+	if len(c.Auth.ServiceToken) < auth.MinServiceTokenSize {
+		problems.Addf("auth.service_token must be at least %d characters (SERVICE_TOKEN)", auth.MinServiceTokenSize)
+	}
+	switch c.Mail.Driver {
+	case "smtp":
+		if _, _, err := net.SplitHostPort(c.Mail.SMTPAddr); err != nil {
+			problems.Addf("mail.smtp_addr must be host:port for the smtp driver (SMTP_ADDR)")
+		}
+	case "log":
+	default:
+		problems.Addf("mail.driver %q is not one of smtp, log", c.Mail.Driver)
+	}
+	if _, err := mail.ParseAddress(c.Mail.From); err != nil {
+		problems.Addf("mail.from %q is not an email address", c.Mail.From)
+	}
+	if u, err := url.Parse(c.Mail.LinkBaseURL); err != nil || u.Scheme == "" || u.Host == "" {
+		problems.Addf("mail.link_base_url must be an absolute URL")
+	}
+	if c.Lockout.Store != "postgres" && c.Lockout.Store != "memory" {
+		problems.Addf("lockout.store %q is not one of postgres, memory", c.Lockout.Store)
+	}
+	if c.Lockout.Window <= 0 || c.Lockout.Duration <= 0 || c.Lockout.MaxDelay < 0 {
+		problems.Addf("lockout.window and lockout.duration must be positive")
+	}
+	if c.Lockout.SweepInterval <= 0 {
+		problems.Addf("lockout.sweep_interval must be positive")
+	}
+	if c.Lockout.FreeAttempts < 0 {
+		problems.Addf("lockout.free_attempts can't be negative")
+	}
+	if c.Lockout.AccountLimit <= c.Lockout.FreeAttempts || c.Lockout.IPLimit < c.Lockout.AccountLimit {
+		problems.Addf("lockout limits must satisfy free_attempts < account_limit <= ip_limit")
+	}
+	switch c.MessageBus.Driver {
+	case "kafka":
+		if len(c.MessageBus.Brokers) == 0 {
+			problems.Addf("message_bus.brokers is required for the kafka driver")
+		}
+	case "postgres":
+		if u, err := url.Parse(c.MessageBus.DatabaseURL); err != nil || (u.Scheme != "postgres" && u.Scheme != "postgresql") {
+			problems.Addf("message_bus.database_url must be a postgres:// URL shared by all services (MESSAGE_BUS_DATABASE_URL)")
+		}
+	default:
+		problems.Addf("message_bus.driver %q is not one of kafka, postgres", c.MessageBus.Driver)
+	}
+	if c.MessageBus.Topic == "" || c.MessageBus.ConfirmationsTopic == "" {
+		problems.Addf("message_bus.topic and message_bus.confirmations_topic are required")
+	}
+	switch c.Billing.Provider {
+	case "none":
+	case "fake":
+		if len(c.Billing.WebhookSecret) < minWebhookSecretSize {
+			problems.Addf("billing.webhook_secret must be at least %d characters (BILLING_WEBHOOK_SECRET)", minWebhookSecretSize)
+		}
+	default:
+		problems.Addf("billing.provider %q is not one of none, fake", c.Billing.Provider)
+	}
+	if c.Billing.TrialPeriod < 0 || c.Billing.GracePeriod < 0 || c.Billing.SweepInterval <= 0 {
+		problems.Addf("billing periods can't be negative and billing.sweep_interval must be positive")
+	}
+	if c.Deletion.GracePeriod < 0 || c.Deletion.SweepInterval <= 0 {
+		problems.Addf("deletion.grace_period can't be negative and deletion.sweep_interval must be positive")
+	}
+	if c.Export.LinkTTL <= 0 || c.Export.PollInterval <= 0 {
+		problems.Addf("export.link_ttl and export.poll_interval must be positive")
+	}
+	if u, err := url.Parse(c.Export.VideoStoreURL); err != nil || u.Scheme == "" || u.Host == "" {
+		problems.Addf("export.video_store_url must be an absolute URL (VIDEO_STORE_INTERNAL_URL)")
+	}
+	if c.S3.Bucket == "" {
+		problems.Addf("s3.bucket is required (S3_BUCKET_NAME)")
+	}
+	if !telemetry.ValidExporter(c.Tracing.Exporter) {
+		problems.Addf("tracing.exporter %q is not one of none, stdout, otlp", c.Tracing.Exporter)
+	}
+
+	return problems.Err()
+}
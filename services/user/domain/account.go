@@ -0,0 +1,157 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"golang.org/x/text/language"
+)
+
+const (
+	MaxAvatarSize = 2 << 20
+
+	maxDisplayNameLength = 40
+	maxBioLength         = 280
+)
+
+var (
+	ErrInvalidUserUpdate = errors.New("invalid user update")
+	ErrInvalidAvatar     = errors.New("avatar must be a PNG, JPEG or WebP image of at most 2MB")
+	ErrAvatarNotFound    = errors.New("avatar not found")
+)
+
+// avatarExtensions are the image types accepted as avatars, sniffed from
+// their content rather than trusted from the upload.
+var avatarExtensions = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/webp": ".webp",
+}
+
+type AvatarStore interface {
+	PutAvatar(ctx context.Context, key string, contentType string, image []byte) error
+	GetAvatar(ctx context.Context, key string) (io.ReadCloser, string, error)
+	DeleteAvatar(ctx context.Context, key string) error
+}
+
+// normalizeProfile validates the profile fields of update and puts them in
+// the form they are stored in.
+func normalizeProfile(update *UserUpdate) error {
+	if update.DisplayName != nil {
+		name := strings.TrimSpace(*update.DisplayName)
+		if utf8.RuneCountInString(name) > maxDisplayNameLength {
+			return fmt.Errorf("display name must be at most %d characters", maxDisplayNameLength)
+		}
+		if strings.IndexFunc(name, unicode.IsControl) >= 0 {
+			return fmt.Errorf("display name can't contain control characters")
+		}
+		update.DisplayName = &name
+	}
+	if update.Bio != nil {
+		bio := strings.TrimSpace(*update.Bio)
+		if utf8.RuneCountInString(bio) > maxBioLength {
+			return fmt.Errorf("bio must be at most %d characters", maxBioLength)
+		}
+		update.Bio = &bio
+	}
+	if update.Locale != nil && *update.Locale != "" {
+		tag, err := language.Parse(*update.Locale)
+		if err != nil {
+			return fmt.Errorf("locale must be a BCP 47 language tag such as pt-BR")
+		}
+		locale := tag.String()
+		update.Locale = &locale
+	}
+	if update.Timezone != nil && *update.Timezone != "" {
+		// LoadLocation also accepts "Local" and "UTC", only the first is
+		// meaningless to clients.
+		if *update.Timezone == "Local" {
+			return fmt.Errorf("timezone must be an IANA time zone such as America/Sao_Paulo")
+		}
+		if _, err := time.LoadLocation(*update.Timezone); err != nil {
+			return fmt.Errorf("timezone must be an IANA time zone such as America/Sao_Paulo")
+		}
+	}
+	return nil
+}
+
+func (u *UserManager) CurrentUser(ctx context.Context, id string) (*User, error) {
+	user, err := u.db.GetUserProfile(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("error getting user: %w", err)
+	}
+	return user, nil
+}
+
+// SetAvatar stores image under a new key, so clients and caches never see a
+// stale avatar, and deletes the one it replaces.
+func (u *UserManager) SetAvatar(ctx context.Context, userID string, image []byte) (*User, error) {
+	if len(image) == 0 || len(image) > MaxAvatarSize {
+		return nil, ErrInvalidAvatar
+	}
+	contentType := http.DetectContentType(image)
+	ext, ok := avatarExtensions[contentType]
+	if !ok {
+		return nil, ErrInvalidAvatar
+	}
+
+	key := fmt.Sprintf("avatars/%s/%s%s", userID, uuid.New().String(), ext)
+	if err := u.avatars.PutAvatar(ctx, key, contentType, image); err != nil {
+		return nil, fmt.Errorf("error storing avatar: %w", err)
+	}
+	previous, err := u.db.SetAvatar(ctx, userID, key)
+	if err != nil {
+		u.deleteAvatar(ctx, key)
+		return nil, fmt.Errorf("error saving avatar: %w", err)
+	}
+	u.deleteAvatar(ctx, previous)
+	return u.CurrentUser(ctx, userID)
+}
+
+func (u *UserManager) RemoveAvatar(ctx context.Context, userID string) error {
+	previous, err := u.db.SetAvatar(ctx, userID, "")
+	if err != nil {
+		return fmt.Errorf("error removing avatar: %w", err)
+	}
+	if previous == "" {
+		return ErrAvatarNotFound
+	}
+	u.deleteAvatar(ctx, previous)
+	return nil
+}
+
+// Avatar serves the current avatar of the user, file being the last element
+// of its key as published in the avatar URL.
+func (u *UserManager) Avatar(ctx context.Context, userID string, file string) (io.ReadCloser, string, error) {
+	user, err := u.db.GetUserProfile(ctx, userID)
+	if errors.Is(err, ErrUserNotFound) {
+		return nil, "", ErrAvatarNotFound
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("error getting user: %w", err)
+	}
+	if user.AvatarKey == "" || path.Base(user.AvatarKey) != file {
+		return nil, "", ErrAvatarNotFound
+	}
+	return u.avatars.GetAvatar(ctx, user.AvatarKey)
+}
+
+// deleteAvatar only logs failures, an orphan object costs less than failing
+// a change that already happened.
+func (u *UserManager) deleteAvatar(ctx context.Context, key string) {
+	if key == "" {
+		return
+	}
+	if err := u.avatars.DeleteAvatar(ctx, key); err != nil {
+		fmt.Printf("failed to delete avatar %s: %v\n", key, err)
+	}
+}
@@ -0,0 +1,218 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type MockAvatarStore struct{ mock.Mock }
+
+func (m *MockAvatarStore) PutAvatar(ctx context.Context, key string, contentType string, image []byte) error {
+	return m.Called(ctx, key, contentType).Error(0)
+}
+
+func (m *MockAvatarStore) GetAvatar(ctx context.Context, key string) (io.ReadCloser, string, error) {
+	args := m.Called(ctx, key)
+	body, _ := args.Get(0).(io.ReadCloser)
+	return body, args.String(1), args.Error(2)
+}
+
+func (m *MockAvatarStore) DeleteAvatar(ctx context.Context, key string) error {
+	return m.Called(ctx, key).Error(0)
+}
+
+func ptr(s string) *string {
+	return &s
+}
+
+func TestUpdateUser(t *testing.T) {
+	ctx := context.Background()
+
+	tests := map[string]struct {
+		update    UserUpdate
+		expect    UserUpdate
+		expectErr error
+	}{
+		"profile fields are normalized": {
+			update: UserUpdate{DisplayName: ptr("  Ana  "), Bio: ptr("films "), Locale: ptr("pt-br"), Timezone: ptr("America/Sao_Paulo")},
+			expect: UserUpdate{DisplayName: ptr("Ana"), Bio: ptr("films"), Locale: ptr("pt-BR"), Timezone: ptr("America/Sao_Paulo")},
+		},
+		"empty values clear the fields": {
+			update: UserUpdate{DisplayName: ptr(""), Locale: ptr(""), Timezone: ptr("")},
+			expect: UserUpdate{DisplayName: ptr(""), Locale: ptr(""), Timezone: ptr("")},
+		},
+		"unknown timezone": {
+			update:    UserUpdate{Timezone: ptr("Mars/Olympus")},
+			expectErr: ErrInvalidUserUpdate,
+		},
+		"local timezone": {
+			update:    UserUpdate{Timezone: ptr("Local")},
+			expectErr: ErrInvalidUserUpdate,
+		},
+		"malformed locale": {
+			update:    UserUpdate{Locale: ptr("not a locale")},
+			expectErr: ErrInvalidUserUpdate,
+		},
+		"bio too long": {
+			update:    UserUpdate{Bio: ptr(strings.Repeat("é", maxBioLength+1))},
+			expectErr: ErrInvalidUserUpdate,
+		},
+		"display name with control characters": {
+			update:    UserUpdate{DisplayName: ptr("Ana\u0000")},
+			expectErr: ErrInvalidUserUpdate,
+		},
+		"short name": {
+			update:    UserUpdate{Name: "Al"},
+			expectErr: ErrInvalidUserUpdate,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			db := new(MockStorage)
+			db.On("UpdateUser", ctx, "user-1", tc.expect).Return(nil)
+
+			u := NewUserManager(db, new(MockToken), new(MockAuditLog), new(MockMailer), Links{}, nil, nil, nil, nil)
+			err := u.UpdateUser(ctx, "user-1", tc.update)
+			if tc.expectErr != nil {
+				assert.ErrorIs(t, err, tc.expectErr)
+				db.AssertNotCalled(t, "UpdateUser", ctx, "user-1", mock.Anything)
+				return
+			}
+			assert.NoError(t, err)
+			db.AssertCalled(t, "UpdateUser", ctx, "user-1", tc.expect)
+		})
+	}
+}
+
+func TestUpdateUser_HashesPassword(t *testing.T) {
+	ctx := context.Background()
+	db := new(MockStorage)
+	db.On("UpdateUser", ctx, "user-1", mock.MatchedBy(func(update UserUpdate) bool {
+		return update.Password != nil && bcrypt.CompareHashAndPassword([]byte(*update.Password), []byte("new-password")) == nil
+	})).Return(nil)
+	audit := new(MockAuditLog)
+	audit.On("RecordAuditEvent", ctx, AuditPasswordChanged, "").Return(nil)
+
+	u := NewUserManager(db, new(MockToken), audit, new(MockMailer), Links{}, nil, nil, nil, nil)
+	assert.NoError(t, u.UpdateUser(ctx, "user-1", UserUpdate{Password: ptr("new-password")}))
+	audit.AssertExpectations(t)
+}
+
+func TestSetAvatar(t *testing.T) {
+	ctx := context.Background()
+	png := append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 64)...)
+
+	tests := map[string]struct {
+		image          []byte
+		previous       string
+		dbErr          error
+		expectErr      error
+		expectStored   bool
+		expectDeletion string
+	}{
+		"replaces the previous avatar": {
+			image:          png,
+			previous:       "avatars/user-1/old.png",
+			expectStored:   true,
+			expectDeletion: "avatars/user-1/old.png",
+		},
+		"first avatar": {
+			image:        png,
+			expectStored: true,
+		},
+		"not an image": {
+			image:     []byte("<svg onload=alert(1)>"),
+			expectErr: ErrInvalidAvatar,
+		},
+		"too large": {
+			image:     append(png, make([]byte, MaxAvatarSize)...),
+			expectErr: ErrInvalidAvatar,
+		},
+		"database failure removes the upload": {
+			image:        png,
+			dbErr:        errors.New("connection reset"),
+			expectStored: true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			db := new(MockStorage)
+			store := new(MockAvatarStore)
+			var stored string
+			store.On("PutAvatar", ctx, mock.Anything, "image/png").Run(func(args mock.Arguments) {
+				stored = args.String(1)
+			}).Return(nil)
+			store.On("DeleteAvatar", ctx, mock.Anything).Return(nil)
+			db.On("SetAvatar", ctx, "user-1", mock.Anything).Return(tc.previous, tc.dbErr)
+			db.On("GetUserProfile", ctx, "user-1").Return(&User{ID: "user-1"}, nil)
+
+			u := NewUserManager(db, new(MockToken), new(MockAuditLog), new(MockMailer), Links{}, nil, nil, nil, store)
+			_, err := u.SetAvatar(ctx, "user-1", tc.image)
+			switch {
+			case tc.expectErr != nil:
+				assert.ErrorIs(t, err, tc.expectErr)
+			case tc.dbErr != nil:
+				assert.Error(t, err)
+			default:
+				assert.NoError(t, err)
+			}
+			if !tc.expectStored {
+				store.AssertNotCalled(t, "PutAvatar", ctx, mock.Anything, mock.Anything)
+				return
+			}
+			assert.True(t, strings.HasPrefix(stored, "avatars/user-1/"))
+			assert.True(t, strings.HasSuffix(stored, ".png"))
+			if tc.dbErr != nil {
+				store.AssertCalled(t, "DeleteAvatar", ctx, stored)
+			} else {
+				store.AssertNotCalled(t, "DeleteAvatar", ctx, stored)
+			}
+			if tc.expectDeletion != "" {
+				store.AssertCalled(t, "DeleteAvatar", ctx, tc.expectDeletion)
+			}
+		})
+	}
+}
+
+func TestAvatar(t *testing.T) {
+	ctx := context.Background()
+
+	tests := map[string]struct {
+		key       string
+		file      string
+		expectErr error
+	}{
+		"current avatar":  {key: "avatars/user-1/a.png", file: "a.png"},
+		"replaced avatar": {key: "avatars/user-1/b.png", file: "a.png", expectErr: ErrAvatarNotFound},
+		"no avatar":       {file: "a.png", expectErr: ErrAvatarNotFound},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			db := new(MockStorage)
+			store := new(MockAvatarStore)
+			db.On("GetUserProfile", ctx, "user-1").Return(&User{ID: "user-1", AvatarKey: tc.key}, nil)
+			store.On("GetAvatar", ctx, tc.key).Return(io.NopCloser(strings.NewReader("png")), "image/png", nil)
+
+			u := NewUserManager(db, new(MockToken), new(MockAuditLog), new(MockMailer), Links{}, nil, nil, nil, store)
+			body, contentType, err := u.Avatar(ctx, "user-1", tc.file)
+			if tc.expectErr != nil {
+				assert.ErrorIs(t, err, tc.expectErr)
+				store.AssertNotCalled(t, "GetAvatar", ctx, mock.Anything)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "image/png", contentType)
+			body.Close()
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/eduardo-ax/video-streaming/pkg/auth"
+)
+
+const (
+	StatusActive    = "active"
+	StatusSuspended = "suspended"
+
+	defaultPerPage = 20
+	maxPerPage     = 100
+)
+
+var (
+	ErrAccountSuspended = errors.New("account suspended")
+	ErrCannotManageSelf = errors.New("admins can't suspend or delete their own account")
+	ErrInvalidFilter    = errors.New("invalid filter")
+)
+
+type UserFilter struct {
+	Email   string
+	Plan    *int8
+	Status  string
+	Page    int
+	PerPage int
+}
+
+type UserSummary struct {
+	ID               string
+	Name             string
+	Email            string
+	Plan             int8
+	Status           string
+	EmailVerified    bool
+	Roles            []string
+	SuspendedAt      *time.Time
+	SuspensionReason string
+	CreatedAt        time.Time
+}
+
+type UserPage struct {
+	Users   []UserSummary
+	Total   int
+	Page    int
+	PerPage int
+}
+
+type UserDetails struct {
+	User     UserSummary
+	Sessions []Session
+}
+
+// SearchUsers lists users newest first, Email matches any part of the
+// address.
+func (u *UserManager) SearchUsers(ctx context.Context, filter UserFilter) (*UserPage, error) {
+	switch filter.Status {
+	case "", StatusActive, StatusSuspended, StatusPendingDeletion:
+	default:
+		return nil, fmt.Errorf("%w: status must be %s, %s or %s", ErrInvalidFilter, StatusActive, StatusSuspended, StatusPendingDeletion)
+	}
+	if filter.Page < 1 {
+		filter.Page = 1
+	}
+	if filter.PerPage < 1 {
+		filter.PerPage = defaultPerPage
+	}
+	filter.PerPage = min(filter.PerPage, maxPerPage)
+
+	users, total, err := u.db.SearchUsers(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("error searching users: %w", err)
+	}
+	return &UserPage{
+		Users:   users,
+		Total:   total,
+		Page:    filter.Page,
+		PerPage: filter.PerPage,
+	}, nil
+}
+
+func (u *UserManager) GetUserDetails(ctx context.Context, id string) (*UserDetails, error) {
+	user, err := u.db.GetUserSummary(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	sessions, err := u.db.ListSessions(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("error listing sessions: %w", err)
+	}
+	return &UserDetails{User: *user, Sessions: sessions}, nil
+}
+
+// SuspendUser blocks logins and renewals of the user and revokes their
+// sessions, their access tokens are rejected through the revocation check.
+func (u *UserManager) SuspendUser(ctx context.Context, actorID string, id string, reason string) error {
+	if actorID == id {
+		return ErrCannotManageSelf
+	}
+	if err := u.db.SuspendUser(ctx, id, reason); err != nil {
+		return err
+	}
+	u.recordAudit(ctx, AuditEvent{
+		Type:     AuditUserSuspended,
+		ActorID:  actorID,
+		UserID:   id,
+		Metadata: map[string]string{"reason": reason},
+	})
+	return nil
+}
+
+func (u *UserManager) UnsuspendUser(ctx context.Context, actorID string, id string) error {
+	if err := u.db.UnsuspendUser(ctx, id); err != nil {
+		return err
+	}
+	u.recordAudit(ctx, AuditEvent{
+		Type:    AuditUserUnsuspended,
+		ActorID: actorID,
+		UserID:  id,
+	})
+	return nil
+}
+
+// ForceLogout revokes every session of the user.
+func (u *UserManager) ForceLogout(ctx context.Context, actorID string, id string) (int64, error) {
+	if _, err := u.db.GetUserSummary(ctx, id); err != nil {
+		return 0, err
+	}
+	revoked, err := u.db.RevokeUserSessions(ctx, id, "")
+	if err != nil {
+		return 0, fmt.Errorf("error revoking sessions: %w", err)
+	}
+	u.recordAudit(ctx, AuditEvent{
+		Type:     AuditForcedLogout,
+		ActorID:  actorID,
+		UserID:   id,
+		Metadata: map[string]string{"revoked_sessions": fmt.Sprint(revoked)},
+	})
+	return revoked, nil
+}
+
+// Revocations lists the sessions revoked and the users suspended or deleted
+// while access tokens issued before could still be valid.
+func (u *UserManager) Revocations(ctx context.Context) (*auth.Revocations, error) {
+	list, err := u.db.RecentRevocations(ctx, time.Now().Add(-AccessTokenTTL))
+	if err != nil {
+		return nil, fmt.Errorf("error listing revocations: %w", err)
+	}
+	return list, nil
+}
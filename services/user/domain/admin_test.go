@@ -0,0 +1,129 @@
+package domain
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestUserLogin_Suspended(t *testing.T) {
+	ctx := context.Background()
+	hash, err := HashPassword("a-strong-password")
+	assert.NoError(t, err)
+
+	tests := map[string]struct {
+		mfaEnabled bool
+	}{
+		"without mfa": {},
+		"with mfa":    {mfaEnabled: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			db := new(MockStorage)
+			token := new(MockToken)
+			user := &UserAuthData{ID: "user-1", Email: "user@example.com", Password: hash, Status: StatusSuspended, MFAEnabled: tc.mfaEnabled}
+			db.On("GetUser", ctx, "user@example.com").Return(user, nil)
+
+			u := NewUserManager(db, token, new(MockAuditLog), new(MockMailer), Links{}, nil, nil, nil, nil)
+			_, err := u.UserLogin(ctx, "user@example.com", "a-strong-password", Client{})
+			assert.ErrorIs(t, err, ErrAccountSuspended)
+			db.AssertNotCalled(t, "CreateMFAChallenge", ctx, "user-1")
+			db.AssertNotCalled(t, "CreateSession", ctx, mock.Anything)
+		})
+	}
+}
+
+func TestSuspendUser(t *testing.T) {
+	ctx := context.Background()
+
+	tests := map[string]struct {
+		actorID     string
+		storeErr    error
+		expectErr   error
+		expectStore bool
+	}{
+		"user suspended": {
+			actorID:     "admin-1",
+			expectStore: true,
+		},
+		"unknown user": {
+			actorID:     "admin-1",
+			storeErr:    ErrUserNotFound,
+			expectErr:   ErrUserNotFound,
+			expectStore: true,
+		},
+		"admin suspending themselves": {
+			actorID:   "user-1",
+			expectErr: ErrCannotManageSelf,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			db := new(MockStorage)
+			audit := new(MockAuditLog)
+			db.On("SuspendUser", ctx, "user-1", "chargeback").Return(tc.storeErr)
+			audit.On("RecordAuditEvent", ctx, AuditUserSuspended, "").Return(nil)
+
+			u := NewUserManager(db, new(MockToken), audit, new(MockMailer), Links{}, nil, nil, nil, nil)
+			err := u.SuspendUser(ctx, tc.actorID, "user-1", "chargeback")
+			if tc.expectStore {
+				db.AssertCalled(t, "SuspendUser", ctx, "user-1", "chargeback")
+			} else {
+				db.AssertNotCalled(t, "SuspendUser", ctx, "user-1", "chargeback")
+			}
+			if tc.expectErr != nil {
+				assert.ErrorIs(t, err, tc.expectErr)
+				audit.AssertNotCalled(t, "RecordAuditEvent", ctx, AuditUserSuspended, "")
+				return
+			}
+			assert.NoError(t, err)
+			audit.AssertCalled(t, "RecordAuditEvent", ctx, AuditUserSuspended, "")
+		})
+	}
+}
+
+func TestSearchUsers(t *testing.T) {
+	ctx := context.Background()
+
+	tests := map[string]struct {
+		filter      UserFilter
+		expectQuery UserFilter
+		expectErr   error
+	}{
+		"defaults the page": {
+			filter:      UserFilter{Email: "example.com"},
+			expectQuery: UserFilter{Email: "example.com", Page: 1, PerPage: defaultPerPage},
+		},
+		"caps the page size": {
+			filter:      UserFilter{Page: 3, PerPage: 1000},
+			expectQuery: UserFilter{Page: 3, PerPage: maxPerPage},
+		},
+		"unknown status": {
+			filter:    UserFilter{Status: "banned"},
+			expectErr: ErrInvalidFilter,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			db := new(MockStorage)
+			db.On("SearchUsers", ctx, tc.expectQuery).Return([]UserSummary{{ID: "user-1"}}, 41, nil)
+
+			u := NewUserManager(db, new(MockToken), new(MockAuditLog), new(MockMailer), Links{}, nil, nil, nil, nil)
+			page, err := u.SearchUsers(ctx, tc.filter)
+			if tc.expectErr != nil {
+				assert.ErrorIs(t, err, tc.expectErr)
+				db.AssertNotCalled(t, "SearchUsers", ctx, mock.Anything)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, 41, page.Total)
+			assert.Equal(t, tc.expectQuery.Page, page.Page)
+			assert.Equal(t, tc.expectQuery.PerPage, page.PerPage)
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	AuditSignup               = "signup"
+	AuditLoginSucceeded       = "login_succeeded"
+	AuditLoginFailed          = "login_failed"
+	AuditLogout               = "logout"
+	AuditTokenRenewed         = "token_renewed"
+	AuditSessionRevoked       = "session_revoked"
+	AuditOtherSessionsRevoked = "other_sessions_revoked"
+	AuditPasswordChanged      = "password_changed"
+	AuditEmailChanged         = "email_changed"
+	AuditRefreshTokenReused   = "refresh_token_reused"
+	AuditPasswordReset        = "password_reset"
+	AuditMFAEnabled           = "mfa_enabled"
+	AuditMFAFailed            = "mfa_failed"
+	AuditMFARecoveryCodeUsed  = "mfa_recovery_code_used"
+	AuditAccountLocked        = "account_locked"
+	AuditRoleGranted          = "role_granted"
+	AuditRoleRevoked          = "role_revoked"
+	AuditUserSuspended        = "user_suspended"
+	AuditUserUnsuspended      = "user_unsuspended"
+	AuditForcedLogout         = "forced_logout"
+	AuditPlanChanged          = "plan_changed"
+	AuditUserDeleted          = "user_deleted"
+	AuditSubscriptionStarted  = "subscription_started"
+	AuditSubscriptionCanceled = "subscription_canceled"
+	AuditPaymentFailed        = "payment_failed"
+
+	// recentActivityLimit is how many events the user sees of their own
+	// security activity.
+	recentActivityLimit = 50
+)
+
+// AuditEvent is an entry of the append-only audit log. UserID is the
+// account the event is about and ActorID who caused it: the user themselves,
+// an admin, or nobody for the billing provider and background jobs.
+type AuditEvent struct {
+	ID        int64
+	Type      string
+	ActorID   string
+	UserID    string
+	SessionID string
+	IP        string
+	UserAgent string
+	Metadata  map[string]string
+	CreatedAt time.Time
+}
+
+// AuditFilter narrows the audit log, the zero value matches every event.
+type AuditFilter struct {
+	UserID  string
+	ActorID string
+	Type    string
+	IP      string
+	Since   *time.Time
+	Until   *time.Time
+	Page    int
+	PerPage int
+}
+
+type AuditPage struct {
+	Events  []AuditEvent
+	Total   int
+	Page    int
+	PerPage int
+}
+
+type AuditLog interface {
+	RecordAuditEvent(ctx context.Context, event AuditEvent) error
+	SearchAuditEvents(ctx context.Context, filter AuditFilter) ([]AuditEvent, int, error)
+}
+
+type clientKey struct{}
+
+// WithClient attaches the client of the request to ctx, audit events
+// recorded with it carry its IP and user agent without every method taking
+// a Client.
+func WithClient(ctx context.Context, client Client) context.Context {
+	return context.WithValue(ctx, clientKey{}, client)
+}
+
+func clientFrom(ctx context.Context) Client {
+	client, _ := ctx.Value(clientKey{}).(Client)
+	return client
+}
+
+// recordAudit never fails the caller: losing an audit record must not turn a
+// security response such as a revocation into an error.
+func (u *UserManager) recordAudit(ctx context.Context, event AuditEvent) {
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now().UTC()
+	}
+	if event.IP == "" && event.UserAgent == "" {
+		client := clientFrom(ctx)
+		event.IP, event.UserAgent = client.IP, client.UserAgent
+	}
+	if err := u.audit.RecordAuditEvent(ctx, event); err != nil {
+		fmt.Printf("failed to record audit event %s: %v\n", event.Type, err)
+	}
+}
+
+// SearchAuditEvents lists audit events newest first.
+func (u *UserManager) SearchAuditEvents(ctx context.Context, filter AuditFilter) (*AuditPage, error) {
+	if filter.Since != nil && filter.Until != nil && filter.Until.Before(*filter.Since) {
+		return nil, fmt.Errorf("%w: until is before since", ErrInvalidFilter)
+	}
+	if filter.Page < 1 {
+		filter.Page = 1
+	}
+	if filter.PerPage < 1 {
+		filter.PerPage = defaultPerPage
+	}
+	filter.PerPage = min(filter.PerPage, maxPerPage)
+
+	events, total, err := u.audit.SearchAuditEvents(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("error searching audit events: %w", err)
+	}
+	return &AuditPage{
+		Events:  events,
+		Total:   total,
+		Page:    filter.Page,
+		PerPage: filter.PerPage,
+	}, nil
+}
+
+// SecurityActivity returns the latest events about the user's account, for
+// them to spot logins and changes they didn't make.
+func (u *UserManager) SecurityActivity(ctx context.Context, userID string) ([]AuditEvent, error) {
+	events, _, err := u.audit.SearchAuditEvents(ctx, AuditFilter{UserID: userID, Page: 1, PerPage: recentActivityLimit})
+	if err != nil {
+		return nil, fmt.Errorf("error listing security activity: %w", err)
+	}
+	return events, nil
+}
@@ -0,0 +1,109 @@
+package domain
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type recordingAuditLog struct {
+	MockAuditLog
+	events []AuditEvent
+}
+
+func (r *recordingAuditLog) RecordAuditEvent(ctx context.Context, event AuditEvent) error {
+	r.events = append(r.events, event)
+	return nil
+}
+
+func TestRecordAudit_Client(t *testing.T) {
+	requestClient := Client{IP: "203.0.113.7", UserAgent: "Firefox"}
+
+	tests := map[string]struct {
+		ctx      context.Context
+		event    AuditEvent
+		expected Client
+	}{
+		"client of the request": {
+			ctx:      WithClient(context.Background(), requestClient),
+			event:    AuditEvent{Type: AuditLogout},
+			expected: requestClient,
+		},
+		"client set on the event wins": {
+			ctx:      WithClient(context.Background(), requestClient),
+			event:    AuditEvent{Type: AuditLoginSucceeded, IP: "198.51.100.1", UserAgent: "curl"},
+			expected: Client{IP: "198.51.100.1", UserAgent: "curl"},
+		},
+		"background job": {
+			ctx:   context.Background(),
+			event: AuditEvent{Type: AuditPaymentFailed},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			audit := &recordingAuditLog{}
+			u := NewUserManager(new(MockStorage), new(MockToken), audit, new(MockMailer), Links{}, nil, nil, nil, nil)
+			u.recordAudit(tc.ctx, tc.event)
+
+			assert.Len(t, audit.events, 1)
+			assert.Equal(t, tc.expected, Client{IP: audit.events[0].IP, UserAgent: audit.events[0].UserAgent})
+			assert.False(t, audit.events[0].CreatedAt.IsZero())
+		})
+	}
+}
+
+func TestSearchAuditEvents(t *testing.T) {
+	ctx := context.Background()
+	since := time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)
+	before := since.Add(-time.Hour)
+
+	tests := map[string]struct {
+		filter         AuditFilter
+		expectErr      error
+		expectPage     int
+		expectPerPage  int
+		expectSearched bool
+	}{
+		"defaults": {
+			expectPage:     1,
+			expectPerPage:  defaultPerPage,
+			expectSearched: true,
+		},
+		"per page is capped": {
+			filter:         AuditFilter{Page: 3, PerPage: 1000},
+			expectPage:     3,
+			expectPerPage:  maxPerPage,
+			expectSearched: true,
+		},
+		"until before since": {
+			filter:    AuditFilter{Since: &since, Until: &before},
+			expectErr: ErrInvalidFilter,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			audit := new(MockAuditLog)
+			audit.On("SearchAuditEvents", ctx, mock.Anything).Return([]AuditEvent{{ID: 1, Type: AuditSignup}}, 1, nil)
+
+			u := NewUserManager(new(MockStorage), new(MockToken), audit, new(MockMailer), Links{}, nil, nil, nil, nil)
+			page, err := u.SearchAuditEvents(ctx, tc.filter)
+			if tc.expectErr != nil {
+				assert.ErrorIs(t, err, tc.expectErr)
+				audit.AssertNotCalled(t, "SearchAuditEvents", ctx, mock.Anything)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectPage, page.Page)
+			assert.Equal(t, tc.expectPerPage, page.PerPage)
+			assert.Equal(t, 1, page.Total)
+			audit.AssertCalled(t, "SearchAuditEvents", ctx, mock.MatchedBy(func(f AuditFilter) bool {
+				return f.Page == tc.expectPage && f.PerPage == tc.expectPerPage
+			}))
+		})
+	}
+}
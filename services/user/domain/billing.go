@@ -0,0 +1,414 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	SubscriptionIncomplete = "incomplete"
+	SubscriptionTrialing   = "trialing"
+	SubscriptionActive     = "active"
+	SubscriptionPastDue    = "past_due"
+	SubscriptionCanceled   = "canceled"
+
+	PaymentSucceeded      = "payment.succeeded"
+	PaymentFailed         = "payment.failed"
+	SubscriptionCancelled = "subscription.cancelled"
+
+	// billingActor is recorded as the author of plan changes made by the
+	// payment provider.
+	billingActor = "billing"
+)
+
+var (
+	ErrPaymentRequired        = errors.New("paid plans need a subscription")
+	ErrSubscriptionExists     = errors.New("a subscription is already running")
+	ErrSubscriptionNotFound   = errors.New("subscription not found")
+	ErrInvalidWebhook         = errors.New("invalid webhook signature")
+	ErrSubscriptionCancelling = errors.New("subscription already cancelled")
+	ErrBillingDisabled        = errors.New("billing is disabled")
+	ErrPaymentEventProcessed  = errors.New("payment event already processed")
+)
+
+// Subscription charges a user for a paid plan. A trialing, active or past
+// due subscription grants its plan, a past due one only until GraceEndsAt.
+type Subscription struct {
+	ID                     string
+	UserID                 string
+	PlanID                 int8
+	Status                 string
+	ProviderCustomerID     string
+	ProviderSubscriptionID string
+	TrialEndsAt            *time.Time
+	CurrentPeriodEnd       *time.Time
+	GraceEndsAt            *time.Time
+	CancelAtPeriodEnd      bool
+	CanceledAt             *time.Time
+	CreatedAt              time.Time
+}
+
+func (s *Subscription) Live() bool {
+	return s.Status != SubscriptionCanceled
+}
+
+// ProviderSubscription is the state of a subscription at the provider.
+type ProviderSubscription struct {
+	ID               string
+	Status           string
+	CurrentPeriodEnd *time.Time
+}
+
+// PaymentEvent is a verified webhook of the payment provider.
+type PaymentEvent struct {
+	ID             string
+	Type           string
+	SubscriptionID string
+	PeriodEnd      *time.Time
+	OccurredAt     time.Time
+}
+
+// PlanGrant moves the user of a subscription to PlanID. With FromPlanID
+// set, only a user still on that plan is moved.
+type PlanGrant struct {
+	PlanID     int8
+	FromPlanID *int8
+}
+
+// PaymentProvider charges the subscriptions. The provider owns the billing
+// cycle and reports payments through signed webhooks.
+type PaymentProvider interface {
+	CreateCustomer(ctx context.Context, userID string, email string) (string, error)
+	CreateSubscription(ctx context.Context, customerID string, plan Plan, trialEnd *time.Time) (*ProviderSubscription, error)
+	CancelSubscription(ctx context.Context, subscriptionID string, atPeriodEnd bool) error
+	ParseWebhook(payload []byte, signature string) (*PaymentEvent, error)
+}
+
+// BillingPolicy configures Billing. A user's first subscription starts with
+// TrialPeriod, and a failed payment keeps the plan for GracePeriod.
+type BillingPolicy struct {
+	TrialPeriod time.Duration
+	GracePeriod time.Duration
+}
+
+type BillingInterface interface {
+	Subscription(ctx context.Context, userID string) (*Subscription, error)
+	Subscribe(ctx context.Context, userID string, planID int8) (*Subscription, error)
+	CancelSubscription(ctx context.Context, userID string) (*Subscription, error)
+	HandleWebhook(ctx context.Context, payload []byte, signature string) error
+}
+
+// Billing moves users between plans as their subscriptions are paid,
+// fail and get cancelled.
+type Billing struct {
+	users    *UserManager
+	provider PaymentProvider
+	policy   BillingPolicy
+	now      func() time.Time
+}
+
+func NewBilling(users *UserManager, provider PaymentProvider, policy BillingPolicy) *Billing {
+	return &Billing{
+		users:    users,
+		provider: provider,
+		policy:   policy,
+		now:      time.Now,
+	}
+}
+
+func (b *Billing) Subscription(ctx context.Context, userID string) (*Subscription, error) {
+	return b.users.db.GetSubscription(ctx, userID)
+}
+
+// Subscribe starts a subscription to a paid plan. The first one of a user
+// is trialing and grants the plan right away, later ones wait for the first
+// payment.
+func (b *Billing) Subscribe(ctx context.Context, userID string, planID int8) (*Subscription, error) {
+	plan, err := b.users.db.GetPlan(ctx, planID)
+	if err != nil {
+		return nil, err
+	}
+	if !plan.IsPublic || !plan.Paid() {
+		return nil, ErrPlanUnavailable
+	}
+	user, err := b.users.db.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting user: %w", err)
+	}
+	if !user.EmailVerified {
+		return nil, ErrEmailNotVerified
+	}
+
+	previous, err := b.users.db.GetSubscription(ctx, userID)
+	if err != nil && !errors.Is(err, ErrSubscriptionNotFound) {
+		return nil, err
+	}
+	if previous != nil && previous.Live() {
+		return nil, ErrSubscriptionExists
+	}
+
+	var customerID string
+	var trialEnd *time.Time
+	if previous != nil {
+		customerID = previous.ProviderCustomerID
+	} else {
+		if customerID, err = b.provider.CreateCustomer(ctx, userID, user.Email); err != nil {
+			return nil, fmt.Errorf("error creating customer: %w", err)
+		}
+		if b.policy.TrialPeriod > 0 {
+			end := b.now().Add(b.policy.TrialPeriod)
+			trialEnd = &end
+		}
+	}
+
+	remote, err := b.provider.CreateSubscription(ctx, customerID, *plan, trialEnd)
+	if err != nil {
+		return nil, fmt.Errorf("error creating subscription: %w", err)
+	}
+	sub := &Subscription{
+		UserID:                 userID,
+		PlanID:                 plan.ID,
+		Status:                 remote.Status,
+		ProviderCustomerID:     customerID,
+		ProviderSubscriptionID: remote.ID,
+		TrialEndsAt:            trialEnd,
+		CurrentPeriodEnd:       remote.CurrentPeriodEnd,
+	}
+	if err := b.users.db.CreateSubscription(ctx, sub); err != nil {
+		if cancelErr := b.provider.CancelSubscription(ctx, remote.ID, false); cancelErr != nil {
+			fmt.Printf("failed to cancel orphan subscription %s: %v\n", remote.ID, cancelErr)
+		}
+		return nil, err
+	}
+
+	b.users.recordAudit(ctx, AuditEvent{
+		Type:     AuditSubscriptionStarted,
+		ActorID:  userID,
+		UserID:   userID,
+		Metadata: map[string]string{"plan": plan.Name, "status": sub.Status},
+	})
+	if sub.Status == SubscriptionTrialing || sub.Status == SubscriptionActive {
+		if err := b.grantPlan(ctx, sub); err != nil {
+			return nil, err
+		}
+	}
+	return sub, nil
+}
+
+// CancelSubscription stops the renewals, the plan stays until the paid
+// period ends and the provider reports the cancellation.
+func (b *Billing) CancelSubscription(ctx context.Context, userID string) (*Subscription, error) {
+	sub, err := b.users.db.GetSubscription(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !sub.Live() {
+		return nil, ErrSubscriptionNotFound
+	}
+	if sub.CancelAtPeriodEnd {
+		return nil, ErrSubscriptionCancelling
+	}
+
+	// Nothing was paid yet, there's no period to wait for.
+	atPeriodEnd := sub.Status != SubscriptionIncomplete
+	if err := b.provider.CancelSubscription(ctx, sub.ProviderSubscriptionID, atPeriodEnd); err != nil {
+		return nil, fmt.Errorf("error cancelling subscription: %w", err)
+	}
+	if !atPeriodEnd {
+		return sub, b.endSubscription(ctx, sub)
+	}
+	sub.CancelAtPeriodEnd = true
+	if err := b.users.db.UpdateSubscription(ctx, sub); err != nil {
+		return nil, err
+	}
+	b.users.recordAudit(ctx, AuditEvent{
+		Type:     AuditSubscriptionCanceled,
+		ActorID:  userID,
+		UserID:   userID,
+		Metadata: map[string]string{"at_period_end": "true"},
+	})
+	return sub, nil
+}
+
+// HandleWebhook applies a payment event once. The event is claimed in the
+// transaction that stores its effects, redeliveries of a processed event are
+// acknowledged without effect.
+func (b *Billing) HandleWebhook(ctx context.Context, payload []byte, signature string) error {
+	event, err := b.provider.ParseWebhook(payload, signature)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidWebhook, err)
+	}
+
+	sub, err := b.users.db.GetSubscriptionByProviderID(ctx, event.SubscriptionID)
+	if errors.Is(err, ErrSubscriptionNotFound) {
+		// Not ours, or already gone: acknowledge so it isn't retried.
+		fmt.Printf("ignoring %s for unknown subscription %s\n", event.Type, event.SubscriptionID)
+		_, err := b.users.db.ApplyPaymentEvent(ctx, event, nil, nil)
+		if errors.Is(err, ErrPaymentEventProcessed) {
+			return nil
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	var (
+		update *Subscription
+		grant  *PlanGrant
+		target *Plan
+		audit  *AuditEvent
+	)
+	switch {
+	case !sub.Live():
+	case event.Type == PaymentSucceeded:
+		previous := sub.Status
+		sub.Status = SubscriptionActive
+		sub.GraceEndsAt = nil
+		if event.PeriodEnd != nil {
+			sub.CurrentPeriodEnd = event.PeriodEnd
+		}
+		if target, err = b.users.db.GetPlan(ctx, sub.PlanID); err != nil {
+			return err
+		}
+		update = sub
+		grant = &PlanGrant{PlanID: target.ID}
+		// The first payment and the one ending a grace period move the user
+		// to the plan. A renewal keeps a plan an admin set meanwhile.
+		if previous != SubscriptionIncomplete && previous != SubscriptionPastDue {
+			grant.FromPlanID = &sub.PlanID
+		}
+	case event.Type == PaymentFailed:
+		// Only the first failure starts the grace period, retries by the
+		// provider don't extend it.
+		if sub.GraceEndsAt != nil {
+			break
+		}
+		grace := b.now().Add(b.policy.GracePeriod)
+		sub.Status = SubscriptionPastDue
+		sub.GraceEndsAt = &grace
+		update = sub
+		audit = &AuditEvent{
+			Type:     AuditPaymentFailed,
+			UserID:   sub.UserID,
+			Metadata: map[string]string{"grace_ends_at": grace.UTC().Format(time.RFC3339)},
+		}
+	case event.Type == SubscriptionCancelled:
+		now := b.now()
+		sub.Status = SubscriptionCanceled
+		sub.CanceledAt = &now
+		if target, err = b.users.db.GetDefaultPlan(ctx); err != nil {
+			return err
+		}
+		update = sub
+		// An admin may have moved the user to another plan meanwhile, keep it.
+		grant = &PlanGrant{PlanID: target.ID, FromPlanID: &sub.PlanID}
+		audit = &AuditEvent{
+			Type:     AuditSubscriptionCanceled,
+			UserID:   sub.UserID,
+			Metadata: map[string]string{"at_period_end": "false"},
+		}
+	default:
+		fmt.Printf("ignoring unknown payment event %s\n", event.Type)
+	}
+
+	fromID, err := b.users.db.ApplyPaymentEvent(ctx, event, update, grant)
+	if errors.Is(err, ErrPaymentEventProcessed) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if audit != nil {
+		b.users.recordAudit(ctx, *audit)
+	}
+	if fromID != nil {
+		from, err := b.users.db.GetPlan(ctx, *fromID)
+		if err != nil {
+			fmt.Printf("failed to announce plan change of %s: %v\n", sub.UserID, err)
+			return nil
+		}
+		b.users.planChanged(ctx, sub.UserID, from, target, billingActor)
+	}
+	return nil
+}
+
+// ExpireSubscriptions cancels the past due subscriptions whose grace period
+// is over and the trials that ended without a payment, returning how many
+// were.
+func (b *Billing) ExpireSubscriptions(ctx context.Context) (int, error) {
+	subs, err := b.users.db.ListExpiredSubscriptions(ctx, b.now())
+	if err != nil {
+		return 0, err
+	}
+	var errs []error
+	expired := 0
+	for i := range subs {
+		sub := &subs[i]
+		if err := b.provider.CancelSubscription(ctx, sub.ProviderSubscriptionID, false); err != nil {
+			errs = append(errs, fmt.Errorf("subscription %s: %w", sub.ID, err))
+			continue
+		}
+		if err := b.endSubscription(ctx, sub); err != nil {
+			errs = append(errs, fmt.Errorf("subscription %s: %w", sub.ID, err))
+			continue
+		}
+		expired++
+	}
+	return expired, errors.Join(errs...)
+}
+
+// endSubscription cancels the subscription and moves the user back to the
+// default plan.
+func (b *Billing) endSubscription(ctx context.Context, sub *Subscription) error {
+	if !sub.Live() {
+		return nil
+	}
+	now := b.now()
+	sub.Status = SubscriptionCanceled
+	sub.CanceledAt = &now
+	if err := b.users.db.UpdateSubscription(ctx, sub); err != nil {
+		return err
+	}
+	b.users.recordAudit(ctx, AuditEvent{
+		Type:     AuditSubscriptionCanceled,
+		UserID:   sub.UserID,
+		Metadata: map[string]string{"at_period_end": "false"},
+	})
+
+	user, err := b.users.db.GetUserByID(ctx, sub.UserID)
+	if err != nil {
+		return fmt.Errorf("error getting user: %w", err)
+	}
+	if user.Plan != sub.PlanID {
+		// An admin moved the user to another plan meanwhile, keep it.
+		return nil
+	}
+	fallback, err := b.users.db.GetDefaultPlan(ctx)
+	if err != nil {
+		return err
+	}
+	err = b.users.changePlan(ctx, sub.UserID, user.Plan, fallback, billingActor)
+	if errors.Is(err, ErrSamePlan) {
+		return nil
+	}
+	return err
+}
+
+func (b *Billing) grantPlan(ctx context.Context, sub *Subscription) error {
+	user, err := b.users.db.GetUserByID(ctx, sub.UserID)
+	if err != nil {
+		return fmt.Errorf("error getting user: %w", err)
+	}
+	plan, err := b.users.db.GetPlan(ctx, sub.PlanID)
+	if err != nil {
+		return err
+	}
+	err = b.users.changePlan(ctx, sub.UserID, user.Plan, plan, billingActor)
+	if errors.Is(err, ErrSamePlan) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,235 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/eduardo-ax/video-streaming/pkg/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockPaymentProvider struct{ mock.Mock }
+
+func (m *MockPaymentProvider) CreateCustomer(ctx context.Context, userID string, email string) (string, error) {
+	args := m.Called(ctx, userID)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockPaymentProvider) CreateSubscription(ctx context.Context, customerID string, plan Plan, trialEnd *time.Time) (*ProviderSubscription, error) {
+	args := m.Called(ctx, customerID, plan.ID, trialEnd != nil)
+	sub, _ := args.Get(0).(*ProviderSubscription)
+	return sub, args.Error(1)
+}
+
+func (m *MockPaymentProvider) CancelSubscription(ctx context.Context, subscriptionID string, atPeriodEnd bool) error {
+	return m.Called(ctx, subscriptionID, atPeriodEnd).Error(0)
+}
+
+func (m *MockPaymentProvider) ParseWebhook(payload []byte, signature string) (*PaymentEvent, error) {
+	args := m.Called(string(payload), signature)
+	event, _ := args.Get(0).(*PaymentEvent)
+	return event, args.Error(1)
+}
+
+var testBillingPolicy = BillingPolicy{TrialPeriod: 14 * 24 * time.Hour, GracePeriod: 7 * 24 * time.Hour}
+
+func newTestBilling(db *MockStorage, provider *MockPaymentProvider) (*Billing, *MockEventPublisher) {
+	audit := new(MockAuditLog)
+	audit.On("RecordAuditEvent", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	pub := new(MockEventPublisher)
+	pub.On("PublishUserEvent", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	users := NewUserManager(db, new(MockToken), audit, new(MockMailer), Links{}, nil, nil, pub, nil)
+	return NewBilling(users, provider, testBillingPolicy), pub
+}
+
+func TestSubscribe(t *testing.T) {
+	ctx := context.Background()
+
+	tests := map[string]struct {
+		previous      *Subscription
+		emailVerified bool
+		remoteStatus  string
+		expectTrial   bool
+		expectPlan    bool
+		expectErr     error
+	}{
+		"first subscription trials with the plan": {
+			emailVerified: true,
+			remoteStatus:  SubscriptionTrialing,
+			expectTrial:   true,
+			expectPlan:    true,
+		},
+		"returning subscriber waits for the payment": {
+			previous:      &Subscription{Status: SubscriptionCanceled, ProviderCustomerID: "cus_1"},
+			emailVerified: true,
+			remoteStatus:  SubscriptionIncomplete,
+		},
+		"already subscribed": {
+			previous:      &Subscription{Status: SubscriptionActive, ProviderCustomerID: "cus_1"},
+			emailVerified: true,
+			expectErr:     ErrSubscriptionExists,
+		},
+		"unverified email": {
+			expectErr: ErrEmailNotVerified,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			db := new(MockStorage)
+			provider := new(MockPaymentProvider)
+			db.On("GetPlan", ctx, int8(0)).Return(freePlan, nil)
+			db.On("GetPlan", ctx, int8(1)).Return(standardPlan, nil)
+			db.On("GetUserByID", ctx, "user-1").Return(&UserAuthData{ID: "user-1", Plan: 0, EmailVerified: tc.emailVerified}, nil)
+			if tc.previous != nil {
+				db.On("GetSubscription", ctx, "user-1").Return(tc.previous, nil)
+			} else {
+				db.On("GetSubscription", ctx, "user-1").Return(nil, ErrSubscriptionNotFound)
+			}
+			provider.On("CreateCustomer", ctx, "user-1").Return("cus_1", nil)
+			provider.On("CreateSubscription", ctx, "cus_1", int8(1), tc.expectTrial).Return(&ProviderSubscription{ID: "sub_1", Status: tc.remoteStatus}, nil)
+			db.On("CreateSubscription", ctx, "user-1", tc.remoteStatus).Return(nil)
+			db.On("ChangePlan", ctx, "user-1", int8(1)).Return(nil)
+
+			b, _ := newTestBilling(db, provider)
+			sub, err := b.Subscribe(ctx, "user-1", 1)
+			if tc.expectErr != nil {
+				assert.ErrorIs(t, err, tc.expectErr)
+				provider.AssertNotCalled(t, "CreateSubscription", ctx, mock.Anything, mock.Anything, mock.Anything)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.remoteStatus, sub.Status)
+			assert.Equal(t, tc.expectTrial, sub.TrialEndsAt != nil)
+			if tc.expectPlan {
+				db.AssertCalled(t, "ChangePlan", ctx, "user-1", int8(1))
+			} else {
+				db.AssertNotCalled(t, "ChangePlan", ctx, "user-1", int8(1))
+			}
+		})
+	}
+}
+
+func TestHandleWebhook(t *testing.T) {
+	ctx := context.Background()
+	periodEnd := time.Now().Add(30 * 24 * time.Hour)
+
+	tests := map[string]struct {
+		event        *PaymentEvent
+		parseErr     error
+		applyErr     error
+		sub          Subscription
+		movedFrom    *int8
+		expectStatus string
+		expectGrant  *PlanGrant
+		expectErr    error
+	}{
+		"payment grants the plan": {
+			event:        &PaymentEvent{ID: "evt_1", Type: PaymentSucceeded, SubscriptionID: "sub_1", PeriodEnd: &periodEnd},
+			sub:          Subscription{ID: "s-1", UserID: "user-1", PlanID: 1, Status: SubscriptionIncomplete},
+			movedFrom:    &freePlan.ID,
+			expectStatus: SubscriptionActive,
+			expectGrant:  &PlanGrant{PlanID: standardPlan.ID},
+		},
+		"payment after the grace period grants the plan": {
+			event:        &PaymentEvent{ID: "evt_1", Type: PaymentSucceeded, SubscriptionID: "sub_1", PeriodEnd: &periodEnd},
+			sub:          Subscription{ID: "s-1", UserID: "user-1", PlanID: 1, Status: SubscriptionPastDue},
+			movedFrom:    &freePlan.ID,
+			expectStatus: SubscriptionActive,
+			expectGrant:  &PlanGrant{PlanID: standardPlan.ID},
+		},
+		"renewal keeps a plan set by an admin": {
+			event:        &PaymentEvent{ID: "evt_1", Type: PaymentSucceeded, SubscriptionID: "sub_1", PeriodEnd: &periodEnd},
+			sub:          Subscription{ID: "s-1", UserID: "user-1", PlanID: 1, Status: SubscriptionActive},
+			expectStatus: SubscriptionActive,
+			expectGrant:  &PlanGrant{PlanID: standardPlan.ID, FromPlanID: &standardPlan.ID},
+		},
+		"failed payment starts the grace period": {
+			event:        &PaymentEvent{ID: "evt_1", Type: PaymentFailed, SubscriptionID: "sub_1"},
+			sub:          Subscription{ID: "s-1", UserID: "user-1", PlanID: 1, Status: SubscriptionActive},
+			expectStatus: SubscriptionPastDue,
+		},
+		"cancellation returns to the default plan": {
+			event:        &PaymentEvent{ID: "evt_1", Type: SubscriptionCancelled, SubscriptionID: "sub_1"},
+			sub:          Subscription{ID: "s-1", UserID: "user-1", PlanID: 1, Status: SubscriptionActive, CancelAtPeriodEnd: true},
+			movedFrom:    &standardPlan.ID,
+			expectStatus: SubscriptionCanceled,
+			expectGrant:  &PlanGrant{PlanID: freePlan.ID, FromPlanID: &standardPlan.ID},
+		},
+		"cancellation keeps a plan set by an admin": {
+			event:        &PaymentEvent{ID: "evt_1", Type: SubscriptionCancelled, SubscriptionID: "sub_1"},
+			sub:          Subscription{ID: "s-1", UserID: "user-1", PlanID: 1, Status: SubscriptionActive, CancelAtPeriodEnd: true},
+			expectStatus: SubscriptionCanceled,
+			expectGrant:  &PlanGrant{PlanID: freePlan.ID, FromPlanID: &standardPlan.ID},
+		},
+		"redelivered event": {
+			event:        &PaymentEvent{ID: "evt_1", Type: SubscriptionCancelled, SubscriptionID: "sub_1"},
+			applyErr:     ErrPaymentEventProcessed,
+			sub:          Subscription{ID: "s-1", UserID: "user-1", PlanID: 1, Status: SubscriptionActive},
+			expectStatus: SubscriptionCanceled,
+			expectGrant:  &PlanGrant{PlanID: freePlan.ID, FromPlanID: &standardPlan.ID},
+		},
+		"bad signature": {
+			parseErr:  errors.New("signature mismatch"),
+			expectErr: ErrInvalidWebhook,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			db := new(MockStorage)
+			provider := new(MockPaymentProvider)
+			sub := tc.sub
+			provider.On("ParseWebhook", "{}", "sig").Return(tc.event, tc.parseErr)
+			db.On("GetSubscriptionByProviderID", ctx, "sub_1").Return(&sub, nil)
+			db.On("ApplyPaymentEvent", ctx, "evt_1", mock.Anything, mock.Anything).Return(tc.movedFrom, tc.applyErr)
+			db.On("GetPlan", ctx, int8(0)).Return(freePlan, nil)
+			db.On("GetPlan", ctx, int8(1)).Return(standardPlan, nil)
+			db.On("GetDefaultPlan", ctx).Return(freePlan, nil)
+
+			b, pub := newTestBilling(db, provider)
+			err := b.HandleWebhook(ctx, []byte("{}"), "sig")
+			if tc.expectErr != nil {
+				assert.ErrorIs(t, err, tc.expectErr)
+				db.AssertNotCalled(t, "ApplyPaymentEvent", ctx, mock.Anything, mock.Anything, mock.Anything)
+				return
+			}
+			assert.NoError(t, err)
+			db.AssertCalled(t, "ApplyPaymentEvent", ctx, "evt_1", tc.expectStatus, tc.expectGrant)
+			if tc.movedFrom != nil {
+				pub.AssertCalled(t, "PublishUserEvent", ctx, events.TypePlanChanged, "user-1")
+			} else {
+				pub.AssertNotCalled(t, "PublishUserEvent", ctx, events.TypePlanChanged, "user-1")
+			}
+		})
+	}
+}
+
+func TestExpireSubscriptions(t *testing.T) {
+	ctx := context.Background()
+	db := new(MockStorage)
+	provider := new(MockPaymentProvider)
+	ended := time.Now().Add(-time.Hour)
+	db.On("ListExpiredSubscriptions", ctx).Return([]Subscription{
+		{ID: "s-1", UserID: "user-1", PlanID: 1, Status: SubscriptionPastDue, ProviderSubscriptionID: "sub_1", GraceEndsAt: &ended},
+		{ID: "s-2", UserID: "user-2", PlanID: 1, Status: SubscriptionTrialing, ProviderSubscriptionID: "sub_2", TrialEndsAt: &ended},
+	}, nil)
+	provider.On("CancelSubscription", ctx, mock.Anything, false).Return(nil)
+	db.On("UpdateSubscription", ctx, mock.Anything, SubscriptionCanceled).Return(nil)
+	db.On("GetUserByID", ctx, "user-1").Return(&UserAuthData{ID: "user-1", Plan: 1}, nil)
+	db.On("GetUserByID", ctx, "user-2").Return(&UserAuthData{ID: "user-2", Plan: 1}, nil)
+	db.On("GetPlan", ctx, int8(1)).Return(standardPlan, nil)
+	db.On("GetDefaultPlan", ctx).Return(freePlan, nil)
+	db.On("ChangePlan", ctx, mock.Anything, int8(0)).Return(nil)
+
+	b, _ := newTestBilling(db, provider)
+	expired, err := b.ExpireSubscriptions(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, expired)
+	provider.AssertCalled(t, "CancelSubscription", ctx, "sub_2", false)
+	db.AssertCalled(t, "ChangePlan", ctx, "user-1", int8(0))
+	db.AssertCalled(t, "ChangePlan", ctx, "user-2", int8(0))
+}
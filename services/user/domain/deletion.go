@@ -0,0 +1,287 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/eduardo-ax/video-streaming/pkg/events"
+)
+
+const (
+	StatusPendingDeletion = "pending_deletion"
+
+	AuditDeletionScheduled = "deletion_scheduled"
+	AuditDeletionCanceled  = "deletion_canceled"
+	AuditDeletionCompleted = "deletion_completed"
+
+	// deletionRepublishAfter is how long a deletion waits for the services'
+	// confirmations before user.deleted is published again. Consumers erase
+	// idempotently, a duplicate costs nothing.
+	deletionRepublishAfter = time.Hour
+)
+
+var (
+	ErrDeletionScheduled = errors.New("account scheduled for deletion")
+	ErrInvalidUndoToken  = errors.New("invalid or expired deletion undo token")
+	ErrDeletionNotFound  = errors.New("account deletion not found")
+	ErrWrongPassword     = errors.New("password incorrect")
+)
+
+// AccountDeletion records an erased account until every service confirmed
+// it deleted the user's data. Email is cleared once it is completed.
+type AccountDeletion struct {
+	ID          string
+	UserID      string
+	Email       string
+	RequestedAt time.Time
+	DeletedAt   time.Time
+	PublishedAt *time.Time
+	CompletedAt *time.Time
+}
+
+// DeletionPolicy configures Deletions. Accounts are erased GracePeriod after
+// the user asked, and deletions complete once each of Services confirmed.
+type DeletionPolicy struct {
+	GracePeriod time.Duration
+	Services    []string
+}
+
+type DeletionInterface interface {
+	RequestDeletion(ctx context.Context, userID string, password string) (time.Time, error)
+	CancelDeletion(ctx context.Context, token string) error
+	AdminDeleteUser(ctx context.Context, actorID string, id string) error
+}
+
+// Deletions erases accounts. The user row goes first, then every service
+// owning data of the user erases it on user.deleted and confirms with
+// user.deletion_confirmed.
+type Deletions struct {
+	users  *UserManager
+	policy DeletionPolicy
+	now    func() time.Time
+}
+
+func NewDeletions(users *UserManager, policy DeletionPolicy) *Deletions {
+	return &Deletions{
+		users:  users,
+		policy: policy,
+		now:    time.Now,
+	}
+}
+
+// accountStatusError is why an account that isn't active can't log in.
+func accountStatusError(status string) error {
+	switch status {
+	case StatusSuspended:
+		return ErrAccountSuspended
+	case StatusPendingDeletion:
+		return ErrDeletionScheduled
+	}
+	return nil
+}
+
+// RequestDeletion freezes the account for the grace period: its sessions
+// are revoked, logins refused, and the user gets a link to undo. Running
+// subscriptions must be cancelled first, the provider would keep charging.
+func (d *Deletions) RequestDeletion(ctx context.Context, userID string, password string) (time.Time, error) {
+	user, err := d.users.db.GetUserByID(ctx, userID)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error getting user: %w", err)
+	}
+	if !CheckPassword(password, user.Password) {
+		return time.Time{}, ErrWrongPassword
+	}
+	if err := d.users.checkNoRunningSubscription(ctx, userID); err != nil {
+		return time.Time{}, err
+	}
+
+	token, err := NewOpaqueToken()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error creating undo token: %w", err)
+	}
+	scheduledFor := d.now().Add(d.policy.GracePeriod).UTC()
+	if err := d.users.db.ScheduleDeletion(ctx, userID, HashToken(token), scheduledFor); err != nil {
+		return time.Time{}, err
+	}
+	d.users.recordAudit(ctx, AuditEvent{
+		Type:     AuditDeletionScheduled,
+		ActorID:  userID,
+		UserID:   userID,
+		Metadata: map[string]string{"scheduled_for": scheduledFor.Format(time.RFC3339)},
+	})
+
+	link := d.users.links.build("/cancel-deletion", token)
+	err = d.users.mailer.Send(ctx, Email{
+		To:      user.Email,
+		Subject: "Your account will be deleted",
+		Body: fmt.Sprintf("Your account and everything in it will be deleted on %s.\n\nTo keep your account, open the link below before then:\n\n%s\n\nIf you didn't ask for this, open the link and change your password.\n",
+			scheduledFor.Format(time.RFC1123), link),
+	})
+	if err != nil {
+		fmt.Printf("failed to send deletion email to user %s: %v\n", userID, err)
+	}
+	return scheduledFor, nil
+}
+
+// CancelDeletion restores an account scheduled for deletion. The user logs
+// in again, their sessions stay revoked.
+func (d *Deletions) CancelDeletion(ctx context.Context, token string) error {
+	if token == "" {
+		return ErrInvalidUndoToken
+	}
+	userID, err := d.users.db.CancelDeletion(ctx, HashToken(token))
+	if err != nil {
+		return err
+	}
+	d.users.recordAudit(ctx, AuditEvent{Type: AuditDeletionCanceled, ActorID: userID, UserID: userID})
+	return nil
+}
+
+// AdminDeleteUser erases the account at once, without a grace period.
+func (d *Deletions) AdminDeleteUser(ctx context.Context, actorID string, id string) error {
+	if actorID == id {
+		return ErrCannotManageSelf
+	}
+	if err := d.users.checkNoRunningSubscription(ctx, id); err != nil {
+		return err
+	}
+	return d.erase(ctx, id, actorID)
+}
+
+// ExecuteDueDeletions erases the accounts whose grace period is over and
+// publishes again the deletions the services didn't confirm, returning how
+// many accounts were erased.
+func (d *Deletions) ExecuteDueDeletions(ctx context.Context) (int, error) {
+	var errs []error
+	due, err := d.users.db.ListDueDeletions(ctx, d.now())
+	if err != nil {
+		return 0, err
+	}
+	erased := 0
+	for _, userID := range due {
+		if err := d.erase(ctx, userID, ""); err != nil {
+			errs = append(errs, fmt.Errorf("user %s: %w", userID, err))
+			continue
+		}
+		erased++
+	}
+
+	pending, err := d.users.db.ListUnconfirmedDeletions(ctx, d.now().Add(-deletionRepublishAfter))
+	if err != nil {
+		return erased, errors.Join(append(errs, err)...)
+	}
+	for i := range pending {
+		if err := d.publish(ctx, &pending[i]); err != nil {
+			errs = append(errs, fmt.Errorf("deletion %s: %w", pending[i].ID, err))
+		}
+	}
+	return erased, errors.Join(errs...)
+}
+
+// HandleConfirmation records that a service erased the user's data, the
+// last expected confirmation completes the deletion.
+func (d *Deletions) HandleConfirmation(ctx context.Context, event events.UserEvent) error {
+	if event.Type != events.TypeDeletionConfirmed {
+		return nil
+	}
+	confirmed, err := d.users.db.ConfirmDeletion(ctx, event.Deletion.ID, event.UserID, event.Deletion.Service)
+	if err != nil {
+		return err
+	}
+	for _, service := range d.policy.Services {
+		if !slices.Contains(confirmed, service) {
+			return nil
+		}
+	}
+	return d.complete(ctx, event.Deletion.ID, event.UserID)
+}
+
+// erase deletes the user row, and with it the sessions, profiles and
+// everything else referencing it, then tells the other services.
+func (d *Deletions) erase(ctx context.Context, userID string, actorID string) error {
+	profile, err := d.users.db.GetUserProfile(ctx, userID)
+	if err != nil {
+		return err
+	}
+	deletion, err := d.users.db.EraseUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if profile.AvatarKey != "" {
+		d.users.deleteAvatar(ctx, profile.AvatarKey)
+	}
+	d.users.recordAudit(ctx, AuditEvent{
+		Type:     AuditUserDeleted,
+		ActorID:  actorID,
+		UserID:   userID,
+		Metadata: map[string]string{"deletion_id": deletion.ID},
+	})
+
+	// The account is gone either way, the sweeper publishes the deletions
+	// that failed to.
+	if err := d.publish(ctx, deletion); err != nil {
+		fmt.Printf("failed to publish deletion of user %s: %v\n", userID, err)
+	}
+	return nil
+}
+
+func (d *Deletions) publish(ctx context.Context, deletion *AccountDeletion) error {
+	if len(d.policy.Services) == 0 {
+		return d.complete(ctx, deletion.ID, deletion.UserID)
+	}
+	if d.users.events == nil {
+		return errors.New("no event publisher")
+	}
+	if err := d.users.events.PublishUserEvent(ctx, events.NewDeleted(deletion.UserID, deletion.ID)); err != nil {
+		return err
+	}
+	return d.users.db.MarkDeletionPublished(ctx, deletion.ID)
+}
+
+// complete runs once per deletion, redelivered confirmations find it
+// completed already.
+func (d *Deletions) complete(ctx context.Context, id string, userID string) error {
+	email, err := d.users.db.CompleteDeletion(ctx, id)
+	if errors.Is(err, ErrDeletionNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	d.users.recordAudit(ctx, AuditEvent{
+		Type:     AuditDeletionCompleted,
+		UserID:   userID,
+		Metadata: map[string]string{"deletion_id": id},
+	})
+	if email == "" {
+		return nil
+	}
+	err = d.users.mailer.Send(ctx, Email{
+		To:      email,
+		Subject: "Your account was deleted",
+		Body:    "Your account was deleted, along with your videos and the rest of your data in every service.\n\nThis is the last message you will get from us.\n",
+	})
+	if err != nil {
+		fmt.Printf("failed to send deletion confirmation for user %s: %v\n", userID, err)
+	}
+	return nil
+}
+
+// checkNoRunningSubscription refuses to delete accounts still being charged,
+// a subscription cancelled at the period end is fine.
+func (u *UserManager) checkNoRunningSubscription(ctx context.Context, userID string) error {
+	sub, err := u.db.GetSubscription(ctx, userID)
+	if errors.Is(err, ErrSubscriptionNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if sub.Live() && !sub.CancelAtPeriodEnd {
+		return ErrSubscriptionExists
+	}
+	return nil
+}
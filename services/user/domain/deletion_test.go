@@ -0,0 +1,153 @@
+package domain
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/eduardo-ax/video-streaming/pkg/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var testDeletionPolicy = DeletionPolicy{GracePeriod: 7 * 24 * time.Hour, Services: []string{"video_store"}}
+
+func newTestDeletions(db *MockStorage, mailer *MockMailer, pub *MockEventPublisher) *Deletions {
+	audit := new(MockAuditLog)
+	audit.On("RecordAuditEvent", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	users := NewUserManager(db, new(MockToken), audit, mailer, Links{}, nil, nil, pub, nil)
+	return NewDeletions(users, testDeletionPolicy)
+}
+
+func TestRequestDeletion(t *testing.T) {
+	ctx := context.Background()
+	hash, err := HashPassword("password123")
+	assert.NoError(t, err)
+
+	tests := map[string]struct {
+		password     string
+		subscription *Subscription
+		expectErr    error
+	}{
+		"scheduled": {
+			password: "password123",
+		},
+		"subscription cancelled at the period end": {
+			password:     "password123",
+			subscription: &Subscription{Status: SubscriptionActive, CancelAtPeriodEnd: true},
+		},
+		"wrong password": {
+			password:  "password124",
+			expectErr: ErrWrongPassword,
+		},
+		"running subscription": {
+			password:     "password123",
+			subscription: &Subscription{Status: SubscriptionActive},
+			expectErr:    ErrSubscriptionExists,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			db := new(MockStorage)
+			mailer := new(MockMailer)
+			db.On("GetUserByID", ctx, "user-1").Return(&UserAuthData{ID: "user-1", Email: "user@example.com", Password: hash, Status: StatusActive}, nil)
+			if tc.subscription != nil {
+				db.On("GetSubscription", ctx, "user-1").Return(tc.subscription, nil)
+			} else {
+				db.On("GetSubscription", ctx, "user-1").Return(nil, ErrSubscriptionNotFound)
+			}
+			db.On("ScheduleDeletion", ctx, "user-1", mock.Anything, mock.Anything).Return(nil)
+			mailer.On("Send", ctx, "user@example.com").Return(nil)
+
+			d := newTestDeletions(db, mailer, nil)
+			now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+			d.now = func() time.Time { return now }
+
+			scheduledFor, err := d.RequestDeletion(ctx, "user-1", tc.password)
+			if tc.expectErr != nil {
+				assert.ErrorIs(t, err, tc.expectErr)
+				db.AssertNotCalled(t, "ScheduleDeletion", ctx, "user-1", mock.Anything, mock.Anything)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, now.Add(testDeletionPolicy.GracePeriod), scheduledFor)
+			db.AssertCalled(t, "ScheduleDeletion", ctx, "user-1", mock.Anything, scheduledFor)
+			mailer.AssertCalled(t, "Send", ctx, "user@example.com")
+		})
+	}
+}
+
+func TestExecuteDueDeletions(t *testing.T) {
+	ctx := context.Background()
+	db := new(MockStorage)
+	pub := new(MockEventPublisher)
+	db.On("ListDueDeletions", ctx, mock.Anything).Return([]string{"user-1"}, nil)
+	db.On("GetUserProfile", ctx, "user-1").Return(&User{ID: "user-1"}, nil)
+	db.On("EraseUser", ctx, "user-1").Return(&AccountDeletion{ID: "deletion-1", UserID: "user-1"}, nil)
+	db.On("ListUnconfirmedDeletions", ctx, mock.Anything).Return([]AccountDeletion{{ID: "deletion-0", UserID: "user-0"}}, nil)
+	db.On("MarkDeletionPublished", ctx, mock.Anything).Return(nil)
+	pub.On("PublishUserEvent", ctx, events.TypeDeleted, mock.Anything).Return(nil)
+
+	d := newTestDeletions(db, new(MockMailer), pub)
+	erased, err := d.ExecuteDueDeletions(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, erased)
+	pub.AssertCalled(t, "PublishUserEvent", ctx, events.TypeDeleted, "user-1")
+	pub.AssertCalled(t, "PublishUserEvent", ctx, events.TypeDeleted, "user-0")
+	db.AssertCalled(t, "MarkDeletionPublished", ctx, "deletion-1")
+	db.AssertCalled(t, "MarkDeletionPublished", ctx, "deletion-0")
+}
+
+func TestHandleConfirmation(t *testing.T) {
+	ctx := context.Background()
+
+	tests := map[string]struct {
+		service        string
+		confirmed      []string
+		completeErr    error
+		expectComplete bool
+		expectMail     bool
+	}{
+		"last service completes the deletion": {
+			service:        "video_store",
+			confirmed:      []string{"video_store"},
+			expectComplete: true,
+			expectMail:     true,
+		},
+		"waits for the expected services": {
+			service:   "recommendations",
+			confirmed: []string{"recommendations"},
+		},
+		"already completed": {
+			service:        "video_store",
+			confirmed:      []string{"video_store"},
+			completeErr:    ErrDeletionNotFound,
+			expectComplete: true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			db := new(MockStorage)
+			mailer := new(MockMailer)
+			db.On("ConfirmDeletion", ctx, "deletion-1", "user-1", tc.service).Return(tc.confirmed, nil)
+			db.On("CompleteDeletion", ctx, "deletion-1").Return("user@example.com", tc.completeErr)
+			mailer.On("Send", ctx, "user@example.com").Return(nil)
+
+			d := newTestDeletions(db, mailer, nil)
+			err := d.HandleConfirmation(ctx, events.NewDeletionConfirmed("user-1", "deletion-1", tc.service))
+			assert.NoError(t, err)
+			if tc.expectComplete {
+				db.AssertCalled(t, "CompleteDeletion", ctx, "deletion-1")
+			} else {
+				db.AssertNotCalled(t, "CompleteDeletion", ctx, "deletion-1")
+			}
+			if tc.expectMail {
+				mailer.AssertCalled(t, "Send", ctx, "user@example.com")
+			} else {
+				mailer.AssertNotCalled(t, "Send", ctx, "user@example.com")
+			}
+		})
+	}
+}
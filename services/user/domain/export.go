@@ -0,0 +1,482 @@
+package domain
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"time"
+)
+
+const (
+	ExportPending = "pending"
+	ExportRunning = "running"
+	ExportReady   = "ready"
+	ExportFailed  = "failed"
+	ExportExpired = "expired"
+
+	AuditDataExportRequested  = "data_export_requested"
+	AuditDataExportDownloaded = "data_export_downloaded"
+
+	// A running export not finished after exportTimeout is taken to have
+	// lost its worker and claimed again, up to maxExportAttempts times.
+	exportTimeout     = 15 * time.Minute
+	maxExportAttempts = 3
+
+	exportAuditPageSize = 500
+)
+
+var (
+	ErrExportInProgress = errors.New("a data export is already in progress")
+	ErrExportNotFound   = errors.New("data export not found")
+)
+
+// DataExport is an archive of everything held about a user, built in the
+// background and downloadable until ExpiresAt.
+type DataExport struct {
+	ID          string
+	UserID      string
+	Status      string
+	ObjectKey   string
+	Attempts    int
+	RequestedAt time.Time
+	CompletedAt *time.Time
+	ExpiresAt   *time.Time
+}
+
+// ExportSource is another service holding data of the user, it returns
+// JSON documents by file name.
+type ExportSource interface {
+	ExportUserData(ctx context.Context, userID string) (map[string]json.RawMessage, error)
+}
+
+type ExportStore interface {
+	PutExport(ctx context.Context, key string, archive []byte) error
+	GetExport(ctx context.Context, key string) (io.ReadCloser, error)
+	DeleteExport(ctx context.Context, key string) error
+}
+
+// ExportPolicy configures Exports, archives can be downloaded for LinkTTL.
+type ExportPolicy struct {
+	LinkTTL time.Duration
+}
+
+type ExportInterface interface {
+	RequestExport(ctx context.Context, userID string) (*DataExport, error)
+	ListExports(ctx context.Context, userID string) ([]DataExport, error)
+	Download(ctx context.Context, token string) (io.ReadCloser, *DataExport, error)
+}
+
+// Exports builds the data exports: the account, subscription, viewer
+// profiles, sessions and audit events from here, plus a folder per source
+// service.
+type Exports struct {
+	users   *UserManager
+	store   ExportStore
+	sources map[string]ExportSource
+	policy  ExportPolicy
+	now     func() time.Time
+}
+
+func NewExports(users *UserManager, store ExportStore, sources map[string]ExportSource, policy ExportPolicy) *Exports {
+	return &Exports{
+		users:   users,
+		store:   store,
+		sources: sources,
+		policy:  policy,
+		now:     time.Now,
+	}
+}
+
+// RequestExport queues an export, a user has one in progress at most.
+func (e *Exports) RequestExport(ctx context.Context, userID string) (*DataExport, error) {
+	export, err := e.users.db.CreateExport(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	e.users.recordAudit(ctx, AuditEvent{
+		Type:     AuditDataExportRequested,
+		ActorID:  userID,
+		UserID:   userID,
+		Metadata: map[string]string{"export_id": export.ID},
+	})
+	return export, nil
+}
+
+func (e *Exports) ListExports(ctx context.Context, userID string) ([]DataExport, error) {
+	exports, err := e.users.db.ListExports(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error listing exports: %w", err)
+	}
+	return exports, nil
+}
+
+// Download opens the archive of the emailed link, the token is the only
+// credential so the link works from any device until it expires.
+func (e *Exports) Download(ctx context.Context, token string) (io.ReadCloser, *DataExport, error) {
+	if token == "" {
+		return nil, nil, ErrExportNotFound
+	}
+	export, err := e.users.db.GetExportByToken(ctx, HashToken(token))
+	if err != nil {
+		return nil, nil, err
+	}
+	archive, err := e.store.GetExport(ctx, export.ObjectKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error opening export: %w", err)
+	}
+	e.users.recordAudit(ctx, AuditEvent{
+		Type:     AuditDataExportDownloaded,
+		UserID:   export.UserID,
+		Metadata: map[string]string{"export_id": export.ID},
+	})
+	return archive, export, nil
+}
+
+// RunPendingExports builds the queued exports one after the other and
+// returns how many are ready.
+func (e *Exports) RunPendingExports(ctx context.Context) (int, error) {
+	var errs []error
+	ready := 0
+	for ctx.Err() == nil {
+		export, err := e.users.db.ClaimExport(ctx, e.now().Add(-exportTimeout))
+		if errors.Is(err, ErrExportNotFound) {
+			break
+		}
+		if err != nil {
+			return ready, errors.Join(append(errs, err)...)
+		}
+		if export.Attempts > maxExportAttempts {
+			if err := e.users.db.FailExport(ctx, export.ID, false); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		if err := e.run(ctx, export); err != nil {
+			errs = append(errs, fmt.Errorf("export %s: %w", export.ID, err))
+			retry := export.Attempts < maxExportAttempts
+			if err := e.users.db.FailExport(ctx, export.ID, retry); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		ready++
+	}
+	return ready, errors.Join(errs...)
+}
+
+// ExpireExports deletes the archives whose link expired.
+func (e *Exports) ExpireExports(ctx context.Context) (int, error) {
+	expired, err := e.users.db.ListExpiredExports(ctx, e.now())
+	if err != nil {
+		return 0, err
+	}
+	var errs []error
+	deleted := 0
+	for _, export := range expired {
+		if err := e.store.DeleteExport(ctx, export.ObjectKey); err != nil {
+			errs = append(errs, fmt.Errorf("export %s: %w", export.ID, err))
+			continue
+		}
+		if err := e.users.db.ExpireExport(ctx, export.ID); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		deleted++
+	}
+	return deleted, errors.Join(errs...)
+}
+
+func (e *Exports) run(ctx context.Context, export *DataExport) error {
+	user, err := e.users.db.GetUserProfile(ctx, export.UserID)
+	if err != nil {
+		return err
+	}
+	archive, err := e.build(ctx, user)
+	if err != nil {
+		return err
+	}
+	key := fmt.Sprintf("exports/%s/%s.zip", export.UserID, export.ID)
+	if err := e.store.PutExport(ctx, key, archive); err != nil {
+		return fmt.Errorf("error storing export: %w", err)
+	}
+
+	token, err := NewOpaqueToken()
+	if err != nil {
+		return fmt.Errorf("error creating download token: %w", err)
+	}
+	expiresAt := e.now().Add(e.policy.LinkTTL)
+	if err := e.users.db.CompleteExport(ctx, export.ID, key, HashToken(token), expiresAt); err != nil {
+		if err := e.store.DeleteExport(ctx, key); err != nil {
+			fmt.Printf("failed to delete export %s: %v\n", key, err)
+		}
+		return err
+	}
+
+	link := e.users.links.build("/data-export", token)
+	err = e.users.mailer.Send(ctx, Email{
+		To:      user.Email,
+		Subject: "Your data export is ready",
+		Body: fmt.Sprintf("The copy of your data you asked for is ready.\n\nDownload it from the link below, it expires in %s:\n\n%s\n\nIf you didn't ask for it, change your password.\n",
+			e.policy.LinkTTL, link),
+	})
+	if err != nil {
+		fmt.Printf("failed to send export email to user %s: %v\n", user.ID, err)
+	}
+	return nil
+}
+
+// build writes the archive: a JSON file per kind of record, the avatar, and
+// the files of each source under its name.
+func (e *Exports) build(ctx context.Context, user *User) ([]byte, error) {
+	profiles, err := e.users.db.ListProfiles(ctx, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error listing profiles: %w", err)
+	}
+	sessions, err := e.users.db.ListAllSessions(ctx, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error listing sessions: %w", err)
+	}
+	audit, err := e.auditEvents(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	subscription, err := e.users.db.GetSubscription(ctx, user.ID)
+	if err != nil && !errors.Is(err, ErrSubscriptionNotFound) {
+		return nil, fmt.Errorf("error getting subscription: %w", err)
+	}
+
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	files := []exportFile{
+		{"account.json", exportedAccount(user)},
+		{"profiles.json", exportedProfiles(profiles)},
+		{"sessions.json", exportedSessions(sessions)},
+		{"audit_events.json", exportedAuditEvents(audit)},
+	}
+	if subscription != nil {
+		files = append(files, exportFile{"subscription.json", exportedSubscription(subscription)})
+	}
+	for _, f := range files {
+		value, err := json.MarshalIndent(f.content, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		if err := writeZipFile(zw, f.name, value); err != nil {
+			return nil, err
+		}
+	}
+
+	if user.AvatarKey != "" && e.users.avatars != nil {
+		if err := e.addAvatar(ctx, zw, user.AvatarKey); err != nil {
+			return nil, err
+		}
+	}
+
+	names := make([]string, 0, len(e.sources))
+	for name := range e.sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		data, err := e.sources[name].ExportUserData(ctx, user.ID)
+		if err != nil {
+			return nil, fmt.Errorf("error exporting %s data: %w", name, err)
+		}
+		fileNames := make([]string, 0, len(data))
+		for file := range data {
+			fileNames = append(fileNames, file)
+		}
+		sort.Strings(fileNames)
+		for _, file := range fileNames {
+			if err := writeZipFile(zw, path.Join(name, path.Base(file)), data[file]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (e *Exports) auditEvents(ctx context.Context, userID string) ([]AuditEvent, error) {
+	var all []AuditEvent
+	for page := 1; ; page++ {
+		events, total, err := e.users.audit.SearchAuditEvents(ctx, AuditFilter{UserID: userID, Page: page, PerPage: exportAuditPageSize})
+		if err != nil {
+			return nil, fmt.Errorf("error listing audit events: %w", err)
+		}
+		all = append(all, events...)
+		if len(events) == 0 || len(all) >= total {
+			return all, nil
+		}
+	}
+}
+
+func (e *Exports) addAvatar(ctx context.Context, zw *zip.Writer, key string) error {
+	avatar, _, err := e.users.avatars.GetAvatar(ctx, key)
+	if err != nil {
+		return fmt.Errorf("error reading avatar: %w", err)
+	}
+	defer avatar.Close()
+	image, err := io.ReadAll(avatar)
+	if err != nil {
+		return fmt.Errorf("error reading avatar: %w", err)
+	}
+	return writeZipFile(zw, "avatar"+path.Ext(key), image)
+}
+
+type exportFile struct {
+	name    string
+	content any
+}
+
+func writeZipFile(zw *zip.Writer, name string, content []byte) error {
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(content)
+	return err
+}
+
+// The exported records leave out the secrets held about the user, such as
+// password, token and PIN hashes.
+type exportAccount struct {
+	ID            string    `json:"id"`
+	Name          string    `json:"name"`
+	Email         string    `json:"email"`
+	EmailVerified bool      `json:"email_verified"`
+	Plan          int8      `json:"plan"`
+	MFAEnabled    bool      `json:"mfa_enabled"`
+	Roles         []string  `json:"roles"`
+	DisplayName   string    `json:"display_name"`
+	Bio           string    `json:"bio"`
+	Locale        string    `json:"locale"`
+	Timezone      string    `json:"timezone"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+type exportProfile struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Avatar      string    `json:"avatar"`
+	MaxMaturity int       `json:"max_maturity"`
+	HasPIN      bool      `json:"has_pin"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type exportSession struct {
+	ID         string    `json:"id"`
+	ProfileID  string    `json:"profile_id,omitempty"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	Revoked    bool      `json:"revoked"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+}
+
+type exportSubscription struct {
+	Plan              int8       `json:"plan"`
+	Status            string     `json:"status"`
+	TrialEndsAt       *time.Time `json:"trial_ends_at,omitempty"`
+	CurrentPeriodEnd  *time.Time `json:"current_period_end,omitempty"`
+	CancelAtPeriodEnd bool       `json:"cancel_at_period_end"`
+	CanceledAt        *time.Time `json:"canceled_at,omitempty"`
+	CreatedAt         time.Time  `json:"created_at"`
+}
+
+type exportAuditEvent struct {
+	Type      string            `json:"type"`
+	ActorID   string            `json:"actor_id,omitempty"`
+	SessionID string            `json:"session_id,omitempty"`
+	IP        string            `json:"ip,omitempty"`
+	UserAgent string            `json:"user_agent,omitempty"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+func exportedAccount(u *User) exportAccount {
+	return exportAccount{
+		ID:            u.ID,
+		Name:          u.Name,
+		Email:         u.Email,
+		EmailVerified: u.EmailVerified,
+		Plan:          u.Plan,
+		MFAEnabled:    u.MFAEnabled,
+		Roles:         u.Roles,
+		DisplayName:   u.DisplayName,
+		Bio:           u.Bio,
+		Locale:        u.Locale,
+		Timezone:      u.Timezone,
+		CreatedAt:     u.CreatedAt,
+	}
+}
+
+func exportedProfiles(profiles []Profile) []exportProfile {
+	res := make([]exportProfile, 0, len(profiles))
+	for _, p := range profiles {
+		res = append(res, exportProfile{
+			ID:          p.ID,
+			Name:        p.Name,
+			Avatar:      p.Avatar,
+			MaxMaturity: p.MaxMaturity,
+			HasPIN:      p.HasPIN(),
+			CreatedAt:   p.CreatedAt,
+		})
+	}
+	return res
+}
+
+func exportedSessions(sessions []Session) []exportSession {
+	res := make([]exportSession, 0, len(sessions))
+	for _, s := range sessions {
+		res = append(res, exportSession{
+			ID:         s.ID,
+			ProfileID:  s.ProfileID,
+			UserAgent:  s.UserAgent,
+			IP:         s.IP,
+			Revoked:    s.IsRevoked,
+			CreatedAt:  s.CreatedAt,
+			ExpiresAt:  s.ExpiresAt,
+			LastUsedAt: s.LastUsedAt,
+		})
+	}
+	return res
+}
+
+func exportedSubscription(s *Subscription) exportSubscription {
+	return exportSubscription{
+		Plan:              s.PlanID,
+		Status:            s.Status,
+		TrialEndsAt:       s.TrialEndsAt,
+		CurrentPeriodEnd:  s.CurrentPeriodEnd,
+		CancelAtPeriodEnd: s.CancelAtPeriodEnd,
+		CanceledAt:        s.CanceledAt,
+		CreatedAt:         s.CreatedAt,
+	}
+}
+
+func exportedAuditEvents(events []AuditEvent) []exportAuditEvent {
+	res := make([]exportAuditEvent, 0, len(events))
+	for _, e := range events {
+		res = append(res, exportAuditEvent{
+			Type:      e.Type,
+			ActorID:   e.ActorID,
+			SessionID: e.SessionID,
+			IP:        e.IP,
+			UserAgent: e.UserAgent,
+			Metadata:  e.Metadata,
+			CreatedAt: e.CreatedAt,
+		})
+	}
+	return res
+}
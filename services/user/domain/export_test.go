@@ -0,0 +1,220 @@
+package domain
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockExportStore struct {
+	mock.Mock
+	archive []byte
+}
+
+func (m *MockExportStore) PutExport(ctx context.Context, key string, archive []byte) error {
+	m.archive = archive
+	return m.Called(ctx, key).Error(0)
+}
+
+func (m *MockExportStore) GetExport(ctx context.Context, key string) (io.ReadCloser, error) {
+	args := m.Called(ctx, key)
+	body, _ := args.Get(0).(io.ReadCloser)
+	return body, args.Error(1)
+}
+
+func (m *MockExportStore) DeleteExport(ctx context.Context, key string) error {
+	return m.Called(ctx, key).Error(0)
+}
+
+type MockExportSource struct{ mock.Mock }
+
+func (m *MockExportSource) ExportUserData(ctx context.Context, userID string) (map[string]json.RawMessage, error) {
+	args := m.Called(ctx, userID)
+	data, _ := args.Get(0).(map[string]json.RawMessage)
+	return data, args.Error(1)
+}
+
+func newTestExports(db *MockStorage, audit *MockAuditLog, mailer *MockMailer, store *MockExportStore, source *MockExportSource) *Exports {
+	audit.On("RecordAuditEvent", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	users := NewUserManager(db, new(MockToken), audit, mailer, Links{BaseURL: "https://example.com"}, nil, nil, nil, nil)
+	return NewExports(users, store, map[string]ExportSource{"video_store": source}, ExportPolicy{LinkTTL: 48 * time.Hour})
+}
+
+func TestRequestExport(t *testing.T) {
+	ctx := context.Background()
+
+	tests := map[string]struct {
+		createErr error
+		expectErr error
+	}{
+		"queued": {},
+		"already in progress": {
+			createErr: ErrExportInProgress,
+			expectErr: ErrExportInProgress,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			db := new(MockStorage)
+			audit := new(MockAuditLog)
+			if tc.createErr != nil {
+				db.On("CreateExport", ctx, "user-1").Return(nil, tc.createErr)
+			} else {
+				db.On("CreateExport", ctx, "user-1").Return(&DataExport{ID: "export-1", UserID: "user-1", Status: ExportPending}, nil)
+			}
+
+			e := newTestExports(db, audit, new(MockMailer), new(MockExportStore), new(MockExportSource))
+			export, err := e.RequestExport(ctx, "user-1")
+			if tc.expectErr != nil {
+				assert.ErrorIs(t, err, tc.expectErr)
+				audit.AssertNotCalled(t, "RecordAuditEvent", ctx, AuditDataExportRequested, "")
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, ExportPending, export.Status)
+			audit.AssertCalled(t, "RecordAuditEvent", ctx, AuditDataExportRequested, "")
+		})
+	}
+}
+
+func TestRunPendingExports(t *testing.T) {
+	ctx := context.Background()
+
+	tests := map[string]struct {
+		attempts    int
+		sourceErr   error
+		expectReady int
+		expectFail  bool
+		expectRetry bool
+	}{
+		"builds the archive": {
+			attempts:    1,
+			expectReady: 1,
+		},
+		"source failure is retried": {
+			attempts:    1,
+			sourceErr:   errors.New("video_store down"),
+			expectFail:  true,
+			expectRetry: true,
+		},
+		"gives up after the last attempt": {
+			attempts:   maxExportAttempts,
+			sourceErr:  errors.New("video_store down"),
+			expectFail: true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			db := new(MockStorage)
+			audit := new(MockAuditLog)
+			mailer := new(MockMailer)
+			store := new(MockExportStore)
+			source := new(MockExportSource)
+			db.On("ClaimExport", ctx, mock.Anything).Return(&DataExport{ID: "export-1", UserID: "user-1", Attempts: tc.attempts}, nil).Once()
+			db.On("ClaimExport", ctx, mock.Anything).Return(nil, ErrExportNotFound)
+			db.On("GetUserProfile", ctx, "user-1").Return(&User{ID: "user-1", Email: "user@example.com"}, nil)
+			db.On("ListProfiles", ctx, "user-1").Return([]Profile{{ID: "profile-1", Name: "Kids", PINHash: "secret"}}, nil)
+			db.On("ListAllSessions", ctx, "user-1").Return([]Session{{ID: "session-1", RefreshTokenHash: "secret"}}, nil)
+			db.On("GetSubscription", ctx, "user-1").Return(nil, ErrSubscriptionNotFound)
+			audit.On("SearchAuditEvents", ctx, mock.Anything).Return([]AuditEvent{{Type: AuditLoginSucceeded}}, 1, nil)
+			if tc.sourceErr != nil {
+				source.On("ExportUserData", ctx, "user-1").Return(nil, tc.sourceErr)
+			} else {
+				source.On("ExportUserData", ctx, "user-1").Return(map[string]json.RawMessage{"videos.json": json.RawMessage(`[]`)}, nil)
+			}
+			store.On("PutExport", ctx, "exports/user-1/export-1.zip").Return(nil)
+			db.On("CompleteExport", ctx, "export-1", "exports/user-1/export-1.zip", mock.Anything, mock.Anything).Return(nil)
+			db.On("FailExport", ctx, "export-1", mock.Anything).Return(nil)
+			mailer.On("Send", ctx, "user@example.com").Return(nil)
+
+			e := newTestExports(db, audit, mailer, store, source)
+			ready, err := e.RunPendingExports(ctx)
+			assert.Equal(t, tc.expectReady, ready)
+			if tc.expectFail {
+				assert.ErrorIs(t, err, tc.sourceErr)
+				db.AssertCalled(t, "FailExport", ctx, "export-1", tc.expectRetry)
+				store.AssertNotCalled(t, "PutExport", ctx, mock.Anything)
+				mailer.AssertNotCalled(t, "Send", ctx, "user@example.com")
+				return
+			}
+			assert.NoError(t, err)
+			mailer.AssertCalled(t, "Send", ctx, "user@example.com")
+
+			zr, err := zip.NewReader(bytes.NewReader(store.archive), int64(len(store.archive)))
+			assert.NoError(t, err)
+			files := map[string]string{}
+			for _, f := range zr.File {
+				r, err := f.Open()
+				assert.NoError(t, err)
+				content, err := io.ReadAll(r)
+				assert.NoError(t, err)
+				files[f.Name] = string(content)
+			}
+			assert.Contains(t, files, "account.json")
+			assert.Contains(t, files, "audit_events.json")
+			assert.Equal(t, "[]", files["video_store/videos.json"])
+			assert.Contains(t, files["profiles.json"], `"has_pin": true`)
+			assert.NotContains(t, files["profiles.json"], "secret")
+			assert.Contains(t, files["sessions.json"], "session-1")
+			assert.NotContains(t, files["sessions.json"], "secret")
+		})
+	}
+}
+
+func TestDownloadExport(t *testing.T) {
+	ctx := context.Background()
+
+	tests := map[string]struct {
+		token     string
+		found     bool
+		expectErr error
+	}{
+		"valid link": {
+			token: "token",
+			found: true,
+		},
+		"expired link": {
+			token:     "token",
+			expectErr: ErrExportNotFound,
+		},
+		"missing token": {
+			expectErr: ErrExportNotFound,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			db := new(MockStorage)
+			audit := new(MockAuditLog)
+			store := new(MockExportStore)
+			if tc.found {
+				db.On("GetExportByToken", ctx, HashToken(tc.token)).Return(&DataExport{ID: "export-1", UserID: "user-1", ObjectKey: "exports/user-1/export-1.zip"}, nil)
+			} else {
+				db.On("GetExportByToken", ctx, HashToken(tc.token)).Return(nil, ErrExportNotFound)
+			}
+			store.On("GetExport", ctx, "exports/user-1/export-1.zip").Return(io.NopCloser(bytes.NewReader([]byte("zip"))), nil)
+
+			e := newTestExports(db, audit, new(MockMailer), store, new(MockExportSource))
+			archive, export, err := e.Download(ctx, tc.token)
+			if tc.expectErr != nil {
+				assert.ErrorIs(t, err, tc.expectErr)
+				audit.AssertNotCalled(t, "RecordAuditEvent", ctx, AuditDataExportDownloaded, "")
+				return
+			}
+			assert.NoError(t, err)
+			defer archive.Close()
+			assert.Equal(t, "export-1", export.ID)
+			audit.AssertCalled(t, "RecordAuditEvent", ctx, AuditDataExportDownloaded, "")
+		})
+	}
+}
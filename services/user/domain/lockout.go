@@ -0,0 +1,155 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// AttemptStore counts failed logins per key over a sliding window, a
+// failure older than since no longer counts.
+type AttemptStore interface {
+	AddFailure(ctx context.Context, key string, at time.Time, since time.Time) (int, error)
+	Failures(ctx context.Context, key string, since time.Time) (int, time.Time, error)
+	ResetFailures(ctx context.Context, key string) error
+	// DeleteFailures removes the failures of every key up to before and
+	// reports how many were removed.
+	DeleteFailures(ctx context.Context, before time.Time) (int64, error)
+}
+
+// LockoutPolicy configures the LoginGuard. After FreeAttempts failures an
+// account waits one second before the next attempt, doubling with every
+// further failure up to MaxDelay. AccountLimit failures of an account, or
+// IPLimit failures from one address, within Window lock logins out for
+// LockoutDuration.
+type LockoutPolicy struct {
+	Window          time.Duration
+	FreeAttempts    int
+	MaxDelay        time.Duration
+	AccountLimit    int
+	IPLimit         int
+	LockoutDuration time.Duration
+}
+
+type TooManyAttemptsError struct {
+	RetryAfter time.Duration
+}
+
+func (e *TooManyAttemptsError) Error() string {
+	return fmt.Sprintf("too many login attempts, retry in %s", e.RetryAfter.Round(time.Second))
+}
+
+// LoginGuard throttles password guessing before the bcrypt check. A nil
+// guard lets every attempt through.
+type LoginGuard struct {
+	store  AttemptStore
+	policy LockoutPolicy
+	now    func() time.Time
+}
+
+func NewLoginGuard(store AttemptStore, policy LockoutPolicy) *LoginGuard {
+	return &LoginGuard{
+		store:  store,
+		policy: policy,
+		now:    time.Now,
+	}
+}
+
+// Check returns a *TooManyAttemptsError while the account or the address
+// must wait.
+func (g *LoginGuard) Check(ctx context.Context, email string, ip string) error {
+	if g == nil {
+		return nil
+	}
+	now := g.now()
+	since := now.Add(-g.policy.Window)
+
+	count, last, err := g.store.Failures(ctx, accountKey(email), since)
+	if err != nil {
+		return fmt.Errorf("error counting login failures: %w", err)
+	}
+	wait := last.Add(g.delay(count)).Sub(now)
+	if count >= g.policy.AccountLimit {
+		wait = last.Add(g.policy.LockoutDuration).Sub(now)
+	}
+	if wait > 0 {
+		return &TooManyAttemptsError{RetryAfter: wait}
+	}
+
+	count, last, err = g.store.Failures(ctx, ipKey(ip), since)
+	if err != nil {
+		return fmt.Errorf("error counting login failures: %w", err)
+	}
+	if count >= g.policy.IPLimit {
+		if wait := last.Add(g.policy.LockoutDuration).Sub(now); wait > 0 {
+			return &TooManyAttemptsError{RetryAfter: wait}
+		}
+	}
+	return nil
+}
+
+// Failed records a failed attempt and reports whether it locked the account.
+func (g *LoginGuard) Failed(ctx context.Context, email string, ip string) (bool, error) {
+	if g == nil {
+		return false, nil
+	}
+	now := g.now()
+	since := now.Add(-g.policy.Window)
+
+	count, err := g.store.AddFailure(ctx, accountKey(email), now, since)
+	if err != nil {
+		return false, fmt.Errorf("error recording login failure: %w", err)
+	}
+	if _, err := g.store.AddFailure(ctx, ipKey(ip), now, since); err != nil {
+		return false, fmt.Errorf("error recording login failure: %w", err)
+	}
+	return count == g.policy.AccountLimit, nil
+}
+
+// Succeeded clears the failures of the account. Those of the address stay,
+// logging into an account of their own must not let an attacker keep
+// guessing others.
+func (g *LoginGuard) Succeeded(ctx context.Context, email string) error {
+	if g == nil {
+		return nil
+	}
+	return g.store.ResetFailures(ctx, accountKey(email))
+}
+
+// Sweep deletes the failures that left the window. A key is only pruned when
+// it fails again, so keys that never come back, such as sprayed emails or
+// addresses, would stay forever.
+func (g *LoginGuard) Sweep(ctx context.Context) (int64, error) {
+	if g == nil {
+		return 0, nil
+	}
+	deleted, err := g.store.DeleteFailures(ctx, g.now().Add(-g.policy.Window))
+	if err != nil {
+		return 0, fmt.Errorf("error deleting login failures: %w", err)
+	}
+	return deleted, nil
+}
+
+func (g *LoginGuard) LockedUntil() time.Time {
+	return g.now().Add(g.policy.LockoutDuration)
+}
+
+func (g *LoginGuard) delay(failures int) time.Duration {
+	if failures <= g.policy.FreeAttempts {
+		return 0
+	}
+	delay := time.Second
+	for i := g.policy.FreeAttempts + 1; i < failures && delay < g.policy.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, g.policy.MaxDelay)
+}
+
+func accountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
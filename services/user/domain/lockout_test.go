@@ -0,0 +1,194 @@
+package domain
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type memoryAttempts map[string][]time.Time
+
+func (m memoryAttempts) AddFailure(ctx context.Context, key string, at time.Time, since time.Time) (int, error) {
+	m[key] = append(m[key], at)
+	count, _, err := m.Failures(ctx, key, since)
+	return count, err
+}
+
+func (m memoryAttempts) Failures(ctx context.Context, key string, since time.Time) (int, time.Time, error) {
+	var count int
+	var last time.Time
+	for _, at := range m[key] {
+		if at.After(since) {
+			count++
+			last = at
+		}
+	}
+	return count, last, nil
+}
+
+func (m memoryAttempts) ResetFailures(ctx context.Context, key string) error {
+	delete(m, key)
+	return nil
+}
+
+func (m memoryAttempts) DeleteFailures(ctx context.Context, before time.Time) (int64, error) {
+	var deleted int64
+	for key, failures := range m {
+		var kept []time.Time
+		for _, at := range failures {
+			if at.After(before) {
+				kept = append(kept, at)
+			}
+		}
+		deleted += int64(len(failures) - len(kept))
+		if len(kept) == 0 {
+			delete(m, key)
+		} else {
+			m[key] = kept
+		}
+	}
+	return deleted, nil
+}
+
+var testPolicy = LockoutPolicy{
+	Window:          15 * time.Minute,
+	FreeAttempts:    2,
+	MaxDelay:        4 * time.Second,
+	AccountLimit:    5,
+	IPLimit:         8,
+	LockoutDuration: 10 * time.Minute,
+}
+
+func TestLoginGuard(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := map[string]struct {
+		failures    int
+		otherEmails bool
+		elapsed     time.Duration
+		expectWait  time.Duration
+	}{
+		"free attempts":                  {failures: 2},
+		"first delay":                    {failures: 3, expectWait: time.Second},
+		"delay doubles":                  {failures: 4, expectWait: 2 * time.Second},
+		"delay passed":                   {failures: 4, elapsed: 3 * time.Second},
+		"account locked":                 {failures: 5, elapsed: time.Minute, expectWait: 9 * time.Minute},
+		"lockout over":                   {failures: 5, elapsed: 11 * time.Minute},
+		"failures leave the window":      {failures: 5, elapsed: 16 * time.Minute},
+		"address locked across accounts": {failures: 8, otherEmails: true, elapsed: time.Minute, expectWait: 9 * time.Minute},
+		"address below its limit":        {failures: 7, otherEmails: true, elapsed: time.Minute},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			g := NewLoginGuard(memoryAttempts{}, testPolicy)
+			g.now = func() time.Time { return start }
+			for i := 0; i < tc.failures; i++ {
+				email := "user@example.com"
+				if tc.otherEmails {
+					email = string(rune('a'+i)) + "@example.com"
+				}
+				_, err := g.Failed(ctx, email, "10.0.0.1")
+				assert.NoError(t, err)
+			}
+
+			g.now = func() time.Time { return start.Add(tc.elapsed) }
+			err := g.Check(ctx, "user@example.com", "10.0.0.1")
+			if tc.expectWait == 0 {
+				assert.NoError(t, err)
+				return
+			}
+			var throttled *TooManyAttemptsError
+			assert.ErrorAs(t, err, &throttled)
+			assert.Equal(t, tc.expectWait, throttled.RetryAfter)
+		})
+	}
+}
+
+func TestLoginGuard_Sweep(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	store := memoryAttempts{}
+	g := NewLoginGuard(store, testPolicy)
+
+	g.now = func() time.Time { return start }
+	_, err := g.Failed(ctx, "sprayed@example.com", "10.0.0.1")
+	assert.NoError(t, err)
+	g.now = func() time.Time { return start.Add(10 * time.Minute) }
+	_, err = g.Failed(ctx, "user@example.com", "10.0.0.2")
+	assert.NoError(t, err)
+
+	g.now = func() time.Time { return start.Add(16 * time.Minute) }
+	deleted, err := g.Sweep(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), deleted)
+	assert.NotContains(t, store, accountKey("sprayed@example.com"))
+	assert.NotContains(t, store, ipKey("10.0.0.1"))
+	assert.Contains(t, store, accountKey("user@example.com"))
+}
+
+func TestUserLogin_Lockout(t *testing.T) {
+	ctx := context.Background()
+	hash, err := HashPassword("a-strong-password")
+	assert.NoError(t, err)
+
+	db := new(MockStorage)
+	audit := new(MockAuditLog)
+	db.On("GetUser", ctx, "user@example.com").Return(&UserAuthData{ID: "user-1", Email: "user@example.com", Password: hash}, nil)
+	audit.On("RecordAuditEvent", ctx, AuditLoginFailed, "").Return(nil)
+	audit.On("RecordAuditEvent", ctx, AuditAccountLocked, "").Return(nil)
+
+	guard := NewLoginGuard(memoryAttempts{}, LockoutPolicy{
+		Window:          15 * time.Minute,
+		AccountLimit:    3,
+		IPLimit:         3,
+		LockoutDuration: 10 * time.Minute,
+	})
+	u := NewUserManager(db, new(MockToken), audit, new(MockMailer), Links{}, nil, guard, nil, nil)
+
+	for i := 0; i < 3; i++ {
+		_, err := u.UserLogin(ctx, "user@example.com", "wrong-password", Client{IP: "10.0.0.1"})
+		assert.EqualError(t, err, "password incorrect")
+	}
+	// Three failures, then the lockout.
+	audit.AssertNumberOfCalls(t, "RecordAuditEvent", 4)
+	audit.AssertCalled(t, "RecordAuditEvent", ctx, AuditAccountLocked, "")
+
+	_, err = u.UserLogin(ctx, "user@example.com", "a-strong-password", Client{IP: "10.0.0.1"})
+	var throttled *TooManyAttemptsError
+	assert.ErrorAs(t, err, &throttled)
+	db.AssertNumberOfCalls(t, "GetUser", 3)
+	db.AssertNotCalled(t, "CreateSession", ctx, mock.Anything)
+}
+
+func TestThrottlePasswordReset(t *testing.T) {
+	ctx := context.Background()
+
+	guard := NewLoginGuard(memoryAttempts{}, LockoutPolicy{
+		Window:          15 * time.Minute,
+		FreeAttempts:    5,
+		AccountLimit:    3,
+		IPLimit:         5,
+		LockoutDuration: 10 * time.Minute,
+	})
+	u := NewUserManager(new(MockStorage), new(MockToken), new(MockAuditLog), new(MockMailer), Links{}, nil, guard, nil, nil)
+	client := Client{IP: "10.0.0.1"}
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, u.ThrottlePasswordReset(ctx, "user@example.com", client))
+	}
+	var throttled *TooManyAttemptsError
+	assert.ErrorAs(t, u.ThrottlePasswordReset(ctx, "user@example.com", client), &throttled)
+
+	// Two more addresses reach the limit of the client.
+	assert.NoError(t, u.ThrottlePasswordReset(ctx, "a@example.com", client))
+	assert.NoError(t, u.ThrottlePasswordReset(ctx, "b@example.com", client))
+	assert.ErrorAs(t, u.ThrottlePasswordReset(ctx, "c@example.com", client), &throttled)
+
+	// Reset requests don't count against logins.
+	assert.NoError(t, guard.Check(ctx, "user@example.com", "10.0.0.1"))
+}
@@ -0,0 +1,231 @@
+package domain
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/eduardo-ax/video-streaming/services/user/totp"
+)
+
+const (
+	mfaIssuer               = "Video Streaming"
+	mfaChallengeTTL         = 5 * time.Minute
+	maxMFAChallengeAttempts = 5
+	recoveryCodeCount       = 10
+)
+
+var (
+	ErrMFAAlreadyEnabled   = errors.New("two-factor authentication already enabled")
+	ErrMFANotEnrolled      = errors.New("two-factor authentication not enrolled")
+	ErrInvalidMFACode      = errors.New("invalid two-factor code")
+	ErrInvalidMFAChallenge = errors.New("invalid or expired two-factor challenge")
+)
+
+// MFA is the TOTP enrollment of a user. Secret is sealed with the SecretBox,
+// LastUsedStep is the period of the last accepted code so it can't be
+// replayed.
+type MFA struct {
+	UserID       string
+	Secret       []byte
+	Enabled      bool
+	LastUsedStep int64
+}
+
+type MFAEnrollment struct {
+	Secret string
+	URI    string
+}
+
+// MFAChallenge is returned by UserLogin instead of tokens when the account
+// has two-factor authentication, it is exchanged with CompleteMFALogin.
+type MFAChallenge struct {
+	Token     string
+	ExpiresAt time.Time
+}
+
+// SecretBox encrypts the secrets that must be readable again, such as TOTP
+// seeds.
+type SecretBox interface {
+	Seal(plaintext []byte, additionalData []byte) ([]byte, error)
+	Open(sealed []byte, additionalData []byte) ([]byte, error)
+}
+
+// EnrollMFA starts an enrollment with a new secret, it only takes effect once
+// confirmed with a code. Enrolling again before confirming replaces the
+// secret.
+func (u *UserManager) EnrollMFA(ctx context.Context, userID string) (*MFAEnrollment, error) {
+	user, err := u.db.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting user: %w", err)
+	}
+	if user.MFAEnabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, fmt.Errorf("error generating secret: %w", err)
+	}
+	sealed, err := u.box.Seal([]byte(secret), []byte(userID))
+	if err != nil {
+		return nil, fmt.Errorf("error sealing secret: %w", err)
+	}
+	if err := u.db.SaveMFASecret(ctx, userID, sealed); err != nil {
+		return nil, err
+	}
+	return &MFAEnrollment{
+		Secret: secret,
+		URI:    totp.URI(mfaIssuer, user.Email, secret),
+	}, nil
+}
+
+// ConfirmMFA enables two-factor authentication once the user proves the
+// authenticator works, and returns the recovery codes. They are only shown
+// this once, the database keeps their hashes.
+func (u *UserManager) ConfirmMFA(ctx context.Context, userID string, code string) ([]string, error) {
+	mfa, err := u.db.GetMFA(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if mfa.Enabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	step, ok, err := u.validateTOTP(mfa, code)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		if codes[i], err = newRecoveryCode(); err != nil {
+			return nil, fmt.Errorf("error generating recovery codes: %w", err)
+		}
+		hashes[i] = HashToken(codes[i])
+	}
+	if err := u.db.EnableMFA(ctx, userID, step, hashes); err != nil {
+		return nil, err
+	}
+	u.recordAudit(ctx, AuditEvent{Type: AuditMFAEnabled, ActorID: userID, UserID: userID})
+	return codes, nil
+}
+
+// CompleteMFALogin exchanges a challenge and a TOTP or recovery code for the
+// session UserLogin would have created. A challenge allows
+// maxMFAChallengeAttempts codes and is consumed by the first good one.
+// Wrong codes are also counted per user by the LoginGuard, since a correct
+// password mints a new challenge.
+func (u *UserManager) CompleteMFALogin(ctx context.Context, challenge string, code string, client Client) (*LoginUserRes, error) {
+	if challenge == "" {
+		return nil, ErrInvalidMFAChallenge
+	}
+	userID, err := u.db.AttemptMFAChallenge(ctx, HashToken(challenge), maxMFAChallengeAttempts)
+	if err != nil {
+		return nil, err
+	}
+	mfa, err := u.db.GetMFA(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !mfa.Enabled {
+		return nil, ErrInvalidMFAChallenge
+	}
+	key := "mfa:" + userID
+	if err := u.guard.Check(ctx, key, client.IP); err != nil {
+		return nil, err
+	}
+
+	step, ok, err := u.validateTOTP(mfa, code)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case ok:
+		if err := u.db.UseTOTPStep(ctx, userID, step); err != nil {
+			return nil, err
+		}
+	case u.db.UseRecoveryCode(ctx, userID, HashToken(normalizeRecoveryCode(code))) == nil:
+		u.recordAudit(ctx, AuditEvent{
+			Type:      AuditMFARecoveryCodeUsed,
+			ActorID:   userID,
+			UserID:    userID,
+			IP:        client.IP,
+			UserAgent: client.UserAgent,
+		})
+	default:
+		u.recordAudit(ctx, AuditEvent{
+			Type:      AuditMFAFailed,
+			UserID:    userID,
+			IP:        client.IP,
+			UserAgent: client.UserAgent,
+		})
+		if _, err := u.guard.Failed(ctx, key, client.IP); err != nil {
+			fmt.Printf("failed to record MFA failure: %v\n", err)
+		}
+		return nil, ErrInvalidMFACode
+	}
+	if err := u.guard.Succeeded(ctx, key); err != nil {
+		fmt.Printf("failed to reset MFA failures: %v\n", err)
+	}
+
+	if err := u.db.DeleteMFAChallenge(ctx, HashToken(challenge)); err != nil {
+		return nil, fmt.Errorf("error consuming challenge: %w", err)
+	}
+	user, err := u.db.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting user: %w", err)
+	}
+	return u.startSession(ctx, user, client)
+}
+
+func (u *UserManager) createMFAChallenge(ctx context.Context, userID string) (*MFAChallenge, error) {
+	token, err := NewOpaqueToken()
+	if err != nil {
+		return nil, fmt.Errorf("error creating challenge: %w", err)
+	}
+	expiresAt := time.Now().Add(mfaChallengeTTL)
+	if err := u.db.CreateMFAChallenge(ctx, userID, HashToken(token), expiresAt); err != nil {
+		return nil, fmt.Errorf("error storing challenge: %w", err)
+	}
+	return &MFAChallenge{Token: token, ExpiresAt: expiresAt}, nil
+}
+
+// validateTOTP refuses codes of periods up to the last one used.
+func (u *UserManager) validateTOTP(mfa *MFA, code string) (int64, bool, error) {
+	secret, err := u.box.Open(mfa.Secret, []byte(mfa.UserID))
+	if err != nil {
+		return 0, false, fmt.Errorf("error opening totp secret: %w", err)
+	}
+	step, ok := totp.Validate(string(secret), strings.TrimSpace(code), time.Now())
+	if !ok || step <= mfa.LastUsedStep {
+		return 0, false, nil
+	}
+	return step, true, nil
+}
+
+// newRecoveryCode returns a code such as "k7qm-2xfa-9dtp".
+func newRecoveryCode() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	raw := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))[:12]
+	return raw[:4] + "-" + raw[4:8] + "-" + raw[8:], nil
+}
+
+// normalizeRecoveryCode accepts codes typed in upper case or without dashes.
+func normalizeRecoveryCode(code string) string {
+	raw := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	if len(raw) != 12 {
+		return code
+	}
+	return raw[:4] + "-" + raw[4:8] + "-" + raw[8:]
+}
@@ -0,0 +1,180 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/eduardo-ax/video-streaming/services/user/secretbox"
+	"github.com/eduardo-ax/video-streaming/services/user/totp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestUserLogin_MFA(t *testing.T) {
+	ctx := context.Background()
+	hash, err := HashPassword("a-strong-password")
+	assert.NoError(t, err)
+
+	tests := map[string]struct {
+		mfaEnabled      bool
+		expectChallenge bool
+	}{
+		"without mfa a session is created": {},
+		"with mfa only a challenge is returned": {
+			mfaEnabled:      true,
+			expectChallenge: true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			db := new(MockStorage)
+			token := new(MockToken)
+			user := &UserAuthData{ID: "user-1", Email: "user@example.com", Password: hash, MFAEnabled: tc.mfaEnabled}
+			db.On("GetUser", ctx, "user@example.com").Return(user, nil)
+			db.On("CreateMFAChallenge", ctx, "user-1").Return(nil)
+			db.On("CreateSession", ctx, mock.Anything).Return(nil)
+			token.On("CreateToken", "user-1", mock.Anything, mock.Anything).Return("token", nil)
+			audit := new(MockAuditLog)
+			audit.On("RecordAuditEvent", ctx, AuditLoginSucceeded, mock.Anything).Return(nil)
+
+			u := NewUserManager(db, token, audit, new(MockMailer), Links{}, nil, nil, nil, nil)
+			res, err := u.UserLogin(ctx, "user@example.com", "a-strong-password", Client{})
+			assert.NoError(t, err)
+			if tc.expectChallenge {
+				assert.NotNil(t, res.MFAChallenge)
+				assert.Empty(t, res.AccessToken)
+				token.AssertNotCalled(t, "CreateToken", "user-1", mock.Anything, mock.Anything)
+				return
+			}
+			assert.Nil(t, res.MFAChallenge)
+			assert.Equal(t, "token", res.AccessToken)
+			audit.AssertCalled(t, "RecordAuditEvent", ctx, AuditLoginSucceeded, res.SessionID)
+		})
+	}
+}
+
+func TestCompleteMFALogin(t *testing.T) {
+	ctx := context.Background()
+	box, err := secretbox.New("a-secret-key-of-at-least-32-characters")
+	assert.NoError(t, err)
+	secret, err := totp.GenerateSecret()
+	assert.NoError(t, err)
+	sealed, err := box.Seal([]byte(secret), []byte("user-1"))
+	assert.NoError(t, err)
+	code, err := totp.Code(secret, time.Now())
+	assert.NoError(t, err)
+	step := time.Now().Unix() / int64(totp.Period.Seconds())
+
+	tests := map[string]struct {
+		code         string
+		lastUsedStep int64
+		challengeErr error
+		recoveryErr  error
+		expectErr    error
+		expectAudit  bool
+	}{
+		"valid totp code": {
+			code: code,
+		},
+		"totp code already used": {
+			code:         code,
+			lastUsedStep: step + 1,
+			recoveryErr:  ErrInvalidMFACode,
+			expectErr:    ErrInvalidMFACode,
+		},
+		"recovery code": {
+			code:        "ABCD-EFGH-IJKL",
+			expectAudit: true,
+		},
+		"wrong code": {
+			code:        "000000-x",
+			recoveryErr: ErrInvalidMFACode,
+			expectErr:   ErrInvalidMFACode,
+		},
+		"expired or exhausted challenge": {
+			code:         code,
+			challengeErr: ErrInvalidMFAChallenge,
+			expectErr:    ErrInvalidMFAChallenge,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			db := new(MockStorage)
+			token := new(MockToken)
+			audit := new(MockAuditLog)
+			db.On("AttemptMFAChallenge", ctx, HashToken("challenge-1")).Return("user-1", tc.challengeErr)
+			db.On("GetMFA", ctx, "user-1").Return(&MFA{UserID: "user-1", Secret: sealed, Enabled: true, LastUsedStep: tc.lastUsedStep}, nil)
+			db.On("UseTOTPStep", ctx, "user-1", mock.Anything).Return(nil)
+			db.On("UseRecoveryCode", ctx, "user-1", mock.Anything).Return(tc.recoveryErr)
+			db.On("DeleteMFAChallenge", ctx, HashToken("challenge-1")).Return(nil)
+			db.On("GetUserByID", ctx, "user-1").Return(&UserAuthData{ID: "user-1", MFAEnabled: true}, nil)
+			db.On("CreateSession", ctx, mock.Anything).Return(nil)
+			token.On("CreateToken", "user-1", mock.Anything, mock.Anything).Return("token", nil)
+			audit.On("RecordAuditEvent", ctx, AuditMFARecoveryCodeUsed, "").Return(nil)
+			audit.On("RecordAuditEvent", ctx, AuditMFAFailed, "").Return(nil)
+			audit.On("RecordAuditEvent", ctx, AuditLoginSucceeded, mock.Anything).Return(nil)
+
+			u := NewUserManager(db, token, audit, new(MockMailer), Links{}, box, nil, nil, nil)
+			res, err := u.CompleteMFALogin(ctx, "challenge-1", tc.code, Client{})
+			if tc.expectErr != nil {
+				assert.ErrorIs(t, err, tc.expectErr)
+				db.AssertNotCalled(t, "CreateSession", ctx, mock.Anything)
+				if errors.Is(tc.expectErr, ErrInvalidMFACode) {
+					audit.AssertCalled(t, "RecordAuditEvent", ctx, AuditMFAFailed, "")
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "token", res.AccessToken)
+			db.AssertCalled(t, "DeleteMFAChallenge", ctx, HashToken("challenge-1"))
+			if tc.expectAudit {
+				db.AssertCalled(t, "UseRecoveryCode", ctx, "user-1", HashToken("abcd-efgh-ijkl"))
+				audit.AssertCalled(t, "RecordAuditEvent", ctx, AuditMFARecoveryCodeUsed, "")
+			}
+		})
+	}
+}
+
+func TestCompleteMFALogin_Lockout(t *testing.T) {
+	ctx := context.Background()
+	box, err := secretbox.New("a-secret-key-of-at-least-32-characters")
+	assert.NoError(t, err)
+	secret, err := totp.GenerateSecret()
+	assert.NoError(t, err)
+	sealed, err := box.Seal([]byte(secret), []byte("user-1"))
+	assert.NoError(t, err)
+	code, err := totp.Code(secret, time.Now())
+	assert.NoError(t, err)
+
+	db := new(MockStorage)
+	audit := new(MockAuditLog)
+	db.On("AttemptMFAChallenge", ctx, mock.Anything).Return("user-1", nil)
+	db.On("GetMFA", ctx, "user-1").Return(&MFA{UserID: "user-1", Secret: sealed, Enabled: true}, nil)
+	db.On("UseRecoveryCode", ctx, "user-1", mock.Anything).Return(ErrInvalidMFACode)
+	audit.On("RecordAuditEvent", ctx, AuditMFAFailed, "").Return(nil)
+
+	guard := NewLoginGuard(memoryAttempts{}, LockoutPolicy{
+		Window:          15 * time.Minute,
+		FreeAttempts:    3,
+		AccountLimit:    3,
+		IPLimit:         10,
+		LockoutDuration: 10 * time.Minute,
+	})
+	u := NewUserManager(db, new(MockToken), audit, new(MockMailer), Links{}, box, guard, nil, nil)
+
+	// Every wrong code uses a fresh challenge, as after logging in again.
+	for i := 0; i < 3; i++ {
+		_, err := u.CompleteMFALogin(ctx, "challenge-"+strconv.Itoa(i), "000000", Client{IP: "10.0.0.1"})
+		assert.ErrorIs(t, err, ErrInvalidMFACode)
+	}
+
+	_, err = u.CompleteMFALogin(ctx, "challenge-3", code, Client{IP: "10.0.0.1"})
+	var throttled *TooManyAttemptsError
+	assert.ErrorAs(t, err, &throttled)
+	db.AssertNotCalled(t, "UseTOTPStep", ctx, "user-1", mock.Anything)
+}
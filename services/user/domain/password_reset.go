@@ -0,0 +1,90 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const passwordResetTokenTTL = time.Hour
+
+var (
+	ErrInvalidResetToken = errors.New("invalid or expired password reset token")
+	ErrInvalidPassword   = errors.New("invalid password")
+)
+
+// ThrottlePasswordReset counts a reset request like a failed login, under
+// keys of its own, so an address can't be flooded with links and a client
+// can't request them for many addresses. It doesn't look the email up and
+// runs before ForgotPassword.
+func (u *UserManager) ThrottlePasswordReset(ctx context.Context, email string, client Client) error {
+	key := "reset:" + email
+	ip := "reset:" + client.IP
+	if err := u.guard.Check(ctx, key, ip); err != nil {
+		return err
+	}
+	if _, err := u.guard.Failed(ctx, key, ip); err != nil {
+		return fmt.Errorf("error recording password reset request: %w", err)
+	}
+	return nil
+}
+
+// ForgotPassword mails a reset link when the address belongs to an account.
+// Unknown addresses are not an error so callers can't tell them apart.
+func (u *UserManager) ForgotPassword(ctx context.Context, email string) error {
+	user, err := u.db.GetUser(ctx, strings.TrimSpace(email))
+	if err != nil {
+		return nil
+	}
+
+	token, err := NewOpaqueToken()
+	if err != nil {
+		return fmt.Errorf("error creating password reset token: %w", err)
+	}
+	if err := u.db.CreatePasswordResetToken(ctx, user.ID, HashToken(token), time.Now().Add(passwordResetTokenTTL)); err != nil {
+		return fmt.Errorf("error storing password reset token: %w", err)
+	}
+
+	link := u.links.build("/reset-password", token)
+	return u.mailer.Send(ctx, Email{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Someone asked to reset the password of your account.\n\nChoose a new password by opening the link below, it expires in %s and works once:\n\n%s\n\nIf it wasn't you, ignore this message, your password stays the same.\n",
+			passwordResetTokenTTL, link),
+	})
+}
+
+// ResetPassword consumes a reset token and sets the new password. Every
+// session of the user is revoked, whoever knew the old password is logged
+// out.
+func (u *UserManager) ResetPassword(ctx context.Context, token string, password string) error {
+	if token == "" {
+		return ErrInvalidResetToken
+	}
+	if err := ValidateUpdateUserFields("", nil, &password); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidPassword, err)
+	}
+	hash, err := HashPassword(password)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	userID, err := u.db.ResetPassword(ctx, HashToken(token), hash)
+	if err != nil {
+		return err
+	}
+
+	revoked, err := u.db.RevokeUserSessions(ctx, userID, "")
+	if err != nil {
+		return fmt.Errorf("error revoking sessions: %w", err)
+	}
+	u.recordAudit(ctx, AuditEvent{
+		Type:     AuditPasswordReset,
+		ActorID:  userID,
+		UserID:   userID,
+		Metadata: map[string]string{"revoked_sessions": fmt.Sprint(revoked)},
+	})
+	return nil
+}
@@ -0,0 +1,153 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/eduardo-ax/video-streaming/pkg/events"
+)
+
+var (
+	ErrPlanNotFound     = errors.New("plan not found")
+	ErrPlanUnavailable  = errors.New("plan not available")
+	ErrSamePlan         = errors.New("already on this plan")
+	ErrEmailNotVerified = errors.New("email not verified")
+)
+
+type Plan struct {
+	ID            int8
+	Name          string
+	Description   string
+	PriceCents    int
+	Currency      string
+	MaxStreams    int
+	MaxProfiles   int
+	MaxResolution int
+	Features      []string
+	IsDefault     bool
+	IsPublic      bool
+}
+
+func (p Plan) Paid() bool {
+	return p.PriceCents > 0
+}
+
+// direction ranks plans by price, the ID breaking ties.
+func direction(from *Plan, to *Plan) string {
+	if to.PriceCents > from.PriceCents || (to.PriceCents == from.PriceCents && to.ID > from.ID) {
+		return events.DirectionUpgrade
+	}
+	return events.DirectionDowngrade
+}
+
+type EventPublisher interface {
+	PublishUserEvent(ctx context.Context, event events.UserEvent) error
+}
+
+// ListPlans returns the plans users can choose, cheapest first.
+func (u *UserManager) ListPlans(ctx context.Context) ([]Plan, error) {
+	plans, err := u.db.ListPlans(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error listing plans: %w", err)
+	}
+	public := make([]Plan, 0, len(plans))
+	for _, p := range plans {
+		if p.IsPublic {
+			public = append(public, p)
+		}
+	}
+	return public, nil
+}
+
+// SwitchPlan moves the user to a free plan. Paid plans are reached through
+// a subscription, which must be cancelled before leaving them. The new plan
+// reaches the access token at the next renewal.
+func (u *UserManager) SwitchPlan(ctx context.Context, userID string, planID int8) (*Plan, error) {
+	target, err := u.db.GetPlan(ctx, planID)
+	if err != nil {
+		return nil, err
+	}
+	if !target.IsPublic {
+		return nil, ErrPlanUnavailable
+	}
+	if target.Paid() {
+		return nil, ErrPaymentRequired
+	}
+	sub, err := u.db.GetSubscription(ctx, userID)
+	if err != nil && !errors.Is(err, ErrSubscriptionNotFound) {
+		return nil, err
+	}
+	if sub != nil && sub.Live() {
+		return nil, ErrSubscriptionExists
+	}
+	user, err := u.db.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting user: %w", err)
+	}
+	if err := u.changePlan(ctx, userID, user.Plan, target, userID); err != nil {
+		return nil, err
+	}
+	return target, nil
+}
+
+// ChangePlan is the admin override, any existing plan can be set.
+func (u *UserManager) ChangePlan(ctx context.Context, actorID string, id string, planID int8) error {
+	target, err := u.db.GetPlan(ctx, planID)
+	if err != nil {
+		return err
+	}
+	user, err := u.db.GetUserSummary(ctx, id)
+	if err != nil {
+		return err
+	}
+	return u.changePlan(ctx, id, user.Plan, target, actorID)
+}
+
+func (u *UserManager) changePlan(ctx context.Context, userID string, fromID int8, target *Plan, actorID string) error {
+	if fromID == target.ID {
+		return ErrSamePlan
+	}
+	from, err := u.db.GetPlan(ctx, fromID)
+	if err != nil {
+		return fmt.Errorf("error getting current plan: %w", err)
+	}
+	if err := u.db.ChangePlan(ctx, userID, target.ID); err != nil {
+		return err
+	}
+	u.planChanged(ctx, userID, from, target, actorID)
+	return nil
+}
+
+// planChanged records and publishes a plan change already stored.
+func (u *UserManager) planChanged(ctx context.Context, userID string, from *Plan, target *Plan, actorID string) {
+	dir := direction(from, target)
+	u.recordAudit(ctx, AuditEvent{
+		Type:    AuditPlanChanged,
+		ActorID: actorID,
+		UserID:  userID,
+		Metadata: map[string]string{
+			"from":      from.Name,
+			"to":        target.Name,
+			"direction": dir,
+		},
+	})
+	u.publish(ctx, events.NewPlanChanged(userID, events.PlanChange{
+		From:      from.ID,
+		To:        target.ID,
+		Name:      target.Name,
+		Direction: dir,
+		ChangedBy: actorID,
+	}))
+}
+
+// publish doesn't fail the change that already happened, a lost event is
+// logged instead.
+func (u *UserManager) publish(ctx context.Context, event events.UserEvent) {
+	if u.events == nil {
+		return
+	}
+	if err := u.events.PublishUserEvent(ctx, event); err != nil {
+		fmt.Printf("failed to publish %s event for user %s: %v\n", event.Type, event.UserID, err)
+	}
+}
@@ -0,0 +1,117 @@
+package domain
+
+import (
+	"context"
+	"testing"
+
+	"github.com/eduardo-ax/video-streaming/pkg/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var (
+	freePlan     = &Plan{ID: 0, Name: "free", IsDefault: true, IsPublic: true}
+	standardPlan = &Plan{ID: 1, Name: "standard", PriceCents: 999, IsPublic: true}
+	legacyPlan   = &Plan{ID: 9, Name: "legacy", PriceCents: 499}
+)
+
+func TestSwitchPlan(t *testing.T) {
+	ctx := context.Background()
+
+	tests := map[string]struct {
+		current   int8
+		target    int8
+		sub       *Subscription
+		expectErr error
+	}{
+		"downgrade to the free plan": {
+			current: 1,
+			target:  0,
+			sub:     &Subscription{Status: SubscriptionCanceled},
+		},
+		"paid plan needs a subscription": {
+			current:   0,
+			target:    1,
+			expectErr: ErrPaymentRequired,
+		},
+		"leaving a plan still subscribed": {
+			current:   1,
+			target:    0,
+			sub:       &Subscription{Status: SubscriptionActive},
+			expectErr: ErrSubscriptionExists,
+		},
+		"same plan": {
+			current:   0,
+			target:    0,
+			expectErr: ErrSamePlan,
+		},
+		"plan only admins can set": {
+			current:   0,
+			target:    9,
+			expectErr: ErrPlanUnavailable,
+		},
+		"unknown plan": {
+			current:   0,
+			target:    42,
+			expectErr: ErrPlanNotFound,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			db := new(MockStorage)
+			audit := new(MockAuditLog)
+			pub := new(MockEventPublisher)
+			db.On("GetPlan", ctx, int8(0)).Return(freePlan, nil)
+			db.On("GetPlan", ctx, int8(1)).Return(standardPlan, nil)
+			db.On("GetPlan", ctx, int8(9)).Return(legacyPlan, nil)
+			db.On("GetPlan", ctx, int8(42)).Return(nil, ErrPlanNotFound)
+			db.On("GetUserByID", ctx, "user-1").Return(&UserAuthData{ID: "user-1", Plan: tc.current}, nil)
+			if tc.sub != nil {
+				db.On("GetSubscription", ctx, "user-1").Return(tc.sub, nil)
+			} else {
+				db.On("GetSubscription", ctx, "user-1").Return(nil, ErrSubscriptionNotFound)
+			}
+			db.On("ChangePlan", ctx, "user-1", tc.target).Return(nil)
+			audit.On("RecordAuditEvent", ctx, AuditPlanChanged, "").Return(nil)
+			pub.On("PublishUserEvent", ctx, events.TypePlanChanged, "user-1").Return(nil)
+
+			u := NewUserManager(db, new(MockToken), audit, new(MockMailer), Links{}, nil, nil, pub, nil)
+			plan, err := u.SwitchPlan(ctx, "user-1", tc.target)
+			if tc.expectErr != nil {
+				assert.ErrorIs(t, err, tc.expectErr)
+				db.AssertNotCalled(t, "ChangePlan", ctx, "user-1", mock.Anything)
+				pub.AssertNotCalled(t, "PublishUserEvent", ctx, events.TypePlanChanged, "user-1")
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.target, plan.ID)
+			db.AssertCalled(t, "ChangePlan", ctx, "user-1", tc.target)
+			audit.AssertCalled(t, "RecordAuditEvent", ctx, AuditPlanChanged, "")
+			pub.AssertCalled(t, "PublishUserEvent", ctx, events.TypePlanChanged, "user-1")
+		})
+	}
+}
+
+func TestChangePlan_Admin(t *testing.T) {
+	ctx := context.Background()
+	db := new(MockStorage)
+	pub := new(MockEventPublisher)
+	audit := new(MockAuditLog)
+	db.On("GetPlan", ctx, int8(0)).Return(freePlan, nil)
+	db.On("GetPlan", ctx, int8(9)).Return(legacyPlan, nil)
+	db.On("GetUserSummary", ctx, "user-1").Return(&UserSummary{ID: "user-1", Plan: 0}, nil)
+	db.On("ChangePlan", ctx, "user-1", int8(9)).Return(nil)
+	audit.On("RecordAuditEvent", ctx, AuditPlanChanged, "").Return(nil)
+	pub.On("PublishUserEvent", ctx, events.TypePlanChanged, "user-1").Return(nil)
+
+	u := NewUserManager(db, new(MockToken), audit, new(MockMailer), Links{}, nil, nil, pub, nil)
+	assert.NoError(t, u.ChangePlan(ctx, "admin-1", "user-1", 9))
+	pub.AssertCalled(t, "PublishUserEvent", ctx, events.TypePlanChanged, "user-1")
+}
+
+func TestDirection(t *testing.T) {
+	assert.Equal(t, events.DirectionUpgrade, direction(freePlan, standardPlan))
+	assert.Equal(t, events.DirectionDowngrade, direction(standardPlan, freePlan))
+	assert.Equal(t, events.DirectionDowngrade, direction(standardPlan, legacyPlan))
+}
@@ -0,0 +1,250 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/eduardo-ax/video-streaming/pkg/auth"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const maxProfileNameLength = 30
+
+var (
+	ErrProfileNotFound   = errors.New("profile not found")
+	ErrProfileLimit      = errors.New("the plan allows no more profiles")
+	ErrProfileNameTaken  = errors.New("a profile with this name already exists")
+	ErrProfileRestricted = errors.New("restricted profiles can't manage the account")
+	ErrProfileInUse      = errors.New("profile in use by this session")
+	ErrInvalidProfile    = errors.New("invalid profile")
+	ErrInvalidPIN        = errors.New("invalid PIN")
+
+	avatarPattern = regexp.MustCompile(`^[a-z0-9-]{0,32}$`)
+	pinPattern    = regexp.MustCompile(`^[0-9]{4,6}$`)
+)
+
+// Profile is a viewer of a shared account. MaxMaturity is the highest
+// auth.Maturity rating it may watch, PINHash is empty when switching to the
+// profile needs no PIN.
+type Profile struct {
+	ID          string
+	UserID      string
+	Name        string
+	Avatar      string
+	MaxMaturity int
+	PINHash     string
+	CreatedAt   time.Time
+}
+
+func (p *Profile) HasPIN() bool {
+	return p.PINHash != ""
+}
+
+// Restricted profiles can't watch every rating, nor manage profiles.
+func (p *Profile) Restricted() bool {
+	return p.MaxMaturity < auth.MaturityAdult
+}
+
+// ProfileInput holds the fields to set, the nil ones are left as they are.
+// An empty PIN removes it.
+type ProfileInput struct {
+	Name        *string
+	Avatar      *string
+	MaxMaturity *int
+	PIN         *string
+}
+
+// ProfileSwitch is the access token of the session once it uses Profile.
+type ProfileSwitch struct {
+	Profile              *Profile
+	AccessToken          string
+	AccessTokenExpiresAt time.Time
+}
+
+// apply validates input and sets it on profile.
+func (input ProfileInput) apply(profile *Profile) error {
+	if input.Name != nil {
+		name := strings.TrimSpace(*input.Name)
+		if name == "" || utf8.RuneCountInString(name) > maxProfileNameLength {
+			return fmt.Errorf("%w: name must be 1 to %d characters", ErrInvalidProfile, maxProfileNameLength)
+		}
+		if strings.IndexFunc(name, unicode.IsControl) >= 0 {
+			return fmt.Errorf("%w: name can't contain control characters", ErrInvalidProfile)
+		}
+		profile.Name = name
+	}
+	if input.Avatar != nil {
+		if !avatarPattern.MatchString(*input.Avatar) {
+			return fmt.Errorf("%w: avatar must be the name of a built-in avatar", ErrInvalidProfile)
+		}
+		profile.Avatar = *input.Avatar
+	}
+	if input.MaxMaturity != nil {
+		if !auth.ValidMaturity(*input.MaxMaturity) {
+			return fmt.Errorf("%w: max_maturity must be one of %v", ErrInvalidProfile, auth.MaturityRatings)
+		}
+		profile.MaxMaturity = *input.MaxMaturity
+	}
+	if input.PIN != nil {
+		if *input.PIN == "" {
+			profile.PINHash = ""
+			return nil
+		}
+		if !pinPattern.MatchString(*input.PIN) {
+			return fmt.Errorf("%w: PIN must be 4 to 6 digits", ErrInvalidProfile)
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(*input.PIN), bcrypt.DefaultCost)
+		if err != nil {
+			return fmt.Errorf("failed to hash PIN: %w", err)
+		}
+		profile.PINHash = string(hash)
+	}
+	return nil
+}
+
+func (u *UserManager) ListProfiles(ctx context.Context, userID string) ([]Profile, error) {
+	profiles, err := u.db.ListProfiles(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error listing profiles: %w", err)
+	}
+	return profiles, nil
+}
+
+// CreateProfile adds a profile, as many as the plan of the user allows.
+// Profiles are unrestricted unless input says otherwise.
+func (u *UserManager) CreateProfile(ctx context.Context, userID string, currentProfileID string, input ProfileInput) (*Profile, error) {
+	if err := u.RequireUnrestricted(ctx, userID, currentProfileID); err != nil {
+		return nil, err
+	}
+	if input.Name == nil {
+		return nil, fmt.Errorf("%w: name is required", ErrInvalidProfile)
+	}
+	profile := &Profile{UserID: userID, MaxMaturity: auth.MaturityAdult}
+	if err := input.apply(profile); err != nil {
+		return nil, err
+	}
+
+	user, err := u.db.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting user: %w", err)
+	}
+	plan, err := u.db.GetPlan(ctx, user.Plan)
+	if err != nil {
+		return nil, fmt.Errorf("error getting plan: %w", err)
+	}
+	if err := u.db.CreateProfile(ctx, profile, plan.MaxProfiles); err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
+
+// UpdateProfile changes a profile, a maturity limit applies to the sessions
+// using it from their next renewal.
+func (u *UserManager) UpdateProfile(ctx context.Context, userID string, currentProfileID string, id string, input ProfileInput) (*Profile, error) {
+	if err := u.RequireUnrestricted(ctx, userID, currentProfileID); err != nil {
+		return nil, err
+	}
+	profile, err := u.db.GetProfile(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := input.apply(profile); err != nil {
+		return nil, err
+	}
+	if err := u.db.UpdateProfile(ctx, profile); err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
+
+// DeleteProfile also revokes the sessions using the profile, they would
+// otherwise be renewed without its maturity limit.
+func (u *UserManager) DeleteProfile(ctx context.Context, userID string, currentProfileID string, id string) error {
+	if err := u.RequireUnrestricted(ctx, userID, currentProfileID); err != nil {
+		return err
+	}
+	if id == currentProfileID {
+		return ErrProfileInUse
+	}
+	return u.db.DeleteProfile(ctx, userID, id)
+}
+
+// SwitchProfile selects the profile for the session and returns an access
+// token carrying it. PIN guesses are throttled like passwords.
+func (u *UserManager) SwitchProfile(ctx context.Context, userID string, sessionID string, id string, pin string, client Client) (*ProfileSwitch, error) {
+	profile, err := u.db.GetProfile(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if profile.HasPIN() {
+		key := "profile:" + profile.ID
+		if err := u.guard.Check(ctx, key, client.IP); err != nil {
+			return nil, err
+		}
+		if bcrypt.CompareHashAndPassword([]byte(profile.PINHash), []byte(pin)) != nil {
+			if _, err := u.guard.Failed(ctx, key, client.IP); err != nil {
+				fmt.Printf("failed to record PIN failure: %v\n", err)
+			}
+			return nil, ErrInvalidPIN
+		}
+		if err := u.guard.Succeeded(ctx, key); err != nil {
+			fmt.Printf("failed to reset PIN failures: %v\n", err)
+		}
+	}
+
+	user, err := u.db.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting user: %w", err)
+	}
+	if err := accountStatusError(user.Status); err != nil {
+		return nil, err
+	}
+	if err := u.db.SetSessionProfile(ctx, userID, sessionID, profile.ID); err != nil {
+		return nil, err
+	}
+
+	accessToken, claims, err := u.token.CreateToken(profilePayload(user, profile), sessionID, auth.TokenTypeAccess, AccessTokenTTL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create token: %w", err)
+	}
+	return &ProfileSwitch{
+		Profile:              profile,
+		AccessToken:          accessToken,
+		AccessTokenExpiresAt: claims.RegisteredClaims.ExpiresAt.Time,
+	}, nil
+}
+
+// RequireUnrestricted refuses sessions on a restricted profile, so a kids
+// profile can't lift its own limit or manage the account. A deleted profile
+// counts as restricted.
+func (u *UserManager) RequireUnrestricted(ctx context.Context, userID string, currentProfileID string) error {
+	if currentProfileID == "" {
+		return nil
+	}
+	current, err := u.db.GetProfile(ctx, userID, currentProfileID)
+	if errors.Is(err, ErrProfileNotFound) {
+		return ErrProfileRestricted
+	}
+	if err != nil {
+		return fmt.Errorf("error getting current profile: %w", err)
+	}
+	if current.Restricted() {
+		return ErrProfileRestricted
+	}
+	return nil
+}
+
+func profilePayload(user *UserAuthData, profile *Profile) UserPayload {
+	payload := user.Payload()
+	if profile != nil {
+		payload.ProfileID = profile.ID
+		payload.MaxMaturity = profile.MaxMaturity
+	}
+	return payload
+}
@@ -0,0 +1,145 @@
+package domain
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/eduardo-ax/video-streaming/pkg/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func TestCreateProfile(t *testing.T) {
+	ctx := context.Background()
+	kids := &Profile{ID: "kids", UserID: "user-1", MaxMaturity: auth.Maturity7}
+	adult := &Profile{ID: "adult", UserID: "user-1", MaxMaturity: auth.MaturityAdult}
+
+	tests := map[string]struct {
+		current        string
+		input          ProfileInput
+		createErr      error
+		expectErr      error
+		expectMaturity int
+	}{
+		"unrestricted by default": {
+			input:          ProfileInput{Name: ptr(" Dad ")},
+			expectMaturity: auth.MaturityAdult,
+		},
+		"kids profile from an adult profile": {
+			current:        "adult",
+			input:          ProfileInput{Name: ptr("Kids"), MaxMaturity: intPtr(auth.Maturity7), PIN: ptr("")},
+			expectMaturity: auth.Maturity7,
+		},
+		"restricted profile can't add profiles": {
+			current:   "kids",
+			input:     ProfileInput{Name: ptr("Mine")},
+			expectErr: ErrProfileRestricted,
+		},
+		"deleted current profile": {
+			current:   "gone",
+			input:     ProfileInput{Name: ptr("Mine")},
+			expectErr: ErrProfileRestricted,
+		},
+		"name is required": {
+			input:     ProfileInput{},
+			expectErr: ErrInvalidProfile,
+		},
+		"unknown rating": {
+			input:     ProfileInput{Name: ptr("Teen"), MaxMaturity: intPtr(12)},
+			expectErr: ErrInvalidProfile,
+		},
+		"short PIN": {
+			input:     ProfileInput{Name: ptr("Teen"), PIN: ptr("12")},
+			expectErr: ErrInvalidProfile,
+		},
+		"plan limit reached": {
+			input:     ProfileInput{Name: ptr("Fifth")},
+			createErr: ErrProfileLimit,
+			expectErr: ErrProfileLimit,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			db := new(MockStorage)
+			db.On("GetProfile", ctx, "user-1", "kids").Return(kids, nil)
+			db.On("GetProfile", ctx, "user-1", "adult").Return(adult, nil)
+			db.On("GetProfile", ctx, "user-1", "gone").Return(nil, ErrProfileNotFound)
+			db.On("GetUserByID", ctx, "user-1").Return(&UserAuthData{ID: "user-1", Plan: 1}, nil)
+			db.On("GetPlan", ctx, int8(1)).Return(&Plan{ID: 1, MaxProfiles: 3}, nil)
+			db.On("CreateProfile", ctx, "user-1", 3).Return(tc.createErr)
+
+			u := NewUserManager(db, new(MockToken), new(MockAuditLog), new(MockMailer), Links{}, nil, nil, nil, nil)
+			profile, err := u.CreateProfile(ctx, "user-1", tc.current, tc.input)
+			if tc.expectErr != nil {
+				assert.ErrorIs(t, err, tc.expectErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectMaturity, profile.MaxMaturity)
+			assert.False(t, profile.HasPIN())
+			db.AssertCalled(t, "CreateProfile", ctx, "user-1", 3)
+		})
+	}
+}
+
+func TestSwitchProfile(t *testing.T) {
+	ctx := context.Background()
+	pinHash, err := bcrypt.GenerateFromPassword([]byte("4321"), bcrypt.MinCost)
+	assert.NoError(t, err)
+
+	tests := map[string]struct {
+		profile   *Profile
+		pin       string
+		expectErr error
+	}{
+		"profile without PIN": {
+			profile: &Profile{ID: "kids", UserID: "user-1", MaxMaturity: auth.Maturity7},
+		},
+		"right PIN": {
+			profile: &Profile{ID: "adult", UserID: "user-1", MaxMaturity: auth.MaturityAdult, PINHash: string(pinHash)},
+			pin:     "4321",
+		},
+		"wrong PIN": {
+			profile:   &Profile{ID: "adult", UserID: "user-1", MaxMaturity: auth.MaturityAdult, PINHash: string(pinHash)},
+			pin:       "1234",
+			expectErr: ErrInvalidPIN,
+		},
+		"missing PIN": {
+			profile:   &Profile{ID: "adult", UserID: "user-1", MaxMaturity: auth.MaturityAdult, PINHash: string(pinHash)},
+			expectErr: ErrInvalidPIN,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			db := new(MockStorage)
+			token := new(MockToken)
+			attempts := memoryAttempts{}
+			guard := NewLoginGuard(attempts, LockoutPolicy{Window: time.Minute, FreeAttempts: 3, AccountLimit: 5, IPLimit: 10, LockoutDuration: time.Minute})
+			db.On("GetProfile", ctx, "user-1", tc.profile.ID).Return(tc.profile, nil)
+			db.On("GetUserByID", ctx, "user-1").Return(&UserAuthData{ID: "user-1"}, nil)
+			db.On("SetSessionProfile", ctx, "user-1", "session-1", tc.profile.ID).Return(nil)
+			token.On("CreateToken", "user-1", "session-1", AccessTokenTTL).Return("access", nil)
+
+			u := NewUserManager(db, token, new(MockAuditLog), new(MockMailer), Links{}, nil, guard, nil, nil)
+			switched, err := u.SwitchProfile(ctx, "user-1", "session-1", tc.profile.ID, tc.pin, Client{IP: "203.0.113.7"})
+			if tc.expectErr != nil {
+				assert.ErrorIs(t, err, tc.expectErr)
+				db.AssertNotCalled(t, "SetSessionProfile", ctx, mock.Anything, mock.Anything, mock.Anything)
+				assert.Len(t, attempts["account:profile:adult"], 1)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "access", switched.AccessToken)
+			assert.Equal(t, tc.profile.ID, token.Payloads[0].ProfileID)
+			assert.Equal(t, tc.profile.MaxMaturity, token.Payloads[0].MaxMaturity)
+		})
+	}
+}
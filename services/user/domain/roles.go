@@ -0,0 +1,42 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+)
+
+func (u *UserManager) ListRoles(ctx context.Context) ([]Role, error) {
+	roles, err := u.db.ListRoles(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error listing roles: %w", err)
+	}
+	return roles, nil
+}
+
+// GrantRole gives role to the user. Tokens pick the change up at their next
+// renewal, within the lifetime of an access token.
+func (u *UserManager) GrantRole(ctx context.Context, actorID string, userID string, role string) error {
+	if err := u.db.GrantRole(ctx, userID, role); err != nil {
+		return err
+	}
+	u.recordAudit(ctx, AuditEvent{
+		Type:     AuditRoleGranted,
+		ActorID:  actorID,
+		UserID:   userID,
+		Metadata: map[string]string{"role": role},
+	})
+	return nil
+}
+
+func (u *UserManager) RevokeRole(ctx context.Context, actorID string, userID string, role string) error {
+	if err := u.db.RevokeRole(ctx, userID, role); err != nil {
+		return err
+	}
+	u.recordAudit(ctx, AuditEvent{
+		Type:     AuditRoleRevoked,
+		ActorID:  actorID,
+		UserID:   userID,
+		Metadata: map[string]string{"role": role},
+	})
+	return nil
+}
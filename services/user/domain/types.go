@@ -6,42 +6,108 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// User is the account as its owner sees it.
 type User struct {
-	ID       string
-	Name     string
-	Email    string
-	Password string
-	Plan     string
+	ID            string
+	Name          string
+	Email         string
+	EmailVerified bool
+	Plan          int8
+	MFAEnabled    bool
+	Roles         []string
+	DisplayName   string
+	AvatarKey     string
+	Bio           string
+	Locale        string
+	Timezone      string
+	CreatedAt     time.Time
+}
+
+// UserUpdate holds the fields to change, the nil ones are left as they are
+// and an empty string clears a profile field.
+type UserUpdate struct {
+	Name        string
+	Email       *string
+	Password    *string
+	DisplayName *string
+	Bio         *string
+	Locale      *string
+	Timezone    *string
+}
+
+func (u UserUpdate) Empty() bool {
+	return u.Name == "" && u.Email == nil && u.Password == nil &&
+		u.DisplayName == nil && u.Bio == nil && u.Locale == nil && u.Timezone == nil
 }
 
 type UserPayload struct {
-	ID    string
-	Email string
-	Plan  int8
+	ID            string
+	Email         string
+	Plan          int8
+	EmailVerified bool
+	Roles         []string
+	Permissions   []string
+	MaxStreams    int
+	ProfileID     string
+	MaxMaturity   int
 }
 
 type UserAuthData struct {
-	ID       string
-	Password string
-	Plan     int8
+	ID            string
+	Email         string
+	Password      string
+	Plan          int8
+	EmailVerified bool
+	MFAEnabled    bool
+	Status        string
+	Roles         []string
+	Permissions   []string
+	MaxStreams    int
+}
+
+func (u *UserAuthData) Payload() UserPayload {
+	return UserPayload{
+		ID:            u.ID,
+		Email:         u.Email,
+		Plan:          u.Plan,
+		EmailVerified: u.EmailVerified,
+		Roles:         u.Roles,
+		Permissions:   u.Permissions,
+		MaxStreams:    u.MaxStreams,
+	}
 }
 
+// LoginUserRes holds either the session tokens or, when the account has
+// two-factor authentication, only MFAChallenge.
 type LoginUserRes struct {
-	SessionID             string      `json:"session_id"`
-	AccessToken           string      `json:"access_token"`
-	RefreshToken          string      `json:"refresh_token"`
-	AccessTokenExpiresAt  time.Time   `json:"acess_token_expires_at"`
-	RefreshTokenExpiresAt time.Time   `json:"refresh_token_expires_at"`
-	User                  UserPayload `json:"user"`
+	SessionID             string        `json:"session_id"`
+	AccessToken           string        `json:"access_token"`
+	RefreshToken          string        `json:"refresh_token"`
+	AccessTokenExpiresAt  time.Time     `json:"acess_token_expires_at"`
+	RefreshTokenExpiresAt time.Time     `json:"refresh_token_expires_at"`
+	User                  UserPayload   `json:"user"`
+	MFAChallenge          *MFAChallenge `json:"-"`
 }
 
+// Session keeps only a SHA-256 hash of its current refresh token, see
+// HashToken.
 type Session struct {
-	ID           string
-	Email        string
-	RefreshToken string
-	IsRevoked    bool
-	CreatedAt    time.Time
-	ExpiresAt    time.Time
+	ID               string
+	UserID           string
+	ProfileID        string
+	RefreshTokenHash string
+	UserAgent        string
+	IP               string
+	IsRevoked        bool
+	CreatedAt        time.Time
+	ExpiresAt        time.Time
+	LastUsedAt       time.Time
+}
+
+// Client describes where a request comes from.
+type Client struct {
+	UserAgent string
+	IP        string
 }
 
 type RenewAcessTokenReq struct {
@@ -49,18 +115,45 @@ type RenewAcessTokenReq struct {
 }
 
 type RenewAccessTokenRes struct {
-	AccessToken         string    `json:"access_token"`
-	AcessTokenExpiresAt time.Time `json:"acess_token_expires_at"`
+	AccessToken           string    `json:"access_token"`
+	AcessTokenExpiresAt   time.Time `json:"acess_token_expires_at"`
+	RefreshToken          string    `json:"refresh_token"`
+	RefreshTokenExpiresAt time.Time `json:"refresh_token_expires_at"`
 }
 
+// UserClaims identifies the session family in SessionID, every token
+// issued for the session gets its own RegisteredClaims.ID.
 type UserClaims struct {
-	ID    string `json:"id`
-	Email string `json:"email"`
-	Plan  int8   `json:"plan"`
+	ID            string   `json:"id"`
+	Email         string   `json:"email"`
+	Plan          int8     `json:"plan"`
+	EmailVerified bool     `json:"email_verified"`
+	Roles         []string `json:"roles"`
+	Permissions   []string `json:"perms"`
+	SessionID     string   `json:"sid"`
+	Type          string   `json:"typ"`
+	MaxStreams    int      `json:"max_streams,omitempty"`
+	ProfileID     string   `json:"pid,omitempty"`
+	MaxMaturity   int      `json:"max_maturity,omitempty"`
 	jwt.RegisteredClaims
 }
 
+// Role groups permissions, see the constants of pkg/auth.
+type Role struct {
+	Name        string
+	Description string
+	IsDefault   bool
+	Permissions []string
+}
+
 type UserManager struct {
-	db    Storage
-	token TokenInterface
+	db      Storage
+	token   TokenInterface
+	audit   AuditLog
+	mailer  Mailer
+	links   Links
+	box     SecretBox
+	guard   *LoginGuard
+	events  EventPublisher
+	avatars AvatarStore
 }
@@ -2,44 +2,177 @@ package domain
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"net/mail"
 	"time"
 
+	"github.com/eduardo-ax/video-streaming/pkg/auth"
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// AccessTokenTTL also bounds how long the access tokens of a revoked
+// session stay valid, the revocation list only needs to cover that window.
+const (
+	AccessTokenTTL  = 15 * time.Minute
+	RefreshTokenTTL = 24 * time.Hour
+)
+
+var (
+	ErrSessionRevoked      = errors.New("session revoked")
+	ErrRefreshTokenReused  = errors.New("refresh token reused")
+	ErrSessionNotFound     = errors.New("session not found")
+	ErrRoleNotFound        = errors.New("role not found")
+	ErrUserNotFound        = errors.New("user not found")
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+
+	errInvalidSession = errors.New("invalid session")
+)
+
 type Storage interface {
-	Persist(ctx context.Context, name string, email string, password string, plan int8) (string, error)
-	DeleteUser(ctx context.Context, id string) error
-	UpdateUser(ctx context.Context, id string, name string, email *string, password *string) error
+	Persist(ctx context.Context, name string, email string, password string) (string, error)
+	UpdateUser(ctx context.Context, id string, update UserUpdate) error
+	GetUserProfile(ctx context.Context, id string) (*User, error)
+	SetAvatar(ctx context.Context, id string, key string) (string, error)
 	GetUser(ctx context.Context, email string) (*UserAuthData, error)
+	GetUserByID(ctx context.Context, id string) (*UserAuthData, error)
+	CreateVerificationToken(ctx context.Context, userID string, email string, tokenHash string, expiresAt time.Time) error
+	VerificationTokenIssuedAt(ctx context.Context, userID string) (time.Time, error)
+	VerifyEmail(ctx context.Context, tokenHash string) (string, error)
+	CreatePasswordResetToken(ctx context.Context, userID string, tokenHash string, expiresAt time.Time) error
+	ResetPassword(ctx context.Context, tokenHash string, passwordHash string) (string, error)
+	GetMFA(ctx context.Context, userID string) (*MFA, error)
+	SaveMFASecret(ctx context.Context, userID string, sealedSecret []byte) error
+	EnableMFA(ctx context.Context, userID string, step int64, recoveryCodeHashes []string) error
+	UseTOTPStep(ctx context.Context, userID string, step int64) error
+	UseRecoveryCode(ctx context.Context, userID string, codeHash string) error
+	CreateMFAChallenge(ctx context.Context, userID string, tokenHash string, expiresAt time.Time) error
+	AttemptMFAChallenge(ctx context.Context, tokenHash string, maxAttempts int) (string, error)
+	DeleteMFAChallenge(ctx context.Context, tokenHash string) error
+	SearchUsers(ctx context.Context, filter UserFilter) ([]UserSummary, int, error)
+	GetUserSummary(ctx context.Context, id string) (*UserSummary, error)
+	SuspendUser(ctx context.Context, id string, reason string) error
+	UnsuspendUser(ctx context.Context, id string) error
+	ListPlans(ctx context.Context) ([]Plan, error)
+	GetPlan(ctx context.Context, id int8) (*Plan, error)
+	GetDefaultPlan(ctx context.Context) (*Plan, error)
+	ChangePlan(ctx context.Context, id string, plan int8) error
+	RecentRevocations(ctx context.Context, since time.Time) (*auth.Revocations, error)
+	GetSubscription(ctx context.Context, userID string) (*Subscription, error)
+	GetSubscriptionByProviderID(ctx context.Context, providerID string) (*Subscription, error)
+	CreateSubscription(ctx context.Context, sub *Subscription) error
+	UpdateSubscription(ctx context.Context, sub *Subscription) error
+	ListExpiredSubscriptions(ctx context.Context, now time.Time) ([]Subscription, error)
+	ApplyPaymentEvent(ctx context.Context, event *PaymentEvent, sub *Subscription, grant *PlanGrant) (*int8, error)
+	ListRoles(ctx context.Context) ([]Role, error)
+	GrantRole(ctx context.Context, userID string, role string) error
+	RevokeRole(ctx context.Context, userID string, role string) error
 	CreateSession(ctx context.Context, session *Session) (*Session, error)
 	GetSession(ctx context.Context, id string) (*Session, error)
-	DeleteSession(ctx context.Context, id string) error
 	RevokeSession(ctx context.Context, id string) error
+	RotateSession(ctx context.Context, id string, oldTokenHash string, newTokenHash string, expiresAt time.Time, client Client) error
+	ListSessions(ctx context.Context, userID string) ([]Session, error)
+	RevokeOwnedSession(ctx context.Context, userID string, id string) error
+	RevokeUserSessions(ctx context.Context, userID string, exceptID string) (int64, error)
+	SetSessionProfile(ctx context.Context, userID string, sessionID string, profileID string) error
+	ListProfiles(ctx context.Context, userID string) ([]Profile, error)
+	GetProfile(ctx context.Context, userID string, id string) (*Profile, error)
+	CreateProfile(ctx context.Context, profile *Profile, limit int) error
+	UpdateProfile(ctx context.Context, profile *Profile) error
+	DeleteProfile(ctx context.Context, userID string, id string) error
+	ScheduleDeletion(ctx context.Context, id string, undoHash string, scheduledFor time.Time) error
+	CancelDeletion(ctx context.Context, undoHash string) (string, error)
+	ListDueDeletions(ctx context.Context, now time.Time) ([]string, error)
+	// EraseUser deletes the user and records the deletion in the same
+	// transaction.
+	EraseUser(ctx context.Context, id string) (*AccountDeletion, error)
+	MarkDeletionPublished(ctx context.Context, id string) error
+	ListUnconfirmedDeletions(ctx context.Context, publishedBefore time.Time) ([]AccountDeletion, error)
+	// ConfirmDeletion returns every service that confirmed the deletion so
+	// far.
+	ConfirmDeletion(ctx context.Context, id string, userID string, service string) ([]string, error)
+	// CompleteDeletion returns the email of the erased account, it fails with
+	// ErrDeletionNotFound when the deletion was already completed.
+	CompleteDeletion(ctx context.Context, id string) (string, error)
+	// ListAllSessions lists the revoked and expired sessions too.
+	ListAllSessions(ctx context.Context, userID string) ([]Session, error)
+	CreateExport(ctx context.Context, userID string) (*DataExport, error)
+	ListExports(ctx context.Context, userID string) ([]DataExport, error)
+	// ClaimExport starts the oldest pending export, or one still running
+	// since before staleBefore, and fails with ErrExportNotFound when none is
+	// left.
+	ClaimExport(ctx context.Context, staleBefore time.Time) (*DataExport, error)
+	CompleteExport(ctx context.Context, id string, objectKey string, tokenHash string, expiresAt time.Time) error
+	FailExport(ctx context.Context, id string, retry bool) error
+	GetExportByToken(ctx context.Context, tokenHash string) (*DataExport, error)
+	ListExpiredExports(ctx context.Context, now time.Time) ([]DataExport, error)
+	ExpireExport(ctx context.Context, id string) error
 }
 
 type TokenInterface interface {
-	CreateToken(id string, email string, plan int8, sessionID string, duration time.Duration) (string, *UserClaims, error)
+	CreateToken(user UserPayload, sessionID string, tokenType string, duration time.Duration) (string, *UserClaims, error)
 	VerifyToken(tokenStr string) (*UserClaims, error)
 }
 
 type UserInterface interface {
-	CreateUser(ctx context.Context, name string, email string, plan int8, pass string) error
-	DeleteUser(ctx context.Context, id string) error
-	UpdateUser(ctx context.Context, id string, name string, email *string, password *string) error
-	UserLogin(ctx context.Context, email string, password string) (*LoginUserRes, error)
-	UserLogout(ctx context.Context, id string) error
-	RenewAccessToken(ctx context.Context, refreshToken string) (*RenewAccessTokenRes, error)
-	RevokeSession(ctx context.Context, id string) error
+	CreateUser(ctx context.Context, name string, email string, pass string) error
+	UpdateUser(ctx context.Context, id string, update UserUpdate) error
+	CurrentUser(ctx context.Context, id string) (*User, error)
+	SetAvatar(ctx context.Context, userID string, image []byte) (*User, error)
+	RemoveAvatar(ctx context.Context, userID string) error
+	Avatar(ctx context.Context, userID string, file string) (io.ReadCloser, string, error)
+	UserLogin(ctx context.Context, email string, password string, client Client) (*LoginUserRes, error)
+	UserLogout(ctx context.Context, userID string, id string) error
+	RenewAccessToken(ctx context.Context, refreshToken string, client Client) (*RenewAccessTokenRes, error)
+	ListSessions(ctx context.Context, userID string) ([]Session, error)
+	RevokeSession(ctx context.Context, userID string, id string) error
+	RevokeOtherSessions(ctx context.Context, userID string, currentID string) (int64, error)
+	VerifyEmail(ctx context.Context, token string) error
+	ResendVerification(ctx context.Context, userID string) error
+	ThrottlePasswordReset(ctx context.Context, email string, client Client) error
+	ForgotPassword(ctx context.Context, email string) error
+	ResetPassword(ctx context.Context, token string, password string) error
+	EnrollMFA(ctx context.Context, userID string) (*MFAEnrollment, error)
+	ConfirmMFA(ctx context.Context, userID string, code string) ([]string, error)
+	CompleteMFALogin(ctx context.Context, challenge string, code string, client Client) (*LoginUserRes, error)
+	ListRoles(ctx context.Context) ([]Role, error)
+	GrantRole(ctx context.Context, actorID string, userID string, role string) error
+	RevokeRole(ctx context.Context, actorID string, userID string, role string) error
+	ListPlans(ctx context.Context) ([]Plan, error)
+	SwitchPlan(ctx context.Context, userID string, planID int8) (*Plan, error)
+	SearchUsers(ctx context.Context, filter UserFilter) (*UserPage, error)
+	GetUserDetails(ctx context.Context, id string) (*UserDetails, error)
+	SuspendUser(ctx context.Context, actorID string, id string, reason string) error
+	UnsuspendUser(ctx context.Context, actorID string, id string) error
+	ForceLogout(ctx context.Context, actorID string, id string) (int64, error)
+	ChangePlan(ctx context.Context, actorID string, id string, plan int8) error
+	Revocations(ctx context.Context) (*auth.Revocations, error)
+	SearchAuditEvents(ctx context.Context, filter AuditFilter) (*AuditPage, error)
+	SecurityActivity(ctx context.Context, userID string) ([]AuditEvent, error)
+	ListProfiles(ctx context.Context, userID string) ([]Profile, error)
+	RequireUnrestricted(ctx context.Context, userID string, currentProfileID string) error
+	CreateProfile(ctx context.Context, userID string, currentProfileID string, input ProfileInput) (*Profile, error)
+	UpdateProfile(ctx context.Context, userID string, currentProfileID string, id string, input ProfileInput) (*Profile, error)
+	DeleteProfile(ctx context.Context, userID string, currentProfileID string, id string) error
+	SwitchProfile(ctx context.Context, userID string, sessionID string, id string, pin string, client Client) (*ProfileSwitch, error)
 }
 
-func NewUserManager(db Storage, token TokenInterface) *UserManager {
+func NewUserManager(db Storage, token TokenInterface, audit AuditLog, mailer Mailer, links Links, box SecretBox, guard *LoginGuard, events EventPublisher, avatars AvatarStore) *UserManager {
 	return &UserManager{
-		db:    db,
-		token: token,
+		db:      db,
+		token:   token,
+		audit:   audit,
+		mailer:  mailer,
+		links:   links,
+		box:     box,
+		guard:   guard,
+		events:  events,
+		avatars: avatars,
 	}
 }
 
@@ -71,147 +204,334 @@ func ValidateUpdateUserFields(name string, email *string, password *string) erro
 	return nil
 }
 
-func (u *UserManager) CreateUser(ctx context.Context, name string, email string, plan int8, password string) error {
+// CreateUser signs the user up on the default plan.
+func (u *UserManager) CreateUser(ctx context.Context, name string, email string, password string) error {
+	if err := ValidateUpdateUserFields(name, &email, &password); err != nil {
+		return fmt.Errorf("invalid user: %w", err)
+	}
 	cryptPassword, err := HashPassword(password)
 	if err != nil {
 		return err
 	}
-	_, err = u.db.Persist(ctx, name, email, cryptPassword, plan)
+	id, err := u.db.Persist(ctx, name, email, cryptPassword)
 	if err != nil {
 		return err
 	}
-	return nil
-}
-
-func (u *UserManager) DeleteUser(ctx context.Context, id string) error {
-	err := u.db.DeleteUser(ctx, id)
-	if err != nil {
-		return fmt.Errorf("delete user error %w", err)
+	u.recordAudit(ctx, AuditEvent{Type: AuditSignup, ActorID: id, UserID: id})
+	if err := u.sendVerification(ctx, id, email); err != nil {
+		fmt.Printf("failed to send verification email to user %s: %v\n", id, err)
 	}
 	return nil
 }
 
-func (u *UserManager) UpdateUser(ctx context.Context, id string, name string, email *string, password *string) error {
-	err := ValidateUpdateUserFields(name, email, password)
-	if err != nil {
-		return fmt.Errorf("invalid credentials: %w", err)
+// UpdateUser changes the fields set in update. A new email must be verified
+// again.
+func (u *UserManager) UpdateUser(ctx context.Context, id string, update UserUpdate) error {
+	if err := ValidateUpdateUserFields(update.Name, update.Email, update.Password); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidUserUpdate, err)
+	}
+	if err := normalizeProfile(&update); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidUserUpdate, err)
 	}
 
-	var newPasswordHash *string
-	if password != nil {
-		rawPassword := *password
-		hash, err := HashPassword(rawPassword)
+	if update.Password != nil {
+		hash, err := HashPassword(*update.Password)
 		if err != nil {
 			return fmt.Errorf("failed to hash password: %w", err)
 		}
-		newPasswordHash = &hash
+		update.Password = &hash
 	}
 
-	err = u.db.UpdateUser(ctx, id, name, email, newPasswordHash)
+	err := u.db.UpdateUser(ctx, id, update)
 	if err != nil {
 		return fmt.Errorf("update user error %w", err)
 	}
+	if update.Password != nil {
+		u.recordAudit(ctx, AuditEvent{Type: AuditPasswordChanged, ActorID: id, UserID: id})
+	}
+	if update.Email != nil {
+		u.recordAudit(ctx, AuditEvent{
+			Type:     AuditEmailChanged,
+			ActorID:  id,
+			UserID:   id,
+			Metadata: map[string]string{"email": *update.Email},
+		})
+		if err := u.sendVerification(ctx, id, *update.Email); err != nil {
+			fmt.Printf("failed to send verification email to user %s: %v\n", id, err)
+		}
+	}
 	return nil
 }
 
-func (u *UserManager) UserLogin(ctx context.Context, email string, password string) (*LoginUserRes, error) {
+// UserLogin refuses attempts the LoginGuard throttles with a
+// *TooManyAttemptsError, before spending a bcrypt comparison on them.
+func (u *UserManager) UserLogin(ctx context.Context, email string, password string, client Client) (*LoginUserRes, error) {
+	if err := u.guard.Check(ctx, email, client.IP); err != nil {
+		return nil, err
+	}
 	user, err := u.db.GetUser(ctx, email)
-	sessionID := uuid.New().String()
 	if err != nil {
+		u.loginFailed(ctx, email, nil, client)
 		return nil, err
 	}
 	if !CheckPassword(password, user.Password) {
+		u.loginFailed(ctx, email, user, client)
 		return nil, fmt.Errorf("password incorrect")
 	}
+	if err := u.guard.Succeeded(ctx, email); err != nil {
+		fmt.Printf("failed to reset login failures: %v\n", err)
+	}
+	if err := accountStatusError(user.Status); err != nil {
+		return nil, err
+	}
+
+	if user.MFAEnabled {
+		challenge, err := u.createMFAChallenge(ctx, user.ID)
+		if err != nil {
+			return nil, err
+		}
+		return &LoginUserRes{MFAChallenge: challenge}, nil
+	}
+	return u.startSession(ctx, user, client)
+}
 
-	acessToken, accessClaims, err := u.token.CreateToken(user.ID, email, user.Plan, sessionID, 15*time.Minute)
+// loginFailed counts a failure, unknown emails included so they lock the
+// same way, and audits it with the lockout of existing accounts.
+func (u *UserManager) loginFailed(ctx context.Context, email string, user *UserAuthData, client Client) {
+	event := AuditEvent{
+		Type:      AuditLoginFailed,
+		IP:        client.IP,
+		UserAgent: client.UserAgent,
+		Metadata:  map[string]string{"email": email},
+	}
+	if user != nil {
+		event.UserID = user.ID
+	}
+	u.recordAudit(ctx, event)
+
+	locked, err := u.guard.Failed(ctx, email, client.IP)
+	if err != nil {
+		fmt.Printf("failed to record login failure: %v\n", err)
+		return
+	}
+	if !locked || user == nil {
+		return
+	}
+	u.recordAudit(ctx, AuditEvent{
+		Type:      AuditAccountLocked,
+		UserID:    user.ID,
+		IP:        client.IP,
+		UserAgent: client.UserAgent,
+		Metadata:  map[string]string{"locked_until": u.guard.LockedUntil().UTC().Format(time.RFC3339)},
+	})
+}
+
+func (u *UserManager) startSession(ctx context.Context, user *UserAuthData, client Client) (*LoginUserRes, error) {
+	if err := accountStatusError(user.Status); err != nil {
+		return nil, err
+	}
+	sessionID := uuid.New().String()
+	acessToken, accessClaims, err := u.token.CreateToken(user.Payload(), sessionID, auth.TokenTypeAccess, AccessTokenTTL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create token: %w", err)
 	}
 
-	refreshToken, refreshClaim, err := u.token.CreateToken(user.ID, email, user.Plan, sessionID, 24*time.Hour)
+	refreshToken, refreshClaim, err := u.token.CreateToken(user.Payload(), sessionID, auth.TokenTypeRefresh, RefreshTokenTTL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create refresh token: %w", err)
 	}
 
 	session, err := u.db.CreateSession(ctx, &Session{
-		ID:           refreshClaim.RegisteredClaims.ID,
-		Email:        email,
-		RefreshToken: refreshToken,
-		IsRevoked:    false,
-		ExpiresAt:    refreshClaim.RegisteredClaims.ExpiresAt.Time,
+		ID:               sessionID,
+		UserID:           user.ID,
+		RefreshTokenHash: HashToken(refreshToken),
+		UserAgent:        client.UserAgent,
+		IP:               client.IP,
+		IsRevoked:        false,
+		ExpiresAt:        refreshClaim.RegisteredClaims.ExpiresAt.Time,
 	})
 
 	if err != nil {
 		return nil, fmt.Errorf("error creating session: %w", err)
 	}
+	u.recordAudit(ctx, AuditEvent{
+		Type:      AuditLoginSucceeded,
+		ActorID:   user.ID,
+		UserID:    user.ID,
+		SessionID: session.ID,
+		IP:        client.IP,
+		UserAgent: client.UserAgent,
+	})
 	return &LoginUserRes{
 		SessionID:             session.ID,
 		AccessToken:           acessToken,
 		RefreshToken:          refreshToken,
 		AccessTokenExpiresAt:  accessClaims.RegisteredClaims.ExpiresAt.Time,
 		RefreshTokenExpiresAt: refreshClaim.RegisteredClaims.ExpiresAt.Time,
-		User: UserPayload{
-			ID:    user.ID,
-			Email: email,
-			Plan:  user.Plan,
-		},
+		User:                  user.Payload(),
 	}, nil
 }
 
-func (u *UserManager) UserLogout(ctx context.Context, id string) error {
-	err := u.db.DeleteSession(ctx, id)
+// UserLogout revokes the session rather than deleting it, so its access
+// tokens reach the revocation list.
+func (u *UserManager) UserLogout(ctx context.Context, userID string, id string) error {
+	err := u.db.RevokeOwnedSession(ctx, userID, id)
 	if err != nil {
 		return fmt.Errorf("logout error %w", err)
 	}
+	u.recordAudit(ctx, AuditEvent{Type: AuditLogout, ActorID: userID, UserID: userID, SessionID: id})
 	return nil
 }
 
-func (u *UserManager) RenewAccessToken(ctx context.Context, refreshToken string) (*RenewAccessTokenRes, error) {
+// RenewAccessToken rotates the refresh token: the presented token is
+// replaced by a new one and can't be used again. Presenting a token that was
+// already rotated means it leaked, so the whole session is revoked.
+func (u *UserManager) RenewAccessToken(ctx context.Context, refreshToken string, client Client) (*RenewAccessTokenRes, error) {
 
 	refreshClaims, err := u.token.VerifyToken(refreshToken)
 	if err != nil {
-		return nil, fmt.Errorf("error verifying token: %w", err)
+		return nil, fmt.Errorf("%w: %w", ErrInvalidRefreshToken, err)
+	}
+	if refreshClaims.Type != auth.TokenTypeRefresh {
+		return nil, ErrInvalidRefreshToken
 	}
 
-	session, err := u.db.GetSession(ctx, refreshClaims.RegisteredClaims.ID)
+	session, err := u.db.GetSession(ctx, refreshClaims.SessionID)
+	if errors.Is(err, ErrSessionNotFound) {
+		return nil, ErrInvalidRefreshToken
+	}
 	if err != nil {
 		return nil, fmt.Errorf("error getting session: %w", err)
 	}
 
 	if session.IsRevoked {
-		return nil, fmt.Errorf("session revoked: %w", err)
+		return nil, ErrSessionRevoked
+	}
+
+	if session.UserID != refreshClaims.ID {
+		return nil, errInvalidSession
 	}
 
-	if session.Email != refreshClaims.Email {
-		return nil, fmt.Errorf("invalid session")
+	tokenHash := HashToken(refreshToken)
+	if subtle.ConstantTimeCompare([]byte(session.RefreshTokenHash), []byte(tokenHash)) != 1 {
+		return nil, u.revokeReusedSession(ctx, session, refreshClaims, client)
 	}
-	sessionID := refreshClaims.RegisteredClaims.ID
-	acessToken, accessClaims, err := u.token.CreateToken(refreshClaims.ID, refreshClaims.Email, refreshClaims.Plan, sessionID, 15*time.Minute)
 
+	// Claims are rebuilt from the current user and profile so a verified
+	// email, a new plan or a new maturity limit shows up at the next renewal.
+	user, err := u.db.GetUserByID(ctx, session.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting user: %w", err)
+	}
+	if err := accountStatusError(user.Status); err != nil {
+		return nil, err
+	}
+	var profile *Profile
+	if session.ProfileID != "" {
+		profile, err = u.db.GetProfile(ctx, user.ID, session.ProfileID)
+		if err != nil {
+			return nil, fmt.Errorf("error getting profile: %w", err)
+		}
+	}
+	payload := profilePayload(user, profile)
+
+	sessionID := session.ID
+	acessToken, accessClaims, err := u.token.CreateToken(payload, sessionID, auth.TokenTypeAccess, AccessTokenTTL)
 	if err != nil {
 		return nil, fmt.Errorf("error creating token: %w", err)
 	}
 
+	newRefreshToken, newRefreshClaims, err := u.token.CreateToken(payload, sessionID, auth.TokenTypeRefresh, RefreshTokenTTL)
+	if err != nil {
+		return nil, fmt.Errorf("error creating refresh token: %w", err)
+	}
+
+	err = u.db.RotateSession(ctx, sessionID, tokenHash, HashToken(newRefreshToken), newRefreshClaims.RegisteredClaims.ExpiresAt.Time, client)
+	if errors.Is(err, ErrRefreshTokenReused) {
+		return nil, u.revokeReusedSession(ctx, session, refreshClaims, client)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error rotating session: %w", err)
+	}
+	u.recordAudit(ctx, AuditEvent{
+		Type:      AuditTokenRenewed,
+		ActorID:   user.ID,
+		UserID:    user.ID,
+		SessionID: sessionID,
+		IP:        client.IP,
+		UserAgent: client.UserAgent,
+	})
+
 	return &RenewAccessTokenRes{
-		AccessToken:         acessToken,
-		AcessTokenExpiresAt: accessClaims.RegisteredClaims.ExpiresAt.Time,
+		AccessToken:           acessToken,
+		AcessTokenExpiresAt:   accessClaims.RegisteredClaims.ExpiresAt.Time,
+		RefreshToken:          newRefreshToken,
+		RefreshTokenExpiresAt: newRefreshClaims.RegisteredClaims.ExpiresAt.Time,
 	}, nil
 }
 
-func (u *UserManager) RevokeSession(ctx context.Context, id string) error {
-	err := u.db.RevokeSession(ctx, id)
+func (u *UserManager) revokeReusedSession(ctx context.Context, session *Session, claims *UserClaims, client Client) error {
+	if err := u.db.RevokeSession(ctx, session.ID); err != nil {
+		return fmt.Errorf("error revoking reused session: %w", err)
+	}
+	u.recordAudit(ctx, AuditEvent{
+		Type:      AuditRefreshTokenReused,
+		UserID:    claims.ID,
+		SessionID: session.ID,
+		IP:        client.IP,
+		UserAgent: client.UserAgent,
+		Metadata:  map[string]string{"token_id": claims.RegisteredClaims.ID},
+	})
+	return ErrRefreshTokenReused
+}
+
+// ListSessions returns the sessions of the user that can still be renewed.
+func (u *UserManager) ListSessions(ctx context.Context, userID string) ([]Session, error) {
+	sessions, err := u.db.ListSessions(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error listing sessions: %w", err)
+	}
+	return sessions, nil
+}
+
+// RevokeSession revokes a session of the user, sessions of other users are
+// reported as not found.
+func (u *UserManager) RevokeSession(ctx context.Context, userID string, id string) error {
+	err := u.db.RevokeOwnedSession(ctx, userID, id)
 	if err != nil {
 		return fmt.Errorf("error revoking session %w", err)
 	}
+	u.recordAudit(ctx, AuditEvent{Type: AuditSessionRevoked, ActorID: userID, UserID: userID, SessionID: id})
 	return nil
 }
 
+func (u *UserManager) RevokeOtherSessions(ctx context.Context, userID string, currentID string) (int64, error) {
+	revoked, err := u.db.RevokeUserSessions(ctx, userID, currentID)
+	if err != nil {
+		return 0, fmt.Errorf("error revoking sessions %w", err)
+	}
+	u.recordAudit(ctx, AuditEvent{
+		Type:      AuditOtherSessionsRevoked,
+		ActorID:   userID,
+		UserID:    userID,
+		SessionID: currentID,
+		Metadata:  map[string]string{"revoked_sessions": fmt.Sprint(revoked)},
+	})
+	return revoked, nil
+}
+
 func HashPassword(pass string) (string, error) {
 	bytes, err := bcrypt.GenerateFromPassword([]byte(pass), bcrypt.DefaultCost)
 	return string(bytes), err
 }
 
+// HashToken is what the database stores instead of refresh tokens and other
+// bearer secrets, a leaked table doesn't hand out usable tokens.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
 func CheckPassword(pass, hash string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass))
 	return err == nil
@@ -0,0 +1,837 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/eduardo-ax/video-streaming/pkg/auth"
+	"github.com/eduardo-ax/video-streaming/pkg/events"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockStorage struct{ mock.Mock }
+
+func (m *MockStorage) Persist(ctx context.Context, name string, email string, password string) (string, error) {
+	args := m.Called(ctx, name, email, password)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockStorage) ScheduleDeletion(ctx context.Context, id string, undoHash string, scheduledFor time.Time) error {
+	return m.Called(ctx, id, undoHash, scheduledFor).Error(0)
+}
+
+func (m *MockStorage) CancelDeletion(ctx context.Context, undoHash string) (string, error) {
+	args := m.Called(ctx, undoHash)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockStorage) ListDueDeletions(ctx context.Context, now time.Time) ([]string, error) {
+	args := m.Called(ctx, now)
+	ids, _ := args.Get(0).([]string)
+	return ids, args.Error(1)
+}
+
+func (m *MockStorage) EraseUser(ctx context.Context, id string) (*AccountDeletion, error) {
+	args := m.Called(ctx, id)
+	deletion, _ := args.Get(0).(*AccountDeletion)
+	return deletion, args.Error(1)
+}
+
+func (m *MockStorage) MarkDeletionPublished(ctx context.Context, id string) error {
+	return m.Called(ctx, id).Error(0)
+}
+
+func (m *MockStorage) ListUnconfirmedDeletions(ctx context.Context, publishedBefore time.Time) ([]AccountDeletion, error) {
+	args := m.Called(ctx, publishedBefore)
+	deletions, _ := args.Get(0).([]AccountDeletion)
+	return deletions, args.Error(1)
+}
+
+func (m *MockStorage) ConfirmDeletion(ctx context.Context, id string, userID string, service string) ([]string, error) {
+	args := m.Called(ctx, id, userID, service)
+	confirmed, _ := args.Get(0).([]string)
+	return confirmed, args.Error(1)
+}
+
+func (m *MockStorage) CompleteDeletion(ctx context.Context, id string) (string, error) {
+	args := m.Called(ctx, id)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockStorage) ListAllSessions(ctx context.Context, userID string) ([]Session, error) {
+	args := m.Called(ctx, userID)
+	sessions, _ := args.Get(0).([]Session)
+	return sessions, args.Error(1)
+}
+
+func (m *MockStorage) CreateExport(ctx context.Context, userID string) (*DataExport, error) {
+	args := m.Called(ctx, userID)
+	export, _ := args.Get(0).(*DataExport)
+	return export, args.Error(1)
+}
+
+func (m *MockStorage) ListExports(ctx context.Context, userID string) ([]DataExport, error) {
+	args := m.Called(ctx, userID)
+	exports, _ := args.Get(0).([]DataExport)
+	return exports, args.Error(1)
+}
+
+func (m *MockStorage) ClaimExport(ctx context.Context, staleBefore time.Time) (*DataExport, error) {
+	args := m.Called(ctx, staleBefore)
+	export, _ := args.Get(0).(*DataExport)
+	return export, args.Error(1)
+}
+
+func (m *MockStorage) CompleteExport(ctx context.Context, id string, objectKey string, tokenHash string, expiresAt time.Time) error {
+	return m.Called(ctx, id, objectKey, tokenHash, expiresAt).Error(0)
+}
+
+func (m *MockStorage) FailExport(ctx context.Context, id string, retry bool) error {
+	return m.Called(ctx, id, retry).Error(0)
+}
+
+func (m *MockStorage) GetExportByToken(ctx context.Context, tokenHash string) (*DataExport, error) {
+	args := m.Called(ctx, tokenHash)
+	export, _ := args.Get(0).(*DataExport)
+	return export, args.Error(1)
+}
+
+func (m *MockStorage) ListExpiredExports(ctx context.Context, now time.Time) ([]DataExport, error) {
+	args := m.Called(ctx, now)
+	exports, _ := args.Get(0).([]DataExport)
+	return exports, args.Error(1)
+}
+
+func (m *MockStorage) ExpireExport(ctx context.Context, id string) error {
+	return m.Called(ctx, id).Error(0)
+}
+
+func (m *MockStorage) UpdateUser(ctx context.Context, id string, update UserUpdate) error {
+	return m.Called(ctx, id, update).Error(0)
+}
+
+func (m *MockStorage) SetSessionProfile(ctx context.Context, userID string, sessionID string, profileID string) error {
+	return m.Called(ctx, userID, sessionID, profileID).Error(0)
+}
+
+func (m *MockStorage) ListProfiles(ctx context.Context, userID string) ([]Profile, error) {
+	args := m.Called(ctx, userID)
+	profiles, _ := args.Get(0).([]Profile)
+	return profiles, args.Error(1)
+}
+
+func (m *MockStorage) GetProfile(ctx context.Context, userID string, id string) (*Profile, error) {
+	args := m.Called(ctx, userID, id)
+	profile, _ := args.Get(0).(*Profile)
+	return profile, args.Error(1)
+}
+
+func (m *MockStorage) CreateProfile(ctx context.Context, profile *Profile, limit int) error {
+	return m.Called(ctx, profile.UserID, limit).Error(0)
+}
+
+func (m *MockStorage) UpdateProfile(ctx context.Context, profile *Profile) error {
+	return m.Called(ctx, profile.ID).Error(0)
+}
+
+func (m *MockStorage) DeleteProfile(ctx context.Context, userID string, id string) error {
+	return m.Called(ctx, userID, id).Error(0)
+}
+
+func (m *MockStorage) GetUserProfile(ctx context.Context, id string) (*User, error) {
+	args := m.Called(ctx, id)
+	user, _ := args.Get(0).(*User)
+	return user, args.Error(1)
+}
+
+func (m *MockStorage) SetAvatar(ctx context.Context, id string, key string) (string, error) {
+	args := m.Called(ctx, id, key)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockStorage) GetUser(ctx context.Context, email string) (*UserAuthData, error) {
+	args := m.Called(ctx, email)
+	user, _ := args.Get(0).(*UserAuthData)
+	return user, args.Error(1)
+}
+
+func (m *MockStorage) GetUserByID(ctx context.Context, id string) (*UserAuthData, error) {
+	args := m.Called(ctx, id)
+	user, _ := args.Get(0).(*UserAuthData)
+	return user, args.Error(1)
+}
+
+func (m *MockStorage) CreateVerificationToken(ctx context.Context, userID string, email string, tokenHash string, expiresAt time.Time) error {
+	return m.Called(ctx, userID, email, mock.Anything).Error(0)
+}
+
+func (m *MockStorage) VerificationTokenIssuedAt(ctx context.Context, userID string) (time.Time, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).(time.Time), args.Error(1)
+}
+
+func (m *MockStorage) VerifyEmail(ctx context.Context, tokenHash string) (string, error) {
+	args := m.Called(ctx, tokenHash)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockStorage) CreatePasswordResetToken(ctx context.Context, userID string, tokenHash string, expiresAt time.Time) error {
+	return m.Called(ctx, userID).Error(0)
+}
+
+func (m *MockStorage) ResetPassword(ctx context.Context, tokenHash string, passwordHash string) (string, error) {
+	args := m.Called(ctx, tokenHash, passwordHash)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockStorage) GetMFA(ctx context.Context, userID string) (*MFA, error) {
+	args := m.Called(ctx, userID)
+	mfa, _ := args.Get(0).(*MFA)
+	return mfa, args.Error(1)
+}
+
+func (m *MockStorage) SaveMFASecret(ctx context.Context, userID string, sealedSecret []byte) error {
+	return m.Called(ctx, userID).Error(0)
+}
+
+func (m *MockStorage) EnableMFA(ctx context.Context, userID string, step int64, recoveryCodeHashes []string) error {
+	return m.Called(ctx, userID, step, recoveryCodeHashes).Error(0)
+}
+
+func (m *MockStorage) UseTOTPStep(ctx context.Context, userID string, step int64) error {
+	return m.Called(ctx, userID, step).Error(0)
+}
+
+func (m *MockStorage) UseRecoveryCode(ctx context.Context, userID string, codeHash string) error {
+	return m.Called(ctx, userID, codeHash).Error(0)
+}
+
+func (m *MockStorage) CreateMFAChallenge(ctx context.Context, userID string, tokenHash string, expiresAt time.Time) error {
+	return m.Called(ctx, userID).Error(0)
+}
+
+func (m *MockStorage) AttemptMFAChallenge(ctx context.Context, tokenHash string, maxAttempts int) (string, error) {
+	args := m.Called(ctx, tokenHash)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockStorage) DeleteMFAChallenge(ctx context.Context, tokenHash string) error {
+	return m.Called(ctx, tokenHash).Error(0)
+}
+
+func (m *MockStorage) ListRoles(ctx context.Context) ([]Role, error) {
+	args := m.Called(ctx)
+	roles, _ := args.Get(0).([]Role)
+	return roles, args.Error(1)
+}
+
+func (m *MockStorage) GrantRole(ctx context.Context, userID string, role string) error {
+	return m.Called(ctx, userID, role).Error(0)
+}
+
+func (m *MockStorage) RevokeRole(ctx context.Context, userID string, role string) error {
+	return m.Called(ctx, userID, role).Error(0)
+}
+
+func (m *MockStorage) SearchUsers(ctx context.Context, filter UserFilter) ([]UserSummary, int, error) {
+	args := m.Called(ctx, filter)
+	users, _ := args.Get(0).([]UserSummary)
+	return users, args.Int(1), args.Error(2)
+}
+
+func (m *MockStorage) GetUserSummary(ctx context.Context, id string) (*UserSummary, error) {
+	args := m.Called(ctx, id)
+	user, _ := args.Get(0).(*UserSummary)
+	return user, args.Error(1)
+}
+
+func (m *MockStorage) SuspendUser(ctx context.Context, id string, reason string) error {
+	return m.Called(ctx, id, reason).Error(0)
+}
+
+func (m *MockStorage) UnsuspendUser(ctx context.Context, id string) error {
+	return m.Called(ctx, id).Error(0)
+}
+
+func (m *MockStorage) ListPlans(ctx context.Context) ([]Plan, error) {
+	args := m.Called(ctx)
+	plans, _ := args.Get(0).([]Plan)
+	return plans, args.Error(1)
+}
+
+func (m *MockStorage) GetPlan(ctx context.Context, id int8) (*Plan, error) {
+	args := m.Called(ctx, id)
+	plan, _ := args.Get(0).(*Plan)
+	return plan, args.Error(1)
+}
+
+func (m *MockStorage) GetDefaultPlan(ctx context.Context) (*Plan, error) {
+	args := m.Called(ctx)
+	plan, _ := args.Get(0).(*Plan)
+	return plan, args.Error(1)
+}
+
+func (m *MockStorage) GetSubscription(ctx context.Context, userID string) (*Subscription, error) {
+	args := m.Called(ctx, userID)
+	sub, _ := args.Get(0).(*Subscription)
+	return sub, args.Error(1)
+}
+
+func (m *MockStorage) GetSubscriptionByProviderID(ctx context.Context, providerID string) (*Subscription, error) {
+	args := m.Called(ctx, providerID)
+	sub, _ := args.Get(0).(*Subscription)
+	return sub, args.Error(1)
+}
+
+func (m *MockStorage) CreateSubscription(ctx context.Context, sub *Subscription) error {
+	return m.Called(ctx, sub.UserID, sub.Status).Error(0)
+}
+
+func (m *MockStorage) UpdateSubscription(ctx context.Context, sub *Subscription) error {
+	return m.Called(ctx, sub.ID, sub.Status).Error(0)
+}
+
+func (m *MockStorage) ListExpiredSubscriptions(ctx context.Context, now time.Time) ([]Subscription, error) {
+	args := m.Called(ctx)
+	subs, _ := args.Get(0).([]Subscription)
+	return subs, args.Error(1)
+}
+
+func (m *MockStorage) ApplyPaymentEvent(ctx context.Context, event *PaymentEvent, sub *Subscription, grant *PlanGrant) (*int8, error) {
+	var status string
+	if sub != nil {
+		status = sub.Status
+	}
+	args := m.Called(ctx, event.ID, status, grant)
+	from, _ := args.Get(0).(*int8)
+	return from, args.Error(1)
+}
+
+func (m *MockStorage) ChangePlan(ctx context.Context, id string, plan int8) error {
+	return m.Called(ctx, id, plan).Error(0)
+}
+
+func (m *MockStorage) RecentRevocations(ctx context.Context, since time.Time) (*auth.Revocations, error) {
+	args := m.Called(ctx)
+	list, _ := args.Get(0).(*auth.Revocations)
+	return list, args.Error(1)
+}
+
+func (m *MockStorage) CreateSession(ctx context.Context, session *Session) (*Session, error) {
+	args := m.Called(ctx, session)
+	return session, args.Error(0)
+}
+
+func (m *MockStorage) GetSession(ctx context.Context, id string) (*Session, error) {
+	args := m.Called(ctx, id)
+	session, _ := args.Get(0).(*Session)
+	return session, args.Error(1)
+}
+
+func (m *MockStorage) RevokeSession(ctx context.Context, id string) error {
+	return m.Called(ctx, id).Error(0)
+}
+
+func (m *MockStorage) RotateSession(ctx context.Context, id string, oldTokenHash string, newTokenHash string, expiresAt time.Time, client Client) error {
+	return m.Called(ctx, id, oldTokenHash, newTokenHash).Error(0)
+}
+
+func (m *MockStorage) ListSessions(ctx context.Context, userID string) ([]Session, error) {
+	args := m.Called(ctx, userID)
+	sessions, _ := args.Get(0).([]Session)
+	return sessions, args.Error(1)
+}
+
+func (m *MockStorage) RevokeOwnedSession(ctx context.Context, userID string, id string) error {
+	return m.Called(ctx, userID, id).Error(0)
+}
+
+func (m *MockStorage) RevokeUserSessions(ctx context.Context, userID string, exceptID string) (int64, error) {
+	args := m.Called(ctx, userID, exceptID)
+	return int64(args.Int(0)), args.Error(1)
+}
+
+// MockToken keeps the payloads it signed in Payloads.
+type MockToken struct {
+	mock.Mock
+	Payloads []UserPayload
+}
+
+func (m *MockToken) CreateToken(user UserPayload, sessionID string, tokenType string, duration time.Duration) (string, *UserClaims, error) {
+	m.Payloads = append(m.Payloads, user)
+	args := m.Called(user.ID, sessionID, duration)
+	return args.String(0), newClaims(user.ID, user.Email, sessionID, tokenType, duration), args.Error(1)
+}
+
+func (m *MockToken) VerifyToken(tokenStr string) (*UserClaims, error) {
+	args := m.Called(tokenStr)
+	claims, _ := args.Get(0).(*UserClaims)
+	return claims, args.Error(1)
+}
+
+type MockMailer struct{ mock.Mock }
+
+func (m *MockMailer) Send(ctx context.Context, email Email) error {
+	return m.Called(ctx, email.To).Error(0)
+}
+
+type MockEventPublisher struct{ mock.Mock }
+
+func (m *MockEventPublisher) PublishUserEvent(ctx context.Context, event events.UserEvent) error {
+	return m.Called(ctx, event.Type, event.UserID).Error(0)
+}
+
+type MockAuditLog struct{ mock.Mock }
+
+func (m *MockAuditLog) RecordAuditEvent(ctx context.Context, event AuditEvent) error {
+	return m.Called(ctx, event.Type, event.SessionID).Error(0)
+}
+
+func (m *MockAuditLog) SearchAuditEvents(ctx context.Context, filter AuditFilter) ([]AuditEvent, int, error) {
+	args := m.Called(ctx, filter)
+	events, _ := args.Get(0).([]AuditEvent)
+	return events, args.Int(1), args.Error(2)
+}
+
+func newClaims(id string, email string, sessionID string, tokenType string, duration time.Duration) *UserClaims {
+	return &UserClaims{
+		ID:        id,
+		Email:     email,
+		SessionID: sessionID,
+		Type:      tokenType,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        "token-" + sessionID,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(duration)),
+		},
+	}
+}
+
+func TestRenewAccessToken(t *testing.T) {
+	ctx := context.Background()
+	tests := map[string]struct {
+		tokenType      string
+		session        *Session
+		status         string
+		rotateErr      error
+		expectErr      error
+		expectAudit    bool
+		expectMaturity int
+	}{
+		"rotates the refresh token": {
+			session: &Session{ID: "session-1", UserID: "user-1", RefreshTokenHash: HashToken("refresh-1")},
+		},
+		"rotated token presented again revokes the session": {
+			session:     &Session{ID: "session-1", UserID: "user-1", RefreshTokenHash: HashToken("refresh-2")},
+			expectErr:   ErrRefreshTokenReused,
+			expectAudit: true,
+		},
+		"concurrent renewal with the same token revokes the session": {
+			session:     &Session{ID: "session-1", UserID: "user-1", RefreshTokenHash: HashToken("refresh-1")},
+			rotateErr:   ErrRefreshTokenReused,
+			expectErr:   ErrRefreshTokenReused,
+			expectAudit: true,
+		},
+		"session of another user": {
+			session:   &Session{ID: "session-1", UserID: "user-2", RefreshTokenHash: HashToken("refresh-1")},
+			expectErr: errInvalidSession,
+		},
+		"revoked session": {
+			session:   &Session{ID: "session-1", UserID: "user-1", RefreshTokenHash: HashToken("refresh-1"), IsRevoked: true},
+			expectErr: ErrSessionRevoked,
+		},
+		"suspended user": {
+			session:   &Session{ID: "session-1", UserID: "user-1", RefreshTokenHash: HashToken("refresh-1")},
+			status:    StatusSuspended,
+			expectErr: ErrAccountSuspended,
+		},
+		"access token is refused": {
+			tokenType: auth.TokenTypeAccess,
+			session:   &Session{ID: "session-1", UserID: "user-1", RefreshTokenHash: HashToken("refresh-1")},
+			expectErr: ErrInvalidRefreshToken,
+		},
+		"session on a profile keeps its limit": {
+			session:        &Session{ID: "session-1", UserID: "user-1", ProfileID: "profile-1", RefreshTokenHash: HashToken("refresh-1")},
+			expectMaturity: 7,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			db := new(MockStorage)
+			token := new(MockToken)
+			audit := new(MockAuditLog)
+
+			tokenType := auth.TokenTypeRefresh
+			if tc.tokenType != "" {
+				tokenType = tc.tokenType
+			}
+			claims := newClaims("user-1", "user@example.com", "session-1", tokenType, 24*time.Hour)
+			token.On("VerifyToken", "refresh-1").Return(claims, nil)
+			db.On("GetSession", ctx, "session-1").Return(tc.session, nil)
+			db.On("GetUserByID", ctx, "user-1").Return(&UserAuthData{ID: "user-1", Email: "user@example.com", EmailVerified: true, Status: tc.status}, nil)
+			token.On("CreateToken", "user-1", "session-1", 15*time.Minute).Return("access-2", nil)
+			token.On("CreateToken", "user-1", "session-1", 24*time.Hour).Return("refresh-2", nil)
+			db.On("RotateSession", ctx, "session-1", HashToken("refresh-1"), HashToken("refresh-2")).Return(tc.rotateErr)
+			db.On("RevokeSession", ctx, "session-1").Return(nil)
+			db.On("GetProfile", ctx, "user-1", "profile-1").Return(&Profile{ID: "profile-1", UserID: "user-1", MaxMaturity: 7}, nil)
+			audit.On("RecordAuditEvent", ctx, AuditRefreshTokenReused, "session-1").Return(nil)
+			audit.On("RecordAuditEvent", ctx, AuditTokenRenewed, "session-1").Return(nil)
+
+			u := NewUserManager(db, token, audit, new(MockMailer), Links{}, nil, nil, nil, nil)
+			res, err := u.RenewAccessToken(ctx, "refresh-1", Client{IP: "203.0.113.7"})
+			if tc.session.ProfileID != "" {
+				assert.Len(t, token.Payloads, 2)
+				for _, payload := range token.Payloads {
+					assert.Equal(t, tc.session.ProfileID, payload.ProfileID)
+					assert.Equal(t, tc.expectMaturity, payload.MaxMaturity)
+				}
+			}
+
+			if tc.expectErr != nil {
+				assert.ErrorIs(t, err, tc.expectErr)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, "access-2", res.AccessToken)
+				assert.Equal(t, "refresh-2", res.RefreshToken)
+				db.AssertCalled(t, "RotateSession", ctx, "session-1", HashToken("refresh-1"), HashToken("refresh-2"))
+				audit.AssertCalled(t, "RecordAuditEvent", ctx, AuditTokenRenewed, "session-1")
+			}
+			if tc.expectAudit {
+				db.AssertCalled(t, "RevokeSession", ctx, "session-1")
+				audit.AssertCalled(t, "RecordAuditEvent", ctx, AuditRefreshTokenReused, "session-1")
+			} else {
+				db.AssertNotCalled(t, "RevokeSession", ctx, "session-1")
+				audit.AssertNotCalled(t, "RecordAuditEvent", ctx, AuditRefreshTokenReused, "session-1")
+			}
+		})
+	}
+}
+
+func TestRevokeSession(t *testing.T) {
+	ctx := context.Background()
+
+	tests := map[string]struct {
+		userID    string
+		storeErr  error
+		expectErr error
+	}{
+		"own session": {
+			userID: "user-1",
+		},
+		"session of another user": {
+			userID:    "user-2",
+			storeErr:  ErrSessionNotFound,
+			expectErr: ErrSessionNotFound,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			db := new(MockStorage)
+			db.On("RevokeOwnedSession", ctx, tc.userID, "session-1").Return(tc.storeErr)
+			audit := new(MockAuditLog)
+			audit.On("RecordAuditEvent", ctx, AuditSessionRevoked, "session-1").Return(nil)
+
+			u := NewUserManager(db, new(MockToken), audit, new(MockMailer), Links{}, nil, nil, nil, nil)
+			err := u.RevokeSession(ctx, tc.userID, "session-1")
+			if tc.expectErr != nil {
+				assert.ErrorIs(t, err, tc.expectErr)
+				audit.AssertNotCalled(t, "RecordAuditEvent", ctx, AuditSessionRevoked, "session-1")
+				return
+			}
+			assert.NoError(t, err)
+			audit.AssertCalled(t, "RecordAuditEvent", ctx, AuditSessionRevoked, "session-1")
+		})
+	}
+}
+
+func TestUserLogout(t *testing.T) {
+	ctx := context.Background()
+	db := new(MockStorage)
+	token := new(MockToken)
+	audit := new(MockAuditLog)
+	db.On("RevokeOwnedSession", ctx, "user-1", "session-1").Return(nil)
+	audit.On("RecordAuditEvent", ctx, AuditLogout, "session-1").Return(nil)
+
+	u := NewUserManager(db, token, audit, new(MockMailer), Links{}, nil, nil, nil, nil)
+	assert.NoError(t, u.UserLogout(ctx, "user-1", "session-1"))
+	db.AssertCalled(t, "RevokeOwnedSession", ctx, "user-1", "session-1")
+
+	token.On("VerifyToken", "refresh-1").Return(newClaims("user-1", "user@example.com", "session-1", auth.TokenTypeRefresh, time.Hour), nil)
+	db.On("GetSession", ctx, "session-1").Return(nil, ErrSessionNotFound)
+	_, err := u.RenewAccessToken(ctx, "refresh-1", Client{})
+	assert.ErrorIs(t, err, ErrInvalidRefreshToken)
+}
+
+func TestCreateUser_SendsVerification(t *testing.T) {
+	ctx := context.Background()
+
+	tests := map[string]struct {
+		email      string
+		mailErr    error
+		expectErr  bool
+		expectMail bool
+	}{
+		"verification email is sent": {
+			email:      "user@example.com",
+			expectMail: true,
+		},
+		"mail failure doesn't fail signup": {
+			email:      "user@example.com",
+			mailErr:    errors.New("smtp down"),
+			expectMail: true,
+		},
+		"invalid email is refused": {
+			email:     "not-an-email",
+			expectErr: true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			db := new(MockStorage)
+			mailer := new(MockMailer)
+			db.On("Persist", ctx, "Jane Doe", tc.email, mock.Anything).Return("user-1", nil)
+			db.On("CreateVerificationToken", ctx, "user-1", tc.email, mock.Anything).Return(nil)
+			mailer.On("Send", ctx, tc.email).Return(tc.mailErr)
+			audit := new(MockAuditLog)
+			audit.On("RecordAuditEvent", ctx, AuditSignup, "").Return(nil)
+
+			u := NewUserManager(db, new(MockToken), audit, mailer, Links{BaseURL: "https://app.example.com"}, nil, nil, nil, nil)
+			err := u.CreateUser(ctx, "Jane Doe", tc.email, "a-strong-password")
+			if tc.expectErr {
+				assert.Error(t, err)
+				db.AssertNotCalled(t, "Persist", ctx, "Jane Doe", tc.email, mock.Anything)
+				return
+			}
+			assert.NoError(t, err)
+			audit.AssertCalled(t, "RecordAuditEvent", ctx, AuditSignup, "")
+			if tc.expectMail {
+				mailer.AssertCalled(t, "Send", ctx, tc.email)
+			}
+		})
+	}
+}
+
+func TestVerifyEmail(t *testing.T) {
+	ctx := context.Background()
+
+	tests := map[string]struct {
+		token     string
+		storeErr  error
+		expectErr error
+	}{
+		"valid token": {
+			token: "token-1",
+		},
+		"expired or used token": {
+			token:     "token-1",
+			storeErr:  ErrInvalidVerificationToken,
+			expectErr: ErrInvalidVerificationToken,
+		},
+		"empty token": {
+			token:     "",
+			expectErr: ErrInvalidVerificationToken,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			db := new(MockStorage)
+			db.On("VerifyEmail", ctx, HashToken(tc.token)).Return("user-1", tc.storeErr)
+
+			u := NewUserManager(db, new(MockToken), new(MockAuditLog), new(MockMailer), Links{}, nil, nil, nil, nil)
+			err := u.VerifyEmail(ctx, tc.token)
+			if tc.expectErr != nil {
+				assert.ErrorIs(t, err, tc.expectErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestResendVerification(t *testing.T) {
+	ctx := context.Background()
+
+	tests := map[string]struct {
+		verified   bool
+		issuedAt   time.Time
+		expectErr  error
+		expectMail bool
+	}{
+		"no pending token": {
+			expectMail: true,
+		},
+		"previous token is old enough": {
+			issuedAt:   time.Now().Add(-2 * verificationResendInterval),
+			expectMail: true,
+		},
+		"previous token is too recent": {
+			issuedAt:  time.Now().Add(-verificationResendInterval / 2),
+			expectErr: ErrVerificationSentRecently,
+		},
+		"already verified": {
+			verified:  true,
+			expectErr: ErrEmailAlreadyVerified,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			db := new(MockStorage)
+			mailer := new(MockMailer)
+			db.On("GetUserByID", ctx, "user-1").Return(&UserAuthData{ID: "user-1", Email: "user@example.com", EmailVerified: tc.verified}, nil)
+			db.On("VerificationTokenIssuedAt", ctx, "user-1").Return(tc.issuedAt, nil)
+			db.On("CreateVerificationToken", ctx, "user-1", "user@example.com", mock.Anything).Return(nil)
+			mailer.On("Send", ctx, "user@example.com").Return(nil)
+
+			u := NewUserManager(db, new(MockToken), new(MockAuditLog), mailer, Links{}, nil, nil, nil, nil)
+			err := u.ResendVerification(ctx, "user-1")
+			if tc.expectErr != nil {
+				assert.ErrorIs(t, err, tc.expectErr)
+				mailer.AssertNotCalled(t, "Send", ctx, mock.Anything)
+				return
+			}
+			assert.NoError(t, err)
+			mailer.AssertCalled(t, "Send", ctx, "user@example.com")
+		})
+	}
+}
+
+func TestForgotPassword(t *testing.T) {
+	ctx := context.Background()
+
+	tests := map[string]struct {
+		email      string
+		userErr    error
+		expectMail bool
+	}{
+		"registered email gets a link": {
+			email:      "user@example.com",
+			expectMail: true,
+		},
+		"unknown email is not an error": {
+			email:   "nobody@example.com",
+			userErr: errors.New("user doesn't exist"),
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			db := new(MockStorage)
+			mailer := new(MockMailer)
+			db.On("GetUser", ctx, tc.email).Return(&UserAuthData{ID: "user-1", Email: tc.email}, tc.userErr)
+			db.On("CreatePasswordResetToken", ctx, "user-1").Return(nil)
+			mailer.On("Send", ctx, tc.email).Return(nil)
+
+			u := NewUserManager(db, new(MockToken), new(MockAuditLog), mailer, Links{BaseURL: "https://app.example.com"}, nil, nil, nil, nil)
+			assert.NoError(t, u.ForgotPassword(ctx, tc.email))
+			if tc.expectMail {
+				db.AssertCalled(t, "CreatePasswordResetToken", ctx, "user-1")
+				mailer.AssertCalled(t, "Send", ctx, tc.email)
+				return
+			}
+			db.AssertNotCalled(t, "CreatePasswordResetToken", ctx, "user-1")
+			mailer.AssertNotCalled(t, "Send", ctx, tc.email)
+		})
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	ctx := context.Background()
+
+	tests := map[string]struct {
+		token         string
+		password      string
+		storeErr      error
+		expectErr     error
+		expectRevoked bool
+	}{
+		"valid token revokes every session": {
+			token:         "token-1",
+			password:      "a-new-password",
+			expectRevoked: true,
+		},
+		"used or expired token": {
+			token:     "token-1",
+			password:  "a-new-password",
+			storeErr:  ErrInvalidResetToken,
+			expectErr: ErrInvalidResetToken,
+		},
+		"empty token": {
+			password:  "a-new-password",
+			expectErr: ErrInvalidResetToken,
+		},
+		"short password": {
+			token:     "token-1",
+			password:  "short",
+			expectErr: ErrInvalidPassword,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			db := new(MockStorage)
+			audit := new(MockAuditLog)
+			db.On("ResetPassword", ctx, HashToken(tc.token), mock.Anything).Return("user-1", tc.storeErr)
+			db.On("RevokeUserSessions", ctx, "user-1", "").Return(2, nil)
+			audit.On("RecordAuditEvent", ctx, AuditPasswordReset, "").Return(nil)
+
+			u := NewUserManager(db, new(MockToken), audit, new(MockMailer), Links{}, nil, nil, nil, nil)
+			err := u.ResetPassword(ctx, tc.token, tc.password)
+			if tc.expectErr != nil {
+				assert.ErrorIs(t, err, tc.expectErr)
+				db.AssertNotCalled(t, "RevokeUserSessions", ctx, "user-1", "")
+				return
+			}
+			assert.NoError(t, err)
+			db.AssertCalled(t, "RevokeUserSessions", ctx, "user-1", "")
+			audit.AssertCalled(t, "RecordAuditEvent", ctx, AuditPasswordReset, "")
+		})
+	}
+}
+
+func TestGrantRole(t *testing.T) {
+	ctx := context.Background()
+
+	tests := map[string]struct {
+		role        string
+		storeErr    error
+		expectErr   error
+		expectAudit bool
+	}{
+		"role granted": {
+			role:        "admin",
+			expectAudit: true,
+		},
+		"unknown role": {
+			role:      "superuser",
+			storeErr:  ErrRoleNotFound,
+			expectErr: ErrRoleNotFound,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			db := new(MockStorage)
+			audit := new(MockAuditLog)
+			db.On("GrantRole", ctx, "user-1", tc.role).Return(tc.storeErr)
+			audit.On("RecordAuditEvent", ctx, AuditRoleGranted, "").Return(nil)
+
+			u := NewUserManager(db, new(MockToken), audit, new(MockMailer), Links{}, nil, nil, nil, nil)
+			err := u.GrantRole(ctx, "admin-1", "user-1", tc.role)
+			if tc.expectErr != nil {
+				assert.ErrorIs(t, err, tc.expectErr)
+				audit.AssertNotCalled(t, "RecordAuditEvent", ctx, AuditRoleGranted, "")
+				return
+			}
+			assert.NoError(t, err)
+			audit.AssertCalled(t, "RecordAuditEvent", ctx, AuditRoleGranted, "")
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package domain
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+const (
+	verificationTokenTTL = 24 * time.Hour
+	// verificationResendInterval is the least time between two verification
+	// emails of a user.
+	verificationResendInterval = time.Minute
+)
+
+var (
+	ErrInvalidVerificationToken = errors.New("invalid or expired verification token")
+	ErrEmailAlreadyVerified     = errors.New("email already verified")
+	ErrVerificationSentRecently = errors.New("verification email sent recently")
+)
+
+type Email struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, email Email) error
+}
+
+// Links builds the URLs sent by email, they point to the web client.
+type Links struct {
+	BaseURL string
+}
+
+func (l Links) build(path string, token string) string {
+	return fmt.Sprintf("%s%s?token=%s", l.BaseURL, path, url.QueryEscape(token))
+}
+
+// NewOpaqueToken returns a random token meant to be sent to the user, only
+// its HashToken is stored.
+func NewOpaqueToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func (u *UserManager) sendVerification(ctx context.Context, userID string, email string) error {
+	token, err := NewOpaqueToken()
+	if err != nil {
+		return fmt.Errorf("error creating verification token: %w", err)
+	}
+	if err := u.db.CreateVerificationToken(ctx, userID, email, HashToken(token), time.Now().Add(verificationTokenTTL)); err != nil {
+		return fmt.Errorf("error storing verification token: %w", err)
+	}
+
+	link := u.links.build("/verify-email", token)
+	return u.mailer.Send(ctx, Email{
+		To:      email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf("Welcome!\n\nConfirm your email address by opening the link below, it expires in %s:\n\n%s\n\nIf you didn't create an account, ignore this message.\n",
+			verificationTokenTTL, link),
+	})
+}
+
+// VerifyEmail consumes a verification token. Tokens are single use, a new
+// one replaces the previous ones of the user and a token only verifies the
+// address it was sent to.
+func (u *UserManager) VerifyEmail(ctx context.Context, token string) error {
+	if token == "" {
+		return ErrInvalidVerificationToken
+	}
+	if _, err := u.db.VerifyEmail(ctx, HashToken(token)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ResendVerification sends a new verification email, at most once per
+// verificationResendInterval.
+func (u *UserManager) ResendVerification(ctx context.Context, userID string) error {
+	user, err := u.db.GetUserByID(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("error getting user: %w", err)
+	}
+	if user.EmailVerified {
+		return ErrEmailAlreadyVerified
+	}
+	issuedAt, err := u.db.VerificationTokenIssuedAt(ctx, user.ID)
+	if err != nil {
+		return fmt.Errorf("error getting last verification token: %w", err)
+	}
+	if time.Since(issuedAt) < verificationResendInterval {
+		return ErrVerificationSentRecently
+	}
+	return u.sendVerification(ctx, user.ID, user.Email)
+}
@@ -0,0 +1,187 @@
+package infrastructure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/eduardo-ax/video-streaming/pkg/auth"
+	"github.com/eduardo-ax/video-streaming/services/user/domain"
+	"github.com/jackc/pgx/v5"
+)
+
+const selectUserSummary = `
+	SELECT id, name, email, plan, status, email_verified,
+		ARRAY(SELECT role FROM user_roles WHERE user_roles.user_id = users.id ORDER BY role),
+		suspended_at, suspension_reason, created_at
+	FROM users `
+
+func scanUserSummary(row pgx.Row) (*domain.UserSummary, error) {
+	var u domain.UserSummary
+	err := row.Scan(&u.ID, &u.Name, &u.Email, &u.Plan, &u.Status, &u.EmailVerified, &u.Roles, &u.SuspendedAt, &u.SuspensionReason, &u.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (db *Database) SearchUsers(ctx context.Context, filter domain.UserFilter) ([]domain.UserSummary, int, error) {
+	var where []string
+	var args []any
+	if filter.Email != "" {
+		args = append(args, "%"+escapeLike(filter.Email)+"%")
+		where = append(where, fmt.Sprintf("email ILIKE $%d", len(args)))
+	}
+	if filter.Plan != nil {
+		args = append(args, *filter.Plan)
+		where = append(where, fmt.Sprintf("plan = $%d", len(args)))
+	}
+	if filter.Status != "" {
+		args = append(args, filter.Status)
+		where = append(where, fmt.Sprintf("status = $%d", len(args)))
+	}
+	cond := ""
+	if len(where) > 0 {
+		cond = "WHERE " + strings.Join(where, " AND ") + " "
+	}
+
+	var total int
+	if err := db.pool.QueryRow(ctx, "SELECT count(*) FROM users "+cond, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("error counting users: %w", err)
+	}
+
+	args = append(args, filter.PerPage, (filter.Page-1)*filter.PerPage)
+	rows, err := db.pool.Query(ctx,
+		selectUserSummary+cond+fmt.Sprintf("ORDER BY created_at DESC, id LIMIT $%d OFFSET $%d", len(args)-1, len(args)),
+		args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error searching users: %w", err)
+	}
+	defer rows.Close()
+
+	users := []domain.UserSummary{}
+	for rows.Next() {
+		u, err := scanUserSummary(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		users = append(users, *u)
+	}
+	return users, total, rows.Err()
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+func (db *Database) GetUserSummary(ctx context.Context, id string) (*domain.UserSummary, error) {
+	u, err := scanUserSummary(db.pool.QueryRow(ctx, selectUserSummary+"WHERE id = $1", id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, domain.ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting user: %w", err)
+	}
+	return u, nil
+}
+
+// SuspendUser marks the user suspended and revokes their sessions in the
+// same transaction.
+func (db *Database) SuspendUser(ctx context.Context, id string, reason string) error {
+	return pgx.BeginFunc(ctx, db.pool, func(tx pgx.Tx) error {
+		query, err := tx.Exec(ctx,
+			"UPDATE users SET status = 'suspended', suspended_at = now(), suspension_reason = $2 WHERE id = $1",
+			id, reason)
+		if err != nil {
+			return fmt.Errorf("error suspending user: %w", err)
+		}
+		if query.RowsAffected() == 0 {
+			return domain.ErrUserNotFound
+		}
+		_, err = tx.Exec(ctx,
+			"UPDATE sessions SET is_revoked = true, revoked_at = now() WHERE user_id = $1 AND NOT is_revoked", id)
+		if err != nil {
+			return fmt.Errorf("error revoking sessions: %w", err)
+		}
+		return nil
+	})
+}
+
+func (db *Database) UnsuspendUser(ctx context.Context, id string) error {
+	query, err := db.pool.Exec(ctx,
+		"UPDATE users SET status = 'active', suspended_at = NULL, suspension_reason = '' WHERE id = $1", id)
+	if err != nil {
+		return fmt.Errorf("error unsuspending user: %w", err)
+	}
+	if query.RowsAffected() == 0 {
+		return domain.ErrUserNotFound
+	}
+	return nil
+}
+
+func (db *Database) RecentRevocations(ctx context.Context, since time.Time) (*auth.Revocations, error) {
+	list := &auth.Revocations{
+		Sessions: map[string]time.Time{},
+		Users:    map[string]time.Time{},
+	}
+	rows, err := db.pool.Query(ctx, "SELECT id::text, revoked_at FROM sessions WHERE revoked_at > $1", since)
+	if err != nil {
+		return nil, fmt.Errorf("error listing revoked sessions: %w", err)
+	}
+	for rows.Next() {
+		var id string
+		var at time.Time
+		if err := rows.Scan(&id, &at); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		list.Sessions[id] = at
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.pool.Query(ctx, `
+		SELECT id::text, suspended_at FROM users WHERE status = 'suspended' AND suspended_at > $1
+		UNION ALL
+		SELECT id::text, deletion_requested_at FROM users WHERE status = 'pending_deletion' AND deletion_requested_at > $1
+		UNION ALL
+		SELECT user_id::text, deleted_at FROM account_deletions WHERE deleted_at > $1`, since)
+	if err != nil {
+		return nil, fmt.Errorf("error listing suspended and deleted users: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		var at time.Time
+		if err := rows.Scan(&id, &at); err != nil {
+			return nil, err
+		}
+		list.Users[id] = at
+	}
+	return list, rows.Err()
+}
+
+// Revoked implements auth.RevocationChecker for the user service itself,
+// which can look the session up instead of polling a list.
+func (db *Database) Revoked(ctx context.Context, claims *auth.Claims) (bool, error) {
+	if claims.SessionID == "" {
+		return true, nil
+	}
+	var revoked bool
+	err := db.pool.QueryRow(ctx, `
+		SELECT s.is_revoked OR u.status <> 'active'
+		FROM sessions s JOIN users u ON u.id = s.user_id
+		WHERE s.id = $1 AND s.user_id = $2`,
+		claims.SessionID, claims.UserID).Scan(&revoked)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error checking session: %w", err)
+	}
+	return revoked, nil
+}
@@ -0,0 +1,87 @@
+package infrastructure
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/eduardo-ax/video-streaming/services/user/domain"
+)
+
+const selectAuditEvent = `
+	SELECT id, type, COALESCE(actor_id::text, ''), COALESCE(user_id::text, ''), COALESCE(session_id::text, ''),
+		ip, user_agent, metadata, created_at
+	FROM audit_events `
+
+func (db *Database) RecordAuditEvent(ctx context.Context, event domain.AuditEvent) error {
+	metadata, err := json.Marshal(event.Metadata)
+	if err != nil {
+		return fmt.Errorf("error encoding audit metadata: %w", err)
+	}
+	_, err = db.pool.Exec(ctx, `
+		INSERT INTO audit_events (type, actor_id, user_id, session_id, ip, user_agent, metadata, created_at)
+		VALUES ($1, NULLIF($2, '')::uuid, NULLIF($3, '')::uuid, NULLIF($4, '')::uuid, $5, $6, $7, $8)`,
+		event.Type, event.ActorID, event.UserID, event.SessionID, event.IP, event.UserAgent, metadata, event.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("error recording audit event: %w", err)
+	}
+	return nil
+}
+
+func (db *Database) SearchAuditEvents(ctx context.Context, filter domain.AuditFilter) ([]domain.AuditEvent, int, error) {
+	var where []string
+	var args []any
+	for _, f := range []struct{ column, value string }{
+		{"user_id", filter.UserID},
+		{"actor_id", filter.ActorID},
+		{"type", filter.Type},
+		{"ip", filter.IP},
+	} {
+		if f.value != "" {
+			args = append(args, f.value)
+			where = append(where, fmt.Sprintf("%s = $%d", f.column, len(args)))
+		}
+	}
+	if filter.Since != nil {
+		args = append(args, *filter.Since)
+		where = append(where, fmt.Sprintf("created_at >= $%d", len(args)))
+	}
+	if filter.Until != nil {
+		args = append(args, *filter.Until)
+		where = append(where, fmt.Sprintf("created_at < $%d", len(args)))
+	}
+	cond := ""
+	if len(where) > 0 {
+		cond = "WHERE " + strings.Join(where, " AND ") + " "
+	}
+
+	var total int
+	if err := db.pool.QueryRow(ctx, "SELECT count(*) FROM audit_events "+cond, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("error counting audit events: %w", err)
+	}
+
+	args = append(args, filter.PerPage, (filter.Page-1)*filter.PerPage)
+	rows, err := db.pool.Query(ctx,
+		selectAuditEvent+cond+fmt.Sprintf("ORDER BY created_at DESC, id DESC LIMIT $%d OFFSET $%d", len(args)-1, len(args)),
+		args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error searching audit events: %w", err)
+	}
+	defer rows.Close()
+
+	events := []domain.AuditEvent{}
+	for rows.Next() {
+		var e domain.AuditEvent
+		var metadata []byte
+		err := rows.Scan(&e.ID, &e.Type, &e.ActorID, &e.UserID, &e.SessionID, &e.IP, &e.UserAgent, &metadata, &e.CreatedAt)
+		if err != nil {
+			return nil, 0, err
+		}
+		if err := json.Unmarshal(metadata, &e.Metadata); err != nil {
+			return nil, 0, fmt.Errorf("error decoding audit metadata: %w", err)
+		}
+		events = append(events, e)
+	}
+	return events, total, rows.Err()
+}
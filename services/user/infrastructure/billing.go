@@ -0,0 +1,157 @@
+package infrastructure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/eduardo-ax/video-streaming/services/user/domain"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const selectSubscription = `
+	SELECT id, user_id, plan, status, provider_customer_id, provider_subscription_id,
+		trial_ends_at, current_period_end, grace_ends_at, cancel_at_period_end, canceled_at, created_at
+	FROM subscriptions `
+
+func scanSubscription(row pgx.Row) (*domain.Subscription, error) {
+	var s domain.Subscription
+	err := row.Scan(&s.ID, &s.UserID, &s.PlanID, &s.Status, &s.ProviderCustomerID, &s.ProviderSubscriptionID,
+		&s.TrialEndsAt, &s.CurrentPeriodEnd, &s.GraceEndsAt, &s.CancelAtPeriodEnd, &s.CanceledAt, &s.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// GetSubscription returns the latest subscription of the user, cancelled
+// or not.
+func (db *Database) GetSubscription(ctx context.Context, userID string) (*domain.Subscription, error) {
+	s, err := scanSubscription(db.pool.QueryRow(ctx, selectSubscription+"WHERE user_id = $1 ORDER BY created_at DESC LIMIT 1", userID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, domain.ErrSubscriptionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting subscription: %w", err)
+	}
+	return s, nil
+}
+
+func (db *Database) GetSubscriptionByProviderID(ctx context.Context, providerID string) (*domain.Subscription, error) {
+	s, err := scanSubscription(db.pool.QueryRow(ctx, selectSubscription+"WHERE provider_subscription_id = $1", providerID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, domain.ErrSubscriptionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting subscription: %w", err)
+	}
+	return s, nil
+}
+
+func (db *Database) CreateSubscription(ctx context.Context, sub *domain.Subscription) error {
+	err := db.pool.QueryRow(ctx, `
+		INSERT INTO subscriptions (user_id, plan, status, provider_customer_id, provider_subscription_id, trial_ends_at, current_period_end)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, created_at`,
+		sub.UserID, sub.PlanID, sub.Status, sub.ProviderCustomerID, sub.ProviderSubscriptionID, sub.TrialEndsAt, sub.CurrentPeriodEnd,
+	).Scan(&sub.ID, &sub.CreatedAt)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "subscriptions_live_idx" {
+		return domain.ErrSubscriptionExists
+	}
+	if err != nil {
+		return fmt.Errorf("error creating subscription: %w", err)
+	}
+	return nil
+}
+
+// execer is what the pool and a transaction have in common.
+type execer interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+}
+
+func (db *Database) UpdateSubscription(ctx context.Context, sub *domain.Subscription) error {
+	return updateSubscription(ctx, db.pool, sub)
+}
+
+func updateSubscription(ctx context.Context, conn execer, sub *domain.Subscription) error {
+	query, err := conn.Exec(ctx, `
+		UPDATE subscriptions SET status = $2, current_period_end = $3, grace_ends_at = $4,
+			cancel_at_period_end = $5, canceled_at = $6, updated_at = now()
+		WHERE id = $1`,
+		sub.ID, sub.Status, sub.CurrentPeriodEnd, sub.GraceEndsAt, sub.CancelAtPeriodEnd, sub.CanceledAt)
+	if err != nil {
+		return fmt.Errorf("error updating subscription: %w", err)
+	}
+	if query.RowsAffected() == 0 {
+		return domain.ErrSubscriptionNotFound
+	}
+	return nil
+}
+
+// ListExpiredSubscriptions returns the past due subscriptions whose grace
+// period is over and the trials that ended without a payment.
+func (db *Database) ListExpiredSubscriptions(ctx context.Context, now time.Time) ([]domain.Subscription, error) {
+	rows, err := db.pool.Query(ctx, selectSubscription+`
+		WHERE (status = 'past_due' AND grace_ends_at <= $1)
+			OR (status = 'trialing' AND trial_ends_at <= $1)`, now)
+	if err != nil {
+		return nil, fmt.Errorf("error listing expired subscriptions: %w", err)
+	}
+	defer rows.Close()
+
+	var subs []domain.Subscription
+	for rows.Next() {
+		s, err := scanSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		subs = append(subs, *s)
+	}
+	return subs, rows.Err()
+}
+
+// ApplyPaymentEvent claims the event and stores the subscription and the
+// plan grant in one transaction, so concurrent deliveries of an event can't
+// both apply it. It returns the plan the user left, nil when the plan stayed.
+func (db *Database) ApplyPaymentEvent(ctx context.Context, event *domain.PaymentEvent, sub *domain.Subscription, grant *domain.PlanGrant) (*int8, error) {
+	var from *int8
+	err := pgx.BeginFunc(ctx, db.pool, func(tx pgx.Tx) error {
+		claim, err := tx.Exec(ctx,
+			"INSERT INTO payment_events (id, type, provider_subscription_id) VALUES ($1, $2, $3) ON CONFLICT (id) DO NOTHING",
+			event.ID, event.Type, event.SubscriptionID)
+		if err != nil {
+			return fmt.Errorf("error recording payment event: %w", err)
+		}
+		if claim.RowsAffected() == 0 {
+			return domain.ErrPaymentEventProcessed
+		}
+		if sub == nil {
+			return nil
+		}
+		if err := updateSubscription(ctx, tx, sub); err != nil {
+			return err
+		}
+		if grant == nil {
+			return nil
+		}
+
+		var current int8
+		if err := tx.QueryRow(ctx, "SELECT plan FROM users WHERE id = $1 FOR UPDATE", sub.UserID).Scan(&current); err != nil {
+			return fmt.Errorf("error getting user plan: %w", err)
+		}
+		if current == grant.PlanID || (grant.FromPlanID != nil && current != *grant.FromPlanID) {
+			return nil
+		}
+		if _, err := tx.Exec(ctx, "UPDATE users SET plan = $2, plan_changed_at = now() WHERE id = $1", sub.UserID, grant.PlanID); err != nil {
+			return fmt.Errorf("error changing plan: %w", err)
+		}
+		from = &current
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return from, nil
+}
@@ -5,20 +5,23 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"os"
 	"strings"
+	"time"
 
+	"github.com/eduardo-ax/video-streaming/pkg/telemetry"
 	"github.com/eduardo-ax/video-streaming/services/user/domain"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-func NewPool() *pgxpool.Pool {
-	dbURL := os.Getenv("USERS_DATABASE_URL")
-	if dbURL == "" {
-		log.Fatal("Error database host")
+func NewPool(dbURL string) *pgxpool.Pool {
+	poolConfig, err := pgxpool.ParseConfig(dbURL)
+	if err != nil {
+		log.Fatalf("erro ao ler a url do banco: %v", err)
 	}
-	dbPool, err := pgxpool.New(context.Background(), dbURL)
+	poolConfig.ConnConfig.Tracer = telemetry.NewPgxTracer(poolConfig.ConnConfig.Database)
+	dbPool, err := pgxpool.NewWithConfig(context.Background(), poolConfig)
 	if err != nil {
 		log.Fatalf("erro ao conectar ao banco: %v", err)
 	}
@@ -39,9 +42,23 @@ func (db *Database) Close() {
 	db.pool.Close()
 }
 
-func (db *Database) Persist(ctx context.Context, name string, email string, password string, plan int8) (string, error) {
+func (db *Database) Ping(ctx context.Context) error {
+	return db.pool.Ping(ctx)
+}
+
+// Persist creates the user with the default plan and roles.
+func (db *Database) Persist(ctx context.Context, name string, email string, password string) (string, error) {
 	var id string
-	err := db.pool.QueryRow(ctx, "INSERT INTO users (name,email,password,plan) VALUES ($1, $2, $3, $4) RETURNING id", name, email, password, plan).Scan(&id)
+	err := pgx.BeginFunc(ctx, db.pool, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx,
+			"INSERT INTO users (name,email,password,plan) VALUES ($1, $2, $3, (SELECT id FROM plans WHERE is_default)) RETURNING id",
+			name, email, password).Scan(&id)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, "INSERT INTO user_roles (user_id, role) SELECT $1, name FROM roles WHERE is_default", id)
+		return err
+	})
 
 	if err != nil {
 		var pgErr *pgconn.PgError
@@ -55,39 +72,43 @@ func (db *Database) Persist(ctx context.Context, name string, email string, pass
 	return id, nil
 }
 
-func (db *Database) DeleteUser(ctx context.Context, id string) error {
-	query, err := db.pool.Exec(ctx, "DELETE FROM users WHERE id=$1", id)
-
-	if err != nil {
-		return fmt.Errorf("error deleting user: %w", err)
-	}
-	if query.RowsAffected() == 0 {
-		return fmt.Errorf("user doesn't exist")
-	}
-	return nil
-}
-
-func (db *Database) UpdateUser(ctx context.Context, id string, name string, email *string, password *string) error {
+func (db *Database) UpdateUser(ctx context.Context, id string, update domain.UserUpdate) error {
 	var setClauses []string
 	var args []interface{}
 	argCount := 1
 
-	if name != "" {
-		setClauses = append(setClauses, fmt.Sprintf("name=$%d", argCount))
-		args = append(args, name)
+	set := func(column string, value string) {
+		setClauses = append(setClauses, fmt.Sprintf("%s=$%d", column, argCount))
+		args = append(args, value)
 		argCount++
 	}
 
-	if email != nil {
-		setClauses = append(setClauses, fmt.Sprintf("email=$%d", argCount))
-		args = append(args, *email)
-		argCount++
+	if update.Name != "" {
+		set("name", update.Name)
 	}
 
-	if password != nil {
-		setClauses = append(setClauses, fmt.Sprintf("password_hash=$%d", argCount))
-		args = append(args, *password)
-		argCount++
+	if update.Email != nil {
+		set("email", *update.Email)
+		setClauses = append(setClauses, "email_verified=false")
+	}
+
+	if update.Password != nil {
+		set("password", *update.Password)
+	}
+
+	optional := []struct {
+		column string
+		value  *string
+	}{
+		{"display_name", update.DisplayName},
+		{"bio", update.Bio},
+		{"locale", update.Locale},
+		{"timezone", update.Timezone},
+	}
+	for _, field := range optional {
+		if field.value != nil {
+			set(field.column, *field.value)
+		}
 	}
 
 	if len(setClauses) == 0 {
@@ -111,17 +132,325 @@ func (db *Database) UpdateUser(ctx context.Context, id string, name string, emai
 	return nil
 }
 
+func (db *Database) GetUserProfile(ctx context.Context, id string) (*domain.User, error) {
+	user := &domain.User{}
+	err := db.pool.QueryRow(ctx, `
+		SELECT id, name, email, email_verified, plan,
+			EXISTS (SELECT 1 FROM user_mfa WHERE user_mfa.user_id = users.id AND enabled_at IS NOT NULL),
+			ARRAY(SELECT role FROM user_roles WHERE user_roles.user_id = users.id ORDER BY role),
+			display_name, avatar_key, bio, locale, timezone, created_at
+		FROM users WHERE id = $1`, id).Scan(
+		&user.ID, &user.Name, &user.Email, &user.EmailVerified, &user.Plan, &user.MFAEnabled, &user.Roles,
+		&user.DisplayName, &user.AvatarKey, &user.Bio, &user.Locale, &user.Timezone, &user.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, domain.ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// SetAvatar replaces the avatar key of the user and returns the previous
+// one, read in the same statement so concurrent uploads can't both miss it.
+func (db *Database) SetAvatar(ctx context.Context, id string, key string) (string, error) {
+	var previous string
+	err := db.pool.QueryRow(ctx, `
+		UPDATE users SET avatar_key = $2
+		FROM (SELECT avatar_key FROM users WHERE id = $1 FOR UPDATE) AS old
+		WHERE users.id = $1
+		RETURNING old.avatar_key`, id, key).Scan(&previous)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", domain.ErrUserNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return previous, nil
+}
+
+const selectUserAuthData = `
+	SELECT id, email, password, plan, email_verified, status,
+		EXISTS (SELECT 1 FROM user_mfa WHERE user_mfa.user_id = users.id AND enabled_at IS NOT NULL),
+		ARRAY(SELECT role FROM user_roles WHERE user_roles.user_id = users.id ORDER BY role),
+		ARRAY(SELECT DISTINCT rp.permission FROM user_roles ur JOIN role_permissions rp ON rp.role = ur.role
+			WHERE ur.user_id = users.id ORDER BY rp.permission),
+		(SELECT max_streams FROM plans WHERE plans.id = users.plan)
+	FROM users `
+
 func (db *Database) GetUser(ctx context.Context, email string) (*domain.UserAuthData, error) {
 	user := &domain.UserAuthData{}
-	err := db.pool.QueryRow(ctx, "SELECT id,password,plan FROM users WHERE email = $1", email).Scan(&user.ID, &user.Password, &user.Plan)
+	err := db.pool.QueryRow(ctx, selectUserAuthData+"WHERE email = $1", email).Scan(&user.ID, &user.Email, &user.Password, &user.Plan, &user.EmailVerified, &user.Status, &user.MFAEnabled, &user.Roles, &user.Permissions, &user.MaxStreams)
 	if err != nil {
 		return user, fmt.Errorf("user doesn't exist")
 	}
 	return user, nil
 }
 
+func (db *Database) GetUserByID(ctx context.Context, id string) (*domain.UserAuthData, error) {
+	user := &domain.UserAuthData{}
+	err := db.pool.QueryRow(ctx, selectUserAuthData+"WHERE id = $1", id).Scan(&user.ID, &user.Email, &user.Password, &user.Plan, &user.EmailVerified, &user.Status, &user.MFAEnabled, &user.Roles, &user.Permissions, &user.MaxStreams)
+	if err != nil {
+		return nil, fmt.Errorf("user doesn't exist")
+	}
+	return user, nil
+}
+
+// CreateVerificationToken replaces the pending tokens of the user.
+func (db *Database) CreateVerificationToken(ctx context.Context, userID string, email string, tokenHash string, expiresAt time.Time) error {
+	return pgx.BeginFunc(ctx, db.pool, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, "DELETE FROM email_verification_tokens WHERE user_id = $1", userID); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, "INSERT INTO email_verification_tokens (token_hash, user_id, email, expires_at) VALUES ($1, $2, $3, $4)",
+			tokenHash, userID, email, expiresAt)
+		return err
+	})
+}
+
+// VerificationTokenIssuedAt returns when the pending token of the user was
+// created, the zero time without one.
+func (db *Database) VerificationTokenIssuedAt(ctx context.Context, userID string) (time.Time, error) {
+	var issuedAt time.Time
+	err := db.pool.QueryRow(ctx, "SELECT created_at FROM email_verification_tokens WHERE user_id = $1 ORDER BY created_at DESC LIMIT 1", userID).Scan(&issuedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return issuedAt, nil
+}
+
+// VerifyEmail consumes the token and marks the address it was sent to as
+// verified, provided it's still the address of the user.
+func (db *Database) VerifyEmail(ctx context.Context, tokenHash string) (string, error) {
+	var userID string
+	err := pgx.BeginFunc(ctx, db.pool, func(tx pgx.Tx) error {
+		var email string
+		err := tx.QueryRow(ctx, `
+			DELETE FROM email_verification_tokens
+			WHERE token_hash = $1 AND expires_at > now()
+			RETURNING user_id, email`, tokenHash).Scan(&userID, &email)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return domain.ErrInvalidVerificationToken
+		}
+		if err != nil {
+			return err
+		}
+		query, err := tx.Exec(ctx, "UPDATE users SET email_verified = true WHERE id = $1 AND email = $2", userID, email)
+		if err != nil {
+			return err
+		}
+		if query.RowsAffected() == 0 {
+			return domain.ErrInvalidVerificationToken
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return userID, nil
+}
+
+// CreatePasswordResetToken keeps earlier tokens of the user valid until they
+// expire, a second request doesn't break the link of the first email.
+func (db *Database) CreatePasswordResetToken(ctx context.Context, userID string, tokenHash string, expiresAt time.Time) error {
+	_, err := db.pool.Exec(ctx, "INSERT INTO password_reset_tokens (token_hash, user_id, expires_at) VALUES ($1, $2, $3)",
+		tokenHash, userID, expiresAt)
+	if err != nil {
+		return fmt.Errorf("error creating password reset token: %w", err)
+	}
+	return nil
+}
+
+// ResetPassword consumes the token and sets the password. All the pending
+// tokens of the user are dropped with it.
+func (db *Database) ResetPassword(ctx context.Context, tokenHash string, passwordHash string) (string, error) {
+	var userID string
+	err := pgx.BeginFunc(ctx, db.pool, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx, `
+			DELETE FROM password_reset_tokens
+			WHERE token_hash = $1 AND expires_at > now()
+			RETURNING user_id`, tokenHash).Scan(&userID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return domain.ErrInvalidResetToken
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, "DELETE FROM password_reset_tokens WHERE user_id = $1", userID); err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, "UPDATE users SET password = $1 WHERE id = $2", passwordHash, userID)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return userID, nil
+}
+
+func (db *Database) GetMFA(ctx context.Context, userID string) (*domain.MFA, error) {
+	mfa := &domain.MFA{UserID: userID}
+	err := db.pool.QueryRow(ctx, "SELECT secret, enabled_at IS NOT NULL, last_used_step FROM user_mfa WHERE user_id = $1", userID).
+		Scan(&mfa.Secret, &mfa.Enabled, &mfa.LastUsedStep)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, domain.ErrMFANotEnrolled
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting mfa: %w", err)
+	}
+	return mfa, nil
+}
+
+// SaveMFASecret stores a pending secret, it never replaces an enabled one.
+func (db *Database) SaveMFASecret(ctx context.Context, userID string, sealedSecret []byte) error {
+	query, err := db.pool.Exec(ctx, `
+		INSERT INTO user_mfa (user_id, secret) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, last_used_step = 0, created_at = now()
+		WHERE user_mfa.enabled_at IS NULL`, userID, sealedSecret)
+	if err != nil {
+		return fmt.Errorf("error saving mfa secret: %w", err)
+	}
+	if query.RowsAffected() == 0 {
+		return domain.ErrMFAAlreadyEnabled
+	}
+	return nil
+}
+
+func (db *Database) EnableMFA(ctx context.Context, userID string, step int64, recoveryCodeHashes []string) error {
+	return pgx.BeginFunc(ctx, db.pool, func(tx pgx.Tx) error {
+		query, err := tx.Exec(ctx, "UPDATE user_mfa SET enabled_at = now(), last_used_step = $2 WHERE user_id = $1 AND enabled_at IS NULL", userID, step)
+		if err != nil {
+			return fmt.Errorf("error enabling mfa: %w", err)
+		}
+		if query.RowsAffected() == 0 {
+			return domain.ErrMFAAlreadyEnabled
+		}
+		if _, err := tx.Exec(ctx, "DELETE FROM mfa_recovery_codes WHERE user_id = $1", userID); err != nil {
+			return err
+		}
+		for _, hash := range recoveryCodeHashes {
+			if _, err := tx.Exec(ctx, "INSERT INTO mfa_recovery_codes (user_id, code_hash) VALUES ($1, $2)", userID, hash); err != nil {
+				return fmt.Errorf("error storing recovery code: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// UseTOTPStep records the period of an accepted code. It fails when that
+// period or a later one was already used, so a code works once.
+func (db *Database) UseTOTPStep(ctx context.Context, userID string, step int64) error {
+	query, err := db.pool.Exec(ctx, "UPDATE user_mfa SET last_used_step = $2 WHERE user_id = $1 AND last_used_step < $2", userID, step)
+	if err != nil {
+		return fmt.Errorf("error using totp code: %w", err)
+	}
+	if query.RowsAffected() == 0 {
+		return domain.ErrInvalidMFACode
+	}
+	return nil
+}
+
+func (db *Database) UseRecoveryCode(ctx context.Context, userID string, codeHash string) error {
+	query, err := db.pool.Exec(ctx, "DELETE FROM mfa_recovery_codes WHERE user_id = $1 AND code_hash = $2", userID, codeHash)
+	if err != nil {
+		return fmt.Errorf("error using recovery code: %w", err)
+	}
+	if query.RowsAffected() == 0 {
+		return domain.ErrInvalidMFACode
+	}
+	return nil
+}
+
+func (db *Database) CreateMFAChallenge(ctx context.Context, userID string, tokenHash string, expiresAt time.Time) error {
+	_, err := db.pool.Exec(ctx, "INSERT INTO mfa_challenges (token_hash, user_id, expires_at) VALUES ($1, $2, $3)", tokenHash, userID, expiresAt)
+	if err != nil {
+		return fmt.Errorf("error creating mfa challenge: %w", err)
+	}
+	return nil
+}
+
+// AttemptMFAChallenge counts a code attempt against the challenge, which
+// stops working after maxAttempts.
+func (db *Database) AttemptMFAChallenge(ctx context.Context, tokenHash string, maxAttempts int) (string, error) {
+	var userID string
+	err := db.pool.QueryRow(ctx, `
+		UPDATE mfa_challenges SET attempts = attempts + 1
+		WHERE token_hash = $1 AND expires_at > now() AND attempts < $2
+		RETURNING user_id`, tokenHash, maxAttempts).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", domain.ErrInvalidMFAChallenge
+	}
+	if err != nil {
+		return "", fmt.Errorf("error checking mfa challenge: %w", err)
+	}
+	return userID, nil
+}
+
+func (db *Database) DeleteMFAChallenge(ctx context.Context, tokenHash string) error {
+	query, err := db.pool.Exec(ctx, "DELETE FROM mfa_challenges WHERE token_hash = $1", tokenHash)
+	if err != nil {
+		return fmt.Errorf("error deleting mfa challenge: %w", err)
+	}
+	if query.RowsAffected() == 0 {
+		return domain.ErrInvalidMFAChallenge
+	}
+	return nil
+}
+
+func (db *Database) ListRoles(ctx context.Context) ([]domain.Role, error) {
+	rows, err := db.pool.Query(ctx, `
+		SELECT r.name, r.description, r.is_default,
+			ARRAY(SELECT permission FROM role_permissions WHERE role = r.name ORDER BY permission)
+		FROM roles r ORDER BY r.name`)
+	if err != nil {
+		return nil, fmt.Errorf("error listing roles: %w", err)
+	}
+	defer rows.Close()
+
+	var roles []domain.Role
+	for rows.Next() {
+		var r domain.Role
+		if err := rows.Scan(&r.Name, &r.Description, &r.IsDefault, &r.Permissions); err != nil {
+			return nil, err
+		}
+		roles = append(roles, r)
+	}
+	return roles, rows.Err()
+}
+
+func (db *Database) GrantRole(ctx context.Context, userID string, role string) error {
+	_, err := db.pool.Exec(ctx, "INSERT INTO user_roles (user_id, role) VALUES ($1, $2) ON CONFLICT DO NOTHING", userID, role)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+		if pgErr.ConstraintName == "user_roles_role_fkey" {
+			return domain.ErrRoleNotFound
+		}
+		return domain.ErrUserNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("error granting role: %w", err)
+	}
+	return nil
+}
+
+func (db *Database) RevokeRole(ctx context.Context, userID string, role string) error {
+	query, err := db.pool.Exec(ctx, "DELETE FROM user_roles WHERE user_id = $1 AND role = $2", userID, role)
+	if err != nil {
+		return fmt.Errorf("error revoking role: %w", err)
+	}
+	if query.RowsAffected() == 0 {
+		return domain.ErrRoleNotFound
+	}
+	return nil
+}
+
 func (db *Database) CreateSession(ctx context.Context, session *domain.Session) (*domain.Session, error) {
-	_, err := db.pool.Exec(ctx, "INSERT INTO sessions (id, email, refresh_token, is_revoked, expires_at) VALUES ($1,$2,$3,$4,$5)", session.ID, session.Email, session.RefreshToken, session.IsRevoked, session.ExpiresAt)
+	_, err := db.pool.Exec(ctx,
+		"INSERT INTO sessions (id, user_id, refresh_token_hash, user_agent, ip, is_revoked, expires_at) VALUES ($1,$2,$3,$4,$5,$6,$7)",
+		session.ID, session.UserID, session.RefreshTokenHash, session.UserAgent, session.IP, session.IsRevoked, session.ExpiresAt)
 	if err != nil {
 		return nil, err
 	}
@@ -130,7 +459,12 @@ func (db *Database) CreateSession(ctx context.Context, session *domain.Session)
 
 func (db *Database) GetSession(ctx context.Context, id string) (*domain.Session, error) {
 	var s domain.Session
-	err := db.pool.QueryRow(ctx, `SELECT id, email, refresh_token, is_revoked, expires_at FROM sessions WHERE id = $1`, id).Scan(&s.ID, &s.Email, &s.RefreshToken, &s.IsRevoked, &s.ExpiresAt)
+	err := db.pool.QueryRow(ctx,
+		`SELECT id, user_id, COALESCE(profile_id::text, ''), refresh_token_hash, user_agent, ip, is_revoked, created_at, expires_at, last_used_at FROM sessions WHERE id = $1`,
+		id).Scan(&s.ID, &s.UserID, &s.ProfileID, &s.RefreshTokenHash, &s.UserAgent, &s.IP, &s.IsRevoked, &s.CreatedAt, &s.ExpiresAt, &s.LastUsedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, domain.ErrSessionNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -138,22 +472,69 @@ func (db *Database) GetSession(ctx context.Context, id string) (*domain.Session,
 }
 
 func (db *Database) RevokeSession(ctx context.Context, id string) error {
-	_, err := db.pool.Exec(ctx, "UPDATE sessions SET is_revoked = true WHERE id = $1", id)
+	_, err := db.pool.Exec(ctx, "UPDATE sessions SET is_revoked = true, revoked_at = COALESCE(revoked_at, now()) WHERE id = $1", id)
 	if err != nil {
 		return fmt.Errorf("error revoking session: %w", err)
 	}
 	return nil
 }
 
-func (db *Database) DeleteSession(ctx context.Context, id string) error {
-	query, err := db.pool.Exec(ctx, "DELETE FROM sessions WHERE id=$1", id)
+func (db *Database) ListSessions(ctx context.Context, userID string) ([]domain.Session, error) {
+	rows, err := db.pool.Query(ctx, `
+		SELECT id, user_id, user_agent, ip, is_revoked, created_at, expires_at, last_used_at FROM sessions
+		WHERE user_id = $1 AND NOT is_revoked AND expires_at > now()
+		ORDER BY last_used_at DESC`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error listing sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var sessions []domain.Session
+	for rows.Next() {
+		var s domain.Session
+		if err := rows.Scan(&s.ID, &s.UserID, &s.UserAgent, &s.IP, &s.IsRevoked, &s.CreatedAt, &s.ExpiresAt, &s.LastUsedAt); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
 
+func (db *Database) RevokeOwnedSession(ctx context.Context, userID string, id string) error {
+	query, err := db.pool.Exec(ctx, "UPDATE sessions SET is_revoked = true, revoked_at = COALESCE(revoked_at, now()) WHERE id = $1 AND user_id = $2", id, userID)
 	if err != nil {
-		return fmt.Errorf("error deleting session: %w", err)
+		return fmt.Errorf("error revoking session: %w", err)
 	}
+	if query.RowsAffected() == 0 {
+		return domain.ErrSessionNotFound
+	}
+	return nil
+}
 
+// RevokeUserSessions revokes every session of the user but exceptID, which
+// may be empty.
+func (db *Database) RevokeUserSessions(ctx context.Context, userID string, exceptID string) (int64, error) {
+	query, err := db.pool.Exec(ctx,
+		"UPDATE sessions SET is_revoked = true, revoked_at = now() WHERE user_id = $1 AND id::text <> $2 AND NOT is_revoked",
+		userID, exceptID)
+	if err != nil {
+		return 0, fmt.Errorf("error revoking sessions: %w", err)
+	}
+	return query.RowsAffected(), nil
+}
+
+// RotateSession swaps the refresh token only if the session still holds the
+// old one, so two concurrent renewals with the same token can't both win.
+func (db *Database) RotateSession(ctx context.Context, id string, oldTokenHash string, newTokenHash string, expiresAt time.Time, client domain.Client) error {
+	query, err := db.pool.Exec(ctx,
+		`UPDATE sessions SET refresh_token_hash = $3, expires_at = $4, user_agent = $5, ip = $6, last_used_at = now()
+		WHERE id = $1 AND refresh_token_hash = $2 AND NOT is_revoked`,
+		id, oldTokenHash, newTokenHash, expiresAt, client.UserAgent, client.IP)
+	if err != nil {
+		return fmt.Errorf("error rotating session: %w", err)
+	}
 	if query.RowsAffected() == 0 {
-		return fmt.Errorf("session doesn't exist")
+		return domain.ErrRefreshTokenReused
 	}
 	return nil
 }
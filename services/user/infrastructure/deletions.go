@@ -0,0 +1,211 @@
+package infrastructure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/eduardo-ax/video-streaming/services/user/domain"
+	"github.com/jackc/pgx/v5"
+)
+
+// ScheduleDeletion freezes an active account and revokes its sessions in the
+// same transaction, like a suspension.
+func (db *Database) ScheduleDeletion(ctx context.Context, id string, undoHash string, scheduledFor time.Time) error {
+	return pgx.BeginFunc(ctx, db.pool, func(tx pgx.Tx) error {
+		query, err := tx.Exec(ctx, `
+			UPDATE users SET status = 'pending_deletion', deletion_requested_at = now(),
+				deletion_scheduled_for = $2, deletion_undo_hash = $3
+			WHERE id = $1 AND status = 'active'`,
+			id, scheduledFor, undoHash)
+		if err != nil {
+			return fmt.Errorf("error scheduling deletion: %w", err)
+		}
+		if query.RowsAffected() == 0 {
+			return domain.ErrUserNotFound
+		}
+		_, err = tx.Exec(ctx,
+			"UPDATE sessions SET is_revoked = true, revoked_at = now() WHERE user_id = $1 AND NOT is_revoked", id)
+		if err != nil {
+			return fmt.Errorf("error revoking sessions: %w", err)
+		}
+		return nil
+	})
+}
+
+// CancelDeletion only restores accounts whose grace period isn't over, the
+// sweeper may not have erased them yet.
+func (db *Database) CancelDeletion(ctx context.Context, undoHash string) (string, error) {
+	var id string
+	err := db.pool.QueryRow(ctx, `
+		UPDATE users SET status = 'active', deletion_requested_at = NULL,
+			deletion_scheduled_for = NULL, deletion_undo_hash = NULL
+		WHERE deletion_undo_hash = $1 AND status = 'pending_deletion' AND deletion_scheduled_for > now()
+		RETURNING id::text`, undoHash).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", domain.ErrInvalidUndoToken
+	}
+	if err != nil {
+		return "", fmt.Errorf("error cancelling deletion: %w", err)
+	}
+	return id, nil
+}
+
+func (db *Database) ListDueDeletions(ctx context.Context, now time.Time) ([]string, error) {
+	rows, err := db.pool.Query(ctx, `
+		SELECT id::text FROM users
+		WHERE status = 'pending_deletion' AND deletion_scheduled_for <= $1
+		ORDER BY deletion_scheduled_for`, now)
+	if err != nil {
+		return nil, fmt.Errorf("error listing due deletions: %w", err)
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// EraseUser deletes the user, the rows referencing them go with the cascade.
+// The deletion row keeps track of what other services still have to erase,
+// and the user's exports expire for the sweeper to delete the archives. The
+// audit events about the user stay, stripped of IP addresses, user agents
+// and emails.
+func (db *Database) EraseUser(ctx context.Context, id string) (*domain.AccountDeletion, error) {
+	deletion := &domain.AccountDeletion{UserID: id}
+	err := pgx.BeginFunc(ctx, db.pool, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx, `
+			DELETE FROM users WHERE id = $1
+			RETURNING email, COALESCE(deletion_requested_at, now())`,
+			id).Scan(&deletion.Email, &deletion.RequestedAt)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return domain.ErrUserNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("error deleting user: %w", err)
+		}
+		_, err = tx.Exec(ctx, `
+			UPDATE data_exports SET
+				status = CASE WHEN status = 'ready' THEN status ELSE 'failed' END,
+				expires_at = CASE WHEN status = 'ready' THEN now() ELSE expires_at END
+			WHERE user_id = $1 AND status IN ('pending', 'running', 'ready')`, id)
+		if err != nil {
+			return fmt.Errorf("error expiring exports: %w", err)
+		}
+		if _, err := tx.Exec(ctx, "SELECT anonymize_audit_events($1)", id); err != nil {
+			return fmt.Errorf("error anonymizing audit events: %w", err)
+		}
+		return tx.QueryRow(ctx, `
+			INSERT INTO account_deletions (user_id, email, requested_at)
+			VALUES ($1, $2, $3) RETURNING id::text, deleted_at`,
+			id, deletion.Email, deletion.RequestedAt,
+		).Scan(&deletion.ID, &deletion.DeletedAt)
+	})
+	if errors.Is(err, domain.ErrUserNotFound) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error erasing user: %w", err)
+	}
+	return deletion, nil
+}
+
+func (db *Database) MarkDeletionPublished(ctx context.Context, id string) error {
+	_, err := db.pool.Exec(ctx, "UPDATE account_deletions SET published_at = now() WHERE id::text = $1", id)
+	if err != nil {
+		return fmt.Errorf("error marking deletion published: %w", err)
+	}
+	return nil
+}
+
+// ListUnconfirmedDeletions lists the deletions never published and those
+// published before publishedBefore that aren't completed.
+func (db *Database) ListUnconfirmedDeletions(ctx context.Context, publishedBefore time.Time) ([]domain.AccountDeletion, error) {
+	rows, err := db.pool.Query(ctx, `
+		SELECT id::text, user_id::text, email, requested_at, deleted_at, published_at, completed_at
+		FROM account_deletions
+		WHERE completed_at IS NULL AND (published_at IS NULL OR published_at < $1)
+		ORDER BY deleted_at`, publishedBefore)
+	if err != nil {
+		return nil, fmt.Errorf("error listing unconfirmed deletions: %w", err)
+	}
+	defer rows.Close()
+	var deletions []domain.AccountDeletion
+	for rows.Next() {
+		var d domain.AccountDeletion
+		if err := rows.Scan(&d.ID, &d.UserID, &d.Email, &d.RequestedAt, &d.DeletedAt, &d.PublishedAt, &d.CompletedAt); err != nil {
+			return nil, err
+		}
+		deletions = append(deletions, d)
+	}
+	return deletions, rows.Err()
+}
+
+// ConfirmDeletion locks the deletion, confirmations of two services arriving
+// together both see each other and one of them completes it.
+func (db *Database) ConfirmDeletion(ctx context.Context, id string, userID string, service string) ([]string, error) {
+	var confirmed []string
+	err := pgx.BeginFunc(ctx, db.pool, func(tx pgx.Tx) error {
+		var deletionID string
+		err := tx.QueryRow(ctx,
+			"SELECT id::text FROM account_deletions WHERE id::text = $1 AND user_id::text = $2 FOR UPDATE",
+			id, userID).Scan(&deletionID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return domain.ErrDeletionNotFound
+		}
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx,
+			"INSERT INTO account_deletion_confirmations (deletion_id, service) VALUES ($1, $2) ON CONFLICT DO NOTHING",
+			deletionID, service)
+		if err != nil {
+			return err
+		}
+		rows, err := tx.Query(ctx,
+			"SELECT service FROM account_deletion_confirmations WHERE deletion_id = $1 ORDER BY service", deletionID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var s string
+			if err := rows.Scan(&s); err != nil {
+				return err
+			}
+			confirmed = append(confirmed, s)
+		}
+		return rows.Err()
+	})
+	if errors.Is(err, domain.ErrDeletionNotFound) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error confirming deletion: %w", err)
+	}
+	return confirmed, nil
+}
+
+// CompleteDeletion clears the email it returns, the account is gone
+// everywhere and nothing identifies the user any more.
+func (db *Database) CompleteDeletion(ctx context.Context, id string) (string, error) {
+	var email string
+	err := db.pool.QueryRow(ctx, `
+		UPDATE account_deletions d SET completed_at = now(), email = ''
+		FROM (SELECT id, email FROM account_deletions WHERE id::text = $1 AND completed_at IS NULL FOR UPDATE) old
+		WHERE d.id = old.id
+		RETURNING old.email`, id).Scan(&email)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", domain.ErrDeletionNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("error completing deletion: %w", err)
+	}
+	return email, nil
+}
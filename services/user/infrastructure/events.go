@@ -0,0 +1,108 @@
+package infrastructure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/eduardo-ax/video-streaming/pkg/events"
+	"github.com/eduardo-ax/video-streaming/pkg/messagebus"
+	"github.com/eduardo-ax/video-streaming/pkg/messagebus/kafka"
+	"github.com/eduardo-ax/video-streaming/pkg/messagebus/postgres"
+	"github.com/eduardo-ax/video-streaming/pkg/telemetry"
+	"github.com/eduardo-ax/video-streaming/services/user/config"
+	"github.com/eduardo-ax/video-streaming/services/user/domain"
+)
+
+func NewMessageBus(cfg config.MessageBus) (messagebus.Bus, error) {
+	policy := messagebus.DefaultRetryPolicy()
+
+	switch cfg.Driver {
+	case "kafka":
+		return kafka.NewBus(cfg.Brokers, policy)
+	case "postgres":
+		bus := postgres.NewBus(NewPool(cfg.DatabaseURL), policy, 5*time.Minute)
+		if err := bus.EnsureSchema(context.Background()); err != nil {
+			return nil, err
+		}
+		return bus, nil
+	default:
+		return nil, fmt.Errorf("unknown message bus driver %q", cfg.Driver)
+	}
+}
+
+// EventPublisher publishes user events keyed by user ID, so the events of a
+// user keep their order within a partition.
+type EventPublisher struct {
+	bus   messagebus.Publisher
+	topic string
+}
+
+func NewEventPublisher(bus messagebus.Publisher, topic string) *EventPublisher {
+	return &EventPublisher{
+		bus:   bus,
+		topic: topic,
+	}
+}
+
+func (p *EventPublisher) Close() error {
+	return p.bus.Close()
+}
+
+func (p *EventPublisher) Ping(ctx context.Context) error {
+	if pinger, ok := p.bus.(messagebus.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (p *EventPublisher) PublishUserEvent(ctx context.Context, event events.UserEvent) error {
+	msg := messagebus.NewMessage(event.UserID, nil)
+	ctx, span := telemetry.StartPublishSpan(ctx, p.topic, msg)
+	defer span.End()
+
+	value, err := events.Encode(event)
+	if err != nil {
+		return telemetry.RecordError(span, err)
+	}
+	msg.Value = value
+	msg.SetHeader(events.HeaderContentType, events.ContentType)
+	msg.SetHeader(events.HeaderSchemaVersion, fmt.Sprintf("%d", event.Version))
+	msg.SetHeader(events.HeaderEventType, event.Type)
+	return telemetry.RecordError(span, p.bus.Publish(ctx, p.topic, msg))
+}
+
+// EventConsumer delivers the user events of a topic to a handler. Messages
+// that don't decode and confirmations of unknown deletions are never
+// redelivered.
+type EventConsumer struct {
+	bus   messagebus.Subscriber
+	topic string
+	group string
+}
+
+func NewEventConsumer(bus messagebus.Subscriber, topic string, group string) *EventConsumer {
+	return &EventConsumer{
+		bus:   bus,
+		topic: topic,
+		group: group,
+	}
+}
+
+func (c *EventConsumer) Consume(ctx context.Context, handler func(ctx context.Context, event events.UserEvent) error) error {
+	return c.bus.Subscribe(ctx, c.topic, c.group, func(ctx context.Context, msg *messagebus.Message) error {
+		ctx, span := telemetry.StartConsumeSpan(ctx, msg)
+		defer span.End()
+
+		event, err := events.Decode(msg.Value)
+		if err != nil {
+			return telemetry.RecordError(span, messagebus.Permanent(err))
+		}
+		err = handler(ctx, event)
+		if errors.Is(err, domain.ErrDeletionNotFound) {
+			err = messagebus.Permanent(err)
+		}
+		return telemetry.RecordError(span, err)
+	})
+}
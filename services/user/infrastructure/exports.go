@@ -0,0 +1,165 @@
+package infrastructure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/eduardo-ax/video-streaming/services/user/domain"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const selectExport = `SELECT id::text, user_id::text, status, object_key, attempts, requested_at, completed_at, expires_at FROM data_exports `
+
+func scanExport(row pgx.Row) (*domain.DataExport, error) {
+	e := &domain.DataExport{}
+	err := row.Scan(&e.ID, &e.UserID, &e.Status, &e.ObjectKey, &e.Attempts, &e.RequestedAt, &e.CompletedAt, &e.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (db *Database) ListAllSessions(ctx context.Context, userID string) ([]domain.Session, error) {
+	rows, err := db.pool.Query(ctx, `
+		SELECT id, user_id, COALESCE(profile_id::text, ''), user_agent, ip, is_revoked, created_at, expires_at, last_used_at
+		FROM sessions WHERE user_id = $1
+		ORDER BY created_at DESC`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error listing sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var sessions []domain.Session
+	for rows.Next() {
+		var s domain.Session
+		if err := rows.Scan(&s.ID, &s.UserID, &s.ProfileID, &s.UserAgent, &s.IP, &s.IsRevoked, &s.CreatedAt, &s.ExpiresAt, &s.LastUsedAt); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+func (db *Database) CreateExport(ctx context.Context, userID string) (*domain.DataExport, error) {
+	export, err := scanExport(db.pool.QueryRow(ctx, `
+		INSERT INTO data_exports (user_id) VALUES ($1)
+		RETURNING id::text, user_id::text, status, object_key, attempts, requested_at, completed_at, expires_at`, userID))
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "data_exports_in_progress_idx" {
+		return nil, domain.ErrExportInProgress
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error creating export: %w", err)
+	}
+	return export, nil
+}
+
+func (db *Database) ListExports(ctx context.Context, userID string) ([]domain.DataExport, error) {
+	rows, err := db.pool.Query(ctx, selectExport+"WHERE user_id::text = $1 ORDER BY requested_at DESC", userID)
+	if err != nil {
+		return nil, fmt.Errorf("error listing exports: %w", err)
+	}
+	defer rows.Close()
+
+	var exports []domain.DataExport
+	for rows.Next() {
+		e, err := scanExport(rows)
+		if err != nil {
+			return nil, err
+		}
+		exports = append(exports, *e)
+	}
+	return exports, rows.Err()
+}
+
+// ClaimExport skips the exports locked by other workers, each replica builds
+// a different one.
+func (db *Database) ClaimExport(ctx context.Context, staleBefore time.Time) (*domain.DataExport, error) {
+	export, err := scanExport(db.pool.QueryRow(ctx, `
+		UPDATE data_exports SET status = 'running', started_at = now(), attempts = attempts + 1
+		WHERE id = (
+			SELECT id FROM data_exports
+			WHERE status = 'pending' OR (status = 'running' AND started_at < $1)
+			ORDER BY requested_at
+			LIMIT 1 FOR UPDATE SKIP LOCKED)
+		RETURNING id::text, user_id::text, status, object_key, attempts, requested_at, completed_at, expires_at`, staleBefore))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, domain.ErrExportNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error claiming export: %w", err)
+	}
+	return export, nil
+}
+
+func (db *Database) CompleteExport(ctx context.Context, id string, objectKey string, tokenHash string, expiresAt time.Time) error {
+	query, err := db.pool.Exec(ctx, `
+		UPDATE data_exports SET status = 'ready', object_key = $2, token_hash = $3, completed_at = now(), expires_at = $4
+		WHERE id::text = $1 AND status = 'running'`,
+		id, objectKey, tokenHash, expiresAt)
+	if err != nil {
+		return fmt.Errorf("error completing export: %w", err)
+	}
+	if query.RowsAffected() == 0 {
+		return domain.ErrExportNotFound
+	}
+	return nil
+}
+
+func (db *Database) FailExport(ctx context.Context, id string, retry bool) error {
+	status := domain.ExportFailed
+	if retry {
+		status = domain.ExportPending
+	}
+	_, err := db.pool.Exec(ctx, "UPDATE data_exports SET status = $2 WHERE id::text = $1 AND status = 'running'", id, status)
+	if err != nil {
+		return fmt.Errorf("error failing export: %w", err)
+	}
+	return nil
+}
+
+// GetExportByToken only finds the ready exports of active accounts, the
+// link stops working as soon as it expires or the account is frozen.
+func (db *Database) GetExportByToken(ctx context.Context, tokenHash string) (*domain.DataExport, error) {
+	export, err := scanExport(db.pool.QueryRow(ctx, `
+		SELECT e.id::text, e.user_id::text, e.status, e.object_key, e.attempts, e.requested_at, e.completed_at, e.expires_at
+		FROM data_exports e JOIN users u ON u.id = e.user_id
+		WHERE e.token_hash = $1 AND e.status = 'ready' AND e.expires_at > now() AND u.status = 'active'`,
+		tokenHash))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, domain.ErrExportNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting export: %w", err)
+	}
+	return export, nil
+}
+
+func (db *Database) ListExpiredExports(ctx context.Context, now time.Time) ([]domain.DataExport, error) {
+	rows, err := db.pool.Query(ctx, selectExport+"WHERE status = 'ready' AND expires_at <= $1 ORDER BY expires_at", now)
+	if err != nil {
+		return nil, fmt.Errorf("error listing expired exports: %w", err)
+	}
+	defer rows.Close()
+
+	var exports []domain.DataExport
+	for rows.Next() {
+		e, err := scanExport(rows)
+		if err != nil {
+			return nil, err
+		}
+		exports = append(exports, *e)
+	}
+	return exports, rows.Err()
+}
+
+func (db *Database) ExpireExport(ctx context.Context, id string) error {
+	_, err := db.pool.Exec(ctx, "UPDATE data_exports SET status = 'expired', token_hash = NULL WHERE id::text = $1", id)
+	if err != nil {
+		return fmt.Errorf("error expiring export: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package infrastructure
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// AddFailure records a failed login and returns the failures of key since
+// the start of the window, older rows of the key are pruned on the way.
+func (db *Database) AddFailure(ctx context.Context, key string, at time.Time, since time.Time) (int, error) {
+	var count int
+	err := pgx.BeginFunc(ctx, db.pool, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, "DELETE FROM login_failures WHERE key = $1 AND failed_at <= $2", key, since); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, "INSERT INTO login_failures (key, failed_at) VALUES ($1, $2)", key, at); err != nil {
+			return err
+		}
+		return tx.QueryRow(ctx, "SELECT count(*) FROM login_failures WHERE key = $1 AND failed_at > $2", key, since).Scan(&count)
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error recording login failure: %w", err)
+	}
+	return count, nil
+}
+
+func (db *Database) Failures(ctx context.Context, key string, since time.Time) (int, time.Time, error) {
+	var count int
+	var last *time.Time
+	err := db.pool.QueryRow(ctx, "SELECT count(*), max(failed_at) FROM login_failures WHERE key = $1 AND failed_at > $2", key, since).
+		Scan(&count, &last)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("error counting login failures: %w", err)
+	}
+	if last == nil {
+		return 0, time.Time{}, nil
+	}
+	return count, *last, nil
+}
+
+func (db *Database) ResetFailures(ctx context.Context, key string) error {
+	if _, err := db.pool.Exec(ctx, "DELETE FROM login_failures WHERE key = $1", key); err != nil {
+		return fmt.Errorf("error resetting login failures: %w", err)
+	}
+	return nil
+}
+
+func (db *Database) DeleteFailures(ctx context.Context, before time.Time) (int64, error) {
+	query, err := db.pool.Exec(ctx, "DELETE FROM login_failures WHERE failed_at <= $1", before)
+	if err != nil {
+		return 0, fmt.Errorf("error deleting login failures: %w", err)
+	}
+	return query.RowsAffected(), nil
+}
+
+// MemoryAttemptStore keeps the failures in process, it suits deployments
+// with a single instance of the service.
+type MemoryAttemptStore struct {
+	mu       sync.Mutex
+	failures map[string][]time.Time
+}
+
+func NewMemoryAttemptStore() *MemoryAttemptStore {
+	return &MemoryAttemptStore{
+		failures: map[string][]time.Time{},
+	}
+}
+
+func (m *MemoryAttemptStore) AddFailure(ctx context.Context, key string, at time.Time, since time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failures[key] = append(m.prune(key, since), at)
+	return len(m.failures[key]), nil
+}
+
+func (m *MemoryAttemptStore) Failures(ctx context.Context, key string, since time.Time) (int, time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	failures := m.prune(key, since)
+	if len(failures) == 0 {
+		return 0, time.Time{}, nil
+	}
+	return len(failures), failures[len(failures)-1], nil
+}
+
+func (m *MemoryAttemptStore) ResetFailures(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.failures, key)
+	return nil
+}
+
+func (m *MemoryAttemptStore) DeleteFailures(ctx context.Context, before time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var deleted int64
+	for key, failures := range m.failures {
+		deleted += int64(len(failures) - len(m.prune(key, before)))
+	}
+	return deleted, nil
+}
+
+// prune drops the failures of key outside the window, failures are kept in
+// the order they happened.
+func (m *MemoryAttemptStore) prune(key string, since time.Time) []time.Time {
+	failures := m.failures[key]
+	i := 0
+	for i < len(failures) && !failures[i].After(since) {
+		i++
+	}
+	if i == len(failures) {
+		delete(m.failures, key)
+		return nil
+	}
+	m.failures[key] = failures[i:]
+	return m.failures[key]
+}
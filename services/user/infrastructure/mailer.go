@@ -0,0 +1,86 @@
+package infrastructure
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/eduardo-ax/video-streaming/services/user/domain"
+)
+
+type SMTPMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+func NewSMTPMailer(addr string, from string, username string, password string) *SMTPMailer {
+	var auth smtp.Auth
+	if username != "" {
+		host, _, _ := net.SplitHostPort(addr)
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+	return &SMTPMailer{
+		addr: addr,
+		from: from,
+		auth: auth,
+	}
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, email domain.Email) error {
+	if err := smtp.SendMail(m.addr, m.auth, m.from, []string{email.To}, message(m.from, email)); err != nil {
+		return fmt.Errorf("error sending email: %w", err)
+	}
+	return nil
+}
+
+// LogMailer is meant for local development and tests: messages are written
+// as .eml files to dir, or to the log when dir is empty.
+type LogMailer struct {
+	from string
+	dir  string
+}
+
+func NewLogMailer(from string, dir string) *LogMailer {
+	return &LogMailer{
+		from: from,
+		dir:  dir,
+	}
+}
+
+func (m *LogMailer) Send(ctx context.Context, email domain.Email) error {
+	msg := message(m.from, email)
+	if m.dir == "" {
+		log.Printf("email to %s:\n%s", email.To, msg)
+		return nil
+	}
+	if err := os.MkdirAll(m.dir, 0755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), strings.ReplaceAll(email.To, "@", "_at_"))
+	return os.WriteFile(filepath.Join(m.dir, filepath.Base(name)), msg, 0644)
+}
+
+func message(from string, email domain.Email) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", headerValue(from))
+	fmt.Fprintf(&b, "To: %s\r\n", headerValue(email.To))
+	fmt.Fprintf(&b, "Subject: %s\r\n", headerValue(email.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(email.Body, "\n", "\r\n"))
+	return b.Bytes()
+}
+
+// headerValue drops line breaks so a value can't inject extra headers.
+func headerValue(v string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(v)
+}
@@ -0,0 +1,6 @@
+package infrastructure
+
+import "embed"
+
+//go:embed migrations/*.sql
+var Migrations embed.FS
@@ -5,4 +5,4 @@ CREATE TABLE IF NOT EXISTS sessions (
     is_revoked BOOLEAN NOT NULL DEFAULT FALSE,
     created_at TIMESTAMP DEFAULT (now()),
     expires_at TIMESTAMP
-);
\ No newline at end of file
+);
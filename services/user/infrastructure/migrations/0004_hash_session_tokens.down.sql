@@ -0,0 +1,13 @@
+DELETE FROM sessions;
+
+DROP INDEX IF EXISTS sessions_user_id_idx;
+
+ALTER TABLE sessions
+    ALTER COLUMN created_at DROP NOT NULL,
+    DROP COLUMN user_id,
+    DROP COLUMN refresh_token_hash,
+    DROP COLUMN user_agent,
+    DROP COLUMN ip,
+    DROP COLUMN last_used_at,
+    ADD COLUMN email TEXT NOT NULL,
+    ADD COLUMN refresh_token TEXT NOT NULL;
@@ -0,0 +1,17 @@
+-- Sessions stored the raw refresh token and were keyed by email. Existing
+-- sessions can't be migrated without keeping their tokens usable, so every
+-- user logs in again.
+DELETE FROM sessions;
+
+ALTER TABLE sessions
+    DROP COLUMN email,
+    DROP COLUMN refresh_token,
+    ADD COLUMN user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    ADD COLUMN refresh_token_hash TEXT NOT NULL,
+    ADD COLUMN user_agent TEXT NOT NULL DEFAULT '',
+    ADD COLUMN ip TEXT NOT NULL DEFAULT '',
+    ADD COLUMN last_used_at TIMESTAMP NOT NULL DEFAULT (now());
+
+ALTER TABLE sessions ALTER COLUMN created_at SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS sessions_user_id_idx ON sessions (user_id);
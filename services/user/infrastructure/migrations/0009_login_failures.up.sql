@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS login_failures (
+    key TEXT NOT NULL,
+    failed_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS login_failures_key_failed_at_idx ON login_failures (key, failed_at);
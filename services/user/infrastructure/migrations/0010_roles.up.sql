@@ -0,0 +1,52 @@
+CREATE TABLE IF NOT EXISTS roles (
+    name TEXT PRIMARY KEY,
+    description TEXT NOT NULL,
+    -- Roles granted to every new account.
+    is_default BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE TABLE IF NOT EXISTS permissions (
+    name TEXT PRIMARY KEY,
+    description TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role TEXT NOT NULL REFERENCES roles (name) ON DELETE CASCADE,
+    permission TEXT NOT NULL REFERENCES permissions (name) ON DELETE CASCADE,
+    PRIMARY KEY (role, permission)
+);
+
+CREATE TABLE IF NOT EXISTS user_roles (
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    role TEXT NOT NULL REFERENCES roles (name) ON DELETE CASCADE,
+    granted_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, role)
+);
+
+INSERT INTO roles (name, description, is_default) VALUES
+    ('admin', 'Moderates any video and manages users', FALSE),
+    ('creator', 'Uploads and manages their own videos', TRUE),
+    ('viewer', 'Watches videos', FALSE);
+
+INSERT INTO permissions (name, description) VALUES
+    ('videos:watch', 'Watch videos'),
+    ('videos:upload', 'Upload videos'),
+    ('videos:delete:own', 'Delete videos they uploaded'),
+    ('videos:delete:any', 'Delete any video'),
+    ('users:read', 'List and look up accounts'),
+    ('users:manage', 'Change roles, suspend and delete accounts');
+
+INSERT INTO role_permissions (role, permission) VALUES
+    ('viewer', 'videos:watch'),
+    ('creator', 'videos:watch'),
+    ('creator', 'videos:upload'),
+    ('creator', 'videos:delete:own'),
+    ('admin', 'videos:watch'),
+    ('admin', 'videos:upload'),
+    ('admin', 'videos:delete:own'),
+    ('admin', 'videos:delete:any'),
+    ('admin', 'users:read'),
+    ('admin', 'users:manage');
+
+-- Every account could upload before roles existed.
+INSERT INTO user_roles (user_id, role) SELECT id, 'creator' FROM users;
@@ -0,0 +1,11 @@
+DROP INDEX IF EXISTS sessions_revoked_at_idx;
+ALTER TABLE sessions DROP COLUMN revoked_at;
+
+DROP INDEX IF EXISTS users_created_at_idx;
+ALTER TABLE users
+    DROP COLUMN created_at,
+    DROP COLUMN suspension_reason,
+    DROP COLUMN suspended_at,
+    DROP COLUMN status;
+
+ALTER TABLE users ALTER COLUMN plan TYPE TEXT USING plan::text;
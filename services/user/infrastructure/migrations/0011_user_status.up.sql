@@ -0,0 +1,18 @@
+-- plan was created as TEXT while the service reads and writes a number.
+ALTER TABLE users ALTER COLUMN plan TYPE SMALLINT
+    USING CASE WHEN plan ~ '^[0-9]+$' THEN plan::smallint ELSE 0 END;
+
+ALTER TABLE users
+    ADD COLUMN status TEXT NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'suspended')),
+    ADD COLUMN suspended_at TIMESTAMPTZ,
+    ADD COLUMN suspension_reason TEXT NOT NULL DEFAULT '',
+    ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+CREATE INDEX IF NOT EXISTS users_created_at_idx ON users (created_at DESC);
+
+-- Services reject access tokens of sessions revoked during the lifetime of
+-- an access token, they need to know when.
+ALTER TABLE sessions ADD COLUMN revoked_at TIMESTAMPTZ;
+UPDATE sessions SET revoked_at = last_used_at WHERE is_revoked;
+
+CREATE INDEX IF NOT EXISTS sessions_revoked_at_idx ON sessions (revoked_at) WHERE revoked_at IS NOT NULL;
@@ -0,0 +1,6 @@
+ALTER TABLE users
+    DROP COLUMN IF EXISTS plan_changed_at,
+    DROP CONSTRAINT IF EXISTS users_plan_fkey,
+    ALTER COLUMN plan DROP DEFAULT;
+
+DROP TABLE IF EXISTS plans;
@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS plans (
+    id SMALLINT PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    description TEXT NOT NULL DEFAULT '',
+    price_cents INT NOT NULL DEFAULT 0 CHECK (price_cents >= 0),
+    currency TEXT NOT NULL DEFAULT 'USD',
+    max_streams INT NOT NULL CHECK (max_streams > 0),
+    max_profiles INT NOT NULL CHECK (max_profiles > 0),
+    max_resolution INT NOT NULL,
+    features TEXT[] NOT NULL DEFAULT '{}',
+    -- The plan of new accounts.
+    is_default BOOLEAN NOT NULL DEFAULT FALSE,
+    -- Users can only switch themselves to public plans, admins to any.
+    is_public BOOLEAN NOT NULL DEFAULT TRUE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS plans_default_idx ON plans (is_default) WHERE is_default;
+
+INSERT INTO plans (id, name, description, price_cents, max_streams, max_profiles, max_resolution, features, is_default) VALUES
+    (0, 'free', 'Watch in SD on one screen', 0, 1, 1, 480, '{}', TRUE),
+    (1, 'standard', 'Full HD on two screens', 999, 2, 3, 1080, '{hd}', FALSE),
+    (2, 'premium', '4K on four screens with downloads', 1799, 4, 5, 2160, '{hd,uhd,downloads}', FALSE);
+
+UPDATE users SET plan = 0 WHERE plan NOT IN (SELECT id FROM plans);
+
+ALTER TABLE users
+    ALTER COLUMN plan SET DEFAULT 0,
+    ADD CONSTRAINT users_plan_fkey FOREIGN KEY (plan) REFERENCES plans (id),
+    ADD COLUMN plan_changed_at TIMESTAMPTZ;
@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS subscriptions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    plan SMALLINT NOT NULL REFERENCES plans (id),
+    status TEXT NOT NULL CHECK (status IN ('incomplete', 'trialing', 'active', 'past_due', 'canceled')),
+    provider_customer_id TEXT NOT NULL,
+    provider_subscription_id TEXT NOT NULL UNIQUE,
+    trial_ends_at TIMESTAMPTZ,
+    current_period_end TIMESTAMPTZ,
+    grace_ends_at TIMESTAMPTZ,
+    cancel_at_period_end BOOLEAN NOT NULL DEFAULT FALSE,
+    canceled_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- A user has at most one subscription that isn't canceled.
+CREATE UNIQUE INDEX IF NOT EXISTS subscriptions_live_idx ON subscriptions (user_id) WHERE status <> 'canceled';
+CREATE INDEX IF NOT EXISTS subscriptions_user_idx ON subscriptions (user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS subscriptions_grace_idx ON subscriptions (grace_ends_at) WHERE status = 'past_due';
+
+-- Webhooks already applied, providers deliver at least once.
+CREATE TABLE IF NOT EXISTS payment_events (
+    id TEXT PRIMARY KEY,
+    type TEXT NOT NULL,
+    provider_subscription_id TEXT NOT NULL,
+    processed_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,6 @@
+ALTER TABLE users
+    DROP COLUMN timezone,
+    DROP COLUMN locale,
+    DROP COLUMN bio,
+    DROP COLUMN avatar_key,
+    DROP COLUMN display_name;
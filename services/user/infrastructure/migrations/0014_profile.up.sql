@@ -0,0 +1,6 @@
+ALTER TABLE users
+    ADD COLUMN display_name TEXT NOT NULL DEFAULT '' CHECK (char_length(display_name) <= 40),
+    ADD COLUMN avatar_key TEXT NOT NULL DEFAULT '',
+    ADD COLUMN bio TEXT NOT NULL DEFAULT '' CHECK (char_length(bio) <= 280),
+    ADD COLUMN locale TEXT NOT NULL DEFAULT '',
+    ADD COLUMN timezone TEXT NOT NULL DEFAULT '';
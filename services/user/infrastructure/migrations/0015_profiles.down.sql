@@ -0,0 +1,2 @@
+ALTER TABLE sessions DROP COLUMN profile_id;
+DROP TABLE IF EXISTS profiles;
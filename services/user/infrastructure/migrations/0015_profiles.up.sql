@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS profiles (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    avatar TEXT NOT NULL DEFAULT '',
+    max_maturity SMALLINT NOT NULL DEFAULT 18 CHECK (max_maturity IN (0, 7, 13, 16, 18)),
+    pin_hash TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (user_id, name)
+);
+
+-- The profile a session selected, renewals put it back in the access token.
+ALTER TABLE sessions ADD COLUMN profile_id UUID REFERENCES profiles (id) ON DELETE SET NULL;
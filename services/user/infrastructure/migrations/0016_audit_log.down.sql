@@ -0,0 +1,17 @@
+DROP TRIGGER IF EXISTS audit_events_no_truncate ON audit_events;
+DROP TRIGGER IF EXISTS audit_events_no_change ON audit_events;
+DROP FUNCTION IF EXISTS audit_events_append_only();
+
+DROP INDEX IF EXISTS audit_events_created_at_idx;
+DROP INDEX IF EXISTS audit_events_actor_id_idx;
+
+UPDATE audit_events SET metadata = metadata || jsonb_strip_nulls(jsonb_build_object(
+    'by', actor_id::text,
+    'ip', NULLIF(ip, ''),
+    'user_agent', NULLIF(user_agent, '')
+));
+
+ALTER TABLE audit_events
+    DROP COLUMN IF EXISTS user_agent,
+    DROP COLUMN IF EXISTS ip,
+    DROP COLUMN IF EXISTS actor_id;
@@ -0,0 +1,32 @@
+-- user_id is the account an event is about, actor_id who caused it. Admin
+-- actions used to keep the actor in metadata.
+ALTER TABLE audit_events
+    ADD COLUMN IF NOT EXISTS actor_id UUID,
+    ADD COLUMN IF NOT EXISTS ip TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS user_agent TEXT NOT NULL DEFAULT '';
+
+UPDATE audit_events SET
+    actor_id = NULLIF(metadata->>'by', '')::uuid,
+    ip = COALESCE(metadata->>'ip', ''),
+    user_agent = COALESCE(metadata->>'user_agent', ''),
+    metadata = metadata - 'by' - 'ip' - 'user_agent'
+WHERE metadata ?| ARRAY['by', 'ip', 'user_agent'];
+
+CREATE INDEX IF NOT EXISTS audit_events_actor_id_idx ON audit_events (actor_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS audit_events_created_at_idx ON audit_events (created_at DESC);
+
+-- The log is append-only: entries can't be changed or removed, not even by
+-- the service's own database user.
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_events_no_change ON audit_events;
+CREATE TRIGGER audit_events_no_change BEFORE UPDATE OR DELETE ON audit_events
+    FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();
+
+DROP TRIGGER IF EXISTS audit_events_no_truncate ON audit_events;
+CREATE TRIGGER audit_events_no_truncate BEFORE TRUNCATE ON audit_events
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_events_append_only();
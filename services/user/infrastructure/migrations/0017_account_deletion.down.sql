@@ -0,0 +1,12 @@
+DROP TABLE IF EXISTS account_deletion_confirmations;
+DROP TABLE IF EXISTS account_deletions;
+
+DROP INDEX IF EXISTS users_deletion_scheduled_for_idx;
+UPDATE users SET status = 'active' WHERE status = 'pending_deletion';
+ALTER TABLE users
+    DROP COLUMN IF EXISTS deletion_undo_hash,
+    DROP COLUMN IF EXISTS deletion_scheduled_for,
+    DROP COLUMN IF EXISTS deletion_requested_at;
+
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_status_check;
+ALTER TABLE users ADD CONSTRAINT users_status_check CHECK (status IN ('active', 'suspended'));
@@ -0,0 +1,35 @@
+-- Accounts asked to be deleted are kept frozen during a grace period, the
+-- undo link mailed to the user restores them.
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_status_check;
+ALTER TABLE users ADD CONSTRAINT users_status_check CHECK (status IN ('active', 'suspended', 'pending_deletion'));
+
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS deletion_requested_at TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS deletion_scheduled_for TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS deletion_undo_hash TEXT UNIQUE;
+
+CREATE INDEX IF NOT EXISTS users_deletion_scheduled_for_idx ON users (deletion_scheduled_for)
+    WHERE status = 'pending_deletion';
+
+-- A deletion outlives the user row it erased, until every service confirmed
+-- it deleted the user's data. The email is only kept to send the final
+-- confirmation and cleared then.
+CREATE TABLE IF NOT EXISTS account_deletions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL UNIQUE,
+    email TEXT NOT NULL DEFAULT '',
+    requested_at TIMESTAMPTZ NOT NULL,
+    deleted_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    published_at TIMESTAMPTZ,
+    completed_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS account_deletions_pending_idx ON account_deletions (published_at)
+    WHERE completed_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS account_deletion_confirmations (
+    deletion_id UUID NOT NULL REFERENCES account_deletions (id) ON DELETE CASCADE,
+    service TEXT NOT NULL,
+    confirmed_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (deletion_id, service)
+);
@@ -0,0 +1,25 @@
+-- Data exports aren't tied to the user row: erasing an account expires its
+-- archives and the sweeper deletes them from object storage.
+CREATE TABLE IF NOT EXISTS data_exports (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'running', 'ready', 'failed', 'expired')),
+    object_key TEXT NOT NULL DEFAULT '',
+    token_hash TEXT UNIQUE,
+    attempts INT NOT NULL DEFAULT 0,
+    requested_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    started_at TIMESTAMPTZ,
+    completed_at TIMESTAMPTZ,
+    expires_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS data_exports_user_id_idx ON data_exports (user_id, requested_at DESC);
+
+CREATE UNIQUE INDEX IF NOT EXISTS data_exports_in_progress_idx ON data_exports (user_id)
+    WHERE status IN ('pending', 'running');
+
+CREATE INDEX IF NOT EXISTS data_exports_queue_idx ON data_exports (requested_at)
+    WHERE status IN ('pending', 'running');
+
+CREATE INDEX IF NOT EXISTS data_exports_expires_at_idx ON data_exports (expires_at)
+    WHERE status = 'ready';
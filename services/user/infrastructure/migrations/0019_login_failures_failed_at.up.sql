@@ -0,0 +1,2 @@
+-- Lets the sweeper find the failures that left the window across all keys.
+CREATE INDEX IF NOT EXISTS login_failures_failed_at_idx ON login_failures (failed_at);
@@ -0,0 +1,7 @@
+DROP FUNCTION IF EXISTS anonymize_audit_events(UUID);
+
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,28 @@
+-- Erasing an account has to scrub what the log keeps about the person: the
+-- IP address, user agent and email of their entries. That is the only
+-- change the trigger lets through, everything else stays append-only.
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'UPDATE'
+        AND NEW.ip = '' AND NEW.user_agent = '' AND NEW.metadata = OLD.metadata - 'email'
+        AND (NEW.id, NEW.type, NEW.user_id, NEW.session_id, NEW.actor_id, NEW.created_at)
+            IS NOT DISTINCT FROM (OLD.id, OLD.type, OLD.user_id, OLD.session_id, OLD.actor_id, OLD.created_at)
+    THEN
+        RETURN NEW;
+    END IF;
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+-- SECURITY DEFINER so a deployment can revoke UPDATE on audit_events from
+-- the service's database user and still erase accounts.
+CREATE OR REPLACE FUNCTION anonymize_audit_events(target UUID) RETURNS BIGINT
+    LANGUAGE sql SECURITY DEFINER SET search_path = pg_catalog, public AS $$
+    WITH scrubbed AS (
+        UPDATE audit_events SET ip = '', user_agent = '', metadata = metadata - 'email'
+        WHERE (user_id = target OR actor_id = target)
+            AND (ip <> '' OR user_agent <> '' OR metadata ? 'email')
+        RETURNING 1
+    )
+    SELECT count(*) FROM scrubbed;
+$$;
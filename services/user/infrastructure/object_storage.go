@@ -0,0 +1,93 @@
+package infrastructure
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+type ObjectStore struct {
+	client *s3.Client
+	bucket string
+}
+
+func NewObjectStore(client *s3.Client, bucket string) *ObjectStore {
+	return &ObjectStore{
+		client: client,
+		bucket: bucket,
+	}
+}
+
+func (o *ObjectStore) Ping(ctx context.Context) error {
+	_, err := o.client.HeadBucket(ctx, &s3.HeadBucketInput{
+		Bucket: aws.String(o.bucket),
+	})
+	return err
+}
+
+func (o *ObjectStore) PutAvatar(ctx context.Context, key string, contentType string, image []byte) error {
+	_, err := o.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(o.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(image),
+		ContentType: aws.String(contentType),
+	})
+	return err
+}
+
+func (o *ObjectStore) GetAvatar(ctx context.Context, key string) (io.ReadCloser, string, error) {
+	out, err := o.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(o.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	contentType := "application/octet-stream"
+	if out.ContentType != nil {
+		contentType = *out.ContentType
+	}
+
+	return out.Body, contentType, nil
+}
+
+func (o *ObjectStore) DeleteAvatar(ctx context.Context, key string) error {
+	_, err := o.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(o.bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+func (o *ObjectStore) PutExport(ctx context.Context, key string, archive []byte) error {
+	_, err := o.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(o.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(archive),
+		ContentType: aws.String("application/zip"),
+	})
+	return err
+}
+
+func (o *ObjectStore) GetExport(ctx context.Context, key string) (io.ReadCloser, error) {
+	out, err := o.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(o.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+func (o *ObjectStore) DeleteExport(ctx context.Context, key string) error {
+	_, err := o.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(o.bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
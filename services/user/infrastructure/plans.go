@@ -0,0 +1,81 @@
+package infrastructure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/eduardo-ax/video-streaming/services/user/domain"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const selectPlan = `
+	SELECT id, name, description, price_cents, currency, max_streams, max_profiles, max_resolution,
+		features, is_default, is_public
+	FROM plans `
+
+func scanPlan(row pgx.Row) (*domain.Plan, error) {
+	var p domain.Plan
+	err := row.Scan(&p.ID, &p.Name, &p.Description, &p.PriceCents, &p.Currency, &p.MaxStreams, &p.MaxProfiles, &p.MaxResolution,
+		&p.Features, &p.IsDefault, &p.IsPublic)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (db *Database) ListPlans(ctx context.Context) ([]domain.Plan, error) {
+	rows, err := db.pool.Query(ctx, selectPlan+"ORDER BY price_cents, id")
+	if err != nil {
+		return nil, fmt.Errorf("error listing plans: %w", err)
+	}
+	defer rows.Close()
+
+	var plans []domain.Plan
+	for rows.Next() {
+		p, err := scanPlan(rows)
+		if err != nil {
+			return nil, err
+		}
+		plans = append(plans, *p)
+	}
+	return plans, rows.Err()
+}
+
+func (db *Database) GetPlan(ctx context.Context, id int8) (*domain.Plan, error) {
+	p, err := scanPlan(db.pool.QueryRow(ctx, selectPlan+"WHERE id = $1", id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, domain.ErrPlanNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting plan: %w", err)
+	}
+	return p, nil
+}
+
+func (db *Database) GetDefaultPlan(ctx context.Context) (*domain.Plan, error) {
+	p, err := scanPlan(db.pool.QueryRow(ctx, selectPlan+"WHERE is_default"))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, domain.ErrPlanNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting default plan: %w", err)
+	}
+	return p, nil
+}
+
+func (db *Database) ChangePlan(ctx context.Context, id string, plan int8) error {
+	query, err := db.pool.Exec(ctx, "UPDATE users SET plan = $2, plan_changed_at = now() WHERE id = $1", id, plan)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+		return domain.ErrPlanNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("error changing plan: %w", err)
+	}
+	if query.RowsAffected() == 0 {
+		return domain.ErrUserNotFound
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package infrastructure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/eduardo-ax/video-streaming/services/user/domain"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const selectProfile = `SELECT id, user_id, name, avatar, max_maturity, pin_hash, created_at FROM profiles `
+
+func scanProfile(row pgx.Row) (*domain.Profile, error) {
+	p := &domain.Profile{}
+	err := row.Scan(&p.ID, &p.UserID, &p.Name, &p.Avatar, &p.MaxMaturity, &p.PINHash, &p.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func profileError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return domain.ErrProfileNameTaken
+	}
+	return err
+}
+
+func (db *Database) ListProfiles(ctx context.Context, userID string) ([]domain.Profile, error) {
+	rows, err := db.pool.Query(ctx, selectProfile+"WHERE user_id = $1 ORDER BY created_at", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	profiles := []domain.Profile{}
+	for rows.Next() {
+		p, err := scanProfile(rows)
+		if err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, *p)
+	}
+	return profiles, rows.Err()
+}
+
+// GetProfile reports the profiles of other users as not found.
+func (db *Database) GetProfile(ctx context.Context, userID string, id string) (*domain.Profile, error) {
+	p, err := scanProfile(db.pool.QueryRow(ctx, selectProfile+"WHERE id::text = $1 AND user_id = $2", id, userID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, domain.ErrProfileNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting profile: %w", err)
+	}
+	return p, nil
+}
+
+// CreateProfile locks the user so concurrent requests can't both take the
+// last profile the plan allows.
+func (db *Database) CreateProfile(ctx context.Context, profile *domain.Profile, limit int) error {
+	err := pgx.BeginFunc(ctx, db.pool, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, "SELECT 1 FROM users WHERE id = $1 FOR UPDATE", profile.UserID)
+		if err != nil {
+			return err
+		}
+		var count int
+		err = tx.QueryRow(ctx, "SELECT count(*) FROM profiles WHERE user_id = $1", profile.UserID).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count >= limit {
+			return domain.ErrProfileLimit
+		}
+		return tx.QueryRow(ctx, `
+			INSERT INTO profiles (user_id, name, avatar, max_maturity, pin_hash) VALUES ($1, $2, $3, $4, $5)
+			RETURNING id, created_at`,
+			profile.UserID, profile.Name, profile.Avatar, profile.MaxMaturity, profile.PINHash).Scan(&profile.ID, &profile.CreatedAt)
+	})
+	return profileError(err)
+}
+
+func (db *Database) UpdateProfile(ctx context.Context, profile *domain.Profile) error {
+	query, err := db.pool.Exec(ctx, `
+		UPDATE profiles SET name = $3, avatar = $4, max_maturity = $5, pin_hash = $6
+		WHERE id = $1 AND user_id = $2`,
+		profile.ID, profile.UserID, profile.Name, profile.Avatar, profile.MaxMaturity, profile.PINHash)
+	if err != nil {
+		return profileError(err)
+	}
+	if query.RowsAffected() == 0 {
+		return domain.ErrProfileNotFound
+	}
+	return nil
+}
+
+// DeleteProfile revokes the sessions using the profile in the same
+// transaction, their tokens are then rejected through the revocation list.
+func (db *Database) DeleteProfile(ctx context.Context, userID string, id string) error {
+	return pgx.BeginFunc(ctx, db.pool, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `
+			UPDATE sessions SET is_revoked = true, revoked_at = now()
+			WHERE profile_id::text = $1 AND user_id = $2 AND NOT is_revoked`, id, userID)
+		if err != nil {
+			return fmt.Errorf("error revoking profile sessions: %w", err)
+		}
+		query, err := tx.Exec(ctx, "DELETE FROM profiles WHERE id::text = $1 AND user_id = $2", id, userID)
+		if err != nil {
+			return fmt.Errorf("error deleting profile: %w", err)
+		}
+		if query.RowsAffected() == 0 {
+			return domain.ErrProfileNotFound
+		}
+		return nil
+	})
+}
+
+func (db *Database) SetSessionProfile(ctx context.Context, userID string, sessionID string, profileID string) error {
+	query, err := db.pool.Exec(ctx,
+		"UPDATE sessions SET profile_id = $3 WHERE id = $1 AND user_id = $2 AND NOT is_revoked",
+		sessionID, userID, profileID)
+	if err != nil {
+		return fmt.Errorf("error selecting profile: %w", err)
+	}
+	if query.RowsAffected() == 0 {
+		return domain.ErrSessionNotFound
+	}
+	return nil
+}
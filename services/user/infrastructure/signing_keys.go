@@ -0,0 +1,65 @@
+package infrastructure
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/eduardo-ax/video-streaming/services/user/token"
+	"github.com/jackc/pgx/v5"
+)
+
+// signingKeysLock serialises key creation between replicas.
+const signingKeysLock = "signing_keys"
+
+func (db *Database) ListSigningKeys(ctx context.Context) ([]token.StoredKey, error) {
+	rows, err := db.pool.Query(ctx,
+		"SELECT id, algorithm, private_key, created_at, retires_at FROM signing_keys WHERE retires_at IS NULL OR retires_at > now() ORDER BY created_at")
+	if err != nil {
+		return nil, fmt.Errorf("error listing signing keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []token.StoredKey
+	for rows.Next() {
+		var key token.StoredKey
+		if err := rows.Scan(&key.ID, &key.Algorithm, &key.PrivateKey, &key.CreatedAt, &key.RetiresAt); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (db *Database) InsertFirstSigningKey(ctx context.Context, key token.StoredKey) error {
+	return pgx.BeginFunc(ctx, db.pool, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock(hashtext($1))", signingKeysLock); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, `
+			INSERT INTO signing_keys (id, algorithm, private_key, created_at)
+			SELECT $1, $2, $3, $4
+			WHERE NOT EXISTS (SELECT 1 FROM signing_keys WHERE retires_at IS NULL)`,
+			key.ID, key.Algorithm, key.PrivateKey, key.CreatedAt)
+		return err
+	})
+}
+
+// RotateSigningKey schedules the retirement of the current keys, stores the
+// new one and drops keys whose grace period is over.
+func (db *Database) RotateSigningKey(ctx context.Context, key token.StoredKey, retireOthersAt time.Time) error {
+	return pgx.BeginFunc(ctx, db.pool, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock(hashtext($1))", signingKeysLock); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, "UPDATE signing_keys SET retires_at = $1 WHERE retires_at IS NULL", retireOthersAt); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, "INSERT INTO signing_keys (id, algorithm, private_key, created_at) VALUES ($1, $2, $3, $4)",
+			key.ID, key.Algorithm, key.PrivateKey, key.CreatedAt); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, "DELETE FROM signing_keys WHERE retires_at <= now()")
+		return err
+	})
+}
@@ -0,0 +1,52 @@
+package infrastructure
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/eduardo-ax/video-streaming/pkg/auth"
+)
+
+// VideoStoreExport fetches the data the video store holds about a user for
+// their data export.
+type VideoStoreExport struct {
+	baseURL      string
+	serviceToken string
+	client       *http.Client
+}
+
+func NewVideoStoreExport(baseURL, serviceToken string) *VideoStoreExport {
+	return &VideoStoreExport{
+		baseURL:      strings.TrimSuffix(baseURL, "/"),
+		serviceToken: serviceToken,
+		client:       &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (v *VideoStoreExport) ExportUserData(ctx context.Context, userID string) (map[string]json.RawMessage, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		fmt.Sprintf("%s/internal/users/%s/export", v.baseURL, url.PathEscape(userID)), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(auth.HeaderServiceToken, v.serviceToken)
+	res, err := v.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch video store export: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch video store export: status %d", res.StatusCode)
+	}
+
+	var files map[string]json.RawMessage
+	if err := json.NewDecoder(res.Body).Decode(&files); err != nil {
+		return nil, fmt.Errorf("failed to decode video store export: %w", err)
+	}
+	return files, nil
+}
@@ -1,18 +1,45 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	_ "time/tzdata"
 
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/eduardo-ax/video-streaming/pkg/auth"
+	"github.com/eduardo-ax/video-streaming/pkg/configloader"
+	"github.com/eduardo-ax/video-streaming/pkg/events"
+	"github.com/eduardo-ax/video-streaming/pkg/health"
+	"github.com/eduardo-ax/video-streaming/pkg/migrate"
+	"github.com/eduardo-ax/video-streaming/pkg/telemetry"
 	"github.com/eduardo-ax/video-streaming/services/user/api"
+	"github.com/eduardo-ax/video-streaming/services/user/config"
 	"github.com/eduardo-ax/video-streaming/services/user/domain"
 	"github.com/eduardo-ax/video-streaming/services/user/infrastructure"
+	"github.com/eduardo-ax/video-streaming/services/user/payments"
+	"github.com/eduardo-ax/video-streaming/services/user/secretbox"
 	"github.com/eduardo-ax/video-streaming/services/user/token"
 	"github.com/joho/godotenv"
 	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/aws/aws-sdk-go-v2/otelaws"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/labstack/echo/otelecho"
 )
 
-const minSecretKeySize = 32
+const serviceName = "user"
 
 func main() {
 
@@ -22,25 +49,435 @@ func main() {
 		log.Println("Warning: Could not load .env file, falling back to environment variables.")
 	}
 
-	secretKey := os.Getenv("SECRET_KEY")
+	cfg, opts, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if opts.PrintConfig {
+		if err := configloader.Print(os.Stdout, cfg); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err != nil {
+		log.Fatalf("FATAL ERROR: invalid configuration:\n%v", err)
+	}
+	if opts.PrintConfig {
+		return
+	}
 
-	if len(secretKey) < minSecretKeySize {
-		log.Println("Warning: secret key size incorrect")
+	shutdownTracing, err := telemetry.SetupTracing(context.Background(), serviceName, cfg.Tracing.Exporter)
+	if err != nil {
+		log.Fatalf("FATAL ERROR: Could not initialize tracing: %v", err)
 	}
+	defer shutdownTracing(context.Background())
 
-	pool := infrastructure.NewPool()
+	pool := infrastructure.NewPool(cfg.Database.URL)
 	db := infrastructure.NewDatabase(pool)
 	defer db.Close()
 
-	token := token.NewJWTMaker(secretKey)
+	migrator, err := migrate.New(pool, infrastructure.Migrations, "migrations")
+	if err != nil {
+		log.Fatalf("FATAL ERROR: Could not load migrations: %v", err)
+	}
+	if len(opts.Args) > 0 && opts.Args[0] == "migrate" {
+		if err := migrate.Run(context.Background(), migrator, opts.Args[1:], os.Stdout); err != nil {
+			log.Fatalf("FATAL ERROR: %v", err)
+		}
+		return
+	}
+	if cfg.Database.AutoMigrate {
+		if err := migrator.Up(context.Background()); err != nil {
+			log.Fatalf("FATAL ERROR: Could not apply migrations: %v", err)
+		}
+	}
+
+	tokenMaker, err := token.NewJWTMaker(db, cfg.Auth.SecretKey)
+	if err != nil {
+		log.Fatalf("FATAL ERROR: Could not initialize token maker: %v", err)
+	}
+	if len(opts.Args) > 0 && opts.Args[0] == "keys" {
+		if err := runKeysCommand(context.Background(), tokenMaker, cfg, opts.Args[1:]); err != nil {
+			log.Fatalf("FATAL ERROR: %v", err)
+		}
+		return
+	}
+	if len(opts.Args) > 0 && opts.Args[0] == "roles" {
+		if err := runRolesCommand(context.Background(), db, opts.Args[1:]); err != nil {
+			log.Fatalf("FATAL ERROR: %v", err)
+		}
+		return
+	}
+	if len(opts.Args) > 0 && opts.Args[0] == "billing" {
+		if cfg.Billing.Provider != "fake" {
+			log.Fatalf("FATAL ERROR: billing events can only be simulated with BILLING_PROVIDER=fake")
+		}
+		if err := runBillingCommand(payments.NewFake(cfg.Billing.WebhookSecret), opts.Args[1:]); err != nil {
+			log.Fatalf("FATAL ERROR: %v", err)
+		}
+		return
+	}
+	if len(opts.Args) > 0 {
+		log.Fatalf("FATAL ERROR: unknown command %q", opts.Args[0])
+	}
+	if err := tokenMaker.Init(context.Background(), cfg.Auth.Algorithm); err != nil {
+		log.Fatalf("FATAL ERROR: Could not load signing keys: %v", err)
+	}
+
+	var mailer domain.Mailer = infrastructure.NewLogMailer(cfg.Mail.From, cfg.Mail.LogDir)
+	if cfg.Mail.Driver == "smtp" {
+		mailer = infrastructure.NewSMTPMailer(cfg.Mail.SMTPAddr, cfg.Mail.From, cfg.Mail.SMTPUsername, cfg.Mail.SMTPPassword)
+	}
+
+	box, err := secretbox.New(cfg.Auth.SecretKey)
+	if err != nil {
+		log.Fatalf("FATAL ERROR: Could not initialize secret box: %v", err)
+	}
+
+	var attempts domain.AttemptStore = db
+	if cfg.Lockout.Store == "memory" {
+		attempts = infrastructure.NewMemoryAttemptStore()
+	}
+	guard := domain.NewLoginGuard(attempts, domain.LockoutPolicy{
+		Window:          cfg.Lockout.Window,
+		FreeAttempts:    cfg.Lockout.FreeAttempts,
+		MaxDelay:        cfg.Lockout.MaxDelay,
+		AccountLimit:    cfg.Lockout.AccountLimit,
+		IPLimit:         cfg.Lockout.IPLimit,
+		LockoutDuration: cfg.Lockout.Duration,
+	})
 
-	u := domain.NewUserManager(db, token)
+	bus, err := infrastructure.NewMessageBus(cfg.MessageBus)
+	if err != nil {
+		log.Fatalf("FATAL ERROR: Could not initialize message bus: %v", err)
+	}
+	pub := infrastructure.NewEventPublisher(bus, cfg.MessageBus.Topic)
+	defer pub.Close()
 
-	handler := api.NewUserHander(u)
+	awsCfg, err := awsconfig.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		log.Fatalf("FATAL ERROR: Could not load AWS configuration: %v", err)
+	}
+	otelaws.AppendMiddlewares(&awsCfg.APIOptions)
+	objectStore := infrastructure.NewObjectStore(s3.NewFromConfig(awsCfg), cfg.S3.Bucket)
+
+	u := domain.NewUserManager(db, tokenMaker, db, mailer, domain.Links{BaseURL: strings.TrimSuffix(cfg.Mail.LinkBaseURL, "/")}, box, guard, pub, objectStore)
+
+	var provider domain.PaymentProvider = payments.Disabled{}
+	if cfg.Billing.Provider == "fake" {
+		log.Println("Warning: the fake payment provider charges no one, use it for development only")
+		provider = payments.NewFake(cfg.Billing.WebhookSecret)
+	}
+	billing := domain.NewBilling(u, provider, domain.BillingPolicy{
+		TrialPeriod: cfg.Billing.TrialPeriod,
+		GracePeriod: cfg.Billing.GracePeriod,
+	})
+
+	deletions := domain.NewDeletions(u, domain.DeletionPolicy{
+		GracePeriod: cfg.Deletion.GracePeriod,
+		Services:    cfg.Deletion.Services,
+	})
+	confirmations := infrastructure.NewEventConsumer(bus, cfg.MessageBus.ConfirmationsTopic, serviceName)
+
+	exports := domain.NewExports(u, objectStore, map[string]domain.ExportSource{
+		"video_store": infrastructure.NewVideoStoreExport(cfg.Export.VideoStoreURL, cfg.Auth.ServiceToken),
+	}, domain.ExportPolicy{LinkTTL: cfg.Export.LinkTTL})
+
+	handler := api.NewUserHander(u, billing, deletions, exports)
+
+	reg := prometheus.NewRegistry()
 
 	echoServer := echo.New()
+	echoServer.IPExtractor = ipExtractor(cfg.HTTP.TrustedProxies)
+	echoServer.Use(otelecho.Middleware(serviceName, otelecho.WithSkipper(func(c echo.Context) bool {
+		return telemetry.IsOperationalPath(c.Path())
+	})))
+	echoServer.Use(telemetry.NewHTTPMetrics(reg, serviceName).Middleware())
+
+	checker := health.NewChecker(2 * time.Second)
+	checker.Add("postgres", db.Ping)
+	checker.Add("s3", objectStore.Ping)
+	checker.Add("message_bus", pub.Ping)
+
+	echoServer.GET("/metrics", echo.WrapHandler(promhttp.HandlerFor(reg, promhttp.HandlerOpts{})))
+	echoServer.GET("/healthz", echo.WrapHandler(checker.LivenessHandler()))
+	echoServer.GET("/readyz", echo.WrapHandler(checker.ReadinessHandler()))
+	echoServer.GET("/.well-known/jwks.json", api.JWKSHandler(tokenMaker))
 	v1Group := echoServer.Group("/v1")
-	handler.Register(v1Group, token)
-	echoServer.Logger.Fatal(echoServer.Start(":8080"))
+	handler.Register(v1Group, tokenMaker, db)
+	echoServer.GET("/internal/revocations", handler.RevocationsHandler, auth.ServiceMiddleware(cfg.Auth.ServiceToken))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Background work stops taking jobs on SIGTERM, but a sweep or an event
+	// in flight gets the shutdown timeout to finish before it is cancelled.
+	drainCtx, cancelDrain := context.WithCancel(context.Background())
+	defer cancelDrain()
+	context.AfterFunc(ctx, func() {
+		time.AfterFunc(cfg.HTTP.ShutdownTimeout, cancelDrain)
+	})
+
+	var workers sync.WaitGroup
+	goWorker := func(work func()) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			work()
+		}()
+	}
+	goWorker(func() { tokenMaker.Run(ctx, cfg.Auth.KeyReloadInterval) })
+	goWorker(func() { runLoginFailureSweeper(ctx, drainCtx, guard, cfg.Lockout.SweepInterval) })
+	goWorker(func() { runBillingSweeper(ctx, drainCtx, billing, cfg.Billing.SweepInterval) })
+	goWorker(func() { runDeletionSweeper(ctx, drainCtx, deletions, cfg.Deletion.SweepInterval) })
+	goWorker(func() { runExportWorker(ctx, drainCtx, exports, cfg.Export.PollInterval) })
+	goWorker(func() {
+		if err := confirmations.Consume(ctx, drained(drainCtx, deletions.HandleConfirmation)); err != nil && ctx.Err() == nil {
+			log.Printf("deletion confirmations consumer stopped: %v", err)
+		}
+	})
 
+	go func() {
+		if err := echoServer.Start(cfg.HTTP.Addr); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("HTTP server error: %v", err)
+			stop()
+		}
+	}()
+
+	<-ctx.Done()
+	log.Println("Shutting down: draining HTTP connections")
+	checker.SetShuttingDown()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.HTTP.ShutdownTimeout)
+	defer cancel()
+	if err := echoServer.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Warning: HTTP shutdown error: %v", err)
+	}
+	// Password reset emails are sent after their request is answered.
+	goWorker(handler.Wait)
+	waitWorkers(shutdownCtx, &workers)
+}
+
+// drained makes an event handler outlive the consumer: an event in flight at
+// shutdown keeps running until drain is cancelled.
+func drained(drain context.Context, handler func(ctx context.Context, event events.UserEvent) error) func(ctx context.Context, event events.UserEvent) error {
+	return func(ctx context.Context, event events.UserEvent) error {
+		ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		defer cancel()
+		defer context.AfterFunc(drain, cancel)()
+		return handler(ctx, event)
+	}
+}
+
+// waitWorkers waits for the background goroutines until ctx is done.
+func waitWorkers(ctx context.Context, workers *sync.WaitGroup) {
+	done := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		log.Println("Shutting down: background work stopped")
+	case <-ctx.Done():
+		log.Println("Warning: background work still running at the shutdown deadline")
+	}
+}
+
+// runKeysCommand executes "keys list" and "keys rotate". Rotation signs new
+// tokens with a fresh key while the previous one keeps verifying for
+// auth.key_grace_period.
+func runKeysCommand(ctx context.Context, tokenMaker *token.JWTMaker, cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: keys list | rotate")
+	}
+	switch args[0] {
+	case "list":
+		if err := tokenMaker.Load(ctx); err != nil {
+			return err
+		}
+	case "rotate":
+		key, err := tokenMaker.Rotate(ctx, cfg.Auth.Algorithm, cfg.Auth.KeyGracePeriod)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("signing with %s key %s, previous keys retire in %s\n", key.Algorithm, key.ID, cfg.Auth.KeyGracePeriod)
+	default:
+		return fmt.Errorf("unknown keys command %q", args[0])
+	}
+
+	for _, key := range tokenMaker.Keys() {
+		status := "signing"
+		if key.RetiresAt != nil {
+			status = "retires " + key.RetiresAt.Format(time.RFC3339)
+		}
+		fmt.Printf("%s\t%s\t%s\t%s\n", key.ID, key.Algorithm, key.CreatedAt.Format(time.RFC3339), status)
+	}
+	return nil
+}
+
+// runRolesCommand executes "roles list", "roles grant <email> <role>" and
+// "roles revoke <email> <role>", which is how the first admin is created.
+func runRolesCommand(ctx context.Context, db *infrastructure.Database, args []string) error {
+	usage := errors.New("usage: roles list | grant <email> <role> | revoke <email> <role>")
+	if len(args) == 0 {
+		return usage
+	}
+	switch args[0] {
+	case "list":
+		roles, err := db.ListRoles(ctx)
+		if err != nil {
+			return err
+		}
+		for _, role := range roles {
+			fmt.Printf("%s\t%s\n", role.Name, strings.Join(role.Permissions, ","))
+		}
+		return nil
+	case "grant", "revoke":
+		if len(args) != 3 {
+			return usage
+		}
+		user, err := db.GetUser(ctx, args[1])
+		if err != nil {
+			return fmt.Errorf("%s: %w", args[1], err)
+		}
+		done := "granted"
+		if args[0] == "grant" {
+			err = db.GrantRole(ctx, user.ID, args[2])
+		} else {
+			err = db.RevokeRole(ctx, user.ID, args[2])
+			done = "revoked"
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("role %s %s for %s, effective at the next token renewal\n", args[2], done, args[1])
+		return nil
+	default:
+		return fmt.Errorf("unknown roles command %q", args[0])
+	}
+}
+
+// runLoginFailureSweeper deletes the failed logins that no longer count.
+func runLoginFailureSweeper(ctx context.Context, drain context.Context, guard *domain.LoginGuard, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := guard.Sweep(drain); err != nil && drain.Err() == nil {
+				log.Printf("failed to delete login failures: %v", err)
+			}
+		}
+	}
+}
+
+// runBillingSweeper cancels the subscriptions whose grace period or trial is
+// over. Like the other sweepers it stops with ctx, a sweep runs on drain.
+func runBillingSweeper(ctx context.Context, drain context.Context, billing *domain.Billing, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			expired, err := billing.ExpireSubscriptions(drain)
+			if err != nil && drain.Err() == nil {
+				log.Printf("failed to expire subscriptions: %v", err)
+			}
+			if expired > 0 {
+				log.Printf("cancelled %d subscriptions after their grace period or trial", expired)
+			}
+		}
+	}
+}
+
+// runDeletionSweeper erases the accounts whose deletion grace period is
+// over.
+func runDeletionSweeper(ctx context.Context, drain context.Context, deletions *domain.Deletions, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			erased, err := deletions.ExecuteDueDeletions(drain)
+			if err != nil && drain.Err() == nil {
+				log.Printf("failed to execute account deletions: %v", err)
+			}
+			if erased > 0 {
+				log.Printf("erased %d accounts after their deletion grace period", erased)
+			}
+		}
+	}
+}
+
+// runExportWorker builds the pending data exports and deletes the archives
+// whose link expired.
+func runExportWorker(ctx context.Context, drain context.Context, exports *domain.Exports, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			ready, err := exports.RunPendingExports(drain)
+			if err != nil && drain.Err() == nil {
+				log.Printf("failed to build data exports: %v", err)
+			}
+			if ready > 0 {
+				log.Printf("built %d data exports", ready)
+			}
+			expired, err := exports.ExpireExports(drain)
+			if err != nil && drain.Err() == nil {
+				log.Printf("failed to expire data exports: %v", err)
+			}
+			if expired > 0 {
+				log.Printf("deleted %d expired data exports", expired)
+			}
+		}
+	}
+}
+
+// runBillingCommand executes "billing event <type> <subscription_id>", which
+// prints a webhook signed like the fake provider's, to drive subscriptions
+// by hand in development.
+func runBillingCommand(fake *payments.Fake, args []string) error {
+	if len(args) != 3 || args[0] != "event" {
+		return errors.New("usage: billing event payment.succeeded|payment.failed|subscription.cancelled <subscription_id>")
+	}
+	var periodEnd *time.Time
+	if args[1] == domain.PaymentSucceeded {
+		end := time.Now().AddDate(0, 1, 0).UTC().Truncate(time.Second)
+		periodEnd = &end
+	}
+	payload, signature, err := fake.Event(args[1], args[2], periodEnd)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("curl -X POST http://localhost:8080/v1/billing/webhook -H '%s: %s' -d '%s'\n", api.HeaderBillingSignature, signature, payload)
+	return nil
+}
+
+// ipExtractor reads the client address from the connection, unless the
+// request comes through one of the trusted proxies. Lockouts and audit
+// events key on it, so clients must not be able to pick it themselves.
+func ipExtractor(trustedProxies []string) echo.IPExtractor {
+	if len(trustedProxies) == 0 {
+		return echo.ExtractIPDirect()
+	}
+	options := []echo.TrustOption{
+		echo.TrustLoopback(false),
+		echo.TrustLinkLocal(false),
+		echo.TrustPrivateNet(false),
+	}
+	for _, cidr := range trustedProxies {
+		_, ipNet, _ := net.ParseCIDR(cidr)
+		options = append(options, echo.TrustIPRange(ipNet))
+	}
+	return echo.ExtractIPFromXFFHeader(options...)
 }
@@ -0,0 +1,29 @@
+package payments
+
+import (
+	"context"
+	"time"
+
+	"github.com/eduardo-ax/video-streaming/services/user/domain"
+)
+
+// Disabled is the PaymentProvider of deployments without billing. Nothing
+// can be subscribed to and every webhook is refused, while subscriptions
+// left from an earlier provider can still be cancelled.
+type Disabled struct{}
+
+func (Disabled) CreateCustomer(ctx context.Context, userID string, email string) (string, error) {
+	return "", domain.ErrBillingDisabled
+}
+
+func (Disabled) CreateSubscription(ctx context.Context, customerID string, plan domain.Plan, trialEnd *time.Time) (*domain.ProviderSubscription, error) {
+	return nil, domain.ErrBillingDisabled
+}
+
+func (Disabled) CancelSubscription(ctx context.Context, subscriptionID string, atPeriodEnd bool) error {
+	return nil
+}
+
+func (Disabled) ParseWebhook(payload []byte, signature string) (*domain.PaymentEvent, error) {
+	return nil, domain.ErrBillingDisabled
+}
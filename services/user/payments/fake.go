@@ -0,0 +1,102 @@
+package payments
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/eduardo-ax/video-streaming/services/user/domain"
+)
+
+// Fake is an in-process PaymentProvider that never charges anyone. It
+// accepts every operation and signs webhooks with Event, so tests and local
+// setups can drive the billing cycle themselves.
+type Fake struct {
+	secret []byte
+	now    func() time.Time
+}
+
+func NewFake(webhookSecret string) *Fake {
+	return &Fake{
+		secret: []byte(webhookSecret),
+		now:    time.Now,
+	}
+}
+
+type webhookBody struct {
+	ID             string     `json:"id"`
+	Type           string     `json:"type"`
+	SubscriptionID string     `json:"subscription_id"`
+	PeriodEnd      *time.Time `json:"period_end,omitempty"`
+	Created        int64      `json:"created"`
+}
+
+func (f *Fake) CreateCustomer(ctx context.Context, userID string, email string) (string, error) {
+	return newID("cus"), nil
+}
+
+// CreateSubscription trials until trialEnd, or waits for a payment.succeeded
+// webhook without a trial.
+func (f *Fake) CreateSubscription(ctx context.Context, customerID string, plan domain.Plan, trialEnd *time.Time) (*domain.ProviderSubscription, error) {
+	sub := &domain.ProviderSubscription{
+		ID:     newID("sub"),
+		Status: domain.SubscriptionIncomplete,
+	}
+	if trialEnd != nil {
+		sub.Status = domain.SubscriptionTrialing
+		sub.CurrentPeriodEnd = trialEnd
+	}
+	return sub, nil
+}
+
+func (f *Fake) CancelSubscription(ctx context.Context, subscriptionID string, atPeriodEnd bool) error {
+	return nil
+}
+
+func (f *Fake) ParseWebhook(payload []byte, signature string) (*domain.PaymentEvent, error) {
+	if err := VerifyWebhook(f.secret, payload, signature, f.now()); err != nil {
+		return nil, err
+	}
+	var body webhookBody
+	if err := json.Unmarshal(payload, &body); err != nil {
+		return nil, fmt.Errorf("malformed webhook: %w", err)
+	}
+	if body.ID == "" || body.Type == "" || body.SubscriptionID == "" {
+		return nil, fmt.Errorf("webhook without id, type or subscription_id")
+	}
+	return &domain.PaymentEvent{
+		ID:             body.ID,
+		Type:           body.Type,
+		SubscriptionID: body.SubscriptionID,
+		PeriodEnd:      body.PeriodEnd,
+		OccurredAt:     time.Unix(body.Created, 0),
+	}, nil
+}
+
+// Event builds a signed webhook for the subscription, periodEnd is only
+// meaningful for payment.succeeded.
+func (f *Fake) Event(eventType string, subscriptionID string, periodEnd *time.Time) ([]byte, string, error) {
+	now := f.now()
+	payload, err := json.Marshal(webhookBody{
+		ID:             newID("evt"),
+		Type:           eventType,
+		SubscriptionID: subscriptionID,
+		PeriodEnd:      periodEnd,
+		Created:        now.Unix(),
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return payload, SignWebhook(f.secret, payload, now), nil
+}
+
+func newID(prefix string) string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return prefix + "_" + hex.EncodeToString(b)
+}
@@ -0,0 +1,73 @@
+package payments
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WebhookTolerance bounds the age of a signed webhook, older deliveries are
+// refused so a captured one can't be replayed later.
+const WebhookTolerance = 5 * time.Minute
+
+var (
+	ErrBadSignature       = errors.New("webhook signature mismatch")
+	ErrStaleWebhook       = errors.New("webhook timestamp outside tolerance")
+	ErrMalformedSignature = errors.New("malformed webhook signature header")
+)
+
+// SignWebhook returns the signature header of payload, "t=<unix>,v1=<hex>",
+// an HMAC-SHA256 of the timestamp and the payload.
+func SignWebhook(secret []byte, payload []byte, at time.Time) string {
+	ts := strconv.FormatInt(at.Unix(), 10)
+	return fmt.Sprintf("t=%s,v1=%s", ts, hex.EncodeToString(mac(secret, ts, payload)))
+}
+
+func VerifyWebhook(secret []byte, payload []byte, header string, now time.Time) error {
+	var ts string
+	var sigs [][]byte
+	for _, part := range strings.Split(header, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return ErrMalformedSignature
+		}
+		switch key {
+		case "t":
+			ts = value
+		case "v1":
+			sig, err := hex.DecodeString(value)
+			if err != nil {
+				return ErrMalformedSignature
+			}
+			sigs = append(sigs, sig)
+		}
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || len(sigs) == 0 {
+		return ErrMalformedSignature
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > WebhookTolerance || age < -WebhookTolerance {
+		return ErrStaleWebhook
+	}
+
+	expected := mac(secret, ts, payload)
+	for _, sig := range sigs {
+		if hmac.Equal(sig, expected) {
+			return nil
+		}
+	}
+	return ErrBadSignature
+}
+
+func mac(secret []byte, ts string, payload []byte) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(ts))
+	h.Write([]byte("."))
+	h.Write(payload)
+	return h.Sum(nil)
+}
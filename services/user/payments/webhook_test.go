@@ -0,0 +1,68 @@
+package payments
+
+import (
+	"testing"
+	"time"
+
+	"github.com/eduardo-ax/video-streaming/services/user/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyWebhook(t *testing.T) {
+	secret := []byte("whsec-test")
+	payload := []byte(`{"id":"evt_1"}`)
+	now := time.Unix(1700000000, 0)
+
+	tests := map[string]struct {
+		header    string
+		expectErr error
+	}{
+		"valid signature": {
+			header: SignWebhook(secret, payload, now),
+		},
+		"signed with another secret": {
+			header:    SignWebhook([]byte("other"), payload, now),
+			expectErr: ErrBadSignature,
+		},
+		"replayed later": {
+			header:    SignWebhook(secret, payload, now.Add(-10*time.Minute)),
+			expectErr: ErrStaleWebhook,
+		},
+		"no signature": {
+			header:    "t=1700000000",
+			expectErr: ErrMalformedSignature,
+		},
+		"garbage": {
+			header:    "sig",
+			expectErr: ErrMalformedSignature,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := VerifyWebhook(secret, payload, tc.header, now)
+			if tc.expectErr != nil {
+				assert.ErrorIs(t, err, tc.expectErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestFake_EventRoundTrip(t *testing.T) {
+	fake := NewFake("whsec-test")
+	end := time.Now().Add(30 * 24 * time.Hour).UTC().Truncate(time.Second)
+
+	payload, signature, err := fake.Event(domain.PaymentSucceeded, "sub_1", &end)
+	assert.NoError(t, err)
+
+	event, err := fake.ParseWebhook(payload, signature)
+	assert.NoError(t, err)
+	assert.Equal(t, domain.PaymentSucceeded, event.Type)
+	assert.Equal(t, "sub_1", event.SubscriptionID)
+	assert.True(t, end.Equal(*event.PeriodEnd))
+
+	_, err = fake.ParseWebhook(append(payload, ' '), signature)
+	assert.ErrorIs(t, err, ErrBadSignature)
+}
@@ -0,0 +1,52 @@
+package secretbox
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+)
+
+var ErrOpen = errors.New("secretbox: message could not be decrypted")
+
+// Box encrypts secrets that must be stored at rest, such as signing keys and
+// TOTP seeds, with AES-GCM under a key derived from SECRET_KEY. The additional
+// data binds a sealed value to its row, so it can't be moved to another one.
+type Box struct {
+	aead cipher.AEAD
+}
+
+func New(secretKey string) (*Box, error) {
+	sum := sha256.Sum256([]byte(secretKey))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Box{aead: aead}, nil
+}
+
+// Seal returns the nonce followed by the ciphertext.
+func (b *Box) Seal(plaintext []byte, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return b.aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func (b *Box) Open(sealed []byte, additionalData []byte) ([]byte, error) {
+	if len(sealed) < b.aead.NonceSize() {
+		return nil, ErrOpen
+	}
+	nonce, ciphertext := sealed[:b.aead.NonceSize()], sealed[b.aead.NonceSize():]
+	plaintext, err := b.aead.Open(nil, nonce, ciphertext, additionalData)
+	if err != nil {
+		return nil, ErrOpen
+	}
+	return plaintext, nil
+}
@@ -5,17 +5,26 @@ import (
 
 	"github.com/eduardo-ax/video-streaming/services/user/domain"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
-func NewUserClaims(id string, email string, plan int8, sessionID string, duration time.Duration) (*domain.UserClaims, error) {
+func NewUserClaims(user domain.UserPayload, sessionID string, tokenType string, duration time.Duration) (*domain.UserClaims, error) {
 
 	return &domain.UserClaims{
-		Email: email,
-		ID:    id,
-		Plan:  plan,
+		Email:         user.Email,
+		ID:            user.ID,
+		Plan:          user.Plan,
+		EmailVerified: user.EmailVerified,
+		Roles:         user.Roles,
+		Permissions:   user.Permissions,
+		SessionID:     sessionID,
+		Type:          tokenType,
+		MaxStreams:    user.MaxStreams,
+		ProfileID:     user.ProfileID,
+		MaxMaturity:   user.MaxMaturity,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ID:        sessionID,
-			Subject:   email,
+			ID:        uuid.New().String(),
+			Subject:   user.Email,
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(duration)),
 		},
@@ -1,45 +1,206 @@
 package token
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log"
+	"sort"
+	"sync"
 	"time"
 
+	"github.com/eduardo-ax/video-streaming/pkg/auth"
 	"github.com/eduardo-ax/video-streaming/services/user/domain"
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// JWTMaker signs tokens with the newest active key and verifies them with any
+// key that isn't retired yet. After a rotation the previous key keeps
+// verifying for the grace period, so tokens signed with it stay valid.
 type JWTMaker struct {
-	secretKey string
+	store  KeyStore
+	sealer *keySealer
+
+	mu       sync.RWMutex
+	signing  *SigningKey
+	keys     map[string]SigningKey
+	loadedAt time.Time
 }
 
-func NewJWTMaker(secretKey string) *JWTMaker {
-	return &JWTMaker{secretKey: secretKey}
+func NewJWTMaker(store KeyStore, secretKey string) (*JWTMaker, error) {
+	sealer, err := newKeySealer(secretKey)
+	if err != nil {
+		return nil, err
+	}
+	return &JWTMaker{
+		store:  store,
+		sealer: sealer,
+		keys:   map[string]SigningKey{},
+	}, nil
 }
 
-func (m *JWTMaker) CreateToken(id string, email string, plan int8, sessionID string, duration time.Duration) (string, *domain.UserClaims, error) {
-	claims, err := NewUserClaims(id, email, plan, sessionID, duration)
+// Init loads the keys and creates the first one when none exists yet.
+func (m *JWTMaker) Init(ctx context.Context, algorithm string) error {
+	if err := m.Load(ctx); err != nil {
+		return err
+	}
+	if m.signingKey() != nil {
+		return nil
+	}
 
+	key, err := GenerateKey(algorithm)
 	if err != nil {
-		return "", nil, err
+		return err
+	}
+	stored, err := m.sealer.seal(key)
+	if err != nil {
+		return err
+	}
+	if err := m.store.InsertFirstSigningKey(ctx, stored); err != nil {
+		return fmt.Errorf("error storing signing key: %w", err)
 	}
+	return m.Load(ctx)
+}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenStr, err := token.SignedString([]byte(m.secretKey))
+func (m *JWTMaker) Load(ctx context.Context) error {
+	stored, err := m.store.ListSigningKeys(ctx)
 	if err != nil {
-		return "", nil, fmt.Errorf("error signing token: %w", err)
+		return fmt.Errorf("error loading signing keys: %w", err)
 	}
-	return tokenStr, claims, nil
+
+	keys := make(map[string]SigningKey, len(stored))
+	var signing *SigningKey
+	for _, s := range stored {
+		key, err := m.sealer.open(s)
+		if err != nil {
+			return err
+		}
+		keys[key.ID] = key
+		if key.RetiresAt == nil && (signing == nil || key.CreatedAt.After(signing.CreatedAt)) {
+			signing = &key
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys = keys
+	m.signing = signing
+	m.loadedAt = time.Now()
+	return nil
 }
 
-func (m JWTMaker) VerifyToken(tokenStr string) (*domain.UserClaims, error) {
-	token, err := jwt.ParseWithClaims(tokenStr, &domain.UserClaims{}, func(token *jwt.Token) (interface{}, error) {
-		_, ok := token.Method.(*jwt.SigningMethodHMAC)
-		if !ok {
-			return nil, fmt.Errorf("invalid token signing method")
+// Run reloads the keys every interval so a rotation made by one replica
+// reaches all of them.
+func (m *JWTMaker) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := m.Load(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("failed to reload signing keys: %v", err)
+			}
 		}
+	}
+}
+
+// Rotate makes a new key the signing key. The previous keys keep verifying
+// until grace has elapsed.
+func (m *JWTMaker) Rotate(ctx context.Context, algorithm string, grace time.Duration) (SigningKey, error) {
+	key, err := GenerateKey(algorithm)
+	if err != nil {
+		return SigningKey{}, err
+	}
+	stored, err := m.sealer.seal(key)
+	if err != nil {
+		return SigningKey{}, err
+	}
+	if err := m.store.RotateSigningKey(ctx, stored, time.Now().Add(grace)); err != nil {
+		return SigningKey{}, fmt.Errorf("error rotating signing key: %w", err)
+	}
+	return key, m.Load(ctx)
+}
 
-		return []byte(m.secretKey), nil
+// Keys returns the keys able to verify tokens, oldest first.
+func (m *JWTMaker) Keys() []SigningKey {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	keys := make([]SigningKey, 0, len(m.keys))
+	for _, key := range m.keys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
 	})
+	return keys
+}
+
+func (m *JWTMaker) JWKS() auth.JWKS {
+	doc := auth.JWKS{Keys: []auth.JWK{}}
+	for _, key := range m.Keys() {
+		jwk, err := auth.NewJWK(key.Public())
+		if err != nil {
+			log.Printf("skipping key %s from JWKS: %v", key.ID, err)
+			continue
+		}
+		doc.Keys = append(doc.Keys, jwk)
+	}
+	return doc
+}
+
+// PublicKey implements auth.KeySet. An unknown kid triggers a reload, at
+// most every few seconds, to pick up a key rotated by another replica.
+func (m *JWTMaker) PublicKey(ctx context.Context, kid string) (auth.PublicKey, error) {
+	m.mu.RLock()
+	key, ok := m.keys[kid]
+	stale := time.Since(m.loadedAt) > 10*time.Second
+	m.mu.RUnlock()
+
+	if !ok && stale {
+		if err := m.Load(ctx); err != nil {
+			return auth.PublicKey{}, err
+		}
+		m.mu.RLock()
+		key, ok = m.keys[kid]
+		m.mu.RUnlock()
+	}
+	if !ok {
+		return auth.PublicKey{}, fmt.Errorf("%w %q", auth.ErrUnknownKey, kid)
+	}
+	return key.Public(), nil
+}
+
+func (m *JWTMaker) signingKey() *SigningKey {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.signing
+}
+
+func (m *JWTMaker) CreateToken(user domain.UserPayload, sessionID string, tokenType string, duration time.Duration) (string, *domain.UserClaims, error) {
+	claims, err := NewUserClaims(user, sessionID, tokenType, duration)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	key := m.signingKey()
+	if key == nil {
+		return "", nil, errors.New("no signing key available")
+	}
+
+	token := jwt.NewWithClaims(key.method(), claims)
+	token.Header["kid"] = key.ID
+	tokenStr, err := token.SignedString(key.Private)
+	if err != nil {
+		return "", nil, fmt.Errorf("error signing token: %w", err)
+	}
+	return tokenStr, claims, nil
+}
+
+func (m *JWTMaker) VerifyToken(tokenStr string) (*domain.UserClaims, error) {
+	token, err := jwt.ParseWithClaims(tokenStr, &domain.UserClaims{}, auth.Keyfunc(context.Background(), m), jwt.WithValidMethods(auth.ValidMethods))
 
 	if err != nil {
 		return nil, fmt.Errorf("error parsin token %w", err)
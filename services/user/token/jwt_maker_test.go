@@ -0,0 +1,138 @@
+package token
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/eduardo-ax/video-streaming/pkg/auth"
+	"github.com/eduardo-ax/video-streaming/services/user/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+type memoryKeyStore struct {
+	keys []StoredKey
+}
+
+func (s *memoryKeyStore) ListSigningKeys(ctx context.Context) ([]StoredKey, error) {
+	var active []StoredKey
+	for _, key := range s.keys {
+		if key.RetiresAt == nil || key.RetiresAt.After(time.Now()) {
+			active = append(active, key)
+		}
+	}
+	return active, nil
+}
+
+func (s *memoryKeyStore) InsertFirstSigningKey(ctx context.Context, key StoredKey) error {
+	for _, existing := range s.keys {
+		if existing.RetiresAt == nil {
+			return nil
+		}
+	}
+	s.keys = append(s.keys, key)
+	return nil
+}
+
+func (s *memoryKeyStore) RotateSigningKey(ctx context.Context, key StoredKey, retireOthersAt time.Time) error {
+	for i := range s.keys {
+		if s.keys[i].RetiresAt == nil {
+			s.keys[i].RetiresAt = &retireOthersAt
+		}
+	}
+	s.keys = append(s.keys, key)
+	return nil
+}
+
+func TestJWTMaker_Rotation(t *testing.T) {
+	ctx := context.Background()
+
+	tests := map[string]struct {
+		algorithm     string
+		grace         time.Duration
+		oldTokenValid bool
+		expectedKeys  int
+	}{
+		"EdDSA keeps the old key during the grace period": {
+			algorithm:     auth.AlgorithmEdDSA,
+			grace:         time.Hour,
+			oldTokenValid: true,
+			expectedKeys:  2,
+		},
+		"RS256 keeps the old key during the grace period": {
+			algorithm:     auth.AlgorithmRS256,
+			grace:         time.Hour,
+			oldTokenValid: true,
+			expectedKeys:  2,
+		},
+		"old key is dropped once retired": {
+			algorithm:    auth.AlgorithmEdDSA,
+			grace:        -time.Second,
+			expectedKeys: 1,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			maker, err := NewJWTMaker(&memoryKeyStore{}, "a-secret-key-of-at-least-32-characters")
+			assert.NoError(t, err)
+			assert.NoError(t, maker.Init(ctx, tc.algorithm))
+
+			oldToken, _, err := maker.CreateToken(domain.UserPayload{ID: "user-1", Email: "user@example.com", Plan: 1}, "session-1", auth.TokenTypeAccess, time.Minute)
+			assert.NoError(t, err)
+
+			_, err = maker.Rotate(ctx, tc.algorithm, tc.grace)
+			assert.NoError(t, err)
+
+			newToken, _, err := maker.CreateToken(domain.UserPayload{ID: "user-1", Email: "user@example.com", Plan: 1}, "session-1", auth.TokenTypeAccess, time.Minute)
+			assert.NoError(t, err)
+			claims, err := maker.VerifyToken(newToken)
+			assert.NoError(t, err)
+			assert.Equal(t, "session-1", claims.SessionID)
+
+			_, err = maker.VerifyToken(oldToken)
+			assert.Equal(t, tc.oldTokenValid, err == nil)
+			assert.Len(t, maker.JWKS().Keys, tc.expectedKeys)
+		})
+	}
+}
+
+func TestJWTMaker_WrongSecret(t *testing.T) {
+	ctx := context.Background()
+	store := &memoryKeyStore{}
+
+	maker, err := NewJWTMaker(store, "a-secret-key-of-at-least-32-characters")
+	assert.NoError(t, err)
+	assert.NoError(t, maker.Init(ctx, auth.AlgorithmEdDSA))
+
+	other, err := NewJWTMaker(store, "another-secret-key-of-32-characters")
+	assert.NoError(t, err)
+	assert.Error(t, other.Load(ctx))
+}
+
+func TestJWTMaker_RolesReachVerifier(t *testing.T) {
+	ctx := context.Background()
+	maker, err := NewJWTMaker(&memoryKeyStore{}, "a-secret-key-of-at-least-32-characters")
+	assert.NoError(t, err)
+	assert.NoError(t, maker.Init(ctx, auth.AlgorithmEdDSA))
+
+	signed, _, err := maker.CreateToken(domain.UserPayload{
+		ID:          "user-1",
+		Roles:       []string{auth.RoleAdmin},
+		Permissions: []string{auth.PermVideosDeleteAny, auth.PermUsersManage},
+		MaxStreams:  3,
+	}, "session-1", auth.TokenTypeAccess, time.Minute)
+	assert.NoError(t, err)
+
+	claims, err := auth.NewVerifier(maker).Verify(ctx, signed)
+	assert.NoError(t, err)
+	assert.True(t, claims.HasRole(auth.RoleAdmin))
+	assert.True(t, claims.HasPermission(auth.PermVideosDeleteAny))
+	assert.False(t, claims.HasPermission(auth.PermVideosUpload))
+	assert.Equal(t, 3, claims.MaxStreams)
+
+	refresh, _, err := maker.CreateToken(domain.UserPayload{ID: "user-1"}, "session-1", auth.TokenTypeRefresh, time.Minute)
+	assert.NoError(t, err)
+	_, err = auth.NewVerifier(maker).Verify(ctx, refresh)
+	assert.ErrorIs(t, err, auth.ErrNotAccessToken)
+}
@@ -0,0 +1,135 @@
+package token
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"time"
+
+	"github.com/eduardo-ax/video-streaming/pkg/auth"
+	"github.com/eduardo-ax/video-streaming/services/user/secretbox"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// StoredKey is a signing key as persisted, PrivateKey is the PKCS#8 key
+// sealed with the service secret.
+type StoredKey struct {
+	ID         string
+	Algorithm  string
+	PrivateKey []byte
+	CreatedAt  time.Time
+	RetiresAt  *time.Time
+}
+
+// KeyStore persists signing keys so every replica signs with the same key.
+type KeyStore interface {
+	ListSigningKeys(ctx context.Context) ([]StoredKey, error)
+	InsertFirstSigningKey(ctx context.Context, key StoredKey) error
+	RotateSigningKey(ctx context.Context, key StoredKey, retireOthersAt time.Time) error
+}
+
+type SigningKey struct {
+	ID        string
+	Algorithm string
+	Private   crypto.Signer
+	CreatedAt time.Time
+	RetiresAt *time.Time
+}
+
+func (k SigningKey) Public() auth.PublicKey {
+	return auth.PublicKey{
+		ID:        k.ID,
+		Algorithm: k.Algorithm,
+		Key:       k.Private.Public(),
+	}
+}
+
+func (k SigningKey) method() jwt.SigningMethod {
+	if k.Algorithm == auth.AlgorithmRS256 {
+		return jwt.SigningMethodRS256
+	}
+	return jwt.SigningMethodEdDSA
+}
+
+func GenerateKey(algorithm string) (SigningKey, error) {
+	var (
+		private crypto.Signer
+		err     error
+	)
+	switch algorithm {
+	case auth.AlgorithmEdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	case auth.AlgorithmRS256:
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	default:
+		return SigningKey{}, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+	if err != nil {
+		return SigningKey{}, fmt.Errorf("error generating %s key: %w", algorithm, err)
+	}
+	return SigningKey{
+		ID:        uuid.New().String(),
+		Algorithm: algorithm,
+		Private:   private,
+		CreatedAt: time.Now().UTC(),
+	}, nil
+}
+
+// keySealer encrypts private keys at rest, the key ID is the additional data
+// of the box.
+type keySealer struct {
+	box *secretbox.Box
+}
+
+func newKeySealer(secretKey string) (*keySealer, error) {
+	box, err := secretbox.New(secretKey)
+	if err != nil {
+		return nil, err
+	}
+	return &keySealer{box: box}, nil
+}
+
+func (s *keySealer) seal(key SigningKey) (StoredKey, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key.Private)
+	if err != nil {
+		return StoredKey{}, fmt.Errorf("error encoding key %s: %w", key.ID, err)
+	}
+	sealed, err := s.box.Seal(der, []byte(key.ID))
+	if err != nil {
+		return StoredKey{}, err
+	}
+	return StoredKey{
+		ID:         key.ID,
+		Algorithm:  key.Algorithm,
+		PrivateKey: sealed,
+		CreatedAt:  key.CreatedAt,
+		RetiresAt:  key.RetiresAt,
+	}, nil
+}
+
+func (s *keySealer) open(stored StoredKey) (SigningKey, error) {
+	der, err := s.box.Open(stored.PrivateKey, []byte(stored.ID))
+	if err != nil {
+		return SigningKey{}, fmt.Errorf("error decrypting key %s, was SECRET_KEY changed? %w", stored.ID, err)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return SigningKey{}, fmt.Errorf("error parsing key %s: %w", stored.ID, err)
+	}
+	private, ok := parsed.(crypto.Signer)
+	if !ok {
+		return SigningKey{}, fmt.Errorf("key %s is not a signing key", stored.ID)
+	}
+	return SigningKey{
+		ID:        stored.ID,
+		Algorithm: stored.Algorithm,
+		Private:   private,
+		CreatedAt: stored.CreatedAt,
+		RetiresAt: stored.RetiresAt,
+	}, nil
+}
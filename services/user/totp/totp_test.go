@@ -0,0 +1,64 @@
+package totp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test vectors of RFC 6238 appendix B for SHA1.
+func TestGenerate(t *testing.T) {
+	key := []byte("12345678901234567890")
+
+	tests := map[string]struct {
+		unix     int64
+		expected string
+	}{
+		"59":          {unix: 59, expected: "94287082"},
+		"1111111109":  {unix: 1111111109, expected: "07081804"},
+		"1111111111":  {unix: 1111111111, expected: "14050471"},
+		"1234567890":  {unix: 1234567890, expected: "89005924"},
+		"2000000000":  {unix: 2000000000, expected: "69279037"},
+		"20000000000": {unix: 20000000000, expected: "65353130"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, generate(key, uint64(tc.unix/30), 8))
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	assert.NoError(t, err)
+	now := time.Unix(1700000000, 0)
+	code := func(t2 time.Time) string {
+		c, err := Code(secret, t2)
+		assert.NoError(t, err)
+		return c
+	}
+
+	tests := map[string]struct {
+		code     string
+		expected bool
+	}{
+		"current code":    {code: code(now), expected: true},
+		"previous period": {code: code(now.Add(-Period)), expected: true},
+		"next period":     {code: code(now.Add(Period)), expected: true},
+		"two periods ago": {code: code(now.Add(-2 * Period))},
+		"wrong length":    {code: "12345"},
+		"not a number":    {code: "abcdef"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			counter, ok := Validate(secret, tc.code, now)
+			assert.Equal(t, tc.expected, ok)
+			if ok {
+				assert.InDelta(t, now.Unix()/30, counter, 1)
+			}
+		})
+	}
+}
@@ -1,18 +1,35 @@
 package api
 
 import (
+	"errors"
 	"io"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/eduardo-ax/video-streaming/pkg/auth"
 	"github.com/eduardo-ax/video-streaming/services/video_store/domain"
-	"github.com/labstack/echo"
+	"github.com/labstack/echo/v4"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
 type VideoRequest struct {
-	Title       string `form:"title"`
-	Description string `form:"description"`
+	Title          string `form:"title"`
+	Description    string `form:"description"`
+	MaturityRating *int   `form:"maturity_rating"`
+}
+
+type PlaybackRequest struct {
+	VideoID int64 `json:"video_id"`
+}
+
+// PlaybackResponse tells the player which lease to send with its playlist
+// and segment requests, and how many seconds to wait between heartbeats.
+type PlaybackResponse struct {
+	LeaseID           string    `json:"lease_id"`
+	VideoID           string    `json:"video_id"`
+	ExpiresAt         time.Time `json:"expires_at"`
+	HeartbeatInterval int       `json:"heartbeat_interval"`
 }
 
 type UploadHandler struct {
@@ -27,9 +44,13 @@ func NewVideoHandler(videoUpload domain.VideoUploader, metrics Metrics) *UploadH
 	}
 }
 
-func (v *UploadHandler) Register(e *echo.Group) {
-	e.POST("/videos", v.HandleVideoUpload)
+func (v *UploadHandler) Register(e *echo.Group, verifier *auth.Verifier) {
+	e.POST("/videos", v.HandleVideoUpload, auth.Middleware(verifier), auth.RequireVerifiedEmail(), auth.RequirePermission(auth.PermVideosUpload))
 	e.GET("/videos/:id/:filename", v.HandleVideoStreaming)
+	e.DELETE("/videos/:id", v.HandleVideoDelete, auth.Middleware(verifier))
+	e.POST("/playback", v.HandlePlaybackStart, auth.Middleware(verifier), auth.RequirePermission(auth.PermVideosWatch))
+	e.PUT("/playback/:id/heartbeat", v.HandlePlaybackHeartbeat, auth.Middleware(verifier))
+	e.DELETE("/playback/:id", v.HandlePlaybackStop, auth.Middleware(verifier))
 }
 
 func (v *UploadHandler) HandleVideoUpload(c echo.Context) error {
@@ -37,7 +58,6 @@ func (v *UploadHandler) HandleVideoUpload(c echo.Context) error {
 	ctx := c.Request().Context()
 	req := &VideoRequest{}
 
-	v.metrics.DevicesInc()
 	if err := c.Bind(req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "invalid request payload")
 	}
@@ -47,7 +67,17 @@ func (v *UploadHandler) HandleVideoUpload(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "file is required")
 	}
 
-	if err := v.videoUpload.Store(ctx, req.Title, req.Description, file); err != nil {
+	// Unrated uploads are kept away from restricted profiles.
+	rating := auth.MaturityAdult
+	if req.MaturityRating != nil {
+		rating = *req.MaturityRating
+	}
+	if !auth.ValidMaturity(rating) {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid maturity rating")
+	}
+
+	claims, _ := auth.ClaimsFromContext(c)
+	if err := v.videoUpload.Store(ctx, claims.UserID, req.Title, req.Description, rating, file); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to upload video")
 	}
 
@@ -57,26 +87,137 @@ func (v *UploadHandler) HandleVideoUpload(c echo.Context) error {
 	return echo.NewHTTPError(http.StatusCreated, "video uploaded successfully")
 }
 
+// HandleVideoStreaming needs no token, the lease is the credential: players
+// can't add headers to every segment request, so it comes in the "lease"
+// query parameter that playlists carry along, or the X-Playback-Lease header.
 func (v *UploadHandler) HandleVideoStreaming(c echo.Context) error {
 	ctx := c.Request().Context()
 	id := c.Param("id")
 	filename := c.Param("filename")
-	data, contentType, err := v.videoUpload.GetStream(ctx, id, filename)
+	leaseID := c.QueryParam("lease")
+	if leaseID == "" {
+		leaseID = c.Request().Header.Get("X-Playback-Lease")
+	}
+	data, contentType, err := v.videoUpload.GetStream(ctx, id, filename, leaseID)
+	if errors.Is(err, domain.ErrLeaseRequired) {
+		return echo.NewHTTPError(http.StatusForbidden, "a live playback lease is required")
+	}
 	if err != nil {
 		return echo.NewHTTPError(
 			http.StatusNotFound, "file not found")
 	}
 	defer data.Close()
 	c.Response().Header().Set("Content-Type", contentType)
-	_, err = io.Copy(c.Response().Writer, data)
+	written, err := io.Copy(c.Response().Writer, data)
+	v.metrics.BytesStreamedAdd(filename, written)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to stream video")
 	}
 	return nil
 }
 
+func (v *UploadHandler) HandleVideoDelete(c echo.Context) error {
+	ctx := c.Request().Context()
+	claims, _ := auth.ClaimsFromContext(c)
+
+	err := v.videoUpload.Delete(ctx, c.Param("id"), claims)
+	if errors.Is(err, domain.ErrVideoNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "video not found")
+	}
+	if errors.Is(err, domain.ErrForbidden) {
+		return echo.NewHTTPError(http.StatusForbidden, "not allowed to delete this video")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete video")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (v *UploadHandler) HandlePlaybackStart(c echo.Context) error {
+	ctx := c.Request().Context()
+	req := &PlaybackRequest{}
+	if err := c.Bind(req); err != nil || req.VideoID <= 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "video_id is required")
+	}
+	claims, _ := auth.ClaimsFromContext(c)
+
+	lease, err := v.videoUpload.StartPlayback(ctx, strconv.FormatInt(req.VideoID, 10), claims)
+	if errors.Is(err, domain.ErrVideoNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "video not found")
+	}
+	if errors.Is(err, domain.ErrMaturityRestricted) {
+		return echo.NewHTTPError(http.StatusForbidden, "video not available on this profile")
+	}
+	if errors.Is(err, domain.ErrStreamLimit) {
+		return echo.NewHTTPError(http.StatusConflict, "too many streams playing on this account")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to start playback")
+	}
+	return c.JSON(http.StatusCreated, v.playbackResponse(lease))
+}
+
+func (v *UploadHandler) HandlePlaybackHeartbeat(c echo.Context) error {
+	ctx := c.Request().Context()
+	claims, _ := auth.ClaimsFromContext(c)
+
+	lease, err := v.videoUpload.Heartbeat(ctx, c.Param("id"), claims)
+	if errors.Is(err, domain.ErrLeaseNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "lease not found or expired")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to renew lease")
+	}
+	return c.JSON(http.StatusOK, v.playbackResponse(lease))
+}
+
+func (v *UploadHandler) HandlePlaybackStop(c echo.Context) error {
+	ctx := c.Request().Context()
+	claims, _ := auth.ClaimsFromContext(c)
+
+	err := v.videoUpload.StopPlayback(ctx, c.Param("id"), claims)
+	if errors.Is(err, domain.ErrLeaseNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "lease not found or expired")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to stop playback")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// HandleAccountExport serves the user service building a data export, a JSON
+// document per file of the archive.
+func (v *UploadHandler) HandleAccountExport(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	data, err := v.videoUpload.ExportAccount(ctx, c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to export account")
+	}
+	videos, history := data.Videos, data.WatchHistory
+	if videos == nil {
+		videos = []domain.OwnedVideo{}
+	}
+	if history == nil {
+		history = []domain.WatchEntry{}
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"videos.json":        videos,
+		"watch_history.json": history,
+	})
+}
+
+func (v *UploadHandler) playbackResponse(lease *domain.Lease) PlaybackResponse {
+	return PlaybackResponse{
+		LeaseID:           lease.ID,
+		VideoID:           lease.VideoID,
+		ExpiresAt:         lease.ExpiresAt,
+		HeartbeatInterval: int(v.videoUpload.HeartbeatInterval().Seconds()),
+	}
+}
+
 type Metrics interface {
 	VideoUploadTime() prometheus.Histogram
-	DevicesInc()
 	UploadsInc()
+	BytesStreamedAdd(filename string, bytes int64)
 }
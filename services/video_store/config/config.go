@@ -0,0 +1,135 @@
+package config
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/eduardo-ax/video-streaming/pkg/auth"
+	"github.com/eduardo-ax/video-streaming/pkg/configloader"
+	"github.com/eduardo-ax/video-streaming/pkg/telemetry"
+)
+
+const serviceName = "video_store"
+
+type Config struct {
+	HTTP       HTTP       `yaml:"http"`
+	Database   Database   `yaml:"database"`
+	S3         S3         `yaml:"s3"`
+	MessageBus MessageBus `yaml:"message_bus"`
+	Auth       Auth       `yaml:"auth"`
+	Playback   Playback   `yaml:"playback"`
+	Tracing    Tracing    `yaml:"tracing"`
+}
+
+type HTTP struct {
+	Addr            string        `yaml:"addr" env:"HTTP_ADDR" flag:"http-addr" default:":8080" usage:"HTTP listen address"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"HTTP_SHUTDOWN_TIMEOUT" flag:"shutdown-timeout" default:"30s" usage:"time allowed to drain HTTP connections"`
+}
+
+type Database struct {
+	URL         string `yaml:"url" env:"VIDEOS_DATABASE_URL" flag:"database-url" secret:"true" usage:"postgres connection URL"`
+	AutoMigrate bool   `yaml:"auto_migrate" env:"DB_AUTO_MIGRATE" flag:"auto-migrate" default:"false" usage:"apply pending migrations at startup"`
+}
+
+type S3 struct {
+	Bucket string `yaml:"bucket" env:"S3_BUCKET_NAME" flag:"s3-bucket" usage:"bucket storing uploaded videos"`
+}
+
+type MessageBus struct {
+	Driver      string   `yaml:"driver" env:"MESSAGE_BUS_DRIVER" flag:"message-bus-driver" default:"kafka" usage:"kafka or postgres"`
+	Brokers     []string `yaml:"brokers" env:"KAFKA_BROKER_URL" flag:"kafka-brokers" default:"kafka:9092" usage:"comma separated Kafka brokers"`
+	DatabaseURL string   `yaml:"database_url" env:"MESSAGE_BUS_DATABASE_URL" secret:"true" usage:"postgres database shared by all services, used by the postgres driver"`
+	Topic       string   `yaml:"topic" env:"TRANSCODING_TOPIC" flag:"transcoding-topic" default:"transcoding" usage:"topic transcoding jobs are published to"`
+
+	UserEventsTopic    string `yaml:"user_events_topic" env:"USER_EVENTS_TOPIC" default:"user-events" usage:"topic the user service publishes user events to"`
+	ConfirmationsTopic string `yaml:"confirmations_topic" env:"DELETION_CONFIRMATIONS_TOPIC" default:"user-deletion-confirmations" usage:"topic account deletions are confirmed on"`
+}
+
+type Auth struct {
+	JWKSURL string        `yaml:"jwks_url" env:"USER_JWKS_URL" flag:"jwks-url" default:"http://user_service:8080/.well-known/jwks.json" usage:"JWKS endpoint of the user service"`
+	KeysTTL time.Duration `yaml:"keys_ttl" env:"USER_JWKS_TTL" default:"5m" usage:"how long fetched keys are cached"`
+
+	RevocationsURL      string        `yaml:"revocations_url" env:"USER_REVOCATIONS_URL" flag:"revocations-url" default:"http://user_service:8080/internal/revocations" usage:"revocation list endpoint of the user service"`
+	RevocationsInterval time.Duration `yaml:"revocations_interval" env:"USER_REVOCATIONS_INTERVAL" default:"30s" usage:"how often the revocation list is fetched"`
+	RevocationsMaxAge   time.Duration `yaml:"revocations_max_age" env:"USER_REVOCATIONS_MAX_AGE" default:"5m" usage:"how old the revocation list may get before access tokens are refused"`
+
+	ServiceToken string `yaml:"service_token" env:"SERVICE_TOKEN" secret:"true" usage:"secret shared by the services for their internal endpoints"`
+}
+
+type Playback struct {
+	LeaseTTL      time.Duration `yaml:"lease_ttl" env:"PLAYBACK_LEASE_TTL" flag:"playback-lease-ttl" default:"90s" usage:"how long a stream lease lives without a heartbeat"`
+	SweepInterval time.Duration `yaml:"sweep_interval" env:"PLAYBACK_SWEEP_INTERVAL" default:"1m" usage:"how often expired stream leases are deleted"`
+}
+
+type Tracing struct {
+	Exporter string `yaml:"exporter" env:"OTEL_TRACES_EXPORTER" flag:"traces-exporter" usage:"none, stdout or otlp"`
+}
+
+func Load(args []string) (*Config, configloader.Options, error) {
+	cfg := &Config{}
+	opts, err := configloader.Load(cfg, serviceName, args)
+	return cfg, opts, err
+}
+
+func (c *Config) Validate() error {
+	var problems configloader.Problems
+
+	if c.HTTP.Addr == "" {
+		problems.Addf("http.addr is required")
+	}
+	if c.HTTP.ShutdownTimeout <= 0 {
+		problems.Addf("http.shutdown_timeout must be positive")
+	}
+	if c.Database.URL == "" {
+		problems.Addf("database.url is required (VIDEOS_DATABASE_URL)")
+	} else if u, err := url.Parse(c.Database.URL); err != nil || (u.Scheme != "postgres" && u.Scheme != "postgresql") {
+		problems.Addf("database.url must be a postgres:// URL")
+	}
+	if c.S3.Bucket == "" {
+		problems.Addf("s3.bucket is required (S3_BUCKET_NAME)")
+	}
+	switch c.MessageBus.Driver {
+	case "kafka":
+		if len(c.MessageBus.Brokers) == 0 {
+			problems.Addf("message_bus.brokers is required for the kafka driver")
+		}
+	case "postgres":
+		if u, err := url.Parse(c.MessageBus.DatabaseURL); err != nil || (u.Scheme != "postgres" && u.Scheme != "postgresql") {
+			problems.Addf("message_bus.database_url must be a postgres:// URL shared by all services (MESSAGE_BUS_DATABASE_URL)")
+		}
+	default:
+		problems.Addf("message_bus.driver %q is not one of kafka, postgres", c.MessageBus.Driver)
+	}
+	if c.MessageBus.Topic == "" || c.MessageBus.UserEventsTopic == "" || c.MessageBus.ConfirmationsTopic == "" {
+		problems.Addf("message_bus.topic, message_bus.user_events_topic and message_bus.confirmations_topic are required")
+	}
+	if u, err := url.Parse(c.Auth.JWKSURL); err != nil || u.Scheme == "" || u.Host == "" {
+		problems.Addf("auth.jwks_url must be an absolute URL (USER_JWKS_URL)")
+	}
+	if c.Auth.KeysTTL <= 0 {
+		problems.Addf("auth.keys_ttl must be positive")
+	}
+	if u, err := url.Parse(c.Auth.RevocationsURL); err != nil || u.Scheme == "" || u.Host == "" {
+		problems.Addf("auth.revocations_url must be an absolute URL (USER_REVOCATIONS_URL)")
+	}
+	if len(c.Auth.ServiceToken) < auth.MinServiceTokenSize {
+		problems.Addf("auth.service_token must be at least %d characters (SERVICE_TOKEN)", auth.MinServiceTokenSize)
+	}
+	if c.Auth.RevocationsInterval <= 0 {
+		problems.Addf("auth.revocations_interval must be positive")
+	}
+	if c.Auth.RevocationsMaxAge <= c.Auth.RevocationsInterval {
+		problems.Addf("auth.revocations_max_age must be longer than auth.revocations_interval")
+	}
+	if c.Playback.LeaseTTL < 3*time.Second {
+		problems.Addf("playback.lease_ttl must be at least 3s")
+	}
+	if c.Playback.SweepInterval <= 0 {
+		problems.Addf("playback.sweep_interval must be positive")
+	}
+	if !telemetry.ValidExporter(c.Tracing.Exporter) {
+		problems.Addf("tracing.exporter %q is not one of none, stdout, otlp", c.Tracing.Exporter)
+	}
+
+	return problems.Err()
+}
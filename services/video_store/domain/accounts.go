@@ -0,0 +1,97 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/eduardo-ax/video-streaming/pkg/events"
+)
+
+// DeletionConfirmer tells the user service this service erased the data of
+// a deleted account.
+type DeletionConfirmer interface {
+	ConfirmDeletion(ctx context.Context, userID string, deletionID string) error
+}
+
+// OwnedVideo is the metadata of a video as exported to its owner.
+type OwnedVideo struct {
+	ID             string     `json:"id"`
+	Title          string     `json:"title"`
+	Description    string     `json:"description"`
+	MaturityRating int        `json:"maturity_rating"`
+	CreatedAt      *time.Time `json:"created_at,omitempty"`
+}
+
+// WatchEntry is a playback the user started, ProfileID is empty for tokens
+// issued before profiles existed.
+type WatchEntry struct {
+	VideoID   string    `json:"video_id"`
+	ProfileID string    `json:"profile_id,omitempty"`
+	WatchedAt time.Time `json:"watched_at"`
+}
+
+// AccountData is what this service holds about a user, for their data
+// export.
+type AccountData struct {
+	Videos       []OwnedVideo
+	WatchHistory []WatchEntry
+}
+
+func (v *VideoManager) ExportAccount(ctx context.Context, userID string) (*AccountData, error) {
+	videos, err := v.db.ListOwnedVideoDetails(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	history, err := v.db.ListWatchHistory(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &AccountData{Videos: videos, WatchHistory: history}, nil
+}
+
+// AccountEraser deletes what deleted users owned: their videos with their
+// files, their stream leases and watch history.
+type AccountEraser struct {
+	db          Storage
+	objectStore ObjectStore
+	confirmer   DeletionConfirmer
+}
+
+func NewAccountEraser(db Storage, objectStore ObjectStore, confirmer DeletionConfirmer) *AccountEraser {
+	return &AccountEraser{
+		db:          db,
+		objectStore: objectStore,
+		confirmer:   confirmer,
+	}
+}
+
+// HandleUserEvent erases the account of a user.deleted event and confirms.
+// It's idempotent, a redelivered event finds nothing left and confirms
+// again. Files go before their row so a failure leaves the video listed for
+// the retry.
+func (a *AccountEraser) HandleUserEvent(ctx context.Context, event events.UserEvent) error {
+	if event.Type != events.TypeDeleted {
+		return nil
+	}
+	videos, err := a.db.ListOwnedVideos(ctx, event.UserID)
+	if err != nil {
+		return err
+	}
+	for _, id := range videos {
+		if err := a.objectStore.DeletePrefix(ctx, fmt.Sprintf("videos/%s/", id)); err != nil {
+			return fmt.Errorf("error deleting files of video %s: %w", id, err)
+		}
+		if err := a.db.DeleteVideo(ctx, id); err != nil && !errors.Is(err, ErrVideoNotFound) {
+			return err
+		}
+	}
+	if err := a.db.DeleteUserLeases(ctx, event.UserID); err != nil {
+		return err
+	}
+	if err := a.db.DeleteWatchHistory(ctx, event.UserID); err != nil {
+		return err
+	}
+	return a.confirmer.ConfirmDeletion(ctx, event.UserID, event.Deletion.ID)
+}
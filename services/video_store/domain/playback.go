@@ -0,0 +1,122 @@
+package domain
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/eduardo-ax/video-streaming/pkg/auth"
+)
+
+var (
+	ErrStreamLimit   = errors.New("the plan allows no more concurrent streams")
+	ErrLeaseNotFound = errors.New("playback lease not found")
+	// ErrLeaseRequired is returned for playlists and segments requested
+	// without a live lease on their video.
+	ErrLeaseRequired = errors.New("a live playback lease is required")
+
+	playlistURIAttribute = regexp.MustCompile(`URI="([^"]*)"`)
+)
+
+// Lease is a running playback. It counts against the plan's concurrent
+// streams until it is stopped or misses its heartbeats past ExpiresAt.
+type Lease struct {
+	ID        string
+	UserID    string
+	ProfileID string
+	SessionID string
+	VideoID   string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// StartPlayback checks the viewer may watch the video and takes a lease on
+// it. A session plays one video at a time: its previous lease ends, so a
+// reloaded player doesn't count twice. Tokens issued before max_streams was
+// added are allowed one stream.
+func (v *VideoManager) StartPlayback(ctx context.Context, videoID string, viewer *auth.Claims) (*Lease, error) {
+	rating, err := v.db.GetVideoMaturity(ctx, videoID)
+	if err != nil {
+		return nil, err
+	}
+	if !viewer.CanWatch(rating) {
+		return nil, ErrMaturityRestricted
+	}
+
+	lease := &Lease{
+		UserID:    viewer.UserID,
+		ProfileID: viewer.ProfileID,
+		SessionID: viewer.SessionID,
+		VideoID:   videoID,
+		ExpiresAt: time.Now().Add(v.leaseTTL),
+	}
+	if err := v.db.CreateLease(ctx, lease, max(viewer.MaxStreams, 1)); err != nil {
+		return nil, err
+	}
+	return lease, nil
+}
+
+// Heartbeat keeps a live lease of the viewer for another lease TTL.
+func (v *VideoManager) Heartbeat(ctx context.Context, id string, viewer *auth.Claims) (*Lease, error) {
+	return v.db.RenewLease(ctx, id, viewer.UserID, time.Now().Add(v.leaseTTL))
+}
+
+// StopPlayback ends a lease of the viewer, freeing its stream at once.
+func (v *VideoManager) StopPlayback(ctx context.Context, id string, viewer *auth.Claims) error {
+	return v.db.EndLease(ctx, id, viewer.UserID)
+}
+
+// HeartbeatInterval is how often players should renew their lease, a few
+// times per TTL so a late heartbeat doesn't cut playback.
+func (v *VideoManager) HeartbeatInterval() time.Duration {
+	return v.leaseTTL / 3
+}
+
+// ExpireLeases deletes the leases that ended or missed their heartbeats.
+func (v *VideoManager) ExpireLeases(ctx context.Context) (int64, error) {
+	return v.db.DeleteExpiredLeases(ctx, time.Now())
+}
+
+// rewritePlaylist adds the lease to every URI of an HLS playlist, players
+// resolve them against the playlist URL and would drop its query otherwise.
+func rewritePlaylist(playlist []byte, leaseID string) []byte {
+	param := "lease=" + url.QueryEscape(leaseID)
+	withLease := func(uri string) string {
+		if strings.Contains(uri, "?") {
+			return uri + "&" + param
+		}
+		return uri + "?" + param
+	}
+
+	lines := bytes.Split(playlist, []byte("\n"))
+	for i, line := range lines {
+		text := strings.TrimRight(string(line), "\r")
+		switch {
+		case text == "":
+		case strings.HasPrefix(text, "#"):
+			text = playlistURIAttribute.ReplaceAllStringFunc(text, func(attr string) string {
+				uri := playlistURIAttribute.FindStringSubmatch(attr)[1]
+				return `URI="` + withLease(uri) + `"`
+			})
+			lines[i] = []byte(text)
+		default:
+			lines[i] = []byte(withLease(text))
+		}
+	}
+	return bytes.Join(lines, []byte("\n"))
+}
+
+func readPlaylist(data io.ReadCloser, leaseID string) (io.ReadCloser, error) {
+	defer data.Close()
+	playlist, err := io.ReadAll(data)
+	if err != nil {
+		return nil, fmt.Errorf("error reading playlist: %w", err)
+	}
+	return io.NopCloser(bytes.NewReader(rewritePlaylist(playlist, leaseID))), nil
+}
@@ -2,18 +2,33 @@ package domain
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
+	"strings"
+	"time"
+
+	"github.com/eduardo-ax/video-streaming/pkg/auth"
+	"github.com/eduardo-ax/video-streaming/pkg/jobs"
+)
+
+var (
+	ErrVideoNotFound = errors.New("video not found")
+	ErrForbidden     = errors.New("not allowed")
+	// ErrMaturityRestricted is returned when the viewer's profile may not
+	// watch the video's rating.
+	ErrMaturityRestricted = errors.New("video rated above the profile's maturity limit")
 )
 
 type Video struct {
-	Content     *multipart.FileHeader
-	Title       string
-	Description string
+	Content        *multipart.FileHeader
+	Title          string
+	Description    string
+	MaturityRating int
 }
 
-func NewVideo(title string, description string, content *multipart.FileHeader) (Video, error) {
+func NewVideo(title string, description string, maturityRating int, content *multipart.FileHeader) (Video, error) {
 
 	if content == nil {
 		return Video{}, fmt.Errorf("video content cannot be nil")
@@ -23,40 +38,53 @@ func NewVideo(title string, description string, content *multipart.FileHeader) (
 		return Video{}, fmt.Errorf("invalid video data")
 	}
 
+	if !auth.ValidMaturity(maturityRating) {
+		return Video{}, fmt.Errorf("maturity rating must be one of %v", auth.MaturityRatings)
+	}
+
 	return Video{
-		Content:     content,
-		Title:       title,
-		Description: description,
+		Content:        content,
+		Title:          title,
+		Description:    description,
+		MaturityRating: maturityRating,
 	}, nil
 }
 
 type VideoUploader interface {
-	Store(ctx context.Context, title string, description string, file *multipart.FileHeader) error
-	GetStream(ctx context.Context, id string, filename string) (io.ReadCloser, string, error)
+	Store(ctx context.Context, ownerID string, title string, description string, maturityRating int, file *multipart.FileHeader) error
+	GetStream(ctx context.Context, id string, filename string, leaseID string) (io.ReadCloser, string, error)
+	Delete(ctx context.Context, id string, requester *auth.Claims) error
+	StartPlayback(ctx context.Context, videoID string, viewer *auth.Claims) (*Lease, error)
+	Heartbeat(ctx context.Context, id string, viewer *auth.Claims) (*Lease, error)
+	StopPlayback(ctx context.Context, id string, viewer *auth.Claims) error
+	HeartbeatInterval() time.Duration
+	ExportAccount(ctx context.Context, userID string) (*AccountData, error)
 }
 
 type VideoManager struct {
 	db          Storage
 	pub         MessagePublisher
 	objectStore ObjectStore
+	leaseTTL    time.Duration
 }
 
-func NewVideoManager(db Storage, pub MessagePublisher, objectStore ObjectStore) *VideoManager {
+func NewVideoManager(db Storage, pub MessagePublisher, objectStore ObjectStore, leaseTTL time.Duration) *VideoManager {
 	return &VideoManager{
 		db:          db,
 		pub:         pub,
 		objectStore: objectStore,
+		leaseTTL:    leaseTTL,
 	}
 }
 
-func (v *VideoManager) Store(ctx context.Context, title string, description string, file *multipart.FileHeader) error {
-	src, err := NewVideo(title, description, file)
+func (v *VideoManager) Store(ctx context.Context, ownerID string, title string, description string, maturityRating int, file *multipart.FileHeader) error {
+	src, err := NewVideo(title, description, maturityRating, file)
 	if err != nil {
 		fmt.Printf("Error creating video entity: %v", err)
 		return err
 	}
 
-	id, err := v.db.Persist(ctx, src.Title, src.Description)
+	id, err := v.db.Persist(ctx, ownerID, src.Title, src.Description, src.MaturityRating)
 	if err != nil {
 		fmt.Printf("Error persisting video metadata: %v", err)
 		return err
@@ -69,27 +97,88 @@ func (v *VideoManager) Store(ctx context.Context, title string, description stri
 	}
 
 	fmt.Printf("Video saved with ID: %d\n", id)
-	err = v.pub.SendMessage(ctx, fmt.Sprintf("%d", id), file.Filename)
+	videoID := fmt.Sprintf("%d", id)
+	job := jobs.NewTranscodeJob(videoID, jobs.SourceKey(videoID, file.Filename), ownerID)
+	err = v.pub.SendMessage(ctx, job)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (v *VideoManager) GetStream(ctx context.Context, id string, filename string) (io.ReadCloser, string, error) {
+// GetStream serves the playlists and segments of a video to the holder of
+// a live lease on it, the maturity limit was checked when the lease was
+// taken. Playlists come back with the lease added to their URIs.
+func (v *VideoManager) GetStream(ctx context.Context, id string, filename string, leaseID string) (io.ReadCloser, string, error) {
+	if leaseID == "" {
+		return nil, "", ErrLeaseRequired
+	}
+	err := v.db.LiveLease(ctx, leaseID, id)
+	if errors.Is(err, ErrLeaseNotFound) {
+		return nil, "", ErrLeaseRequired
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
 	key := fmt.Sprintf("videos/%s/%s", id, filename)
-	return v.objectStore.Download(ctx, key)
+	data, contentType, err := v.objectStore.Download(ctx, key)
+	if err != nil || !strings.HasSuffix(filename, ".m3u8") {
+		return data, contentType, err
+	}
+	playlist, err := readPlaylist(data, leaseID)
+	if err != nil {
+		return nil, "", err
+	}
+	return playlist, contentType, nil
+}
+
+// Delete removes a video and its files. Owners with videos:delete:own can
+// delete their videos, moderators with videos:delete:any every video.
+func (v *VideoManager) Delete(ctx context.Context, id string, requester *auth.Claims) error {
+	ownerID, err := v.db.GetVideoOwner(ctx, id)
+	if err != nil {
+		return err
+	}
+	own := ownerID != "" && ownerID == requester.UserID
+	if !requester.HasPermission(auth.PermVideosDeleteAny) && !(own && requester.HasPermission(auth.PermVideosDeleteOwn)) {
+		return ErrForbidden
+	}
+
+	if err := v.db.DeleteVideo(ctx, id); err != nil {
+		return err
+	}
+	if err := v.objectStore.DeletePrefix(ctx, fmt.Sprintf("videos/%s/", id)); err != nil {
+		return fmt.Errorf("error deleting files of video %s: %w", id, err)
+	}
+	return nil
 }
 
 type Storage interface {
-	Persist(ctx context.Context, title string, description string) (int, error)
+	Persist(ctx context.Context, ownerID string, title string, description string, maturityRating int) (int, error)
+	GetVideoOwner(ctx context.Context, id string) (string, error)
+	GetVideoMaturity(ctx context.Context, id string) (int, error)
+	DeleteVideo(ctx context.Context, id string) error
+	ListOwnedVideos(ctx context.Context, ownerID string) ([]string, error)
+	// CreateLease fails with ErrStreamLimit when the user already holds
+	// maxStreams live leases.
+	CreateLease(ctx context.Context, lease *Lease, maxStreams int) error
+	RenewLease(ctx context.Context, id string, userID string, expiresAt time.Time) (*Lease, error)
+	EndLease(ctx context.Context, id string, userID string) error
+	LiveLease(ctx context.Context, id string, videoID string) error
+	DeleteExpiredLeases(ctx context.Context, now time.Time) (int64, error)
+	DeleteUserLeases(ctx context.Context, userID string) error
+	ListOwnedVideoDetails(ctx context.Context, ownerID string) ([]OwnedVideo, error)
+	ListWatchHistory(ctx context.Context, userID string) ([]WatchEntry, error)
+	DeleteWatchHistory(ctx context.Context, userID string) error
 }
 
 type MessagePublisher interface {
-	SendMessage(ctx context.Context, id string, filename string) error
+	SendMessage(ctx context.Context, job jobs.TranscodeJob) error
 }
 
 type ObjectStore interface {
 	UploadVideo(ctx context.Context, file *multipart.FileHeader, id int) error
 	Download(ctx context.Context, key string) (io.ReadCloser, string, error)
+	DeletePrefix(ctx context.Context, prefix string) error
 }
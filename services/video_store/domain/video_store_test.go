@@ -5,8 +5,13 @@ import (
 	"errors"
 	"io"
 	"mime/multipart"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/eduardo-ax/video-streaming/pkg/auth"
+	"github.com/eduardo-ax/video-streaming/pkg/events"
+	"github.com/eduardo-ax/video-streaming/pkg/jobs"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -44,13 +49,13 @@ func TestVideoValidate(t *testing.T) {
 			title:       "Sample Video",
 			description: "",
 			content:     &multipart.FileHeader{Filename: "video.mp4", Size: 1024},
-			expected:    true,
+			expected:    false,
 			desc:        "should fail validation when description is empty",
 		},
 	}
 	for name, tc := range tests {
 		t.Run(name, func(t *testing.T) {
-			_, err := NewVideo(tc.title, tc.description, tc.content)
+			_, err := NewVideo(tc.title, tc.description, auth.Maturity13, tc.content)
 			if err != nil && tc.expected {
 				t.Errorf("Test %s failed: %s. Expected valid but got error: %v", name, tc.desc, err)
 			}
@@ -60,15 +65,82 @@ func TestVideoValidate(t *testing.T) {
 
 type MockStorage struct{ mock.Mock }
 
-func (m *MockStorage) Persist(ctx context.Context, title string, description string) (int, error) {
+func (m *MockStorage) Persist(ctx context.Context, ownerID string, title string, description string, maturityRating int) (int, error) {
 	args := m.Called(ctx, title, description)
 	return args.Get(0).(int), args.Error(1)
 }
 
+func (m *MockStorage) GetVideoOwner(ctx context.Context, id string) (string, error) {
+	args := m.Called(ctx, id)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockStorage) GetVideoMaturity(ctx context.Context, id string) (int, error) {
+	args := m.Called(ctx, id)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockStorage) DeleteVideo(ctx context.Context, id string) error {
+	return m.Called(ctx, id).Error(0)
+}
+
+func (m *MockStorage) CreateLease(ctx context.Context, lease *Lease, maxStreams int) error {
+	args := m.Called(ctx, lease.VideoID, maxStreams)
+	if args.Error(0) == nil {
+		lease.ID = "lease-1"
+	}
+	return args.Error(0)
+}
+
+func (m *MockStorage) RenewLease(ctx context.Context, id string, userID string, expiresAt time.Time) (*Lease, error) {
+	args := m.Called(ctx, id, userID)
+	lease, _ := args.Get(0).(*Lease)
+	return lease, args.Error(1)
+}
+
+func (m *MockStorage) EndLease(ctx context.Context, id string, userID string) error {
+	return m.Called(ctx, id, userID).Error(0)
+}
+
+func (m *MockStorage) LiveLease(ctx context.Context, id string, videoID string) error {
+	return m.Called(ctx, id, videoID).Error(0)
+}
+
+func (m *MockStorage) DeleteExpiredLeases(ctx context.Context, now time.Time) (int64, error) {
+	args := m.Called(ctx)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockStorage) ListOwnedVideos(ctx context.Context, ownerID string) ([]string, error) {
+	args := m.Called(ctx, ownerID)
+	ids, _ := args.Get(0).([]string)
+	return ids, args.Error(1)
+}
+
+func (m *MockStorage) DeleteUserLeases(ctx context.Context, userID string) error {
+	return m.Called(ctx, userID).Error(0)
+}
+
+func (m *MockStorage) ListOwnedVideoDetails(ctx context.Context, ownerID string) ([]OwnedVideo, error) {
+	args := m.Called(ctx, ownerID)
+	videos, _ := args.Get(0).([]OwnedVideo)
+	return videos, args.Error(1)
+}
+
+func (m *MockStorage) ListWatchHistory(ctx context.Context, userID string) ([]WatchEntry, error) {
+	args := m.Called(ctx, userID)
+	history, _ := args.Get(0).([]WatchEntry)
+	return history, args.Error(1)
+}
+
+func (m *MockStorage) DeleteWatchHistory(ctx context.Context, userID string) error {
+	return m.Called(ctx, userID).Error(0)
+}
+
 type MockMessagePublisher struct{ mock.Mock }
 
-func (m *MockMessagePublisher) SendMessage(ctx context.Context, message string, filename string) error {
-	args := m.Called(ctx, message)
+func (m *MockMessagePublisher) SendMessage(ctx context.Context, job jobs.TranscodeJob) error {
+	args := m.Called(ctx, job.VideoID)
 	return args.Error(0)
 }
 
@@ -81,7 +153,11 @@ func (m *MockObjectStore) UploadVideo(ctx context.Context, file *multipart.FileH
 
 func (m *MockObjectStore) Download(ctx context.Context, key string) (io.ReadCloser, string, error) {
 	args := m.Called(ctx, key)
-	return args.Get(0).(io.ReadCloser), args.Get(1).(string), args.Error(0)
+	return args.Get(0).(io.ReadCloser), args.Get(1).(string), args.Error(2)
+}
+
+func (m *MockObjectStore) DeletePrefix(ctx context.Context, prefix string) error {
+	return m.Called(ctx, prefix).Error(0)
 }
 
 func TestVideoManager_Store(t *testing.T) {
@@ -124,6 +200,7 @@ func TestVideoManager_Store(t *testing.T) {
 			content:     file,
 			setupMocks: func(db *MockStorage, pub *MockMessagePublisher, store *MockObjectStore) {
 				db.On("Persist", mock.Anything, "Sample Video", "This is a sample video description.").Return(1, nil)
+				store.On("UploadVideo", mock.Anything, file, 1).Return(nil)
 				pub.On("SendMessage", mock.Anything, "1").Return(errors.New("failed to send message"))
 			},
 			expected: false,
@@ -135,7 +212,6 @@ func TestVideoManager_Store(t *testing.T) {
 			content:     file,
 			setupMocks: func(db *MockStorage, pub *MockMessagePublisher, store *MockObjectStore) {
 				db.On("Persist", mock.Anything, "Sample Video", "This is a sample video description.").Return(1, nil)
-				pub.On("SendMessage", mock.Anything, "1").Return(nil)
 				store.On("UploadVideo", mock.Anything, file, 1).Return(errors.New("failed to upload video"))
 			},
 			expected: false,
@@ -150,8 +226,8 @@ func TestVideoManager_Store(t *testing.T) {
 			storeMock := new(MockObjectStore)
 
 			tc.setupMocks(dbMock, pubMock, storeMock)
-			manager := NewVideoManager(dbMock, pubMock, storeMock)
-			err := manager.Store(ctx, tc.title, tc.description, tc.content)
+			manager := NewVideoManager(dbMock, pubMock, storeMock, time.Minute)
+			err := manager.Store(ctx, "user-1", tc.title, tc.description, auth.MaturityAll, tc.content)
 
 			if tc.expected {
 				assert.NoError(t, err)
@@ -165,3 +241,293 @@ func TestVideoManager_Store(t *testing.T) {
 	}
 
 }
+
+func TestVideoManager_Delete(t *testing.T) {
+	ctx := context.Background()
+	creator := []string{auth.PermVideosUpload, auth.PermVideosDeleteOwn}
+	admin := []string{auth.PermVideosDeleteAny}
+
+	tests := map[string]struct {
+		owner       string
+		requester   *auth.Claims
+		ownerErr    error
+		expectErr   error
+		expectFiles bool
+	}{
+		"owner deletes their video": {
+			owner:       "user-1",
+			requester:   &auth.Claims{UserID: "user-1", Permissions: creator},
+			expectFiles: true,
+		},
+		"creator can't delete someone else's video": {
+			owner:     "user-2",
+			requester: &auth.Claims{UserID: "user-1", Permissions: creator},
+			expectErr: ErrForbidden,
+		},
+		"video without owner is left to moderators": {
+			requester: &auth.Claims{UserID: "", Permissions: creator},
+			expectErr: ErrForbidden,
+		},
+		"moderator deletes any video": {
+			owner:       "user-2",
+			requester:   &auth.Claims{UserID: "admin-1", Permissions: admin},
+			expectFiles: true,
+		},
+		"unknown video": {
+			requester: &auth.Claims{UserID: "admin-1", Permissions: admin},
+			ownerErr:  ErrVideoNotFound,
+			expectErr: ErrVideoNotFound,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			dbMock := new(MockStorage)
+			storeMock := new(MockObjectStore)
+			dbMock.On("GetVideoOwner", ctx, "1").Return(tc.owner, tc.ownerErr)
+			dbMock.On("DeleteVideo", ctx, "1").Return(nil)
+			storeMock.On("DeletePrefix", ctx, "videos/1/").Return(nil)
+
+			manager := NewVideoManager(dbMock, new(MockMessagePublisher), storeMock, time.Minute)
+			err := manager.Delete(ctx, "1", tc.requester)
+			if tc.expectErr != nil {
+				assert.ErrorIs(t, err, tc.expectErr)
+				dbMock.AssertNotCalled(t, "DeleteVideo", ctx, "1")
+				return
+			}
+			assert.NoError(t, err)
+			storeMock.AssertCalled(t, "DeletePrefix", ctx, "videos/1/")
+		})
+	}
+}
+
+func TestVideoManager_GetStream(t *testing.T) {
+	ctx := context.Background()
+
+	tests := map[string]struct {
+		filename  string
+		leaseID   string
+		leaseErr  error
+		content   string
+		expected  string
+		expectErr error
+	}{
+		"segment with a live lease": {
+			filename: "index0.ts",
+			leaseID:  "lease-1",
+			content:  "segment",
+			expected: "segment",
+		},
+		"playlist carries the lease": {
+			filename: "index.m3u8",
+			leaseID:  "lease-1",
+			content:  "#EXTM3U\n#EXT-X-KEY:METHOD=AES-128,URI=\"key.bin\"\n#EXTINF:10.0,\nindex0.ts\n#EXT-X-ENDLIST\n",
+			expected: "#EXTM3U\n#EXT-X-KEY:METHOD=AES-128,URI=\"key.bin?lease=lease-1\"\n#EXTINF:10.0,\nindex0.ts?lease=lease-1\n#EXT-X-ENDLIST\n",
+		},
+		"no lease": {
+			filename:  "index.m3u8",
+			expectErr: ErrLeaseRequired,
+		},
+		"lease ended, expired or for another video": {
+			filename:  "index0.ts",
+			leaseID:   "lease-1",
+			leaseErr:  ErrLeaseNotFound,
+			expectErr: ErrLeaseRequired,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			db := new(MockStorage)
+			store := new(MockObjectStore)
+			db.On("LiveLease", ctx, tc.leaseID, "7").Return(tc.leaseErr)
+			store.On("Download", ctx, "videos/7/"+tc.filename).Return(io.NopCloser(strings.NewReader(tc.content)), "application/octet-stream", nil)
+
+			manager := NewVideoManager(db, new(MockMessagePublisher), store, time.Minute)
+			data, _, err := manager.GetStream(ctx, "7", tc.filename, tc.leaseID)
+			if tc.expectErr != nil {
+				assert.ErrorIs(t, err, tc.expectErr)
+				store.AssertNotCalled(t, "Download", ctx, mock.Anything)
+				return
+			}
+			assert.NoError(t, err)
+			body, _ := io.ReadAll(data)
+			assert.Equal(t, tc.expected, string(body))
+		})
+	}
+}
+
+func TestVideoManager_StartPlayback(t *testing.T) {
+	ctx := context.Background()
+
+	tests := map[string]struct {
+		viewer     *auth.Claims
+		rating     int
+		ratingErr  error
+		maxStreams int
+		leaseErr   error
+		expectErr  error
+	}{
+		"within the plan's streams": {
+			viewer:     &auth.Claims{UserID: "user-1", SessionID: "session-1", MaxStreams: 2},
+			rating:     auth.MaturityAdult,
+			maxStreams: 2,
+		},
+		"token without max_streams gets one stream": {
+			viewer:     &auth.Claims{UserID: "user-1", SessionID: "session-1"},
+			rating:     auth.MaturityAdult,
+			maxStreams: 1,
+		},
+		"every stream in use": {
+			viewer:     &auth.Claims{UserID: "user-1", SessionID: "session-1", MaxStreams: 2},
+			rating:     auth.MaturityAdult,
+			maxStreams: 2,
+			leaseErr:   ErrStreamLimit,
+			expectErr:  ErrStreamLimit,
+		},
+		"kids profile, rated for kids": {
+			viewer:     &auth.Claims{UserID: "user-1", ProfileID: "kids", MaxMaturity: auth.Maturity7, MaxStreams: 1},
+			rating:     auth.Maturity7,
+			maxStreams: 1,
+		},
+		"kids profile, rated above its limit": {
+			viewer:    &auth.Claims{UserID: "user-1", ProfileID: "kids", MaxMaturity: auth.Maturity7, MaxStreams: 1},
+			rating:    auth.Maturity16,
+			expectErr: ErrMaturityRestricted,
+		},
+		"unknown video": {
+			viewer:    &auth.Claims{UserID: "user-1", MaxStreams: 1},
+			ratingErr: ErrVideoNotFound,
+			expectErr: ErrVideoNotFound,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			db := new(MockStorage)
+			db.On("GetVideoMaturity", ctx, "7").Return(tc.rating, tc.ratingErr)
+			db.On("CreateLease", ctx, "7", tc.maxStreams).Return(tc.leaseErr)
+
+			manager := NewVideoManager(db, new(MockMessagePublisher), new(MockObjectStore), time.Minute)
+			lease, err := manager.StartPlayback(ctx, "7", tc.viewer)
+			if tc.expectErr != nil {
+				assert.ErrorIs(t, err, tc.expectErr)
+				if tc.leaseErr == nil {
+					db.AssertNotCalled(t, "CreateLease", ctx, mock.Anything, mock.Anything)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "lease-1", lease.ID)
+			assert.Equal(t, tc.viewer.ProfileID, lease.ProfileID)
+			assert.WithinDuration(t, time.Now().Add(time.Minute), lease.ExpiresAt, time.Second)
+		})
+	}
+}
+
+type MockDeletionConfirmer struct{ mock.Mock }
+
+func (m *MockDeletionConfirmer) ConfirmDeletion(ctx context.Context, userID string, deletionID string) error {
+	return m.Called(ctx, userID, deletionID).Error(0)
+}
+
+func TestVideoManager_ExportAccount(t *testing.T) {
+	ctx := context.Background()
+
+	tests := map[string]struct {
+		historyErr error
+		expectErr  bool
+	}{
+		"videos and watch history": {},
+		"storage error": {
+			historyErr: errors.New("db unavailable"),
+			expectErr:  true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			dbMock := new(MockStorage)
+			videos := []OwnedVideo{{ID: "1", Title: "Title", MaturityRating: 13}}
+			history := []WatchEntry{{VideoID: "2", ProfileID: "profile-1"}}
+			dbMock.On("ListOwnedVideoDetails", ctx, "user-1").Return(videos, nil)
+			if tc.historyErr != nil {
+				dbMock.On("ListWatchHistory", ctx, "user-1").Return(nil, tc.historyErr)
+			} else {
+				dbMock.On("ListWatchHistory", ctx, "user-1").Return(history, nil)
+			}
+
+			manager := NewVideoManager(dbMock, new(MockMessagePublisher), new(MockObjectStore), time.Minute)
+			data, err := manager.ExportAccount(ctx, "user-1")
+			if tc.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, videos, data.Videos)
+			assert.Equal(t, history, data.WatchHistory)
+		})
+	}
+}
+
+func TestAccountEraser_HandleUserEvent(t *testing.T) {
+	ctx := context.Background()
+
+	tests := map[string]struct {
+		event         events.UserEvent
+		deleteErr     error
+		filesErr      error
+		expectErr     bool
+		expectConfirm bool
+	}{
+		"deletes the videos and confirms": {
+			event:         events.NewDeleted("user-1", "deletion-1"),
+			expectConfirm: true,
+		},
+		"redelivered deletion confirms again": {
+			event:         events.NewDeleted("user-1", "deletion-1"),
+			deleteErr:     ErrVideoNotFound,
+			expectConfirm: true,
+		},
+		"failed file deletion is retried": {
+			event:     events.NewDeleted("user-1", "deletion-1"),
+			filesErr:  errors.New("s3 unavailable"),
+			expectErr: true,
+		},
+		"other events are ignored": {
+			event: events.NewPlanChanged("user-1", events.PlanChange{To: 1, Direction: events.DirectionUpgrade}),
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			dbMock := new(MockStorage)
+			storeMock := new(MockObjectStore)
+			confirmer := new(MockDeletionConfirmer)
+			dbMock.On("ListOwnedVideos", ctx, "user-1").Return([]string{"1", "2"}, nil)
+			dbMock.On("DeleteVideo", ctx, mock.Anything).Return(tc.deleteErr)
+			dbMock.On("DeleteUserLeases", ctx, "user-1").Return(nil)
+			dbMock.On("DeleteWatchHistory", ctx, "user-1").Return(nil)
+			storeMock.On("DeletePrefix", ctx, mock.Anything).Return(tc.filesErr)
+			confirmer.On("ConfirmDeletion", ctx, "user-1", "deletion-1").Return(nil)
+
+			eraser := NewAccountEraser(dbMock, storeMock, confirmer)
+			err := eraser.HandleUserEvent(ctx, tc.event)
+			if tc.expectErr {
+				assert.Error(t, err)
+				dbMock.AssertNotCalled(t, "DeleteVideo", ctx, mock.Anything)
+			} else {
+				assert.NoError(t, err)
+			}
+			if !tc.expectConfirm {
+				confirmer.AssertNotCalled(t, "ConfirmDeletion", ctx, "user-1", "deletion-1")
+				return
+			}
+			storeMock.AssertCalled(t, "DeletePrefix", ctx, "videos/1/")
+			storeMock.AssertCalled(t, "DeletePrefix", ctx, "videos/2/")
+			dbMock.AssertCalled(t, "DeleteUserLeases", ctx, "user-1")
+			dbMock.AssertCalled(t, "DeleteWatchHistory", ctx, "user-1")
+			confirmer.AssertCalled(t, "ConfirmDeletion", ctx, "user-1", "deletion-1")
+		})
+	}
+}
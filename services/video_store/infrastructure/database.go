@@ -2,18 +2,23 @@ package infrastructure
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
-	"os"
 
+	"github.com/eduardo-ax/video-streaming/pkg/telemetry"
+	"github.com/eduardo-ax/video-streaming/services/video_store/domain"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-func NewPool() *pgxpool.Pool {
-	dbURL := os.Getenv("VIDEOS_DATABASE_URL")
-	if dbURL == "" {
-		log.Fatal("Error database host")
+func NewPool(dbURL string) *pgxpool.Pool {
+	poolConfig, err := pgxpool.ParseConfig(dbURL)
+	if err != nil {
+		log.Fatalf("erro parsing database url %v", err)
 	}
-	dbPool, err := pgxpool.New(context.Background(), dbURL)
+	poolConfig.ConnConfig.Tracer = telemetry.NewPgxTracer(poolConfig.ConnConfig.Database)
+	dbPool, err := pgxpool.NewWithConfig(context.Background(), poolConfig)
 	if err != nil {
 		log.Fatalf("erro conected database %v", err)
 	}
@@ -34,12 +39,92 @@ func (db *Database) Close() {
 	db.pool.Close()
 }
 
-func (db *Database) Persist(ctx context.Context, title string, description string) (int, error) {
+func (db *Database) Ping(ctx context.Context) error {
+	return db.pool.Ping(ctx)
+}
+
+func (db *Database) Persist(ctx context.Context, ownerID string, title string, description string, maturityRating int) (int, error) {
 	var id int
-	err := db.pool.QueryRow(ctx, "INSERT INTO videos (title, description) VALUES ($1, $2) RETURNING id", title, description).Scan(&id)
+	err := db.pool.QueryRow(ctx, "INSERT INTO videos (owner_id, title, description, maturity_rating) VALUES (NULLIF($1, '')::uuid, $2, $3, $4) RETURNING id", ownerID, title, description, maturityRating).Scan(&id)
 
 	if err != nil {
 		return -1, err
 	}
 	return id, nil
 }
+
+// GetVideoOwner returns an empty owner for videos uploaded before uploads
+// were authenticated.
+func (db *Database) GetVideoOwner(ctx context.Context, id string) (string, error) {
+	var ownerID *string
+	err := db.pool.QueryRow(ctx, "SELECT owner_id::text FROM videos WHERE id::text = $1", id).Scan(&ownerID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", domain.ErrVideoNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("error getting video: %w", err)
+	}
+	if ownerID == nil {
+		return "", nil
+	}
+	return *ownerID, nil
+}
+
+func (db *Database) GetVideoMaturity(ctx context.Context, id string) (int, error) {
+	var rating int
+	err := db.pool.QueryRow(ctx, "SELECT maturity_rating FROM videos WHERE id::text = $1", id).Scan(&rating)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, domain.ErrVideoNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error getting video: %w", err)
+	}
+	return rating, nil
+}
+
+func (db *Database) DeleteVideo(ctx context.Context, id string) error {
+	query, err := db.pool.Exec(ctx, "DELETE FROM videos WHERE id::text = $1", id)
+	if err != nil {
+		return fmt.Errorf("error deleting video: %w", err)
+	}
+	if query.RowsAffected() == 0 {
+		return domain.ErrVideoNotFound
+	}
+	return nil
+}
+
+func (db *Database) ListOwnedVideos(ctx context.Context, ownerID string) ([]string, error) {
+	rows, err := db.pool.Query(ctx, "SELECT id::text FROM videos WHERE owner_id::text = $1 ORDER BY id", ownerID)
+	if err != nil {
+		return nil, fmt.Errorf("error listing videos: %w", err)
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (db *Database) ListOwnedVideoDetails(ctx context.Context, ownerID string) ([]domain.OwnedVideo, error) {
+	rows, err := db.pool.Query(ctx, `
+		SELECT id::text, title, description, maturity_rating, created_at FROM videos
+		WHERE owner_id::text = $1 ORDER BY id`, ownerID)
+	if err != nil {
+		return nil, fmt.Errorf("error listing videos: %w", err)
+	}
+	defer rows.Close()
+	var videos []domain.OwnedVideo
+	for rows.Next() {
+		var v domain.OwnedVideo
+		if err := rows.Scan(&v.ID, &v.Title, &v.Description, &v.MaturityRating, &v.CreatedAt); err != nil {
+			return nil, err
+		}
+		videos = append(videos, v)
+	}
+	return videos, rows.Err()
+}
@@ -0,0 +1,179 @@
+package infrastructure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/eduardo-ax/video-streaming/services/video_store/domain"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// CreateLease holds an advisory lock on the user while counting, so
+// concurrent starts can't both take the last stream the plan allows. The
+// users live in another database, there is no row to lock. The playback is
+// recorded in the watch history with its lease.
+func (db *Database) CreateLease(ctx context.Context, lease *domain.Lease, maxStreams int) error {
+	err := pgx.BeginFunc(ctx, db.pool, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock(hashtext($1))", lease.UserID)
+		if err != nil {
+			return err
+		}
+		if lease.SessionID != "" {
+			_, err = tx.Exec(ctx, `
+				UPDATE stream_leases SET ended_at = NOW()
+				WHERE session_id = $1 AND ended_at IS NULL AND expires_at > NOW()`,
+				lease.SessionID)
+			if err != nil {
+				return err
+			}
+		}
+		var count int
+		err = tx.QueryRow(ctx, `
+			SELECT count(*) FROM stream_leases
+			WHERE user_id = $1 AND ended_at IS NULL AND expires_at > NOW()`,
+			lease.UserID).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count >= maxStreams {
+			return domain.ErrStreamLimit
+		}
+		err = tx.QueryRow(ctx, `
+			INSERT INTO stream_leases (user_id, profile_id, session_id, video_id, expires_at)
+			VALUES ($1, NULLIF($2, '')::uuid, NULLIF($3, '')::uuid, $4::bigint, $5)
+			RETURNING id, created_at`,
+			lease.UserID, lease.ProfileID, lease.SessionID, lease.VideoID, lease.ExpiresAt,
+		).Scan(&lease.ID, &lease.CreatedAt)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `
+			INSERT INTO watch_history (user_id, profile_id, video_id, watched_at)
+			VALUES ($1, NULLIF($2, '')::uuid, $3::bigint, $4)`,
+			lease.UserID, lease.ProfileID, lease.VideoID, lease.CreatedAt)
+		return err
+	})
+	if errors.Is(err, domain.ErrStreamLimit) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("error creating lease: %w", err)
+	}
+	return nil
+}
+
+// RenewLease reports the leases of other users, and those already ended or
+// expired, as not found: a player that lost its lease has to start again.
+func (db *Database) RenewLease(ctx context.Context, id string, userID string, expiresAt time.Time) (*domain.Lease, error) {
+	lease := &domain.Lease{}
+	var profileID, sessionID *string
+	err := db.pool.QueryRow(ctx, `
+		UPDATE stream_leases SET expires_at = $3
+		WHERE id = $1::uuid AND user_id = $2::uuid AND ended_at IS NULL AND expires_at > NOW()
+		RETURNING id, user_id, profile_id::text, session_id::text, video_id::text, created_at, expires_at`,
+		id, userID, expiresAt,
+	).Scan(&lease.ID, &lease.UserID, &profileID, &sessionID, &lease.VideoID, &lease.CreatedAt, &lease.ExpiresAt)
+	if errors.Is(err, pgx.ErrNoRows) || isInvalidID(err) {
+		return nil, domain.ErrLeaseNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error renewing lease: %w", err)
+	}
+	if profileID != nil {
+		lease.ProfileID = *profileID
+	}
+	if sessionID != nil {
+		lease.SessionID = *sessionID
+	}
+	return lease, nil
+}
+
+func (db *Database) EndLease(ctx context.Context, id string, userID string) error {
+	query, err := db.pool.Exec(ctx, `
+		UPDATE stream_leases SET ended_at = NOW()
+		WHERE id = $1::uuid AND user_id = $2::uuid AND ended_at IS NULL AND expires_at > NOW()`,
+		id, userID)
+	if isInvalidID(err) {
+		return domain.ErrLeaseNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("error ending lease: %w", err)
+	}
+	if query.RowsAffected() == 0 {
+		return domain.ErrLeaseNotFound
+	}
+	return nil
+}
+
+func (db *Database) LiveLease(ctx context.Context, id string, videoID string) error {
+	var live bool
+	err := db.pool.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM stream_leases
+		WHERE id = $1::uuid AND video_id = $2::bigint AND ended_at IS NULL AND expires_at > NOW())`,
+		id, videoID).Scan(&live)
+	if isInvalidID(err) {
+		return domain.ErrLeaseNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("error checking lease: %w", err)
+	}
+	if !live {
+		return domain.ErrLeaseNotFound
+	}
+	return nil
+}
+
+// DeleteExpiredLeases removes the leases that ended or expired before now.
+// The queries above already ignore them, this keeps the table small.
+func (db *Database) DeleteExpiredLeases(ctx context.Context, now time.Time) (int64, error) {
+	query, err := db.pool.Exec(ctx, "DELETE FROM stream_leases WHERE ended_at IS NOT NULL OR expires_at <= $1", now)
+	if err != nil {
+		return 0, fmt.Errorf("error deleting expired leases: %w", err)
+	}
+	return query.RowsAffected(), nil
+}
+
+func (db *Database) DeleteUserLeases(ctx context.Context, userID string) error {
+	_, err := db.pool.Exec(ctx, "DELETE FROM stream_leases WHERE user_id = $1::uuid", userID)
+	if err != nil {
+		return fmt.Errorf("error deleting leases: %w", err)
+	}
+	return nil
+}
+
+func (db *Database) ListWatchHistory(ctx context.Context, userID string) ([]domain.WatchEntry, error) {
+	rows, err := db.pool.Query(ctx, `
+		SELECT video_id::text, COALESCE(profile_id::text, ''), watched_at FROM watch_history
+		WHERE user_id = $1::uuid ORDER BY watched_at DESC`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error listing watch history: %w", err)
+	}
+	defer rows.Close()
+	var history []domain.WatchEntry
+	for rows.Next() {
+		var e domain.WatchEntry
+		if err := rows.Scan(&e.VideoID, &e.ProfileID, &e.WatchedAt); err != nil {
+			return nil, err
+		}
+		history = append(history, e)
+	}
+	return history, rows.Err()
+}
+
+func (db *Database) DeleteWatchHistory(ctx context.Context, userID string) error {
+	_, err := db.pool.Exec(ctx, "DELETE FROM watch_history WHERE user_id = $1::uuid", userID)
+	if err != nil {
+		return fmt.Errorf("error deleting watch history: %w", err)
+	}
+	return nil
+}
+
+// isInvalidID reports whether Postgres refused to cast an ID parameter.
+// Lease and video IDs come from the client, a malformed one matches no lease.
+func isInvalidID(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "22P02"
+}
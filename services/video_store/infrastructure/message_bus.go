@@ -0,0 +1,73 @@
+package infrastructure
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/eduardo-ax/video-streaming/pkg/jobs"
+	"github.com/eduardo-ax/video-streaming/pkg/messagebus"
+	"github.com/eduardo-ax/video-streaming/pkg/messagebus/kafka"
+	"github.com/eduardo-ax/video-streaming/pkg/messagebus/postgres"
+	"github.com/eduardo-ax/video-streaming/pkg/telemetry"
+	"github.com/eduardo-ax/video-streaming/services/video_store/config"
+)
+
+func NewMessageBus(cfg config.MessageBus) (messagebus.Bus, error) {
+	policy := messagebus.DefaultRetryPolicy()
+
+	switch cfg.Driver {
+	case "kafka":
+		return kafka.NewBus(cfg.Brokers, policy)
+	case "postgres":
+		bus := postgres.NewBus(NewPool(cfg.DatabaseURL), policy, 30*time.Minute)
+		if err := bus.EnsureSchema(context.Background()); err != nil {
+			return nil, err
+		}
+		return bus, nil
+	default:
+		return nil, fmt.Errorf("unknown message bus driver %q", cfg.Driver)
+	}
+}
+
+type Publisher struct {
+	bus   messagebus.Publisher
+	topic string
+}
+
+func NewPublisher(bus messagebus.Publisher, topic string) *Publisher {
+	return &Publisher{
+		bus:   bus,
+		topic: topic,
+	}
+}
+
+func (p *Publisher) Close() error {
+	return p.bus.Close()
+}
+
+func (p *Publisher) Ping(ctx context.Context) error {
+	if pinger, ok := p.bus.(messagebus.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (p *Publisher) SendMessage(ctx context.Context, job jobs.TranscodeJob) error {
+	msg := messagebus.NewMessage(job.VideoID, nil)
+	ctx, span := telemetry.StartPublishSpan(ctx, p.topic, msg)
+	defer span.End()
+
+	job.Trace = make(map[string]string, len(msg.Headers))
+	for k, v := range msg.Headers {
+		job.Trace[k] = v
+	}
+	value, err := jobs.Encode(job)
+	if err != nil {
+		return telemetry.RecordError(span, err)
+	}
+	msg.Value = value
+	msg.SetHeader(jobs.HeaderContentType, jobs.ContentType)
+	msg.SetHeader(jobs.HeaderSchemaVersion, fmt.Sprintf("%d", job.Version))
+	return telemetry.RecordError(span, p.bus.Publish(ctx, p.topic, msg))
+}
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS videos_owner_id_idx;
+ALTER TABLE videos DROP COLUMN IF EXISTS owner_id;
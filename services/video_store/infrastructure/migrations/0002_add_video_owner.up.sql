@@ -0,0 +1,5 @@
+-- Videos uploaded before uploads were authenticated have no owner, only
+-- moderators can delete them.
+ALTER TABLE videos ADD COLUMN IF NOT EXISTS owner_id UUID;
+
+CREATE INDEX IF NOT EXISTS videos_owner_id_idx ON videos (owner_id);
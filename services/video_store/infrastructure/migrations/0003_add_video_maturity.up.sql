@@ -0,0 +1,4 @@
+-- Minimum viewer age, one of 0, 7, 13, 16 or 18. Videos uploaded before
+-- ratings existed are treated as adult content until they are rated.
+ALTER TABLE videos ADD COLUMN IF NOT EXISTS maturity_rating SMALLINT NOT NULL DEFAULT 18
+    CHECK (maturity_rating IN (0, 7, 13, 16, 18));
@@ -0,0 +1,14 @@
+-- A lease is live while ended_at is NULL and expires_at is in the future,
+-- players push expires_at forward with heartbeats.
+CREATE TABLE IF NOT EXISTS stream_leases (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL,
+    profile_id UUID,
+    session_id UUID,
+    video_id BIGINT NOT NULL REFERENCES videos(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    ended_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS stream_leases_live_idx ON stream_leases (user_id, expires_at) WHERE ended_at IS NULL;
@@ -0,0 +1,11 @@
+-- A row per playback started, kept after its lease is swept. Erased with the
+-- account, and with the video when it is deleted.
+CREATE TABLE IF NOT EXISTS watch_history (
+    id BIGSERIAL PRIMARY KEY,
+    user_id UUID NOT NULL,
+    profile_id UUID,
+    video_id BIGINT NOT NULL REFERENCES videos(id) ON DELETE CASCADE,
+    watched_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS watch_history_user_id_idx ON watch_history (user_id, watched_at DESC);
@@ -9,6 +9,7 @@ import (
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 )
 
 type ObjectStore struct {
@@ -23,6 +24,13 @@ func NewObjectStore(client *s3.Client, bucket string) *ObjectStore {
 	}
 }
 
+func (o *ObjectStore) Ping(ctx context.Context) error {
+	_, err := o.client.HeadBucket(ctx, &s3.HeadBucketInput{
+		Bucket: aws.String(o.bucket),
+	})
+	return err
+}
+
 func (o *ObjectStore) UploadVideo(ctx context.Context, file *multipart.FileHeader, id int) error {
 	src, err := file.Open()
 	if err != nil {
@@ -64,3 +72,33 @@ func (o *ObjectStore) Download(ctx context.Context, key string) (io.ReadCloser,
 
 	return out.Body, contentType, nil
 }
+
+// DeletePrefix deletes every object under prefix, the source file and the
+// HLS renditions of a video.
+func (o *ObjectStore) DeletePrefix(ctx context.Context, prefix string) error {
+	paginator := s3.NewListObjectsV2Paginator(o.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(o.bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		if len(page.Contents) == 0 {
+			continue
+		}
+		objects := make([]types.ObjectIdentifier, 0, len(page.Contents))
+		for _, obj := range page.Contents {
+			objects = append(objects, types.ObjectIdentifier{Key: obj.Key})
+		}
+		_, err = o.client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(o.bucket),
+			Delete: &types.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
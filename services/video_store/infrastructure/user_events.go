@@ -0,0 +1,64 @@
+package infrastructure
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/eduardo-ax/video-streaming/pkg/events"
+	"github.com/eduardo-ax/video-streaming/pkg/messagebus"
+	"github.com/eduardo-ax/video-streaming/pkg/telemetry"
+)
+
+// DeletionService is how this service signs its deletion confirmations, the
+// user service waits for the services listed in ACCOUNT_DELETION_SERVICES.
+const DeletionService = "video_store"
+
+// UserEvents consumes the events of the user service and answers account
+// deletions on the confirmations topic.
+type UserEvents struct {
+	bus                messagebus.Bus
+	topic              string
+	confirmationsTopic string
+	group              string
+}
+
+func NewUserEvents(bus messagebus.Bus, topic string, confirmationsTopic string, group string) *UserEvents {
+	return &UserEvents{
+		bus:                bus,
+		topic:              topic,
+		confirmationsTopic: confirmationsTopic,
+		group:              group,
+	}
+}
+
+// Consume delivers the user events to handler, messages that don't decode
+// are never redelivered.
+func (u *UserEvents) Consume(ctx context.Context, handler func(ctx context.Context, event events.UserEvent) error) error {
+	return u.bus.Subscribe(ctx, u.topic, u.group, func(ctx context.Context, msg *messagebus.Message) error {
+		ctx, span := telemetry.StartConsumeSpan(ctx, msg)
+		defer span.End()
+
+		event, err := events.Decode(msg.Value)
+		if err != nil {
+			return telemetry.RecordError(span, messagebus.Permanent(err))
+		}
+		return telemetry.RecordError(span, handler(ctx, event))
+	})
+}
+
+func (u *UserEvents) ConfirmDeletion(ctx context.Context, userID string, deletionID string) error {
+	event := events.NewDeletionConfirmed(userID, deletionID, DeletionService)
+	msg := messagebus.NewMessage(userID, nil)
+	ctx, span := telemetry.StartPublishSpan(ctx, u.confirmationsTopic, msg)
+	defer span.End()
+
+	value, err := events.Encode(event)
+	if err != nil {
+		return telemetry.RecordError(span, err)
+	}
+	msg.Value = value
+	msg.SetHeader(events.HeaderContentType, events.ContentType)
+	msg.SetHeader(events.HeaderSchemaVersion, fmt.Sprintf("%d", event.Version))
+	msg.SetHeader(events.HeaderEventType, event.Type)
+	return telemetry.RecordError(span, u.bus.Publish(ctx, u.confirmationsTopic, msg))
+}
@@ -2,22 +2,40 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
-	"github.com/aws/aws-sdk-go-v2/config"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/eduardo-ax/video-streaming/pkg/auth"
+	"github.com/eduardo-ax/video-streaming/pkg/configloader"
+	"github.com/eduardo-ax/video-streaming/pkg/events"
+	"github.com/eduardo-ax/video-streaming/pkg/health"
+	"github.com/eduardo-ax/video-streaming/pkg/migrate"
+	"github.com/eduardo-ax/video-streaming/pkg/telemetry"
 	"github.com/eduardo-ax/video-streaming/services/video_store/api"
+	"github.com/eduardo-ax/video-streaming/services/video_store/config"
 	"github.com/eduardo-ax/video-streaming/services/video_store/domain"
 	"github.com/eduardo-ax/video-streaming/services/video_store/infrastructure"
 	metrics "github.com/eduardo-ax/video-streaming/services/video_store/observability"
 	"github.com/joho/godotenv"
-	"github.com/labstack/echo"
-	"github.com/labstack/echo/middleware"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/aws/aws-sdk-go-v2/otelaws"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/labstack/echo/otelecho"
 )
 
+const serviceName = "video_store"
+
 func main() {
 
 	err := godotenv.Load()
@@ -26,35 +44,178 @@ func main() {
 		log.Println("Warning: Could not load .env file, falling back to environment variables.")
 	}
 
-	pool := infrastructure.NewPool()
+	cfg, opts, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if opts.PrintConfig {
+		if err := configloader.Print(os.Stdout, cfg); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err != nil {
+		log.Fatalf("FATAL ERROR: invalid configuration:\n%v", err)
+	}
+	if opts.PrintConfig {
+		return
+	}
+
+	shutdownTracing, err := telemetry.SetupTracing(context.Background(), serviceName, cfg.Tracing.Exporter)
+	if err != nil {
+		log.Fatalf("FATAL ERROR: Could not initialize tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	pool := infrastructure.NewPool(cfg.Database.URL)
 	db := infrastructure.NewDatabase(pool)
 	defer db.Close()
 
-	cfg, err := config.LoadDefaultConfig(context.TODO())
-	s3Client := s3.NewFromConfig(cfg)
-	bucketName := os.Getenv("S3_BUCKET_NAME")
-	objectStore := infrastructure.NewObjectStore(s3Client, bucketName)
+	migrator, err := migrate.New(pool, infrastructure.Migrations, "migrations")
+	if err != nil {
+		log.Fatalf("FATAL ERROR: Could not load migrations: %v", err)
+	}
+	if len(opts.Args) > 0 && opts.Args[0] == "migrate" {
+		if err := migrate.Run(context.Background(), migrator, opts.Args[1:], os.Stdout); err != nil {
+			log.Fatalf("FATAL ERROR: %v", err)
+		}
+		return
+	}
+	if cfg.Database.AutoMigrate {
+		if err := migrator.Up(context.Background()); err != nil {
+			log.Fatalf("FATAL ERROR: Could not apply migrations: %v", err)
+		}
+	}
+
+	awsCfg, err := awsconfig.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		log.Fatalf("FATAL ERROR: Could not load AWS configuration: %v", err)
+	}
+	otelaws.AppendMiddlewares(&awsCfg.APIOptions)
+	s3Client := s3.NewFromConfig(awsCfg)
+	objectStore := infrastructure.NewObjectStore(s3Client, cfg.S3.Bucket)
 
-	pub, err := infrastructure.NewPublisher()
+	bus, err := infrastructure.NewMessageBus(cfg.MessageBus)
 	if err != nil {
-		log.Fatalf("FATAL ERROR: Could not initialize Kafka Publisher: %v", err)
+		log.Fatalf("FATAL ERROR: Could not initialize message bus: %v", err)
 	}
+	pub := infrastructure.NewPublisher(bus, cfg.MessageBus.Topic)
 	defer pub.Close()
 
-	videoUpload := domain.NewVideoManager(db, pub, objectStore)
+	videoUpload := domain.NewVideoManager(db, pub, objectStore, cfg.Playback.LeaseTTL)
+	userEvents := infrastructure.NewUserEvents(bus, cfg.MessageBus.UserEventsTopic, cfg.MessageBus.ConfirmationsTopic, serviceName)
+	eraser := domain.NewAccountEraser(db, objectStore, userEvents)
 
 	reg := prometheus.NewRegistry()
 	m := metrics.NewMetrics(reg)
 
 	echoServer := echo.New()
 	echoServer.Use(middleware.CORS())
+	echoServer.Use(otelecho.Middleware(serviceName, otelecho.WithSkipper(func(c echo.Context) bool {
+		return telemetry.IsOperationalPath(c.Path())
+	})))
+	echoServer.Use(telemetry.NewHTTPMetrics(reg, serviceName).Middleware())
+
+	checker := health.NewChecker(2 * time.Second)
+	checker.Add("postgres", db.Ping)
+	checker.Add("s3", objectStore.Ping)
+	checker.Add("message_bus", pub.Ping)
 
 	echoServer.GET("/metrics", echo.WrapHandler(promhttp.HandlerFor(reg, promhttp.HandlerOpts{})))
+	echoServer.GET("/healthz", echo.WrapHandler(checker.LivenessHandler()))
+	echoServer.GET("/readyz", echo.WrapHandler(checker.ReadinessHandler()))
 
 	v1Group := echoServer.Group("/v1")
 	handler := api.NewVideoHandler(videoUpload, m)
-	handler.Register(v1Group)
+	revocations := auth.NewRemoteRevocationList(cfg.Auth.RevocationsURL, cfg.Auth.ServiceToken, cfg.Auth.RevocationsMaxAge)
+	verifier := auth.NewVerifier(auth.NewRemoteKeySet(cfg.Auth.JWKSURL, cfg.Auth.KeysTTL), auth.WithRevocationCheck(revocations))
+	handler.Register(v1Group, verifier)
+	echoServer.GET("/internal/users/:id/export", handler.HandleAccountExport, auth.ServiceMiddleware(cfg.Auth.ServiceToken))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Background work stops taking jobs on SIGTERM, but a sweep or an event
+	// in flight gets the shutdown timeout to finish before it is cancelled.
+	drainCtx, cancelDrain := context.WithCancel(context.Background())
+	defer cancelDrain()
+	context.AfterFunc(ctx, func() {
+		time.AfterFunc(cfg.HTTP.ShutdownTimeout, cancelDrain)
+	})
+
+	var workers sync.WaitGroup
+	goWorker := func(work func()) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			work()
+		}()
+	}
+	goWorker(func() { revocations.Run(ctx, cfg.Auth.RevocationsInterval) })
+	goWorker(func() { runLeaseSweeper(ctx, drainCtx, videoUpload, cfg.Playback.SweepInterval) })
+	goWorker(func() {
+		if err := userEvents.Consume(ctx, drained(drainCtx, eraser.HandleUserEvent)); err != nil && ctx.Err() == nil {
+			log.Printf("user events consumer stopped: %v", err)
+		}
+	})
+
+	go func() {
+		if err := echoServer.Start(cfg.HTTP.Addr); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("HTTP server error: %v", err)
+			stop()
+		}
+	}()
+
+	<-ctx.Done()
+	log.Println("Shutting down: draining HTTP connections")
+	checker.SetShuttingDown()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.HTTP.ShutdownTimeout)
+	defer cancel()
+	if err := echoServer.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Warning: HTTP shutdown error: %v", err)
+	}
+	waitWorkers(shutdownCtx, &workers)
+}
+
+// drained makes an event handler outlive the consumer: an event in flight at
+// shutdown keeps running until drain is cancelled.
+func drained(drain context.Context, handler func(ctx context.Context, event events.UserEvent) error) func(ctx context.Context, event events.UserEvent) error {
+	return func(ctx context.Context, event events.UserEvent) error {
+		ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		defer cancel()
+		defer context.AfterFunc(drain, cancel)()
+		return handler(ctx, event)
+	}
+}
 
-	echoServer.Logger.Fatal(echoServer.Start(":8080"))
+// waitWorkers waits for the background goroutines until ctx is done.
+func waitWorkers(ctx context.Context, workers *sync.WaitGroup) {
+	done := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		log.Println("Shutting down: background work stopped")
+	case <-ctx.Done():
+		log.Println("Warning: background work still running at the shutdown deadline")
+	}
+}
 
+// runLeaseSweeper deletes the stream leases that ended or missed their
+// heartbeats. It stops with ctx, a sweep runs on drain.
+func runLeaseSweeper(ctx context.Context, drain context.Context, videos *domain.VideoManager, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := videos.ExpireLeases(drain); err != nil && drain.Err() == nil {
+				log.Printf("failed to expire stream leases: %v", err)
+			}
+		}
+	}
 }
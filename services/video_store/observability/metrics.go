@@ -1,35 +1,41 @@
 package metrics
 
-import "github.com/prometheus/client_golang/prometheus"
+import (
+	"path/filepath"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "video_store"
 
 type Metrics struct {
-	devices         prometheus.Gauge
-	uploads         prometheus.Gauge
+	uploads         prometheus.Counter
 	videoUploadTime prometheus.Histogram
+	bytesStreamed   *prometheus.CounterVec
 }
 
 func NewMetrics(reg prometheus.Registerer) *Metrics {
 	m := &Metrics{
-		devices: prometheus.NewGauge(prometheus.GaugeOpts{
-			Namespace: "myapp",
-			Name:      "devices_conected",
-			Help:      "Number of devices conected.",
-		}),
-		uploads: prometheus.NewGauge(prometheus.GaugeOpts{
-			Namespace: "myapp",
-			Name:      "videos_uploaded",
+		uploads: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "videos_uploaded_total",
 			Help:      "Number of videos uploaded.",
 		}),
 		videoUploadTime: prometheus.NewHistogram(prometheus.HistogramOpts{
-			Namespace: "myapp",
+			Namespace: namespace,
 			Name:      "video_upload_duration_seconds",
 			Help:      "Time taken to upload a video file in seconds.",
 			Buckets:   prometheus.LinearBuckets(1, 1, 10),
 		}),
+		bytesStreamed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "bytes_streamed_total",
+			Help:      "Number of bytes sent to players, by file kind.",
+		}, []string{"kind"}),
 	}
-	reg.MustRegister(m.devices)
 	reg.MustRegister(m.uploads)
 	reg.MustRegister(m.VideoUploadTime())
+	reg.MustRegister(m.bytesStreamed)
 	return m
 }
 
@@ -37,10 +43,21 @@ func (m *Metrics) UploadsInc() {
 	m.uploads.Inc()
 }
 
-func (m *Metrics) DevicesInc() {
-	m.devices.Inc()
-}
-
 func (m *Metrics) VideoUploadTime() prometheus.Histogram {
 	return m.videoUploadTime
 }
+
+func (m *Metrics) BytesStreamedAdd(filename string, bytes int64) {
+	m.bytesStreamed.WithLabelValues(fileKind(filename)).Add(float64(bytes))
+}
+
+func fileKind(filename string) string {
+	switch filepath.Ext(filename) {
+	case ".m3u8":
+		return "playlist"
+	case ".ts":
+		return "segment"
+	default:
+		return "other"
+	}
+}